@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// silence is how long a client must receive nothing for a broadcast to be
+// considered not delivered to it.
+const silence = 300 * time.Millisecond
+
+// setupServer starts the server and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// received is a notification or an announcement received by a client.
+type received struct {
+	event string
+	from  string
+	text  string
+}
+
+// client is a connected socket and the broadcasts it receives.
+type client struct {
+	*io_client.Socket
+	received chan received
+}
+
+// connectClient connects a client. Websocket only, as the polling upgrade
+// occasionally stalls the connection.
+func connectClient(t *testing.T, addr string) *client {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	c := &client{
+		Socket:   manager.Socket("/", io_client.DefaultSocketOptions()),
+		received: make(chan received, 10),
+	}
+	t.Cleanup(func() { c.Disconnect() })
+	for _, event := range []string{"notification", "announcement"} {
+		c.On(types.EventName(event), func(args ...any) {
+			if len(args) > 0 {
+				payload, _ := args[0].(map[string]any)
+				from, _ := payload["from"].(string)
+				text, _ := payload["text"].(string)
+				c.received <- received{event: event, from: from, text: text}
+			}
+		})
+	}
+
+	connected := make(chan struct{}, 1)
+	c.Once("connect", func(...any) { connected <- struct{}{} })
+	c.Connect()
+
+	select {
+	case <-connected:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// call emits event with args and returns its acknowledgement.
+func (c *client) call(t *testing.T, event string, args ...any) map[string]any {
+	t.Helper()
+
+	reply := make(chan map[string]any, 1)
+	c.EmitWithAck(event, args...)(func(args []any, err error) {
+		result := map[string]any{}
+		if len(args) > 0 {
+			result, _ = args[0].(map[string]any)
+		}
+		reply <- result
+	})
+
+	select {
+	case result := <-reply:
+		return result
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s was not acknowledged", event)
+		return nil
+	}
+}
+
+// expect checks the next broadcast received by c.
+func (c *client) expect(t *testing.T, want received) {
+	t.Helper()
+
+	select {
+	case got := <-c.received:
+		if got != want {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected %+v", want)
+	}
+}
+
+// expectSilence checks that c receives no broadcast for a while.
+func (c *client) expectSilence(t *testing.T) {
+	t.Helper()
+
+	select {
+	case got := <-c.received:
+		t.Fatalf("unexpected %+v", got)
+	case <-time.After(silence):
+	}
+}
+
+func TestExcept(t *testing.T) {
+	addr := setupServer(t)
+	sender := connectClient(t, addr)
+	receiver := connectClient(t, addr)
+	mutedReceiver := connectClient(t, addr)
+	from := string(sender.Id())
+
+	if result := mutedReceiver.call(t, "mute"); result["muted"] != true {
+		t.Fatalf("expected to be muted, got %v", result)
+	}
+
+	t.Run("notify-others leaves out the sender and the muted room", func(t *testing.T) {
+		if result := sender.call(t, "notify-others", "hello"); result["sent"] != true {
+			t.Fatalf("expected the notification to be sent, got %v", result)
+		}
+		receiver.expect(t, received{"notification", from, "hello"})
+		sender.expectSilence(t)
+		mutedReceiver.expectSilence(t)
+	})
+
+	t.Run("announce leaves out the muted room only", func(t *testing.T) {
+		if result := sender.call(t, "announce", "news"); result["sent"] != true {
+			t.Fatalf("expected the announcement to be sent, got %v", result)
+		}
+		sender.expect(t, received{"announcement", from, "news"})
+		receiver.expect(t, received{"announcement", from, "news"})
+		mutedReceiver.expectSilence(t)
+	})
+
+	t.Run("leaving the muted room restores delivery", func(t *testing.T) {
+		if result := mutedReceiver.call(t, "unmute"); result["muted"] != false {
+			t.Fatalf("expected to be unmuted, got %v", result)
+		}
+		if result := sender.call(t, "notify-others", "welcome back"); result["sent"] != true {
+			t.Fatalf("expected the notification to be sent, got %v", result)
+		}
+		receiver.expect(t, received{"notification", from, "welcome back"})
+		mutedReceiver.expect(t, received{"notification", from, "welcome back"})
+		sender.expectSilence(t)
+	})
+
+	t.Run("a muted sender does not get its own announcement", func(t *testing.T) {
+		if result := sender.call(t, "mute"); result["muted"] != true {
+			t.Fatalf("expected to be muted, got %v", result)
+		}
+		if result := sender.call(t, "announce", "quiet"); result["sent"] != true {
+			t.Fatalf("expected the announcement to be sent, got %v", result)
+		}
+		receiver.expect(t, received{"announcement", from, "quiet"})
+		mutedReceiver.expect(t, received{"announcement", from, "quiet"})
+		sender.expectSilence(t)
+	})
+}
+
+func TestExceptRefusesEmptyText(t *testing.T) {
+	addr := setupServer(t)
+	sender := connectClient(t, addr)
+	receiver := connectClient(t, addr)
+
+	for _, event := range []string{"notify-others", "announce"} {
+		result := sender.call(t, event, "")
+		if result["sent"] != false || result["error"] != "expected a text" {
+			t.Errorf("%s: expected sent=false with an error, got %v", event, result)
+		}
+	}
+	receiver.expectSilence(t)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Broadcast Except example - broadcasts to a room while leaving out some of
+// its sockets, the sender or a whole other room, with the Except modifier.
+//
+// Features:
+//   - Every client joins the "lobby" room on connection
+//   - "notify-others" reaches the lobby except the sender and the muted clients
+//   - "announce" reaches the lobby except the muted clients, sender included
+//   - "mute" and "unmute" join and leave the "muted" room
+//
+// Chained Except calls add up: a socket in any of the excluded rooms is left
+// out, even when it is in the targeted ones.
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Broadcast Except server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
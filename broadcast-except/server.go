@@ -0,0 +1,96 @@
+package main
+
+import (
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+const (
+	// lobby is the room every client joins on connection.
+	lobby io.Room = "lobby"
+	// muted is the room of the clients which turned the notifications off.
+	muted io.Room = "muted"
+)
+
+// newServer creates a Socket.IO server broadcasting notifications to the
+// lobby, except to the clients which muted them.
+func newServer(httpServer *types.HttpServer) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+		client.Join(lobby)
+
+		// Mute and unmute acknowledge the new state, so that the client knows
+		// the following broadcasts take it into account
+		client.On("mute", func(args ...any) {
+			client.Join(muted)
+			acknowledge(args, map[string]any{"muted": true})
+		})
+		client.On("unmute", func(args ...any) {
+			client.Leave(muted)
+			acknowledge(args, map[string]any{"muted": false})
+		})
+
+		// The sender is left out through the room of its own id, which only
+		// it is in. Each Except returns a new operator excluding the union of
+		// the rooms, so the second one adds the muted clients to the first
+		// instead of replacing it. client.To(lobby) would leave the sender out
+		// on its own, it is spelled out here for the sake of the example.
+		client.On("notify-others", func(args ...any) {
+			text, ok := textOf(args)
+			if !ok {
+				acknowledge(args, map[string]any{"sent": false, "error": "expected a text"})
+				return
+			}
+			server.To(lobby).Except(io.Room(client.Id())).Except(muted).Emit("notification", map[string]any{
+				"from": client.Id(),
+				"text": text,
+			})
+			acknowledge(args, map[string]any{"sent": true})
+		})
+
+		// The sender gets its own announcement back, unless it is muted
+		client.On("announce", func(args ...any) {
+			text, ok := textOf(args)
+			if !ok {
+				acknowledge(args, map[string]any{"sent": false, "error": "expected a text"})
+				return
+			}
+			server.To(lobby).Except(muted).Emit("announcement", map[string]any{
+				"from": client.Id(),
+				"text": text,
+			})
+			acknowledge(args, map[string]any{"sent": true})
+		})
+	})
+	return server
+}
+
+// textOf returns the text an event starts with.
+func textOf(args []any) (string, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+	text, ok := args[0].(string)
+	return text, ok && text != ""
+}
+
+// acknowledge calls the acknowledgement of an event with result, when the
+// client asked for one.
+func acknowledge(args []any, result map[string]any) {
+	if len(args) == 0 {
+		return
+	}
+	if ack, ok := args[len(args)-1].(io.Ack); ok {
+		ack([]any{result}, nil)
+	}
+}
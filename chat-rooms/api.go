@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// emitPath is the HTTP route that emits an event into a room, so that other
+// services can reach the clients without speaking Socket.IO.
+const emitPath = "/api/emit"
+
+// maxEmitBody limits the size of the requests to emitPath.
+const maxEmitBody = 1 << 20
+
+// emitRequest is the body of a request to emitPath.
+type emitRequest struct {
+	Room  string `json:"room"`
+	Event string `json:"event"`
+	Data  any    `json:"data"`
+}
+
+// validate reports why the request cannot be emitted, if it cannot.
+func (r *emitRequest) validate() error {
+	switch {
+	case r.Room == "":
+		return errors.New("room is required")
+	case r.Event == "":
+		return errors.New("event is required")
+	case io.SOCKET_RESERVED_EVENTS.Has(r.Event):
+		return errors.New("event is reserved: " + r.Event)
+	}
+	return nil
+}
+
+// authorized reports whether r carries token as a bearer token. The tokens
+// are compared in constant time, so that the response time does not tell how
+// much of a guess was right.
+func authorized(r *http.Request, token string) bool {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+// emitHandler serves emitPath: it accepts POST requests with a JSON body
+// {"room", "event", "data"} and emits the event with the data to the sockets
+// of the room, on every node when an adapter is configured. It replies 202
+// with {"recipients"}, the number of sockets of this node that were in the
+// room, and 400 without emitting anything when the body is invalid.
+//
+// The endpoint shares the port of the Socket.IO server, which browsers of any
+// origin reach, so the requests must carry token as a bearer token; the others
+// are answered with a 401 before their body is read.
+//
+// The handler only needs the Socket.IO server, which is created first and
+// passed to both the Socket.IO handlers and the HTTP layer.
+func emitHandler(server *io.Server, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req emitRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEmitBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		room := io.Room(req.Room)
+		recipients := 0
+		if sockets, ok := server.Sockets().Adapter().Rooms().Load(room); ok {
+			recipients = sockets.Len()
+		}
+		if err := server.To(room).Emit(req.Event, req.Data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]any{"recipients": recipients})
+	})
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// testToken is the token the HTTP API of the test server requires.
+const testToken = "test-token"
+
+// setupServer starts a chat rooms server for testing and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	srv := io.NewServer(nil, config)
+	registerHandlers(srv)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/socket.io/", srv.ServeHandler(nil))
+	mux.Handle(emitPath, emitHandler(srv, testToken))
+	httpServer := &http.Server{Handler: mux}
+	go httpServer.Serve(ln)
+
+	t.Cleanup(func() {
+		srv.Close(nil)
+		httpServer.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// event is a Socket.IO event as received by a rawClient.
+type event struct {
+	Name string
+	Data any
+}
+
+// rawClient speaks the Engine.IO and Socket.IO protocols over a plain
+// websocket connection, and records every event it receives.
+type rawClient struct {
+	t        *testing.T
+	conn     *websocket.Conn
+	id       string
+	ackId    int
+	received []event
+}
+
+// connectRaw opens a websocket connection to addr and joins the main namespace.
+func connectRaw(t *testing.T, addr string) *rawClient {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/socket.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &rawClient{t: t, conn: conn}
+	if data := c.read(); !strings.HasPrefix(data, "0") {
+		t.Fatalf("expected an Engine.IO handshake, got %q", data)
+	}
+	c.write("40")
+
+	data := c.read()
+	var handshake struct {
+		Sid string `json:"sid"`
+	}
+	if !strings.HasPrefix(data, "40") || json.Unmarshal([]byte(data[2:]), &handshake) != nil {
+		t.Fatalf("expected a Socket.IO handshake, got %q", data)
+	}
+	c.id = handshake.Sid
+	return c
+}
+
+func (c *rawClient) write(data string) {
+	c.t.Helper()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the next packet, answering PINGs along the way.
+func (c *rawClient) read() string {
+	c.t.Helper()
+
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if string(data) == "2" {
+			c.write("3")
+			continue
+		}
+		return string(data)
+	}
+}
+
+// next reads packets until an event or an acknowledgement arrives. Events are
+// recorded, and the id and arguments of an acknowledgement are returned.
+func (c *rawClient) next() (ackId int, args []any, isAck bool) {
+	c.t.Helper()
+
+	data := c.read()
+	switch {
+	case strings.HasPrefix(data, "42"):
+		var payload []any
+		if err := json.Unmarshal([]byte(data[2:]), &payload); err != nil || len(payload) == 0 {
+			c.t.Fatalf("malformed event %q", data)
+		}
+		name, _ := payload[0].(string)
+		var value any
+		if len(payload) > 1 {
+			value = payload[1]
+		}
+		c.received = append(c.received, event{name, value})
+		return 0, nil, false
+	case strings.HasPrefix(data, "43"):
+		body := data[2:]
+		i := strings.IndexByte(body, '[')
+		id, err := strconv.Atoi(body[:max(i, 0)])
+		if i < 0 || err != nil || json.Unmarshal([]byte(body[i:]), &args) != nil {
+			c.t.Fatalf("malformed acknowledgement %q", data)
+		}
+		return id, args, true
+	default:
+		c.t.Fatalf("unexpected packet %q", data)
+		return 0, nil, false
+	}
+}
+
+// call emits an event with an acknowledgement and waits for it.
+func (c *rawClient) call(name string, args ...any) any {
+	c.t.Helper()
+
+	c.ackId++
+	c.emit(strconv.Itoa(c.ackId), name, args...)
+	for {
+		id, reply, isAck := c.next()
+		if !isAck {
+			continue
+		}
+		if id != c.ackId || len(reply) != 1 {
+			c.t.Fatalf("unexpected acknowledgement %d %v", id, reply)
+		}
+		return reply[0]
+	}
+}
+
+func (c *rawClient) emit(ackId, name string, args ...any) {
+	c.t.Helper()
+
+	payload, err := json.Marshal(append([]any{name}, args...))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.write("42" + ackId + string(payload))
+}
+
+// waitFor waits until count events have been received.
+func (c *rawClient) waitFor(count int) {
+	c.t.Helper()
+
+	for len(c.received) < count {
+		if _, _, isAck := c.next(); isAck {
+			c.t.Fatal("unexpected acknowledgement")
+		}
+	}
+}
+
+func membership(room string, members int) map[string]any {
+	return map[string]any{"room": room, "members": float64(members)}
+}
+
+func notice(id, room string, members int) map[string]any {
+	return map[string]any{"id": id, "room": room, "members": float64(members)}
+}
+
+func TestChatRooms(t *testing.T) {
+	addr := setupServer(t)
+
+	alice := connectRaw(t, addr)
+	bob := connectRaw(t, addr)
+	carol := connectRaw(t, addr)
+
+	steps := []struct {
+		client   *rawClient
+		name     string
+		args     []any
+		expected any
+	}{
+		{alice, "join", []any{"lobby"}, membership("lobby", 1)},
+		{bob, "join", []any{"lobby"}, membership("lobby", 2)},
+		{carol, "join", []any{"other"}, membership("other", 1)},
+	}
+	for _, step := range steps {
+		if reply := step.client.call(step.name, step.args...); !reflect.DeepEqual(reply, step.expected) {
+			t.Fatalf("%s: expected %v, got %v", step.name, step.expected, reply)
+		}
+	}
+
+	// Messages reach the members of the room only, sender included
+	alice.emit("", "chat", "lobby", "hello")
+	alice.waitFor(2)
+	// Chatting in a room the client did not join is ignored
+	carol.emit("", "chat", "lobby", "intruder")
+
+	if reply := bob.call("leave", "lobby"); !reflect.DeepEqual(reply, membership("lobby", 1)) {
+		t.Fatalf("leave: expected %v, got %v", membership("lobby", 1), reply)
+	}
+	if reply := carol.call("join", "lobby"); !reflect.DeepEqual(reply, membership("lobby", 2)) {
+		t.Fatalf("join: expected %v, got %v", membership("lobby", 2), reply)
+	}
+
+	// Leaving a room the client is not in notifies nobody, and flushes the
+	// events sent to bob and carol so far
+	if reply := bob.call("leave", "lobby"); !reflect.DeepEqual(reply, membership("lobby", 2)) {
+		t.Fatalf("leave: expected %v, got %v", membership("lobby", 2), reply)
+	}
+	if reply := carol.call("leave", "nowhere"); !reflect.DeepEqual(reply, membership("nowhere", 0)) {
+		t.Fatalf("leave: expected %v, got %v", membership("nowhere", 0), reply)
+	}
+
+	// Disconnecting notifies every room the client was in
+	carol.conn.Close()
+
+	message := map[string]any{"id": alice.id, "room": "lobby", "text": "hello"}
+	tests := []struct {
+		name     string
+		client   *rawClient
+		expected []event
+	}{
+		{"alice", alice, []event{
+			{"user-joined", notice(bob.id, "lobby", 2)},
+			{"chat-message", message},
+			{"user-left", notice(bob.id, "lobby", 1)},
+			{"user-joined", notice(carol.id, "lobby", 2)},
+			{"user-left", notice(carol.id, "lobby", 1)},
+		}},
+		{"bob", bob, []event{
+			{"chat-message", message},
+		}},
+		{"carol", carol, nil},
+	}
+	alice.waitFor(len(tests[0].expected))
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.client.received, tt.expected) {
+			t.Errorf("%s: expected %s, got %s", tt.name, format(tt.expected), format(tt.client.received))
+		}
+	}
+}
+
+func TestPrivateRooms(t *testing.T) {
+	addr := setupServer(t)
+
+	alice := connectRaw(t, addr)
+	bob := connectRaw(t, addr)
+
+	// The room of a socket id would receive everything sent to that socket
+	refused := map[string]any{"error": "invalid room"}
+	for _, room := range []string{bob.id, alice.id} {
+		if reply := alice.call("join", room); !reflect.DeepEqual(reply, refused) {
+			t.Fatalf("join %s: expected %v, got %v", room, refused, reply)
+		}
+	}
+
+	// What is sent to bob stays out of reach of alice
+	if status, reply := postEmit(t, addr, `{"room":"`+bob.id+`","event":"secret"}`); status != http.StatusAccepted || reply != `{"recipients":1}` {
+		t.Fatalf("expected status 202 with 1 recipient, got %d %s", status, reply)
+	}
+	bob.waitFor(1)
+	// Leaving a room the client is not in only replies, which flushes the
+	// events sent so far
+	alice.call("leave", "nowhere")
+	if len(alice.received) != 0 {
+		t.Fatalf("expected alice to receive nothing, got %s", format(alice.received))
+	}
+}
+
+func format(events []event) string {
+	parts := make([]string, 0, len(events))
+	for _, e := range events {
+		parts = append(parts, fmt.Sprintf("%s %v", e.Name, e.Data))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// postEmit posts body to the emit endpoint with the token of the test server
+// and returns the response status and body.
+func postEmit(t *testing.T, addr, body string) (int, string) {
+	t.Helper()
+
+	return postEmitWithToken(t, addr, testToken, body)
+}
+
+// postEmitWithToken posts body to the emit endpoint with token as a bearer
+// token, or without authorization when token is empty, and returns the
+// response status and body.
+func postEmitWithToken(t *testing.T, addr, token, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+emitPath, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(res.Body); err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, strings.TrimSpace(data.String())
+}
+
+func TestEmitAPI(t *testing.T) {
+	addr := setupServer(t)
+
+	alice := connectRaw(t, addr)
+	bob := connectRaw(t, addr)
+
+	if reply := alice.call("join", "lobby"); !reflect.DeepEqual(reply, membership("lobby", 1)) {
+		t.Fatalf("join: expected %v, got %v", membership("lobby", 1), reply)
+	}
+
+	// Requests without the token are rejected before anything is emitted
+	for _, token := range []string{"", "wrong-token", testToken + "x"} {
+		if status, _ := postEmitWithToken(t, addr, token, `{"room":"lobby","event":"announcement","data":"intruder"}`); status != http.StatusUnauthorized {
+			t.Fatalf("token %q: expected status 401, got %d", token, status)
+		}
+	}
+
+	// Invalid bodies are rejected before anything is emitted
+	for _, body := range []string{
+		`not json`,
+		`{"event":"announcement","data":"no room"}`,
+		`{"room":"lobby","data":"no event"}`,
+		`{"room":"lobby","event":"disconnect"}`,
+		`{"room":"lobby","event":"announcement","extra":true}`,
+	} {
+		if status, _ := postEmit(t, addr, body); status != http.StatusBadRequest {
+			t.Fatalf("%s: expected status 400, got %d", body, status)
+		}
+	}
+
+	status, reply := postEmit(t, addr, `{"room":"lobby","event":"announcement","data":{"text":"hello"}}`)
+	if status != http.StatusAccepted || reply != `{"recipients":1}` {
+		t.Fatalf("expected status 202 with 1 recipient, got %d %s", status, reply)
+	}
+
+	// Leaving a room the client is not in only replies, which flushes the
+	// events sent so far
+	alice.call("leave", "nowhere")
+	bob.call("leave", "nowhere")
+
+	tests := []struct {
+		name     string
+		client   *rawClient
+		expected []event
+	}{
+		{"alice", alice, []event{{"announcement", map[string]any{"text": "hello"}}}},
+		{"bob", bob, nil},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.client.received, tt.expected) {
+			t.Errorf("%s: expected %s, got %s", tt.name, format(tt.expected), format(tt.client.received))
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Chat rooms example - clients join named rooms and chat within them.
+//
+// Features:
+//   - Join and leave any number of named rooms
+//   - Messages are broadcast to the members of a single room
+//   - Join/leave notifications to the other members, with the member count
+//   - Leave notifications to every room of a disconnecting client
+//   - An HTTP endpoint emitting events into a room
+
+func main() {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	httpServer := types.NewWebServer(nil)
+	server := io.NewServer(httpServer, config)
+	registerHandlers(server)
+	httpServer.Handle(emitPath, emitHandler(server, apiToken()))
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Chat rooms server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
+
+// apiToken returns the token the HTTP API requires, from the API_TOKEN
+// environment variable. Without it, a random token is generated and printed,
+// so that the API is never open with a guessable token.
+func apiToken() string {
+	if token := os.Getenv("API_TOKEN"); token != "" {
+		return token
+	}
+	token := rand.Text()
+	fmt.Printf("API_TOKEN is not set, the HTTP API requires the generated token %s\n", token)
+	return token
+}
+
+// registerHandlers registers the chat room events on server.
+func registerHandlers(server *io.Server) {
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'join', add it to the room and notify the
+		// other members
+		client.On("join", func(args ...any) {
+			room, ok := roomArg(server, args)
+			if !ok {
+				refuse(args)
+				return
+			}
+			client.Join(room)
+
+			countMembers(server, room, func(members int) {
+				client.To(room).Emit("user-joined", map[string]any{
+					"id":      client.Id(),
+					"room":    room,
+					"members": members,
+				})
+				reply(args, room, members)
+			})
+		})
+
+		// When the client emits 'leave', remove it from the room and notify
+		// the remaining members. Leaving a room it is not in only replies.
+		client.On("leave", func(args ...any) {
+			room, ok := roomArg(server, args)
+			if !ok {
+				refuse(args)
+				return
+			}
+			joined := client.Rooms().Has(room)
+			client.Leave(room)
+
+			countMembers(server, room, func(members int) {
+				if joined {
+					client.To(room).Emit("user-left", map[string]any{
+						"id":      client.Id(),
+						"room":    room,
+						"members": members,
+					})
+				}
+				reply(args, room, members)
+			})
+		})
+
+		// When the client emits 'chat', broadcast the message to every member
+		// of the room, sender included, provided the client joined it
+		client.On("chat", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			room, ok := roomArg(server, args)
+			if !ok || !client.Rooms().Has(room) {
+				return
+			}
+			text, ok := args[1].(string)
+			if !ok {
+				return
+			}
+			server.To(room).Emit("chat-message", map[string]any{
+				"id":   client.Id(),
+				"room": room,
+				"text": text,
+			})
+		})
+
+		// The rooms are still known while disconnecting, but are cleared by
+		// the time 'disconnect' is emitted
+		client.On("disconnecting", func(...any) {
+			for _, room := range client.Rooms().Keys() {
+				if room == io.Room(client.Id()) {
+					continue
+				}
+				// The client is still counted as a member until disconnected
+				countMembers(server, room, func(members int) {
+					client.To(room).Emit("user-left", map[string]any{
+						"id":      client.Id(),
+						"room":    room,
+						"members": members - 1,
+					})
+				})
+			}
+		})
+	})
+}
+
+// roomArg returns the room named by the first argument. Every socket is in a
+// private room named after its id, which receives everything sent to that
+// socket, so clients may not use the id of any connected socket as a room.
+func roomArg(server *io.Server, args []any) (io.Room, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+	name, ok := args[0].(string)
+	if !ok || name == "" {
+		return "", false
+	}
+	if _, taken := server.Sockets().Sockets().Load(io.SocketId(name)); taken {
+		return "", false
+	}
+	return io.Room(name), true
+}
+
+// countMembers reports the number of sockets in room to fn.
+func countMembers(server *io.Server, room io.Room, fn func(int)) {
+	server.In(room).FetchSockets()(func(sockets []*io.RemoteSocket, err error) {
+		if err != nil {
+			log.Printf("failed to count the members of %s: %v", room, err)
+			return
+		}
+		fn(len(sockets))
+	})
+}
+
+// refuse acknowledges a join or leave of an invalid room with an error, when
+// the client asked for an acknowledgement.
+func refuse(args []any) {
+	if len(args) == 0 {
+		return
+	}
+	if ack, ok := args[len(args)-1].(io.Ack); ok {
+		ack([]any{map[string]any{"error": "invalid room"}}, nil)
+	}
+}
+
+// reply acknowledges a join or leave with the room's member count, when the
+// client asked for an acknowledgement.
+func reply(args []any, room io.Room, members int) {
+	if ack, ok := args[len(args)-1].(io.Ack); ok {
+		ack([]any{map[string]any{"room": room, "members": members}}, nil)
+	}
+}
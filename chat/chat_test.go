@@ -34,6 +34,8 @@ func setupServer(t *testing.T) (*io.Server, string) {
 			return
 		}
 
+		limitRate(client, eventsPerSecond, eventsBurst, maxViolations)
+
 		addedUser := false
 
 		client.On("new message", func(args ...any) {
@@ -428,3 +430,127 @@ func TestDuplicateAddUser(t *testing.T) {
 		t.Fatalf("expected 1 login event, got %d", count)
 	}
 }
+
+// addUser connects a client to addr and registers it as username.
+func addUser(t *testing.T, addr, username string) *io_client.Socket {
+	t.Helper()
+
+	client := connectClient(t, addr, "/")
+	client.Emit("add user", username)
+	waitForEvent(t, client, "login", 2*time.Second)
+	return client
+}
+
+// countMessages counts the chat messages client receives from username.
+func countMessages(client *io_client.Socket, username string) *atomic.Int64 {
+	var count atomic.Int64
+	client.On("new message", func(args ...any) {
+		if len(args) > 0 {
+			if data, ok := args[0].(map[string]any); ok && data["username"] == username {
+				count.Add(1)
+			}
+		}
+	})
+	return &count
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	_, addr := setupServer(t)
+
+	alice := addUser(t, addr, "Alice")
+	bob := addUser(t, addr, "Bob")
+	received := countMessages(bob, "Alice")
+
+	errCh := make(chan map[string]any, 1)
+	alice.On("error", func(args ...any) {
+		if len(args) > 0 {
+			if data, ok := args[0].(map[string]any); ok {
+				select {
+				case errCh <- data:
+				default:
+				}
+			}
+		}
+	})
+	disconnected := make(chan struct{})
+	alice.On("disconnect", func(...any) { close(disconnected) })
+
+	// 50 messages in 100ms, while Alice's bucket was just drained by one
+	// 'add user' event
+	for i := range 50 {
+		alice.Emit("new message", fmt.Sprintf("spam %d", i))
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	select {
+	case data := <-errCh:
+		if data["code"] != "rate_limited" {
+			t.Fatalf("expected code rate_limited, got %v", data["code"])
+		}
+		if retryAfter, _ := data["retryAfterMs"].(float64); retryAfter <= 0 || retryAfter > 1000/eventsPerSecond {
+			t.Fatalf("expected retryAfterMs in (0, %d], got %v", 1000/eventsPerSecond, data["retryAfterMs"])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for the rate_limited error")
+	}
+
+	// Roughly the burst, plus the tokens refilled during the 100ms
+	time.Sleep(500 * time.Millisecond)
+	if count := received.Load(); count < eventsBurst-1 || count > eventsBurst+2 {
+		t.Fatalf("expected about %d messages, got %d", eventsBurst, count)
+	}
+
+	// The burst is under the threshold, so Alice stays connected
+	select {
+	case <-disconnected:
+		t.Fatal("expected Alice to stay connected")
+	default:
+	}
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	_, addr := setupServer(t)
+
+	alice := addUser(t, addr, "Alice")
+	bob := addUser(t, addr, "Bob")
+	carol := addUser(t, addr, "Carol")
+	received := countMessages(carol, "Bob")
+
+	disconnected := make(chan struct{})
+	alice.On("disconnect", func(...any) { close(disconnected) })
+
+	// Alice keeps flooding, past the threshold of events over the limit. The
+	// limit counts every event, handled or not, and an unhandled one keeps
+	// her from broadcasting at the same time as Bob
+	go func() {
+		for i := range 2 * maxViolations {
+			select {
+			case <-disconnected:
+				return
+			default:
+			}
+			alice.Emit("spam", i)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// Meanwhile, Bob sends a message every 200ms
+	for i := range 5 {
+		bob.Emit("new message", fmt.Sprintf("hello %d", i))
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Alice to be disconnected")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for received.Load() < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := received.Load(); count != 5 {
+		t.Fatalf("expected all 5 messages of Bob, got %d", count)
+	}
+}
@@ -18,6 +18,7 @@ import (
 //   - Users send chat messages to all connected users
 //   - Typing indicator broadcasts
 //   - Join/leave notifications with user count
+//   - Per-user rate limiting of the events, disconnecting flooding users
 
 var numUsers int64
 
@@ -37,6 +38,9 @@ func main() {
 			return
 		}
 
+		// Drop the events over the rate limit, and disconnect flooding users
+		limitRate(client, eventsPerSecond, eventsBurst, maxViolations)
+
 		addedUser := false
 
 		// When the client emits 'new message', broadcast it to others
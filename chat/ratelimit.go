@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// Default limits of the chat: each user sends up to 10 events per second, and
+// is disconnected after 100 events over the limit.
+const (
+	eventsPerSecond = 10
+	eventsBurst     = 10
+	maxViolations   = 100
+)
+
+// tokenBucket holds up to burst tokens, refilled at rate tokens per second.
+// Each event takes a token, and events without one are over the limit.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// violations counts the events over the limit since the bucket was last
+	// full, that is since the socket last stayed under the limit long enough
+	violations int
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// take takes a token for an event at now. Without one, it returns the number
+// of violations so far, and how long until a token is available.
+func (b *tokenBucket) take(now time.Time) (ok bool, violations int, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens == b.burst {
+		b.violations = 0
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, b.violations, 0
+	}
+	b.violations++
+	retryAfter = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, b.violations, retryAfter
+}
+
+// limitRate registers a packet middleware on the socket, which lets through up
+// to rate events per second, in bursts of up to burst events. The events over
+// the limit are dropped, and the first of them emits an "error" event to the
+// client with {"code": "rate_limited", "retryAfterMs": ...}, the delay until
+// the next event is let through, rounded up. The count starts over once the
+// socket stays under the limit long enough for the bucket to be full again,
+// otherwise the socket is disconnected after maxViolations events over the
+// limit.
+func limitRate(client *io.Socket, rate, burst, maxViolations int) {
+	bucket := newTokenBucket(rate, burst)
+
+	client.Use(func(_ []any, next func(error)) {
+		ok, violations, retryAfter := bucket.take(time.Now())
+		switch {
+		case ok:
+			next(nil)
+		case violations >= maxViolations:
+			client.Disconnect(true)
+		case violations == 1:
+			client.Emit("error", map[string]any{
+				"code":         "rate_limited",
+				"retryAfterMs": math.Ceil(float64(retryAfter) / float64(time.Millisecond)),
+			})
+		}
+	})
+}
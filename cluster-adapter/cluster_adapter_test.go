@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startNode starts a node on a free port and returns its address.
+func startNode(t *testing.T, name, socketPath string) string {
+	t.Helper()
+
+	n := newNode(name, socketPath)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: n.httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		n.Close()
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// rawClient speaks the Engine.IO and Socket.IO protocols over a plain
+// websocket connection.
+type rawClient struct {
+	t     *testing.T
+	conn  *websocket.Conn
+	ackId int
+}
+
+// connectRaw opens a websocket connection to addr and joins the main namespace.
+func connectRaw(t *testing.T, addr string) *rawClient {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/socket.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &rawClient{t: t, conn: conn}
+	if data := c.read(); !strings.HasPrefix(data, "0") {
+		t.Fatalf("expected an Engine.IO handshake, got %q", data)
+	}
+	c.write("40")
+	if data := c.read(); !strings.HasPrefix(data, "40") {
+		t.Fatalf("expected a Socket.IO handshake, got %q", data)
+	}
+	return c
+}
+
+func (c *rawClient) write(data string) {
+	c.t.Helper()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the next packet, answering PINGs along the way.
+func (c *rawClient) read() string {
+	c.t.Helper()
+
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if string(data) == "2" {
+			c.write("3")
+			continue
+		}
+		return string(data)
+	}
+}
+
+func (c *rawClient) emit(ackId, name string, args ...any) {
+	c.t.Helper()
+
+	payload, err := json.Marshal(append([]any{name}, args...))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.write("42" + ackId + string(payload))
+}
+
+// call emits an event with an acknowledgement and returns its arguments.
+func (c *rawClient) call(name string, args ...any) []any {
+	c.t.Helper()
+
+	c.ackId++
+	id, _ := json.Marshal(c.ackId)
+	c.emit(string(id), name, args...)
+
+	prefix := "43" + string(id)
+	data := c.read()
+	var reply []any
+	if !strings.HasPrefix(data, prefix) || json.Unmarshal([]byte(data[len(prefix):]), &reply) != nil {
+		c.t.Fatalf("expected acknowledgement %s, got %q", id, data)
+	}
+	return reply
+}
+
+// receive reads the next packet in the background, answering PINGs along the
+// way, and sends it or the read error to the returned channel.
+func (c *rawClient) receive() <-chan any {
+	received := make(chan any, 1)
+	go func() {
+		for {
+			_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, data, err := c.conn.ReadMessage()
+			if err != nil {
+				received <- err
+				return
+			}
+			if string(data) == "2" {
+				if err := c.conn.WriteMessage(websocket.TextMessage, []byte("3")); err != nil {
+					received <- err
+					return
+				}
+				continue
+			}
+			received <- string(data)
+			return
+		}
+	}()
+	return received
+}
+
+func TestCluster(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "cluster.sock")
+	a := connectRaw(t, startNode(t, "node-a", socketPath))
+	b := connectRaw(t, startNode(t, "node-b", socketPath))
+
+	if reply := b.call("join", "lobby"); !reflect.DeepEqual(reply, []any{"lobby"}) {
+		t.Fatalf("join: expected [lobby], got %v", reply)
+	}
+
+	// A broadcast from node A reaches the member of the room on node B. The
+	// nodes discover each other asynchronously, and the broadcasts sent
+	// before then are lost, so broadcast until one is delivered.
+	received := b.receive()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	var result any
+	for result == nil {
+		a.emit("", "broadcast", "lobby", "hello")
+		select {
+		case result = <-received:
+		case <-ticker.C:
+		}
+	}
+	if expected := `42["message",{"node":"node-a","text":"hello"}]`; result != expected {
+		t.Fatalf("expected %s, got %v", expected, result)
+	}
+
+	// A server-side event from node A is acknowledged by node B, and node A
+	// replies with its own name and the acknowledgements
+	expected := []any{[]any{"node-a", "node-b"}}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reply := a.call("nodes")
+		if reflect.DeepEqual(reply, expected) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes: expected %v, got %v", expected, reply)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestClusterLocalBroadcast(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "cluster.sock")
+	a := connectRaw(t, startNode(t, "node-a", socketPath))
+	b := connectRaw(t, startNode(t, "node-b", socketPath))
+
+	if reply := b.call("join", "lobby"); !reflect.DeepEqual(reply, []any{"lobby"}) {
+		t.Fatalf("join: expected [lobby], got %v", reply)
+	}
+
+	// Wait for the nodes to discover each other, as in TestCluster. The
+	// client of node A only joins the room afterwards, so that it does not
+	// receive these broadcasts.
+	received := b.receive()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	var result any
+	for result == nil {
+		a.emit("", "broadcast", "lobby", "hello")
+		select {
+		case result = <-received:
+		case <-ticker.C:
+		}
+	}
+	if err, ok := result.(error); ok {
+		t.Fatal(err)
+	}
+	if reply := a.call("join", "lobby"); !reflect.DeepEqual(reply, []any{"lobby"}) {
+		t.Fatalf("join: expected [lobby], got %v", reply)
+	}
+
+	// The local broadcast is emitted first: had it crossed to node B, the
+	// client there would receive it before the other one
+	a.emit("", "local-announce", "lobby", "local")
+	a.emit("", "broadcast", "lobby", "global")
+
+	for _, text := range []string{"local", "global"} {
+		expected := `42["message",{"node":"node-a","text":"` + text + `"}]`
+		if data := a.read(); data != expected {
+			t.Fatalf("node A: expected %s, got %q", expected, data)
+		}
+	}
+
+	// Broadcasts still in flight from the discovery may arrive first
+	for {
+		data := b.read()
+		if data == `42["message",{"node":"node-a","text":"hello"}]` {
+			continue
+		}
+		if expected := `42["message",{"node":"node-a","text":"global"}]`; data != expected {
+			t.Fatalf("node B: expected %s, got %q", expected, data)
+		}
+		break
+	}
+}
+
+// postConfig posts a configuration update to the node at addr and returns the
+// decoded reply.
+func postConfig(t *testing.T, addr string, update map[string]any) map[string]any {
+	t.Helper()
+
+	body, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post("http://"+addr+configPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	var reply map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestClusterConfigUpdate(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "cluster.sock")
+	addrA := startNode(t, "node-a", socketPath)
+	b := connectRaw(t, startNode(t, "node-b", socketPath))
+
+	// The nodes discover each other asynchronously, and the server-side events
+	// sent before then are lost, so post until node B acknowledges one
+	received := b.receive()
+	update := map[string]any{"motd": "hello"}
+	expected := map[string]any{"node": "node-a", "acknowledged": []any{"node-b"}}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reply := postConfig(t, addrA, update)
+		if reflect.DeepEqual(reply, expected) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("config: expected %v, got %v", expected, reply)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Node B applied the update, and told its own clients
+	select {
+	case result := <-received:
+		if expected := `42["config-applied",{"config":{"motd":"hello"},"node":"node-b"}]`; result != expected {
+			t.Fatalf("expected %s, got %v", expected, result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for config-applied")
+	}
+
+	// Reserved event names are rejected instead of being sent to the other nodes
+	n := newNode("node-c", socketPath)
+	defer n.Close()
+	if err := n.server.ServerSideEmit("connection"); err == nil {
+		t.Fatal("expected an error for the reserved event name 'connection'")
+	}
+}
+
+func TestClusterConfigValidation(t *testing.T) {
+	addr := startNode(t, "node-a", filepath.Join(t.TempDir(), "cluster.sock"))
+	c := connectRaw(t, addr)
+	received := c.receive()
+
+	// Invalid updates are rejected before any node applies them
+	for _, body := range []string{
+		`not json`,
+		`{}`,
+		`{"motd":42}`,
+		`{"motd":"hello","extra":true}`,
+		`{"motd":"` + strings.Repeat("x", 64<<10) + `"}`,
+	} {
+		res, err := http.Post("http://"+addr+configPath, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%.40s: expected status 400, got %d", body, res.StatusCode)
+		}
+	}
+
+	reply := postConfig(t, addr, map[string]any{"maintenance": true})
+	if expected := map[string]any{"node": "node-a", "acknowledged": []any{}}; !reflect.DeepEqual(reply, expected) {
+		t.Fatalf("config: expected %v, got %v", expected, reply)
+	}
+	select {
+	case result := <-received:
+		if expected := `42["config-applied",{"config":{"maintenance":true},"node":"node-a"}]`; result != expected {
+			t.Fatalf("expected %s, got %v", expected, result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for config-applied")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// configPath is the HTTP route of a node that updates the configuration of
+// the whole cluster, with POST and a JSON object as the body.
+const configPath = "/config"
+
+// maxConfigBody limits the size of the requests to configPath.
+const maxConfigBody = 64 << 10
+
+// configUpdate is the body of a request to configPath. The settings it leaves
+// out keep their value.
+type configUpdate struct {
+	Motd        *string `json:"motd"`
+	Maintenance *bool   `json:"maintenance"`
+}
+
+// settings returns the settings set by u, as applied by the nodes.
+func (u *configUpdate) settings() map[string]any {
+	settings := map[string]any{}
+	if u.Motd != nil {
+		settings["motd"] = *u.Motd
+	}
+	if u.Maintenance != nil {
+		settings["maintenance"] = *u.Maintenance
+	}
+	return settings
+}
+
+// applyConfig merges update into the configuration of the node, and tells the
+// clients connected to this node about it with a 'config-applied' event.
+func (n *node) applyConfig(update map[string]any) {
+	n.mu.Lock()
+	maps.Copy(n.config, update)
+	n.mu.Unlock()
+
+	fmt.Printf("%s: applied config update %v\n", n.name, update)
+	n.server.Local().Emit("config-applied", map[string]any{
+		"node":   n.name,
+		"config": update,
+	})
+}
+
+// updateConfig applies update on this node, then sends it to the other nodes
+// with a 'config-update' server-side event. ServerSideEmit never reaches the
+// node it is called on, hence the local call. done receives the names of the
+// nodes that acknowledged the update, and an error if some of them did not in
+// time.
+func (n *node) updateConfig(update map[string]any, done func(peers []any, err error)) {
+	n.applyConfig(update)
+
+	err := n.server.ServerSideEmitWithAck("config-update", update)(func(responses []any, err error) {
+		// On timeout, the nodes that replied are still listed
+		peers := []any{}
+		for _, response := range responses {
+			peers = append(peers, firstArg(response))
+		}
+		done(peers, err)
+	})
+	// Only reserved event names, such as 'connection', are rejected up front
+	if err != nil {
+		done([]any{}, err)
+	}
+}
+
+// onConfigUpdate applies the configuration updates sent by the other nodes,
+// and acknowledges them with the name of this node.
+func (n *node) onConfigUpdate(args ...any) {
+	if len(args) == 0 {
+		return
+	}
+	if update, ok := args[0].(map[string]any); ok {
+		n.applyConfig(update)
+	}
+	if ack, ok := args[len(args)-1].(io.Ack); ok {
+		ack([]any{n.name}, nil)
+	}
+}
+
+// serveConfig handles configPath: it updates the configuration of the cluster
+// with the settings of the request body, and replies with the name of this
+// node and of the nodes that acknowledged the update. It replies 400 without
+// updating anything when the body is invalid, has unknown fields or sets
+// nothing, as every node would otherwise apply it.
+func (n *node) serveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req configUpdate
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	update := req.settings()
+	if len(update) == 0 {
+		http.Error(w, "no setting to update", http.StatusBadRequest)
+		return
+	}
+
+	type result struct {
+		peers []any
+		err   error
+	}
+	results := make(chan result, 1)
+	n.updateConfig(update, func(peers []any, err error) {
+		results <- result{peers, err}
+	})
+	res := <-results
+
+	reply := map[string]any{
+		"node":         n.name,
+		"acknowledged": res.peers,
+	}
+	if res.err != nil {
+		fmt.Printf("%s: config update not acknowledged by every node: %v\n", n.name, res.err)
+		reply["error"] = res.err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reply)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+)
+
+// Cluster adapter example - two Socket.IO servers share their rooms and
+// server-side events through the Unix domain socket cluster adapter, without
+// Redis.
+//
+// Features:
+//   - Broadcasts to a room reach its members on every node
+//   - Server-side events between the nodes, with acknowledgements
+//   - Configuration updates posted to one node and applied on every node
+//   - Nodes built independently of each other, so that they can run in
+//     separate processes on the same host
+
+func main() {
+	socketPath := filepath.Join(os.TempDir(), "cluster-adapter-example.sock")
+
+	nodes := []*node{
+		newNode("node-a", socketPath),
+		newNode("node-b", socketPath),
+	}
+	for i, n := range nodes {
+		addr := fmt.Sprintf(":%d", 3000+i)
+		n.httpServer.Listen(addr, nil)
+		fmt.Printf("%s listening on %s\n", n.name, addr)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down nodes...")
+	for _, n := range nodes {
+		n.Close()
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zishang520/socket.io/adapters/unix/v3"
+	unix_adapter "github.com/zishang520/socket.io/adapters/unix/v3/adapter"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// node is one Socket.IO server of the cluster. Nodes only share the socket
+// path of the adapter, so each of them can as well run in its own process.
+type node struct {
+	name       string
+	httpServer *types.HttpServer
+	server     *io.Server
+	client     *unix.UnixClient
+
+	mu     sync.Mutex
+	config map[string]any
+}
+
+// newNode creates a node named name, whose adapter finds its peers through the
+// Unix domain sockets derived from socketPath.
+func newNode(name, socketPath string) *node {
+	client := unix.NewUnixClient(context.Background(), socketPath)
+
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+	config.SetAdapter(&unix_adapter.UnixAdapterBuilder{
+		Unix: client,
+		Opts: unix_adapter.DefaultUnixAdapterOptions(),
+	})
+
+	httpServer := types.NewWebServer(nil)
+	n := &node{
+		name:       name,
+		httpServer: httpServer,
+		server:     io.NewServer(httpServer, config),
+		client:     client,
+		config:     map[string]any{},
+	}
+	n.registerHandlers()
+	n.httpServer.HandleFunc(configPath, n.serveConfig)
+	return n
+}
+
+// Close closes the Socket.IO server and the adapter's connections.
+func (n *node) Close() {
+	n.server.Close(nil)
+	_ = n.client.Close()
+}
+
+// registerHandlers registers the same handlers on every node, so that a client
+// gets the same behavior whichever node it is connected to.
+func (n *node) registerHandlers() {
+	// When another node emits 'whois', reply with the name of this one
+	n.server.On("whois", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		if ack, ok := args[len(args)-1].(io.Ack); ok {
+			ack([]any{n.name}, nil)
+		}
+	})
+
+	// When another node emits 'config-update', apply it and acknowledge it
+	n.server.On("config-update", n.onConfigUpdate)
+
+	n.server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'join', add it to the room on this node
+		client.On("join", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			room, ok := args[0].(string)
+			if !ok || room == "" {
+				return
+			}
+			client.Join(io.Room(room))
+
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{room}, nil)
+			}
+		})
+
+		// When the client emits 'broadcast', send the message to the members
+		// of the room on every node
+		client.On("broadcast", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			room, ok := args[0].(string)
+			if !ok || room == "" {
+				return
+			}
+			n.server.To(io.Room(room)).Emit("message", map[string]any{
+				"node": n.name,
+				"text": args[1],
+			})
+		})
+
+		// When the client emits 'local-announce', send the message to the
+		// members of the room on this node only: with the Local flag, the
+		// adapter delivers the broadcast to its own sockets without
+		// forwarding it to the other nodes
+		client.On("local-announce", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			room, ok := args[0].(string)
+			if !ok || room == "" {
+				return
+			}
+			n.server.Local().To(io.Room(room)).Emit("message", map[string]any{
+				"node": n.name,
+				"text": args[1],
+			})
+		})
+
+		// When the client emits 'nodes', reply with the names of every node
+		// of the cluster, this one first
+		client.On("nodes", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			ack, ok := args[len(args)-1].(io.Ack)
+			if !ok {
+				return
+			}
+			_ = n.server.ServerSideEmitWithAck("whois")(func(responses []any, err error) {
+				// On timeout, the nodes that replied are still listed
+				names := []any{n.name}
+				for _, response := range responses {
+					names = append(names, firstArg(response))
+				}
+				ack([]any{names}, nil)
+			})
+		})
+	})
+}
+
+// firstArg returns the first argument of a server-side acknowledgement, which
+// is received as the slice of its arguments.
+func firstArg(response any) any {
+	if args, ok := response.([]any); ok && len(args) > 0 {
+		return args[0]
+	}
+	return response
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// reportBuffer is the number of error reports waiting for the reporter before
+// new ones are dropped.
+const reportBuffer = 256
+
+// Codes of the "app-error" event.
+const (
+	codeValidation = "validation"
+	codeInternal   = "internal"
+)
+
+// validationError is an expected error: the client sent something the
+// handler refuses, and is told why.
+type validationError struct {
+	msg string
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+// invalid returns a validationError.
+func invalid(format string, args ...any) error {
+	return &validationError{msg: fmt.Sprintf(format, args...)}
+}
+
+// classify returns the code and message an error is reported to the client
+// with. Validation errors are sent as they are, while any other error is
+// unexpected, and only logged: its message may leak details of the server.
+func classify(err error) (code, message string) {
+	var invalid *validationError
+	if errors.As(err, &invalid) {
+		return codeValidation, invalid.msg
+	}
+	return codeInternal, "internal error"
+}
+
+// errorReport is an error returned by the handler of an event.
+type errorReport struct {
+	client    *io.Socket
+	event     string
+	requestID string
+	err       error
+}
+
+// reporter funnels the errors of every handler into one goroutine, which logs
+// them and notifies the clients concerned.
+type reporter struct {
+	reports chan errorReport
+	// dropped counts the reports dropped as the buffer was full, and logged
+	// the number of them logged already
+	dropped atomic.Uint64
+	logged  uint64
+}
+
+func newReporter(size int) *reporter {
+	return &reporter{reports: make(chan errorReport, size)}
+}
+
+// report queues an error report without blocking: when the buffer is full,
+// the report is dropped and counted instead, so that an error storm cannot
+// stall the handlers.
+func (r *reporter) report(rep errorReport) {
+	select {
+	case r.reports <- rep:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// run consumes the reports until done is closed. Each one is logged, and the
+// client gets an "app-error" event with {code, message, requestId}.
+func (r *reporter) run(done <-chan struct{}) {
+	for {
+		select {
+		case rep := <-r.reports:
+			r.handle(rep)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (r *reporter) handle(rep errorReport) {
+	if dropped := r.dropped.Load(); dropped != r.logged {
+		log.Printf("%d error reports dropped", dropped-r.logged)
+		r.logged = dropped
+	}
+
+	code, message := classify(rep.err)
+	log.Printf("%s: %q (request %q): %s: %v", rep.client.Id(), rep.event, rep.requestID, code, rep.err)
+	rep.client.Emit("app-error", map[string]any{
+		"code":      code,
+		"message":   message,
+		"requestId": rep.requestID,
+	})
+}
+
+// handle registers fn as the handler of event on client. The error fn returns
+// is reported to r, along with the request id of the event: the "requestId"
+// of its first argument, when it is an object.
+func handle(r *reporter, client *io.Socket, event string, fn func(args ...any) error) {
+	client.On(event, func(args ...any) {
+		err := fn(args...)
+		if err == nil {
+			return
+		}
+		var requestID string
+		if len(args) > 0 {
+			if req, ok := args[0].(map[string]any); ok {
+				requestID, _ = req["requestId"].(string)
+			}
+		}
+		r.report(errorReport{client: client, event: event, requestID: requestID, err: err})
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// setupServer starts the server reporting to errs and returns its address.
+func setupServer(t *testing.T, errs *reporter) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, errs)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// runReporter starts errs until the test ends.
+func runReporter(t *testing.T, errs *reporter) {
+	done := make(chan struct{})
+	go errs.run(done)
+	t.Cleanup(func() { close(done) })
+}
+
+// connectClient connects a client and returns the channel of its "app-error"
+// events. Websocket only, as the polling upgrade occasionally stalls the
+// connection.
+func connectClient(t *testing.T, addr string) (*io_client.Socket, <-chan map[string]any) {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	client := manager.Socket("/", io_client.DefaultSocketOptions())
+	t.Cleanup(func() { client.Disconnect() })
+
+	appErrors := make(chan map[string]any, 100)
+	client.On("app-error", func(args ...any) {
+		if len(args) > 0 {
+			appError, _ := args[0].(map[string]any)
+			appErrors <- appError
+		}
+	})
+
+	connected := make(chan struct{}, 1)
+	client.Once("connect", func(...any) { connected <- struct{}{} })
+	client.Connect()
+
+	select {
+	case <-connected:
+		return client, appErrors
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil, nil
+	}
+}
+
+// expectAppError waits for the next "app-error" event.
+func expectAppError(t *testing.T, appErrors <-chan map[string]any, expected map[string]any) {
+	t.Helper()
+
+	select {
+	case appError := <-appErrors:
+		if !reflect.DeepEqual(appError, expected) {
+			t.Fatalf("expected %v, got %v", expected, appError)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected %v", expected)
+	}
+}
+
+// send emits a valid message and waits for its acknowledgement.
+func send(t *testing.T, client *io_client.Socket, requestID string) {
+	t.Helper()
+
+	acked := make(chan any, 1)
+	client.EmitWithAck("message", map[string]any{"requestId": requestID, "text": "hello"})(func(args []any, _ error) {
+		if len(args) > 0 {
+			acked <- args[0]
+		}
+	})
+	select {
+	case reply := <-acked:
+		if expected := map[string]any{"requestId": requestID}; !reflect.DeepEqual(reply, expected) {
+			t.Fatalf("expected %v, got %v", expected, reply)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("message %s was not acknowledged", requestID)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	errs := newReporter(reportBuffer)
+	runReporter(t, errs)
+	addr := setupServer(t, errs)
+	alice, appErrors := connectClient(t, addr)
+	bob, _ := connectClient(t, addr)
+
+	messages := make(chan any, 1)
+	bob.On("message", func(args ...any) { messages <- args[0] })
+
+	for _, tc := range []struct {
+		name      string
+		args      []any
+		requestID string
+		message   string
+	}{
+		{"no argument", nil, "", "expected {requestId, text}"},
+		{"not an object", []any{"hello"}, "", "expected {requestId, text}"},
+		{"no request id", []any{map[string]any{"text": "hello"}}, "", "requestId is required"},
+		{"text not a string", []any{map[string]any{"requestId": "r1", "text": 42}}, "r1", "text is required"},
+		{"text too long", []any{map[string]any{"requestId": "r2", "text": string(make([]byte, maxText+1))}}, "r2", "text is longer than 500 bytes"},
+	} {
+		alice.Emit("message", tc.args...)
+		expectAppError(t, appErrors, map[string]any{
+			"code":      codeValidation,
+			"message":   tc.message,
+			"requestId": tc.requestID,
+		})
+	}
+
+	// Invalid messages are not relayed, and valid ones are
+	send(t, alice, "r3")
+	select {
+	case message := <-messages:
+		if text := message.(map[string]any)["text"]; text != "hello" {
+			t.Fatalf("expected hello, got %v", message)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the message to be relayed")
+	}
+}
+
+func TestErrorStorm(t *testing.T) {
+	// The reporter only starts after the storm, so that the buffer fills up
+	errs := newReporter(10)
+	addr := setupServer(t, errs)
+	client, appErrors := connectClient(t, addr)
+
+	for i := range 100 {
+		client.Emit("message", map[string]any{"requestId": fmt.Sprint(i)})
+	}
+
+	// The handlers of a socket run in order: had one of the failing ones
+	// blocked, the next message would not be acknowledged
+	send(t, client, "last")
+	if dropped := errs.dropped.Load(); dropped != 90 {
+		t.Fatalf("expected 90 dropped reports, got %d", dropped)
+	}
+
+	runReporter(t, errs)
+	for i := range 10 {
+		expectAppError(t, appErrors, map[string]any{
+			"code":      codeValidation,
+			"message":   "text is required",
+			"requestId": fmt.Sprint(i),
+		})
+	}
+	select {
+	case appError := <-appErrors:
+		t.Fatalf("unexpected app-error %v", appError)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err     error
+		code    string
+		message string
+	}{
+		{invalid("text is required"), codeValidation, "text is required"},
+		{fmt.Errorf("message: %w", invalid("text is required")), codeValidation, "text is required"},
+		{errors.New("connection refused"), codeInternal, "internal error"},
+		{fmt.Errorf("broadcast: %w", errors.New("reserved")), codeInternal, "internal error"},
+	} {
+		if code, message := classify(tc.err); code != tc.code || message != tc.message {
+			t.Errorf("%v: expected %s %q, got %s %q", tc.err, tc.code, tc.message, code, message)
+		}
+	}
+}
+
+// Reports of concurrent handlers are neither lost nor double counted.
+func TestReportConcurrently(t *testing.T) {
+	errs := newReporter(50)
+
+	var wg sync.WaitGroup
+	for range 200 {
+		wg.Go(func() { errs.report(errorReport{err: invalid("x")}) })
+	}
+	wg.Wait()
+
+	if queued, dropped := len(errs.reports), errs.dropped.Load(); queued != 50 || dropped != 150 {
+		t.Fatalf("expected 50 queued and 150 dropped, got %d and %d", queued, dropped)
+	}
+}
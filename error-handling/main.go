@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Error handling example - the handlers return their errors instead of
+// handling them each in its own way, and a single goroutine reports them.
+//
+// Features:
+//   - Handlers registered through handle, which funnels the errors they
+//     return into a buffered channel
+//   - One goroutine logging the errors and sending the client an "app-error"
+//     event with {code, message, requestId}
+//   - Validation errors reported as they are, any other error as an internal
+//     one, whose details stay in the logs
+//   - Reports dropped and counted when the channel is full, so that an error
+//     storm never blocks the handlers
+//
+// The handlers of a socket run one at a time, on the goroutine reading its
+// connection: a handler waiting on a full channel would stall every later
+// event of the client.
+
+func main() {
+	errs := newReporter(reportBuffer)
+	done := make(chan struct{})
+	go errs.run(done)
+
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, errs)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Error handling server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+	close(done)
+}
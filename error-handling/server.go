@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// maxText is the length of the longest message, in bytes.
+const maxText = 500
+
+// newServer creates a Socket.IO server relaying messages between its clients,
+// reporting the errors of its handlers to errs.
+func newServer(httpServer *types.HttpServer, errs *reporter) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'message', with {requestId, text}, send the
+		// text to the other clients and acknowledge the request id
+		handle(errs, client, "message", func(args ...any) error {
+			if len(args) == 0 {
+				return invalid("expected {requestId, text}")
+			}
+			req, ok := args[0].(map[string]any)
+			if !ok {
+				return invalid("expected {requestId, text}")
+			}
+			requestID, _ := req["requestId"].(string)
+			text, ok := req["text"].(string)
+			switch {
+			case requestID == "":
+				return invalid("requestId is required")
+			case !ok || text == "":
+				return invalid("text is required")
+			case len(text) > maxText:
+				return invalid("text is longer than %d bytes", maxText)
+			}
+
+			if err := client.Broadcast().Emit("message", map[string]any{
+				"from": client.Id(),
+				"text": text,
+			}); err != nil {
+				return fmt.Errorf("broadcast: %w", err)
+			}
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{map[string]any{"requestId": requestID}}, nil)
+			}
+			return nil
+		})
+	})
+	return server
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The limits of the uploads the server accepts.
+const (
+	maxFileSize  = 1 << 30
+	maxChunkSize = 512 << 10
+)
+
+// assembly is a file being uploaded, written to a temporary file of dir as
+// its chunks arrive, in any order.
+type assembly struct {
+	dir       string
+	name      string
+	size      int64
+	chunkSize int64
+	file      *os.File
+	received  []bool
+	missing   int
+}
+
+// newAssembly starts the upload of the file name, of size bytes sent in
+// chunks of chunkSize bytes, the last one being shorter.
+func newAssembly(dir, name string, size, chunkSize int64) (*assembly, error) {
+	// Only the base name is kept, so that the file cannot escape dir
+	name = filepath.Base(filepath.Clean("/" + name))
+	switch {
+	case name == "/" || name == ".":
+		return nil, errors.New("invalid file name")
+	case size < 0 || size > maxFileSize:
+		return nil, fmt.Errorf("size must be between 0 and %d bytes", maxFileSize)
+	case chunkSize <= 0 || chunkSize > maxChunkSize:
+		return nil, fmt.Errorf("chunk size must be between 1 and %d bytes", maxChunkSize)
+	}
+
+	file, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	chunks := int((size + chunkSize - 1) / chunkSize)
+	return &assembly{
+		dir:       dir,
+		name:      name,
+		size:      size,
+		chunkSize: chunkSize,
+		file:      file,
+		received:  make([]bool, chunks),
+		missing:   chunks,
+	}, nil
+}
+
+// chunks returns the number of chunks of the file.
+func (a *assembly) chunks() int {
+	return len(a.received)
+}
+
+// write writes the chunk index, and reports whether it had already been
+// received, in which case it must be identical to the first copy.
+func (a *assembly) write(index int, data []byte) (duplicate bool, err error) {
+	if index < 0 || index >= a.chunks() {
+		return false, fmt.Errorf("chunk index %d out of range [0, %d)", index, a.chunks())
+	}
+	offset := int64(index) * a.chunkSize
+	if expected := min(a.chunkSize, a.size-offset); int64(len(data)) != expected {
+		return false, fmt.Errorf("chunk %d has %d bytes, expected %d", index, len(data), expected)
+	}
+
+	// A chunk sent again, usually because its acknowledgement was lost, is
+	// acknowledged again without being written twice
+	if a.received[index] {
+		written := make([]byte, len(data))
+		if _, err := a.file.ReadAt(written, offset); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(written, data) {
+			return false, fmt.Errorf("chunk %d differs from the one already received", index)
+		}
+		return true, nil
+	}
+
+	if _, err := a.file.WriteAt(data, offset); err != nil {
+		return false, err
+	}
+	a.received[index] = true
+	a.missing--
+	return false, nil
+}
+
+// finish checks that every chunk was received and that the SHA-256 of the
+// file matches checksum, if not empty, then moves the file to its name in
+// dir and returns its SHA-256. Only missing chunks leave the upload in
+// progress, it is aborted on any other error.
+func (a *assembly) finish(checksum string) (string, error) {
+	if a.missing > 0 {
+		for index, received := range a.received {
+			if !received {
+				return "", fmt.Errorf("%d chunks missing, starting with %d", a.missing, index)
+			}
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(a.file, 0, a.size)); err != nil {
+		a.abort()
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && checksum != sum {
+		a.abort()
+		return "", fmt.Errorf("checksum mismatch: got %s, expected %s", sum, checksum)
+	}
+
+	if err := a.file.Close(); err != nil {
+		a.abort()
+		return "", err
+	}
+	if err := os.Rename(a.file.Name(), filepath.Join(a.dir, a.name)); err != nil {
+		a.abort()
+		return "", err
+	}
+	return sum, nil
+}
+
+// abort removes the temporary file.
+func (a *assembly) abort() {
+	a.file.Close()
+	os.Remove(a.file.Name())
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// The defaults of the upload command.
+const (
+	defaultChunkSize  = 64 << 10
+	defaultInFlight   = 8
+	defaultAckTimeout = 5 * time.Second
+	defaultAttempts   = 3
+)
+
+// errAckTimeout is returned when an acknowledgement does not arrive in time.
+var errAckTimeout = errors.New("acknowledgement timed out")
+
+// runUpload uploads the file given as argument and prints its SHA-256, as
+// acknowledged by the server.
+func runUpload(args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:3000", "server URL")
+	chunkSize := fs.Int("chunk-size", defaultChunkSize, "size of the chunks, in bytes")
+	inFlight := fs.Int("in-flight", defaultInFlight, "number of chunks awaiting their acknowledgement at most")
+	ackTimeout := fs.Duration("ack-timeout", defaultAckTimeout, "how long to wait for an acknowledgement before sending a chunk again")
+	attempts := fs.Int("attempts", defaultAttempts, "number of times a chunk is sent at most")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected the file to upload")
+	}
+	if *chunkSize <= 0 || *inFlight <= 0 || *attempts <= 0 {
+		return errors.New("chunk-size, in-flight and attempts must be positive")
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Binary attachments travel as is over websocket, while polling would
+	// encode them in base64
+	opts := io_client.DefaultOptions()
+	opts.SetTransports(types.NewSet(io_client.WebSocket))
+	client, err := io_client.Connect(*url, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	connected := make(chan error, 1)
+	client.Once("connect", func(...any) { connected <- nil })
+	client.Once("connect_error", func(args ...any) { connected <- fmt.Errorf("failed to connect to %s: %v", *url, args) })
+	select {
+	case err := <-connected:
+		if err != nil {
+			return err
+		}
+	case <-time.After(*ackTimeout):
+		return fmt.Errorf("failed to connect to %s: timed out", *url)
+	}
+
+	u := &uploader{
+		socket:     client,
+		chunkSize:  *chunkSize,
+		inFlight:   *inFlight,
+		ackTimeout: *ackTimeout,
+		attempts:   *attempts,
+	}
+	sum, err := u.upload(filepath.Base(file.Name()), file, info.Size())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s  %s\n", sum, file.Name())
+	return nil
+}
+
+// ackEmitter emits events with an acknowledgement callback, as
+// *io_client.Socket does.
+type ackEmitter interface {
+	EmitWithAck(ev string, args ...any) func(io.Ack)
+}
+
+// uploader uploads files in chunks, with at most inFlight chunks awaiting
+// their acknowledgement, each sent up to attempts times.
+type uploader struct {
+	socket     ackEmitter
+	chunkSize  int
+	inFlight   int
+	ackTimeout time.Duration
+	attempts   int
+}
+
+// upload sends the size bytes of r as the file name, and returns the SHA-256
+// acknowledged by the server, once checked against its own.
+func (u *uploader) upload(name string, r interface {
+	ReadAt([]byte, int64) (int, error)
+}, size int64) (string, error) {
+	if _, err := u.call("upload-start", name, size, u.chunkSize); err != nil {
+		return "", fmt.Errorf("upload-start: %w", err)
+	}
+
+	var (
+		hash    = sha256.New()
+		slots   = make(chan struct{}, u.inFlight)
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		failed  = make(chan struct{})
+		sendErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			sendErr = err
+			close(failed)
+		})
+	}
+
+	// The chunks are read in order, for the hash, and acknowledged in any
+	// order
+send:
+	for index, offset := 0, int64(0); offset < size; index, offset = index+1, offset+int64(u.chunkSize) {
+		select {
+		case slots <- struct{}{}:
+		case <-failed:
+			break send
+		}
+
+		chunk := make([]byte, min(int64(u.chunkSize), size-offset))
+		if _, err := r.ReadAt(chunk, offset); err != nil {
+			<-slots
+			fail(err)
+			break
+		}
+		hash.Write(chunk)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := u.sendChunk(index, chunk); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if sendErr != nil {
+		return "", sendErr
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	result, err := u.call("upload-end", sum)
+	if err != nil {
+		return "", fmt.Errorf("upload-end: %w", err)
+	}
+	if acknowledged, _ := result["sha256"].(string); acknowledged != sum {
+		return "", fmt.Errorf("server stored sha256 %s, expected %s", acknowledged, sum)
+	}
+	return sum, nil
+}
+
+// sendChunk sends the chunk index until it is acknowledged. The server
+// acknowledges a chunk sent again without writing it twice, so a chunk whose
+// acknowledgement is lost is simply sent again.
+func (u *uploader) sendChunk(index int, chunk []byte) error {
+	var err error
+	for range u.attempts {
+		if _, err = u.call("upload-chunk", index, chunk); !errors.Is(err, errAckTimeout) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("chunk %d: %w", index, err)
+	}
+	return nil
+}
+
+// call emits ev with args and waits for its acknowledgement, returning the
+// object it carries, or the error reported by the server.
+func (u *uploader) call(ev string, args ...any) (map[string]any, error) {
+	// Buffered, as the acknowledgement may arrive after the timeout
+	acked := make(chan []any, 1)
+	u.socket.EmitWithAck(ev, args...)(func(reply []any, _ error) {
+		acked <- reply
+	})
+
+	select {
+	case reply := <-acked:
+		var result map[string]any
+		if len(reply) > 0 {
+			result, _ = reply[0].(map[string]any)
+		}
+		if message, ok := result["error"].(string); ok {
+			return nil, errors.New(message)
+		}
+		return result, nil
+	case <-time.After(u.ackTimeout):
+		return nil, errAckTimeout
+	}
+}
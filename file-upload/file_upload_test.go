@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// setupServer starts a server storing the uploads in a temporary directory,
+// and returns its address and the directory.
+func setupServer(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String(), dir
+}
+
+// connectClient connects a websocket client to the server at addr.
+func connectClient(t *testing.T, addr string) *io_client.Socket {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	client := manager.Socket("/", io_client.DefaultSocketOptions())
+	t.Cleanup(func() { client.Disconnect() })
+
+	connected := make(chan struct{}, 1)
+	client.Once("connect", func(...any) { connected <- struct{}{} })
+	client.Connect()
+
+	select {
+	case <-connected:
+		return client
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// randomFile returns size random bytes and their SHA-256.
+func randomFile(t *testing.T, size int) ([]byte, string) {
+	t.Helper()
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:])
+}
+
+// checkStored checks that the file name of dir holds data, and that no
+// temporary file is left behind.
+func checkStored(t *testing.T, dir, name string, data []byte) {
+	t.Helper()
+
+	stored, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored file differs from the uploaded one")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only %s in the upload directory, got %d entries", name, len(entries))
+	}
+}
+
+// lossyEmitter drops the first acknowledgement of the chunk index, as if it
+// had been lost on the way.
+type lossyEmitter struct {
+	ackEmitter
+	index int
+
+	mu      sync.Mutex
+	dropped bool
+	sent    map[int]int
+}
+
+func (l *lossyEmitter) EmitWithAck(ev string, args ...any) func(io.Ack) {
+	withAck := l.ackEmitter.EmitWithAck(ev, args...)
+	if ev != "upload-chunk" {
+		return withAck
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	index := args[0].(int)
+	l.sent[index]++
+	if index != l.index || l.dropped {
+		return withAck
+	}
+	l.dropped = true
+	return func(io.Ack) {
+		withAck(func([]any, error) {})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	addr, dir := setupServer(t)
+	client := connectClient(t, addr)
+	data, sum := randomFile(t, 2<<20)
+
+	u := &uploader{
+		socket:     client,
+		chunkSize:  defaultChunkSize,
+		inFlight:   defaultInFlight,
+		ackTimeout: defaultAckTimeout,
+		attempts:   defaultAttempts,
+	}
+	got, err := u.upload("data.bin", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != sum {
+		t.Fatalf("expected sha256 %s, got %s", sum, got)
+	}
+	checkStored(t, dir, "data.bin", data)
+}
+
+func TestUploadLostAck(t *testing.T) {
+	addr, dir := setupServer(t)
+	client := connectClient(t, addr)
+	data, sum := randomFile(t, 2<<20)
+
+	lossy := &lossyEmitter{ackEmitter: client, index: 5, sent: map[int]int{}}
+	u := &uploader{
+		socket:     lossy,
+		chunkSize:  defaultChunkSize,
+		inFlight:   defaultInFlight,
+		ackTimeout: 500 * time.Millisecond,
+		attempts:   defaultAttempts,
+	}
+	got, err := u.upload("data.bin", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != sum {
+		t.Fatalf("expected sha256 %s, got %s", sum, got)
+	}
+	checkStored(t, dir, "data.bin", data)
+
+	lossy.mu.Lock()
+	defer lossy.mu.Unlock()
+	for index, sent := range lossy.sent {
+		if expected := map[bool]int{true: 2, false: 1}[index == lossy.index]; sent != expected {
+			t.Errorf("chunk %d sent %d times, expected %d", index, sent, expected)
+		}
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	addr, _ := setupServer(t)
+	client := connectClient(t, addr)
+	u := &uploader{socket: client, ackTimeout: defaultAckTimeout}
+
+	for _, tc := range []struct {
+		name string
+		ev   string
+		args []any
+		err  string
+	}{
+		{"chunk before start", "upload-chunk", []any{0, []byte("a")}, "no upload in progress"},
+		{"escaping name", "upload-start", []any{"../", 10, 4}, "invalid file name"},
+		{"oversized chunk", "upload-start", []any{"a.txt", 10, maxChunkSize + 1}, "chunk size must be between"},
+		{"start", "upload-start", []any{"a.txt", 10, 4}, ""},
+		{"out of range", "upload-chunk", []any{3, []byte("ab")}, "out of range"},
+		{"short chunk", "upload-chunk", []any{0, []byte("ab")}, "has 2 bytes, expected 4"},
+		{"last chunk first", "upload-chunk", []any{2, []byte("ij")}, ""},
+		{"first chunk", "upload-chunk", []any{0, []byte("abcd")}, ""},
+		{"missing chunk", "upload-end", []any{""}, "1 chunks missing, starting with 1"},
+		{"conflicting duplicate", "upload-chunk", []any{0, []byte("abcX")}, "differs from the one already received"},
+		{"middle chunk", "upload-chunk", []any{1, []byte("efgh")}, ""},
+		{"wrong checksum", "upload-end", []any{strings.Repeat("0", 64)}, "checksum mismatch"},
+		{"discarded", "upload-end", []any{""}, "no upload in progress"},
+	} {
+		_, err := u.call(tc.ev, tc.args...)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Fatalf("%s: %v", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Fatalf("%s: expected error %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
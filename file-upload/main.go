@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// File upload example - a client streams a file to the server in binary
+// chunks, each acknowledged, and the server assembles and verifies it.
+//
+// Features:
+//   - A server writing each chunk at its offset, so that chunks may arrive out
+//     of order, and acknowledging duplicates without writing them twice
+//   - A final acknowledgement carrying the SHA-256 of the assembled file,
+//     checked against the one of the client
+//   - A client sending 64KB chunks with a bounded number awaiting their
+//     acknowledgement, retrying the ones whose acknowledgement is lost
+//
+// Usage:
+//
+//	go run . server -addr :3000 -dir uploads
+//	go run . upload -url http://localhost:3000 photo.jpg
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "server":
+		err = runServer(os.Args[2:])
+	case "upload":
+		err = runUpload(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: file-upload server [flags]")
+	fmt.Fprintln(os.Stderr, "       file-upload upload [flags] file")
+	os.Exit(2)
+}
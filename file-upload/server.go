@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"sync"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// runServer runs a Socket.IO server storing the uploaded files in a directory,
+// until interrupted.
+func runServer(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	addr := fs.String("addr", ":3000", "listen address")
+	dir := fs.String("dir", "uploads", "directory the uploaded files are stored in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, *dir)
+
+	httpServer.Listen(*addr, nil)
+	fmt.Printf("File upload server listening on %s, storing files in %s\n", *addr, *dir)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+	return nil
+}
+
+// newServer creates a Socket.IO server storing the files its clients upload
+// in dir, one upload at a time per client.
+func newServer(httpServer *types.HttpServer, dir string) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		var (
+			mu      sync.Mutex
+			current *assembly
+		)
+
+		// When the client emits 'upload-start', with the name, size and chunk
+		// size of the file, replace its upload in progress, if any
+		client.On("upload-start", func(args ...any) {
+			ack, args := splitAck(args)
+			if len(args) < 3 {
+				reply(ack, nil, errors.New("expected a name, a size and a chunk size"))
+				return
+			}
+			name, _ := args[0].(string)
+			size, sizeOk := integer(args[1])
+			chunkSize, chunkOk := integer(args[2])
+			if !sizeOk || !chunkOk {
+				reply(ack, nil, errors.New("size and chunk size must be integers"))
+				return
+			}
+
+			upload, err := newAssembly(dir, name, size, chunkSize)
+			if err != nil {
+				reply(ack, nil, err)
+				return
+			}
+			mu.Lock()
+			if current != nil {
+				current.abort()
+			}
+			current = upload
+			mu.Unlock()
+			reply(ack, map[string]any{"chunks": upload.chunks()}, nil)
+		})
+
+		// When the client emits 'upload-chunk', with the index of the chunk
+		// and its bytes, write it at its offset
+		client.On("upload-chunk", func(args ...any) {
+			ack, args := splitAck(args)
+			if len(args) < 2 {
+				reply(ack, nil, errors.New("expected an index and the chunk"))
+				return
+			}
+			index, ok := integer(args[0])
+			if !ok || index > math.MaxInt32 {
+				reply(ack, nil, errors.New("index must be an integer"))
+				return
+			}
+			data, ok := binary(args[1])
+			if !ok {
+				reply(ack, nil, errors.New("chunk must be binary"))
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if current == nil {
+				reply(ack, nil, errors.New("no upload in progress"))
+				return
+			}
+			duplicate, err := current.write(int(index), data)
+			if err != nil {
+				reply(ack, nil, err)
+				return
+			}
+			reply(ack, map[string]any{"index": index, "duplicate": duplicate}, nil)
+		})
+
+		// When the client emits 'upload-end', with the SHA-256 of the file,
+		// check the assembled file and store it
+		client.On("upload-end", func(args ...any) {
+			ack, args := splitAck(args)
+			checksum := ""
+			if len(args) > 0 {
+				checksum, _ = args[0].(string)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if current == nil {
+				reply(ack, nil, errors.New("no upload in progress"))
+				return
+			}
+			upload := current
+			sum, err := upload.finish(checksum)
+			if err != nil {
+				// The missing chunks can still be sent, while a mismatching
+				// file is discarded
+				if upload.missing == 0 {
+					current = nil
+				}
+				reply(ack, nil, err)
+				return
+			}
+			current = nil
+			log.Printf("Stored %s, %d bytes, sha256 %s", upload.name, upload.size, sum)
+			reply(ack, map[string]any{"name": upload.name, "size": upload.size, "sha256": sum}, nil)
+		})
+
+		// An upload left unfinished is discarded
+		client.On("disconnect", func(...any) {
+			mu.Lock()
+			defer mu.Unlock()
+			if current != nil {
+				current.abort()
+				current = nil
+			}
+		})
+	})
+	return server
+}
+
+// splitAck separates the acknowledgement callback, if any, from the arguments
+// of an event.
+func splitAck(args []any) (io.Ack, []any) {
+	if len(args) > 0 {
+		if ack, ok := args[len(args)-1].(io.Ack); ok {
+			return ack, args[:len(args)-1]
+		}
+	}
+	return nil, args
+}
+
+// reply acknowledges an event with result, or with {"error": message} when err
+// is not nil, as the error of an io.Ack is not sent to the client.
+func reply(ack io.Ack, result map[string]any, err error) {
+	if ack == nil {
+		return
+	}
+	if err != nil {
+		result = map[string]any{"error": err.Error()}
+	}
+	ack([]any{result}, nil)
+}
+
+// integer returns the JSON number v as an integer, if it is one.
+func integer(v any) (int64, bool) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// binary returns the bytes of an attachment, decoded as a buffer.
+func binary(v any) ([]byte, bool) {
+	switch data := v.(type) {
+	case []byte:
+		return data, true
+	case types.BufferInterface:
+		return data.Bytes(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"net/http"
+
+	"github.com/zishang520/socket.io/servers/engine/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Headers set by the hooks.
+const (
+	// instanceHeader is set on the handshake responses only
+	instanceHeader = "X-Instance-Id"
+	// servedByHeader is set on every response
+	servedByHeader = "X-Served-By"
+	// visitorCookie is set on the handshake responses only
+	visitorCookie = "visitor"
+)
+
+// addHeaderHooks makes the engine add headers to its responses, for the
+// instance instanceID:
+//
+//   - "initial_headers" is emitted for the response of a handshake, the first
+//     request of a session, and is the place for what is set once per session,
+//     such as a cookie or the instance that owns the session, for sticky
+//     sessions
+//   - "headers" is emitted for every polling response, the handshake included,
+//     and is the place for what each response carries, such as the instance
+//     that served it
+//
+// Both hooks get the headers of the response and the context of the request,
+// and are emitted by the polling transport only. The engine sends the
+// response headers of the request when it upgrades a websocket, so a
+// middleware, run before the upgrade, applies the same headers to the
+// websocket requests, see websocketHeaders.
+func addHeaderHooks(e engine.BaseServer, instanceID string) {
+	_ = e.On("initial_headers", func(args ...any) {
+		headers, ctx := hookArgs(args)
+		// The engine checks the request of the handshake rather than the
+		// current one, and emits "initial_headers" for every response of the
+		// polling transport the session started with: skip the requests of
+		// a session, which carry its id
+		if headers == nil || ctx.Query().Has("sid") {
+			return
+		}
+		setInitialHeaders(headers, instanceID)
+	})
+
+	_ = e.On("headers", func(args ...any) {
+		if headers, _ := hookArgs(args); headers != nil {
+			setHeaders(headers, instanceID)
+		}
+	})
+
+	e.Use(websocketHeaders(instanceID))
+}
+
+// websocketHeaders returns an engine middleware setting the headers of the
+// hooks on the websocket requests: the initial headers on the handshakes,
+// without a session id, and the others on every request, the upgrades of a
+// polling session included.
+func websocketHeaders(instanceID string) engine.Middleware {
+	return func(ctx *types.HttpContext, next func(error)) {
+		if ctx.Query().Peek("transport") == "websocket" {
+			if !ctx.Query().Has("sid") {
+				setInitialHeaders(ctx.ResponseHeaders(), instanceID)
+			}
+			setHeaders(ctx.ResponseHeaders(), instanceID)
+		}
+		next(nil)
+	}
+}
+
+// hookArgs returns the response headers and the request context of the
+// arguments of a header hook.
+func hookArgs(args []any) (*types.ParameterBag, *types.HttpContext) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	headers, _ := args[0].(*types.ParameterBag)
+	ctx, _ := args[1].(*types.HttpContext)
+	if ctx == nil {
+		return nil, nil
+	}
+	return headers, ctx
+}
+
+// setInitialHeaders sets the headers of a handshake response. Set-Cookie is
+// added rather than set, so as to keep the cookie of the engine's Cookie
+// option.
+func setInitialHeaders(headers *types.ParameterBag, instanceID string) {
+	headers.Set(instanceHeader, instanceID)
+	cookie := &http.Cookie{
+		Name:     visitorCookie,
+		Value:    rand.Text(),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	headers.Add("Set-Cookie", cookie.String())
+}
+
+// setHeaders sets the headers of every response.
+func setHeaders(headers *types.ParameterBag, instanceID string) {
+	headers.Set(servedByHeader, instanceID)
+}
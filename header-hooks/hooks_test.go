@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+const testInstance = "instance-1"
+
+// setupServer starts the server and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, testInstance)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// poll sends a polling request and returns the response and its body.
+func poll(t *testing.T, method, url, body string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: expected 200, got %d: %s", method, url, resp.StatusCode, data)
+	}
+	return resp, string(data)
+}
+
+// expectHeaders checks the headers of the hooks on the response of name:
+// both, and the visitor cookie, when initial, only X-Served-By otherwise.
+func expectHeaders(t *testing.T, name string, header http.Header, initial bool) {
+	t.Helper()
+
+	if served := header.Get(servedByHeader); served != testInstance {
+		t.Errorf("%s: expected %s %s, got %q", name, servedByHeader, testInstance, served)
+	}
+	var visitor *http.Cookie
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		if cookie.Name == visitorCookie {
+			visitor = cookie
+		}
+	}
+	if !initial {
+		if instance := header.Get(instanceHeader); instance != "" {
+			t.Errorf("%s: expected no %s, got %q", name, instanceHeader, instance)
+		}
+		if visitor != nil {
+			t.Errorf("%s: expected no visitor cookie, got %v", name, visitor)
+		}
+		return
+	}
+	if instance := header.Get(instanceHeader); instance != testInstance {
+		t.Errorf("%s: expected %s %s, got %q", name, instanceHeader, testInstance, instance)
+	}
+	if visitor == nil || visitor.Value == "" || !visitor.HttpOnly {
+		t.Errorf("%s: expected an HttpOnly visitor cookie, got %v", name, header.Values("Set-Cookie"))
+	}
+}
+
+func TestPollingHeaders(t *testing.T) {
+	addr := setupServer(t)
+	url := "http://" + addr + "/socket.io/?EIO=4&transport=polling"
+
+	resp, body := poll(t, http.MethodGet, url, "")
+	expectHeaders(t, "handshake", resp.Header, true)
+	var handshake struct {
+		Sid string `json:"sid"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(body, "0")), &handshake); err != nil {
+		t.Fatalf("expected an open packet, got %q", body)
+	}
+	url += "&sid=" + handshake.Sid
+
+	// The requests of the session only get the headers of every response
+	resp, _ = poll(t, http.MethodPost, url, "40")
+	expectHeaders(t, "post", resp.Header, false)
+	resp, body = poll(t, http.MethodGet, url, "")
+	if !strings.HasPrefix(body, "40") {
+		t.Fatalf("expected a CONNECT packet, got %q", body)
+	}
+	expectHeaders(t, "get", resp.Header, false)
+
+	// The upgrade of the session is not a handshake either
+	conn, resp, err := websocket.DefaultDialer.Dial("ws://"+addr+"/socket.io/?EIO=4&transport=websocket&sid="+handshake.Sid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	expectHeaders(t, "upgrade", resp.Header, false)
+}
+
+func TestWebsocketHandshakeHeaders(t *testing.T) {
+	addr := setupServer(t)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws://"+addr+"/socket.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectHeaders(t, "websocket handshake", resp.Header, true)
+
+	// The hooks do not get in the way of the session
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("40")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) == `42["instance","`+testInstance+`"]` {
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Header hooks example - adds headers to the HTTP responses of the engine,
+// through its "initial_headers" and "headers" events.
+//
+// Features:
+//   - X-Instance-Id and a visitor cookie on the handshake responses only
+//   - X-Served-By on every polling response
+//   - The same headers on the websocket handshakes and upgrades, through an
+//     engine middleware, since the hooks only cover polling
+//
+// The instance id is INSTANCE_ID, or the host name.
+
+func main() {
+	instanceID := os.Getenv("INSTANCE_ID")
+	if instanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("Failed to get the host name: %v", err)
+		}
+		instanceID = hostname
+	}
+
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, instanceID)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Header hooks server %s listening on %s\n", instanceID, addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// newServer creates a Socket.IO server adding the headers of the instance
+// instanceID to its responses.
+func newServer(httpServer *types.HttpServer, instanceID string) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	addHeaderHooks(server.Engine(), instanceID)
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// Tell the client which instance it reached
+		client.Emit("instance", instanceID)
+	})
+	return server
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// Codes of the CONNECT_ERROR data of the rejected clients.
+const (
+	codeTokenExpired = "token_expired"
+	codeTokenInvalid = "token_invalid"
+)
+
+// leeway tolerates the clock skew between the issuer and the server when
+// checking exp and nbf.
+const leeway = 5 * time.Second
+
+// claims are the claims of the tokens, stored on the socket once verified.
+type claims struct {
+	Name string `json:"name,omitempty"`
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// tokenOf returns the token of the handshake: the "token" of the auth, or the
+// bearer token of the Authorization header, for clients that cannot set the
+// auth, such as command line tools.
+func tokenOf(client *io.Socket) string {
+	if token, _ := client.Handshake().Auth["token"].(string); token != "" {
+		return token
+	}
+	scheme, token, ok := strings.Cut(client.Request().Headers().Peek("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// authenticate returns a middleware verifying the token of each client with
+// key, and storing its claims on the socket. The clients are rejected with the
+// data {"code": "token_expired"} when their token expired, which they can
+// renew, and {"code": "token_invalid"} for anything else: no token, a
+// malformed one, a wrong signature or a token not valid yet.
+func authenticate(key []byte) io.NamespaceMiddleware {
+	parser := jwt.NewParser(
+		// Only HMAC, so that a token cannot pick another algorithm, such as
+		// "none", or RS256 with key as the public key
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	)
+	keyFunc := func(*jwt.Token) (any, error) { return key, nil }
+
+	return func(client *io.Socket, next func(*io.ExtendedError)) {
+		token := tokenOf(client)
+		if token == "" {
+			next(io.NewExtendedError("no token", map[string]any{"code": codeTokenInvalid}))
+			return
+		}
+
+		var c claims
+		if _, err := parser.ParseWithClaims(token, &c, keyFunc); err != nil {
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				next(io.NewExtendedError("token expired", map[string]any{"code": codeTokenExpired}))
+				return
+			}
+			next(io.NewExtendedError("invalid token", map[string]any{"code": codeTokenInvalid}))
+			return
+		}
+
+		client.SetData(&c)
+		next(nil)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef0123456789abcdef")
+	otherKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// setupServer starts the server and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, testKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// sign returns a token for alice signed with key, valid from nbf to exp.
+func sign(t *testing.T, key []byte, nbf, exp time.Time) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		Name: "Alice",
+		Role: "admin",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "alice",
+			NotBefore: jwt.NewNumericDate(nbf),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// dial connects a client with auth and the extra handshake headers, and
+// returns the error of the connection. Websocket only, as the polling upgrade
+// occasionally stalls the connection: the Go client sends the extra headers
+// on the websocket handshake too.
+func dial(t *testing.T, addr string, auth map[string]any, headers http.Header) (*io_client.Socket, error) {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+	if headers != nil {
+		managerOpts.SetExtraHeaders(headers)
+	}
+
+	sockOpts := io_client.DefaultSocketOptions()
+	if auth != nil {
+		sockOpts.SetAuth(auth)
+	}
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	client := manager.Socket("/", sockOpts)
+	t.Cleanup(func() { client.Disconnect() })
+
+	connected := make(chan error, 1)
+	client.Once("connect", func(...any) { connected <- nil })
+	client.Once("connect_error", func(args ...any) {
+		err, _ := args[0].(error)
+		connected <- err
+	})
+	client.Connect()
+
+	select {
+	case err := <-connected:
+		return client, err
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil, nil
+	}
+}
+
+// whoami returns the identity the server acknowledges.
+func whoami(t *testing.T, client *io_client.Socket) map[string]any {
+	t.Helper()
+
+	reply := make(chan map[string]any, 1)
+	client.EmitWithAck("whoami")(func(args []any, _ error) {
+		identity, _ := args[0].(map[string]any)
+		reply <- identity
+	})
+
+	select {
+	case identity := <-reply:
+		return identity
+	case <-time.After(3 * time.Second):
+		t.Fatal("whoami was not acknowledged")
+		return nil
+	}
+}
+
+func TestValidToken(t *testing.T) {
+	addr := setupServer(t)
+	now := time.Now()
+	token := sign(t, testKey, now, now.Add(time.Hour))
+
+	for name, dialToken := range map[string]func() (*io_client.Socket, error){
+		"auth": func() (*io_client.Socket, error) {
+			return dial(t, addr, map[string]any{"token": token}, nil)
+		},
+		"header": func() (*io_client.Socket, error) {
+			return dial(t, addr, nil, http.Header{"Authorization": {"Bearer " + token}})
+		},
+	} {
+		client, err := dialToken()
+		if err != nil {
+			t.Fatalf("%s: expected the token to be accepted, got %v", name, err)
+		}
+		identity := whoami(t, client)
+		if identity["sub"] != "alice" || identity["name"] != "Alice" || identity["role"] != "admin" {
+			t.Fatalf("%s: expected the claims of alice, got %v", name, identity)
+		}
+		if identity["exp"] != float64(now.Add(time.Hour).Unix()) {
+			t.Fatalf("%s: expected exp %d, got %v", name, now.Add(time.Hour).Unix(), identity["exp"])
+		}
+	}
+}
+
+func TestRejectedTokens(t *testing.T) {
+	addr := setupServer(t)
+	now := time.Now()
+
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noExp, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "alice"}).SignedString(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		auth    map[string]any
+		headers http.Header
+		code    string
+	}{
+		{"expired", map[string]any{"token": sign(t, testKey, now.Add(-2*time.Hour), now.Add(-time.Hour))}, nil, codeTokenExpired},
+		{"expired in the header", nil, http.Header{"Authorization": {"Bearer " + sign(t, testKey, now.Add(-2*time.Hour), now.Add(-time.Hour))}}, codeTokenExpired},
+		{"wrong key", map[string]any{"token": sign(t, otherKey, now, now.Add(time.Hour))}, nil, codeTokenInvalid},
+		{"expired with a wrong key", map[string]any{"token": sign(t, otherKey, now.Add(-2*time.Hour), now.Add(-time.Hour))}, nil, codeTokenInvalid},
+		{"not valid yet", map[string]any{"token": sign(t, testKey, now.Add(time.Hour), now.Add(2*time.Hour))}, nil, codeTokenInvalid},
+		{"malformed", map[string]any{"token": "not.a.jwt"}, nil, codeTokenInvalid},
+		{"alg none", map[string]any{"token": none}, nil, codeTokenInvalid},
+		{"no exp", map[string]any{"token": noExp}, nil, codeTokenInvalid},
+		{"no token", nil, nil, codeTokenInvalid},
+		{"basic auth header", nil, http.Header{"Authorization": {"Basic YWxpY2U6c2VjcmV0"}}, codeTokenInvalid},
+	} {
+		_, err := dial(t, addr, tc.auth, tc.headers)
+		extended, ok := err.(*types.ExtendedError)
+		if !ok {
+			t.Errorf("%s: expected a connect error, got %v", tc.name, err)
+			continue
+		}
+		if data, _ := extended.Data.(map[string]any); data["code"] != tc.code {
+			t.Errorf("%s: expected %s, got %v (%s)", tc.name, tc.code, extended.Data, extended.Message)
+		}
+	}
+}
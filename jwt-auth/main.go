@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// JWT authentication example - verifies a JSON Web Token in a middleware
+// before accepting a client, and gives the handlers its claims.
+//
+// Features:
+//   - Token read from the handshake auth, or from an "Authorization: Bearer"
+//     header for clients that cannot set the auth
+//   - HS256 signature verified against the key of -secret, and exp and nbf
+//     checked
+//   - CONNECT_ERROR data telling an expired token, {"code":"token_expired"},
+//     from an invalid one, {"code":"token_invalid"}
+//   - Claims stored on the socket, read back with "whoami"
+//
+// Usage:
+//
+//	go run . -secret "$JWT_SECRET"
+
+func main() {
+	addr := flag.String("addr", ":3000", "listen address")
+	secret := flag.String("secret", os.Getenv("JWT_SECRET"), "HMAC key the tokens are signed with (env JWT_SECRET)")
+	flag.Parse()
+
+	if err := run(*addr, *secret); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(addr, secret string) error {
+	// RFC 7518 requires a key at least as long as the hash, 256 bits
+	if len(secret) < 32 {
+		return errors.New("secret must be at least 32 bytes long")
+	}
+
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, []byte(secret))
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("JWT authentication server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+	return nil
+}
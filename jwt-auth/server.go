@@ -0,0 +1,53 @@
+package main
+
+import (
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// newServer creates a Socket.IO server accepting the clients with a token
+// signed with key.
+func newServer(httpServer *types.HttpServer, key []byte) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	server.Use(authenticate(key))
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+		c, ok := client.Data().(*claims)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'whoami', acknowledge with the claims of its
+		// token
+		client.On("whoami", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			ack, ok := args[len(args)-1].(io.Ack)
+			if !ok {
+				return
+			}
+			identity := map[string]any{
+				"id":   client.Id(),
+				"sub":  c.Subject,
+				"name": c.Name,
+				"role": c.Role,
+			}
+			if c.ExpiresAt != nil {
+				identity["exp"] = c.ExpiresAt.Unix()
+			}
+			ack([]any{identity}, nil)
+		})
+	})
+	return server
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// registerAdminEvents registers the inspection events of the /admin
+// namespace on an admin socket. They act on the main namespace:
+//
+//   - "sockets" acknowledges with the id, namespace, rooms and handshake
+//     address of every connected socket, as returned by FetchSockets
+//   - "rooms" acknowledges with the number of sockets in each room of the
+//     adapter, including the private room of every socket
+//   - "kick" takes a socket id, disconnects that socket and closes its
+//     connection, like Disconnect(true), then acknowledges with whether the
+//     socket was found
+//   - "emit" takes a room or socket id, an event name and its arguments,
+//     emits the event to that room, and acknowledges with the number of
+//     recipients, or with an error when the room is empty or the event name
+//     reserved
+//   - "stats" acknowledges with the number of sockets, shared rooms and
+//     Engine.IO clients, and the goroutines and heap of the process
+func registerAdminEvents(server *io.Server, client *io.Socket) {
+	nsp := server.Sockets()
+
+	client.On("sockets", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		ack, ok := args[len(args)-1].(func([]any, error))
+		if !ok {
+			return
+		}
+
+		server.FetchSockets()(func(sockets []*io.RemoteSocket, err error) {
+			if err != nil {
+				ack(nil, err)
+				return
+			}
+			list := make([]map[string]any, 0, len(sockets))
+			for _, s := range sockets {
+				list = append(list, map[string]any{
+					"id":        s.Id(),
+					"namespace": nsp.Name(),
+					"rooms":     s.Rooms().Keys(),
+					"address":   s.Handshake().Address,
+				})
+			}
+			ack([]any{list}, nil)
+		})
+	})
+
+	client.On("rooms", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		ack, ok := args[len(args)-1].(func([]any, error))
+		if !ok {
+			return
+		}
+
+		rooms := map[string]int{}
+		nsp.Adapter().Rooms().Range(func(room io.Room, sids *types.Set[io.SocketId]) bool {
+			rooms[string(room)] = sids.Len()
+			return true
+		})
+		ack([]any{rooms}, nil)
+	})
+
+	client.On("kick", func(args ...any) {
+		if len(args) < 2 {
+			return
+		}
+		ack, ok := args[len(args)-1].(func([]any, error))
+		if !ok {
+			return
+		}
+		sid, _ := args[0].(string)
+
+		target, found := nsp.Sockets().Load(io.SocketId(sid))
+		if found {
+			kick(target)
+		}
+		ack([]any{map[string]any{"kicked": found}}, nil)
+	})
+
+	client.On("emit", func(args ...any) {
+		if len(args) < 3 {
+			return
+		}
+		ack, ok := args[len(args)-1].(func([]any, error))
+		if !ok {
+			return
+		}
+		target, _ := args[0].(string)
+		event, _ := args[1].(string)
+
+		// Every socket is in the room of its own id, so one lookup serves
+		// both, and the adapter drops the rooms once they are empty
+		sids, found := nsp.Adapter().Rooms().Load(io.Room(target))
+		if !found {
+			ack([]any{map[string]any{"error": "unknown room or socket id"}}, nil)
+			return
+		}
+		if err := server.To(io.Room(target)).Emit(event, args[2:len(args)-1]...); err != nil {
+			ack([]any{map[string]any{"error": err.Error()}}, nil)
+			return
+		}
+		ack([]any{map[string]any{"recipients": sids.Len()}}, nil)
+	})
+
+	client.On("stats", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		ack, ok := args[len(args)-1].(func([]any, error))
+		if !ok {
+			return
+		}
+
+		// The private rooms of the sockets are left out
+		rooms := 0
+		nsp.Adapter().Rooms().Range(func(room io.Room, _ *types.Set[io.SocketId]) bool {
+			if _, private := nsp.Sockets().Load(io.SocketId(room)); !private {
+				rooms++
+			}
+			return true
+		})
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		ack([]any{map[string]any{
+			"sockets":    nsp.Sockets().Len(),
+			"rooms":      rooms,
+			"clients":    server.Engine().ClientsCount(),
+			"goroutines": runtime.NumGoroutine(),
+			"heapAlloc":  mem.HeapAlloc,
+		}}, nil)
+	})
+}
+
+// kick disconnects the socket, then closes its connection, so that the client
+// gets the DISCONNECT packet and knows it was kicked rather than seeing its
+// connection drop. Polling sends the packet with the pending payload, so
+// Disconnect(true) is enough there. Websocket is another story with engine
+// v3.0.1: the engine socket reports its buffer drained, and closes on it, as
+// soon as the transport accepted the packets, but the websocket transport
+// only writes them later from its own goroutine, so the connection would be
+// closed with the packet still queued.
+func kick(client *io.Socket) {
+	conn := client.Conn()
+	transport := conn.Transport()
+	if transport.Name() != "websocket" {
+		client.Disconnect(true)
+		return
+	}
+
+	client.Disconnect(false)
+
+	// Wait for the transport to be writable again, which it is once it wrote
+	// the payload with the packet, whether now or on one of its "ready"
+	// events. The listener would otherwise run after every later write of the
+	// transport.
+	var (
+		once      sync.Once
+		closeConn func(...any)
+	)
+	closeConn = func(...any) {
+		if !transport.Writable() {
+			return
+		}
+		once.Do(func() {
+			transport.RemoveListener("ready", closeConn)
+			conn.Close(false)
+		})
+	}
+	_ = transport.On("ready", closeConn)
+	closeConn()
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"reflect"
 	"testing"
 	"time"
 
 	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/parsers/engine/v3/packet"
 	io "github.com/zishang520/socket.io/servers/socket/v3"
 	"github.com/zishang520/socket.io/v3/pkg/types"
 )
@@ -71,6 +74,8 @@ func setupAuthServer(t *testing.T) (*io.Server, string) {
 			"username": username,
 		})
 
+		registerIdentityEvents(client)
+
 		client.On("profile", func(args ...any) {
 			if len(args) > 0 {
 				if ack, ok := args[len(args)-1].(func([]any, error)); ok {
@@ -133,6 +138,8 @@ func setupAuthServer(t *testing.T) (*io.Server, string) {
 				})
 			}
 		})
+
+		registerAdminEvents(srv, client)
 	})
 
 	httpServer := &http.Server{
@@ -492,3 +499,245 @@ func TestAuthMultipleUsersConnection(t *testing.T) {
 		t.Fatal("timeout waiting for user:disconnected event")
 	}
 }
+
+// request emits an event and returns the first argument of its
+// acknowledgement.
+func request(t *testing.T, client *io_client.Socket, ev string, args ...any) any {
+	t.Helper()
+
+	ackCh := make(chan []any, 1)
+	errCh := make(chan error, 1)
+	client.EmitWithAck(ev, args...)(func(args []any, err error) {
+		if err != nil {
+			errCh <- err
+			return
+		}
+		ackCh <- args
+	})
+
+	select {
+	case args := <-ackCh:
+		if len(args) == 0 {
+			t.Fatalf("expected %q ack data", ev)
+		}
+		return args[0]
+	case err := <-errCh:
+		t.Fatalf("%q ack error: %v", ev, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %q ack", ev)
+	}
+	return nil
+}
+
+// listedSockets returns the sockets listed by the "sockets" admin event,
+// keyed by id.
+func listedSockets(t *testing.T, admin *io_client.Socket) map[string]map[string]any {
+	t.Helper()
+
+	list, ok := request(t, admin, "sockets").([]any)
+	if !ok {
+		t.Fatal("expected a list of sockets")
+	}
+	sockets := make(map[string]map[string]any, len(list))
+	for _, item := range list {
+		s, ok := item.(map[string]any)
+		if !ok {
+			t.Fatalf("expected map, got %T", item)
+		}
+		id, _ := s["id"].(string)
+		sockets[id] = s
+	}
+	return sockets
+}
+
+func TestAuthAdminSockets(t *testing.T) {
+	_, addr := setupAuthServer(t)
+
+	alice, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-alice"}, "welcome")
+	bob, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-bob"}, "welcome")
+	admin, _ := connectWithAuth(t, addr, "/admin", map[string]any{"token": "token-admin"}, "admin:welcome")
+
+	sockets := listedSockets(t, admin)
+	if len(sockets) != 2 {
+		t.Fatalf("expected 2 sockets, got %d: %v", len(sockets), sockets)
+	}
+	for _, client := range []*io_client.Socket{alice, bob} {
+		s, ok := sockets[client.Id()]
+		if !ok {
+			t.Fatalf("socket %s not listed", client.Id())
+		}
+		if s["namespace"] != "/" {
+			t.Fatalf("expected namespace /, got %v", s["namespace"])
+		}
+		// Every socket is in its own room
+		if rooms, _ := s["rooms"].([]any); len(rooms) != 1 || rooms[0] != client.Id() {
+			t.Fatalf("expected rooms [%s], got %v", client.Id(), s["rooms"])
+		}
+		if address, _ := s["address"].(string); address == "" {
+			t.Fatal("expected a handshake address")
+		}
+	}
+}
+
+func TestAuthAdminRooms(t *testing.T) {
+	_, addr := setupAuthServer(t)
+
+	alice, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-alice"}, "welcome")
+	bob, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-bob"}, "welcome")
+	admin, _ := connectWithAuth(t, addr, "/admin", map[string]any{"token": "token-admin"}, "admin:welcome")
+
+	rooms, ok := request(t, admin, "rooms").(map[string]any)
+	if !ok {
+		t.Fatal("expected a map of rooms")
+	}
+	if len(rooms) != 2 {
+		t.Fatalf("expected 2 rooms, got %v", rooms)
+	}
+	for _, client := range []*io_client.Socket{alice, bob} {
+		if size, _ := rooms[client.Id()].(float64); size != 1 {
+			t.Fatalf("expected room %s of size 1, got %v", client.Id(), rooms[client.Id()])
+		}
+	}
+}
+
+func TestAuthAdminKick(t *testing.T) {
+	_, addr := setupAuthServer(t)
+
+	alice, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-alice"}, "welcome")
+	bob, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-bob"}, "welcome")
+	admin, _ := connectWithAuth(t, addr, "/admin", map[string]any{"token": "token-admin"}, "admin:welcome")
+
+	// The client hands the decoded packets over to a queue but handles the
+	// close right away, so its "disconnect" reason would say "transport close"
+	// even though the DISCONNECT packet came first. The engine sees both in
+	// the order they arrive
+	packetCh := make(chan string, 16)
+	closeCh := make(chan string, 1)
+	engine := bob.Io().Engine()
+	engine.On("packet", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		p, ok := args[0].(*packet.Packet)
+		if !ok || p.Type != packet.MESSAGE {
+			return
+		}
+		// Reading the data would consume it before the client decodes it
+		if data, ok := p.Data.(fmt.Stringer); ok {
+			packetCh <- "4" + data.String()
+		}
+	})
+	engine.On("close", func(args ...any) {
+		reason := ""
+		if len(args) > 0 {
+			reason, _ = args[0].(string)
+		}
+		select {
+		case closeCh <- reason:
+		default:
+		}
+	})
+
+	bobId := bob.Id()
+	result, ok := request(t, admin, "kick", bobId).(map[string]any)
+	if !ok || result["kicked"] != true {
+		t.Fatalf("expected kicked=true, got %v", result)
+	}
+
+	select {
+	case data := <-packetCh:
+		if data != "41" {
+			t.Fatalf("expected a DISCONNECT packet \"41\", got %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the DISCONNECT packet")
+	}
+	select {
+	case reason := <-closeCh:
+		t.Logf("connection closed: %s", reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the connection to close")
+	}
+
+	sockets := listedSockets(t, admin)
+	if len(sockets) != 1 {
+		t.Fatalf("expected 1 socket after the kick, got %d: %v", len(sockets), sockets)
+	}
+	if _, ok := sockets[alice.Id()]; !ok {
+		t.Fatalf("socket %s not listed", alice.Id())
+	}
+	if _, ok := sockets[bobId]; ok {
+		t.Fatalf("kicked socket %s still listed", bobId)
+	}
+
+	// Kicking an unknown socket is reported as such
+	result, ok = request(t, admin, "kick", bobId).(map[string]any)
+	if !ok || result["kicked"] != false {
+		t.Fatalf("expected kicked=false, got %v", result)
+	}
+}
+
+func TestAuthWhoami(t *testing.T) {
+	_, addr := setupAuthServer(t)
+
+	alice, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-alice"}, "welcome")
+	admin, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-admin"}, "welcome")
+
+	// Every socket sees its own data, without the token
+	for client, expected := range map[*io_client.Socket]map[string]any{
+		alice: {"id": alice.Id(), "username": "Alice", "isAdmin": false},
+		admin: {"id": admin.Id(), "username": "Admin", "isAdmin": true},
+	} {
+		if identity := request(t, client, "whoami"); !reflect.DeepEqual(identity, expected) {
+			t.Fatalf("whoami: expected %v, got %v", expected, identity)
+		}
+	}
+}
+
+func TestAuthWhoamiAfterReconnect(t *testing.T) {
+	_, addr := setupAuthServer(t)
+
+	alice, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-alice"}, "welcome")
+
+	if status := request(t, alice, "set-status", "away"); status != "away" {
+		t.Fatalf("set-status: expected away, got %v", status)
+	}
+	expected := map[string]any{"id": alice.Id(), "username": "Alice", "isAdmin": false, "status": "away"}
+	if identity := request(t, alice, "whoami"); !reflect.DeepEqual(identity, expected) {
+		t.Fatalf("whoami: expected %v, got %v", expected, identity)
+	}
+
+	// Without connection state recovery, the reconnection creates a new socket,
+	// with only the data stored by the middleware
+	firstId := alice.Id()
+	connectCh := make(chan struct{}, 1)
+	alice.On("connect", func(...any) {
+		select {
+		case connectCh <- struct{}{}:
+		default:
+		}
+	})
+	// Like connectWithAuth, retry the connections that stall
+	for attempt := 1; ; attempt++ {
+		alice.Disconnect()
+		alice.Connect()
+		select {
+		case <-connectCh:
+		case <-time.After(5 * time.Second):
+			if attempt < 3 {
+				t.Logf("reconnect attempt %d failed, retrying...", attempt)
+				continue
+			}
+			t.Fatal("timeout waiting for the reconnection")
+		}
+		break
+	}
+
+	if alice.Id() == firstId {
+		t.Fatalf("expected a new socket id, got %s again", firstId)
+	}
+	expected = map[string]any{"id": alice.Id(), "username": "Alice", "isAdmin": false}
+	if identity := request(t, alice, "whoami"); !reflect.DeepEqual(identity, expected) {
+		t.Fatalf("whoami after reconnect: expected %v, got %v", expected, identity)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"maps"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// registerIdentityEvents registers the events reading and replacing the data
+// that the authentication middleware stored on the socket with SetData:
+//
+//   - "whoami" acknowledges with the id of the socket and its data, except
+//     the token
+//   - "set-status" takes a string, stores it as the "status" of the socket,
+//     and acknowledges with it
+//
+// SetData and Data swap the stored value atomically, but the value itself is
+// shared by the handlers of the socket, which may run concurrently. The map
+// stored by the middleware is therefore never modified: "set-status" stores a
+// modified copy instead.
+//
+// The data belongs to one socket of one namespace: the /admin namespace
+// stores its own, and a reconnection creates a new socket, which starts again
+// from what the middleware stores, unless connection state recovery restores
+// the previous one.
+func registerIdentityEvents(client *io.Socket) {
+	client.On("whoami", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		ack, ok := args[len(args)-1].(func([]any, error))
+		if !ok {
+			return
+		}
+
+		identity := map[string]any{"id": client.Id()}
+		if data, ok := client.Data().(map[string]any); ok {
+			maps.Copy(identity, data)
+		}
+		delete(identity, "token")
+		ack([]any{identity}, nil)
+	})
+
+	client.On("set-status", func(args ...any) {
+		if len(args) < 2 {
+			return
+		}
+		ack, ok := args[len(args)-1].(func([]any, error))
+		if !ok {
+			return
+		}
+		status, _ := args[0].(string)
+
+		data, _ := client.Data().(map[string]any)
+		data = maps.Clone(data)
+		if data == nil {
+			data = map[string]any{}
+		}
+		data["status"] = status
+		client.SetData(data)
+
+		ack([]any{status}, nil)
+	})
+}
@@ -19,6 +19,12 @@ import (
 //   - Token validation before connection is established
 //   - Private namespace accessible only to authenticated users
 //   - Room-based authorization
+//   - Per-socket data set by the middleware, read back with "whoami"
+//   - Admin events listing the sockets and rooms, emitting to them, kicking
+//     sockets and reading the server stats
+//
+// "go run . admin -token token-admin" opens a prompt on the /admin namespace
+// of a running server, and -exec runs a list of commands instead.
 
 // validTokens simulates a set of valid authentication tokens.
 var validTokens = map[string]string{
@@ -33,6 +39,10 @@ var adminTokens = map[string]bool{
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdmin(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	config := io.DefaultServerOptions()
 	config.SetCors(&types.Cors{Origin: "*"})
 
@@ -100,6 +110,9 @@ func main() {
 			"username": username,
 		})
 
+		// Handle whoami and set-status, which read and replace the socket data
+		registerIdentityEvents(client)
+
 		// Handle profile request
 		client.On("profile", func(args ...any) {
 			if len(args) > 0 {
@@ -182,6 +195,8 @@ func main() {
 				})
 			}
 		})
+
+		registerAdminEvents(server, client)
 	})
 
 	addr := ":3000"
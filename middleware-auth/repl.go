@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// replHelp lists the commands of the admin prompt.
+const replHelp = `Commands:
+  sockets                           list the sockets of /, with their rooms
+  rooms                             list the rooms of /, with their size
+  emit <room|sid> <event> [args]    emit an event, each argument parsed as JSON
+                                    or taken as a string
+  kick <sid>                        disconnect a socket
+  stats                             show the server stats
+  help                              show this help
+  quit                              leave the prompt`
+
+// errUsage reports a command called with the wrong arguments.
+var errUsage = errors.New("usage")
+
+// adminClient is a connection to the /admin namespace of a server.
+type adminClient struct {
+	socket  *io_client.Socket
+	timeout time.Duration
+}
+
+// dialAdmin connects to the /admin namespace of the server at url with
+// token, over websocket, and waits for at most timeout.
+func dialAdmin(url, token string, timeout time.Duration) (*adminClient, error) {
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	sockOpts := io_client.DefaultSocketOptions()
+	sockOpts.SetAuth(map[string]any{"token": token})
+
+	manager := io_client.NewManager(url, managerOpts)
+	socket := manager.Socket("/admin", sockOpts)
+
+	connected := make(chan error, 1)
+	socket.Once("connect", func(...any) { connected <- nil })
+	socket.Once("connect_error", func(args ...any) {
+		err := errors.New("connection refused")
+		if len(args) > 0 {
+			if extended, ok := args[0].(*types.ExtendedError); ok {
+				// The middleware of /admin details the refusal in the data
+				data, _ := extended.Data.(map[string]any)
+				message, _ := data["message"].(string)
+				err = fmt.Errorf("%s: %s", extended.Message, message)
+			} else if e, ok := args[0].(error); ok {
+				err = e
+			}
+		}
+		connected <- err
+	})
+	socket.Connect()
+
+	select {
+	case err := <-connected:
+		if err != nil {
+			socket.Disconnect()
+			return nil, err
+		}
+		return &adminClient{socket: socket, timeout: timeout}, nil
+	case <-time.After(timeout):
+		socket.Disconnect()
+		return nil, fmt.Errorf("timed out connecting to %s", url)
+	}
+}
+
+// close disconnects the client.
+func (c *adminClient) close() {
+	c.socket.Disconnect()
+}
+
+// call emits event and returns the first argument of the acknowledgement.
+func (c *adminClient) call(event string, args ...any) (any, error) {
+	reply := make(chan []any, 1)
+	c.socket.EmitWithAck(event, args...)(func(args []any, _ error) {
+		reply <- args
+	})
+
+	select {
+	case args := <-reply:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty reply to %s", event)
+		}
+		return args[0], nil
+	case <-time.After(c.timeout):
+		return nil, fmt.Errorf("no reply to %s within %s", event, c.timeout)
+	}
+}
+
+// exec runs one command line, writing its output to out.
+func (c *adminClient) exec(line string, out io.Writer) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	switch command, args := fields[0], fields[1:]; command {
+	case "help":
+		fmt.Fprintln(out, replHelp)
+		return nil
+	case "sockets":
+		return c.sockets(out)
+	case "rooms":
+		return c.rooms(out)
+	case "emit":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: emit <room|sid> <event> [args]", errUsage)
+		}
+		return c.emit(out, args[0], args[1], args[2:])
+	case "kick":
+		if len(args) != 1 {
+			return fmt.Errorf("%w: kick <sid>", errUsage)
+		}
+		return c.kick(out, args[0])
+	case "stats":
+		return c.stats(out)
+	default:
+		return fmt.Errorf("unknown command %q, try help", command)
+	}
+}
+
+// sockets prints the sockets of /, and the rooms they joined besides their
+// own.
+func (c *adminClient) sockets(out io.Writer) error {
+	reply, err := c.call("sockets")
+	if err != nil {
+		return err
+	}
+	list, _ := reply.([]any)
+
+	type row struct{ id, rooms, address string }
+	rows := make([]row, 0, len(list))
+	for _, item := range list {
+		s, _ := item.(map[string]any)
+		id, _ := s["id"].(string)
+		address, _ := s["address"].(string)
+		var rooms []string
+		roomList, _ := s["rooms"].([]any)
+		for _, room := range roomList {
+			if room, _ := room.(string); room != id {
+				rooms = append(rooms, room)
+			}
+		}
+		slices.Sort(rooms)
+		joined := strings.Join(rooms, ",")
+		if joined == "" {
+			joined = "-"
+		}
+		rows = append(rows, row{id, joined, address})
+	}
+	slices.SortFunc(rows, func(a, b row) int { return strings.Compare(a.id, b.id) })
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tROOMS\tADDRESS")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.id, r.rooms, r.address)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%s\n", count(len(rows), "socket"))
+	return nil
+}
+
+// rooms prints the rooms of /, with their number of sockets.
+func (c *adminClient) rooms(out io.Writer) error {
+	reply, err := c.call("rooms")
+	if err != nil {
+		return err
+	}
+	rooms, _ := reply.(map[string]any)
+
+	names := make([]string, 0, len(rooms))
+	for name := range rooms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOM\tSOCKETS")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, number(rooms[name]))
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%s\n", count(len(names), "room"))
+	return nil
+}
+
+// emit emits event to target, a room or a socket id, with args.
+func (c *adminClient) emit(out io.Writer, target, event string, args []string) error {
+	payload := append([]any{target, event}, parseArgs(args)...)
+	reply, err := c.call("emit", payload...)
+	if err != nil {
+		return err
+	}
+	result, _ := reply.(map[string]any)
+	if message, ok := result["error"].(string); ok {
+		return fmt.Errorf("%s: %s", target, message)
+	}
+	recipients, _ := result["recipients"].(float64)
+	fmt.Fprintf(out, "emitted %s to %s (%s)\n", event, target, count(int(recipients), "socket"))
+	return nil
+}
+
+// kick disconnects the socket sid.
+func (c *adminClient) kick(out io.Writer, sid string) error {
+	reply, err := c.call("kick", sid)
+	if err != nil {
+		return err
+	}
+	if result, _ := reply.(map[string]any); result["kicked"] != true {
+		return fmt.Errorf("unknown socket id %s", sid)
+	}
+	fmt.Fprintf(out, "kicked %s\n", sid)
+	return nil
+}
+
+// stats prints the server stats.
+func (c *adminClient) stats(out io.Writer) error {
+	reply, err := c.call("stats")
+	if err != nil {
+		return err
+	}
+	stats, _ := reply.(map[string]any)
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	for _, key := range []string{"sockets", "rooms", "clients", "goroutines", "heapAlloc"} {
+		fmt.Fprintf(w, "%s:\t%s\n", key, number(stats[key]))
+	}
+	return w.Flush()
+}
+
+// parseArgs returns the arguments of emit: each one parsed as JSON, such as
+// 42, true or {"a":1}, or taken as a string otherwise.
+func parseArgs(args []string) []any {
+	values := make([]any, 0, len(args))
+	for _, arg := range args {
+		var value any
+		if err := json.Unmarshal([]byte(arg), &value); err != nil {
+			value = arg
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// number formats a JSON number, which decodes as a float64, without an
+// exponent.
+func number(v any) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// count returns n and noun, pluralized.
+func count(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// runAdmin runs the admin prompt with the command line args: interactively
+// on stdin, or the commands of -exec, separated by semicolons. It returns the
+// exit code, 1 when a command failed.
+func runAdmin(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	url := flags.String("url", "http://localhost:3000", "URL of the server")
+	token := flags.String("token", "", "admin token")
+	script := flags.String("exec", "", "commands to run, separated by semicolons, instead of the prompt")
+	timeout := flags.Duration("timeout", 5*time.Second, "timeout of the connection and of each command")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *token == "" {
+		fmt.Fprintln(stderr, "missing -token")
+		return 2
+	}
+
+	client, err := dialAdmin(*url, *token, *timeout)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	defer client.close()
+
+	code := 0
+	run := func(line string) {
+		if err := client.exec(line, stdout); err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			code = 1
+		}
+	}
+
+	if *script != "" {
+		for line := range strings.SplitSeq(*script, ";") {
+			run(line)
+		}
+		return code
+	}
+
+	scanner := bufio.NewScanner(stdin)
+	for fmt.Fprint(stdout, "admin> "); scanner.Scan(); fmt.Fprint(stdout, "admin> ") {
+		if line := strings.TrimSpace(scanner.Text()); line == "quit" || line == "exit" {
+			return code
+		}
+		run(scanner.Text())
+	}
+	fmt.Fprintln(stdout)
+	return code
+}
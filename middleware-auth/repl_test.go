@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// runScript runs the admin commands of script against the server at addr
+// with token, and returns the exit code and the outputs.
+func runScript(t *testing.T, addr, token, script string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := runAdmin([]string{"-url", "http://" + addr, "-token", token, "-exec", script}, nil, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// expectLines fails unless every line of expected is a line of output, both
+// with their columns separated by single spaces.
+func expectLines(t *testing.T, output string, expected ...string) {
+	t.Helper()
+
+	lines := strings.Split(output, "\n")
+	for _, line := range expected {
+		found := false
+		for _, l := range lines {
+			if strings.Join(strings.Fields(l), " ") == line {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected the line %q in:\n%s", line, output)
+		}
+	}
+}
+
+func TestAdminScript(t *testing.T) {
+	srv, addr := setupAuthServer(t)
+
+	alice, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-alice"}, "welcome")
+	bob, _ := connectWithAuth(t, addr, "/", map[string]any{"token": "token-bob"}, "welcome")
+	srv.In(io.Room(alice.Id())).SocketsJoin("room-a")
+
+	hello := make(chan []any, 1)
+	alice.On("hello", func(args ...any) { hello <- args })
+	ping := make(chan []any, 1)
+	bob.On("ping", func(args ...any) { ping <- args })
+
+	bobId := bob.Id()
+	code, stdout, stderr := runScript(t, addr, "token-admin",
+		"sockets; rooms; emit room-a hello 42 {\"a\":true} text; emit "+bobId+" ping; kick "+bobId+"; stats")
+	if code != 0 || stderr != "" {
+		t.Fatalf("expected the script to succeed, got %d: %s", code, stderr)
+	}
+
+	expectLines(t, stdout,
+		"2 sockets",
+		"room-a 1",
+		"3 rooms",
+		"emitted hello to room-a (1 socket)",
+		"emitted ping to "+bobId+" (1 socket)",
+		"kicked "+bobId,
+		"sockets: 1",
+	)
+	// The address of the handshake includes the port of the client
+	if !strings.Contains(strings.Join(strings.Fields(stdout), " "), alice.Id()+" room-a 127.0.0.1:") {
+		t.Errorf("expected alice in room-a in:\n%s", stdout)
+	}
+
+	select {
+	case args := <-hello:
+		if expected := []any{float64(42), map[string]any{"a": true}, "text"}; !reflect.DeepEqual(args, expected) {
+			t.Fatalf("expected hello %v, got %v", expected, args)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected alice to receive hello")
+	}
+	select {
+	case <-ping:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected bob to receive ping")
+	}
+}
+
+func TestAdminScriptErrors(t *testing.T) {
+	_, addr := setupAuthServer(t)
+
+	// A failing command does not stop the script, but fails it
+	code, stdout, stderr := runScript(t, addr, "token-admin",
+		"kick nobody; emit nowhere hello; emit room-a; emit / connect; bogus; stats")
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	expectLines(t, stderr,
+		"error: unknown socket id nobody",
+		"error: nowhere: unknown room or socket id",
+		"error: usage: emit <room|sid> <event> [args]",
+		`error: unknown command "bogus", try help`,
+	)
+	expectLines(t, stdout, "sockets: 0")
+
+	// Only admins may connect
+	code, _, stderr = runScript(t, addr, "token-alice", "stats")
+	if code != 1 || stderr != "error: authorization error: admin access required\n" {
+		t.Fatalf("expected an authorization error, got %d: %q", code, stderr)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	args := parseArgs([]string{"42", "true", `{"a":[1]}`, `"quoted"`, "text", "{oops"})
+	expected := []any{float64(42), true, map[string]any{"a": []any{float64(1)}}, "quoted", "text", "{oops"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Multiple servers example - two independent Socket.IO servers, with their
+// own options, on the same HTTP server and port.
+//
+// Features:
+//   - A public server at /socket.io/, open to any origin, where clients chat
+//   - An internal server at /internal/socket.io/, with its own heartbeat,
+//     and a token required by its middleware
+//   - Sessions, socket ids, namespaces and broadcasts isolated between them
+//
+// Each server is attached to the HTTP server at its own path. Closing either
+// of them closes the HTTP server, and with it the other one.
+
+func main() {
+	token := internalToken()
+
+	httpServer := types.NewWebServer(nil)
+	public := newPublicServer(httpServer)
+	internal := newInternalServer(httpServer, token, public)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Public server listening on %s%s/\n", addr, public.Path())
+	fmt.Printf("Internal server listening on %s%s/\n", addr, internal.Path())
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down servers...")
+	internal.Close(nil)
+	public.Close(nil)
+}
+
+// internalToken returns the token of the internal server, from the
+// INTERNAL_TOKEN environment variable. Without it, a random token is generated
+// and printed, so that the internal server is never open with a known token.
+func internalToken() string {
+	if token := os.Getenv("INTERNAL_TOKEN"); token != "" {
+		return token
+	}
+	token := rand.Text()
+	fmt.Printf("INTERNAL_TOKEN is not set, the internal server requires the generated token %s\n", token)
+	return token
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+const testToken = "test-token"
+
+// setupServers starts both servers on one HTTP server and returns its address.
+func setupServers(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	public := newPublicServer(httpServer)
+	internal := newInternalServer(httpServer, testToken, public)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		internal.Close(nil)
+		public.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// newClient creates a client of the server at path, which connects with auth.
+func newClient(t *testing.T, addr, path string, auth map[string]any) *io_client.Socket {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetPath(path)
+
+	sockOpts := io_client.DefaultSocketOptions()
+	sockOpts.SetAuth(auth)
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	client := manager.Socket("/", sockOpts)
+	t.Cleanup(func() { client.Disconnect() })
+	return client
+}
+
+// connectClient connects a client of the server at path, retrying on the
+// occasional stalled connection.
+func connectClient(t *testing.T, addr, path string, auth map[string]any) *io_client.Socket {
+	t.Helper()
+
+	const maxAttempts = 3
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		client := newClient(t, addr, path, auth)
+		connected := make(chan struct{}, 1)
+		client.On("connect", func(...any) {
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		})
+		client.Connect()
+
+		select {
+		case <-connected:
+			return client
+		case <-time.After(2 * time.Second):
+			client.Disconnect()
+			t.Logf("connect attempt %d failed, retrying...", attempt)
+		}
+	}
+	t.Fatalf("failed to connect to %s after %d attempts", path, maxAttempts)
+	return nil
+}
+
+// collect returns a channel receiving the first argument of each event.
+func collect(client *io_client.Socket, event string) <-chan any {
+	ch := make(chan any, 10)
+	client.On(types.EventName(event), func(args ...any) {
+		if len(args) > 0 {
+			ch <- args[0]
+		}
+	})
+	return ch
+}
+
+// handshake opens a polling session at path and returns the OPEN packet.
+func handshake(t *testing.T, addr, path string) map[string]any {
+	t.Helper()
+
+	resp, err := http.Get("http://" + addr + path + "/?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var open map[string]any
+	if !strings.HasPrefix(string(body), "0") || json.Unmarshal(body[1:], &open) != nil {
+		t.Fatalf("expected an OPEN packet at %s, got %d %q", path, resp.StatusCode, body)
+	}
+	return open
+}
+
+func TestHandshakeOptions(t *testing.T) {
+	addr := setupServers(t)
+
+	for _, tc := range []struct {
+		path         string
+		pingInterval time.Duration
+		cors         bool
+	}{
+		// The public server keeps the defaults
+		{publicPath, 25 * time.Second, true},
+		{internalPath, internalPingInterval, false},
+	} {
+		open := handshake(t, addr, tc.path)
+		if interval, _ := open["pingInterval"].(float64); interval != float64(tc.pingInterval.Milliseconds()) {
+			t.Errorf("%s: expected pingInterval %d, got %v", tc.path, tc.pingInterval.Milliseconds(), open["pingInterval"])
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+tc.path+"/?EIO=4&transport=polling", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", "http://example.com")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if cors := resp.Header.Get("Access-Control-Allow-Origin") != ""; cors != tc.cors {
+			t.Errorf("%s: expected CORS headers %t, got %q", tc.path, tc.cors, resp.Header.Get("Access-Control-Allow-Origin"))
+		}
+	}
+}
+
+func TestSessionIsolation(t *testing.T) {
+	addr := setupServers(t)
+
+	// A session of one server is unknown to the other
+	for _, paths := range [][2]string{{publicPath, internalPath}, {internalPath, publicPath}} {
+		sid, _ := handshake(t, addr, paths[0])["sid"].(string)
+
+		resp, err := http.Get(fmt.Sprintf("http://%s%s/?EIO=4&transport=polling&sid=%s", addr, paths[1], sid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "Session ID unknown") {
+			t.Fatalf("expected the sid of %s to be unknown to %s, got %d %s", paths[0], paths[1], resp.StatusCode, body)
+		}
+	}
+}
+
+func TestInternalMiddleware(t *testing.T) {
+	addr := setupServers(t)
+
+	// The public server does not run the middleware of the internal one
+	connectClient(t, addr, publicPath, nil)
+
+	client := newClient(t, addr, internalPath, map[string]any{"token": "wrong"})
+	errors := collect(client, "connect_error")
+	client.Connect()
+
+	select {
+	case err := <-errors:
+		if !strings.Contains(fmt.Sprint(err), "unauthorized") {
+			t.Fatalf("expected an unauthorized error, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected connect_error")
+	}
+}
+
+func TestBroadcastIsolation(t *testing.T) {
+	addr := setupServers(t)
+
+	visitor := connectClient(t, addr, publicPath, map[string]any{"name": "Alice"})
+	operator := connectClient(t, addr, internalPath, map[string]any{"token": testToken})
+
+	// The same namespace on both servers, with distinct socket ids
+	if visitor.Id() == operator.Id() {
+		t.Fatalf("expected distinct socket ids, got %s twice", visitor.Id())
+	}
+
+	chats := map[string]<-chan any{"visitor": collect(visitor, "chat"), "operator": collect(operator, "chat")}
+	notices := map[string]<-chan any{"visitor": collect(visitor, "notice"), "operator": collect(operator, "notice")}
+
+	visitor.Emit("chat", "hello")
+	operator.Emit("notice", "maintenance at noon")
+
+	select {
+	case chat := <-chats["visitor"]:
+		if data, _ := chat.(map[string]any); data["name"] != "Alice" || data["message"] != "hello" {
+			t.Fatalf("unexpected chat %v", chat)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the visitor to receive its chat")
+	}
+	select {
+	case notice := <-notices["operator"]:
+		if notice != "maintenance at noon" {
+			t.Fatalf("unexpected notice %v", notice)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the operator to receive its notice")
+	}
+
+	// Neither broadcast crosses over to the other server
+	select {
+	case chat := <-chats["operator"]:
+		t.Fatalf("the operator received a public chat: %v", chat)
+	case notice := <-notices["visitor"]:
+		t.Fatalf("the visitor received an internal notice: %v", notice)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// Each engine only counts its own clients
+	stats := make(chan any, 1)
+	operator.Emit("stats", func(args []any, err error) {
+		if err == nil && len(args) > 0 {
+			stats <- args[0]
+		}
+	})
+	select {
+	case got := <-stats:
+		data, _ := got.(map[string]any)
+		if data["public"] != float64(1) || data["internal"] != float64(1) {
+			t.Fatalf("expected one client on each server, got %v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the stats")
+	}
+}
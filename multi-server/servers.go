@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/subtle"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// The paths of the two servers. The engine serves each path and everything
+// below it, so that /internal/socket.io/ never reaches the public server.
+const (
+	publicPath   = "/socket.io"
+	internalPath = "/internal/socket.io"
+)
+
+// The heartbeat of the internal server is tighter than the default one of the
+// public server, to notice lost operators quickly.
+const (
+	internalPingInterval = 5 * time.Second
+	internalPingTimeout  = 3 * time.Second
+)
+
+// newPublicServer creates the public realtime server on httpServer: any origin
+// may connect, with an optional name, and its clients chat with each other.
+func newPublicServer(httpServer *types.HttpServer) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetPath(publicPath)
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+
+	// The name is optional, guests get a default one
+	server.Use(func(client *io.Socket, next func(*io.ExtendedError)) {
+		name, _ := client.Handshake().Auth["name"].(string)
+		if name == "" {
+			name = "guest"
+		}
+		client.SetData(name)
+		next(nil)
+	})
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'chat', broadcast it to every public client
+		client.On("chat", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			server.Emit("chat", map[string]any{
+				"name":    client.Data(),
+				"message": args[0],
+			})
+		})
+	})
+	return server
+}
+
+// newInternalServer creates the internal admin server on httpServer, next to
+// the public one: its clients must send token in their handshake auth. It has
+// no CORS headers, which only keeps cross-origin pages from polling it: the
+// websocket transport accepts any origin, so the token is what keeps others
+// out. Its operators can send notices to each other, and read the number of
+// clients of both servers.
+func newInternalServer(httpServer *types.HttpServer, token string, public *io.Server) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetPath(internalPath)
+	config.SetPingInterval(internalPingInterval)
+	config.SetPingTimeout(internalPingTimeout)
+
+	server := io.NewServer(httpServer, config)
+
+	server.Use(func(client *io.Socket, next func(*io.ExtendedError)) {
+		// The tokens are compared in constant time, so that the response
+		// time does not tell how much of a guess was right
+		auth, _ := client.Handshake().Auth["token"].(string)
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			next(io.NewExtendedError("unauthorized", map[string]any{"code": "unauthorized"}))
+			return
+		}
+		next(nil)
+	})
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'notice', broadcast it to every operator,
+		// which only ever reaches the clients of this server
+		client.On("notice", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			server.Emit("notice", args[0])
+		})
+
+		// When the client emits 'stats', acknowledge with the number of
+		// Engine.IO clients of each server, counted separately
+		client.On("stats", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{map[string]any{
+					"public":   public.Engine().ClientsCount(),
+					"internal": server.Engine().ClientsCount(),
+				}}, nil)
+			}
+		})
+	})
+	return server
+}
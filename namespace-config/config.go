@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// authMode is the middleware a namespace runs on its sockets.
+type authMode string
+
+const (
+	// authNone lets every socket in, as anonymous.
+	authNone authMode = "none"
+	// authToken requires a known token in the handshake auth.
+	authToken authMode = "token"
+	// authMTLS requires a client certificate the server verified.
+	authMTLS authMode = "mtls"
+)
+
+// namespaceConfig is the policy of a namespace.
+type namespaceConfig struct {
+	Name string   `json:"name"`
+	Auth authMode `json:"auth"`
+	// MaxPayload is the size, in bytes, of the largest arguments of an event
+	// the application accepts, measured as their JSON encoding
+	MaxPayload int `json:"maxPayload"`
+	// Events are the names of the handlers of eventHandlers to register
+	Events []string `json:"events"`
+}
+
+// defaultNamespaces is the configuration used without CONFIG.
+var defaultNamespaces = []namespaceConfig{
+	{Name: "/public", Auth: authNone, MaxPayload: 1 << 10, Events: []string{"echo"}},
+	{Name: "/trusted", Auth: authToken, MaxPayload: 64 << 10, Events: []string{"echo", "whoami", "broadcast"}},
+	{Name: "/internal", Auth: authMTLS, MaxPayload: 1 << 20, Events: []string{"echo", "whoami", "broadcast"}},
+}
+
+// loadConfig reads the namespaces from the JSON file path.
+func loadConfig(path string) ([]namespaceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var namespaces []namespaceConfig
+	if err := json.Unmarshal(data, &namespaces); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return namespaces, nil
+}
+
+// validateConfig checks namespaces before any of them is registered, so that a
+// mistake fails at startup instead of leaving a namespace half open.
+func validateConfig(namespaces []namespaceConfig) error {
+	if len(namespaces) == 0 {
+		return errors.New("no namespace configured")
+	}
+	seen := map[string]bool{}
+	for _, nsp := range namespaces {
+		switch {
+		case !strings.HasPrefix(nsp.Name, "/") || nsp.Name == "/":
+			return fmt.Errorf("namespace %q: name must start with a slash and not be the main namespace", nsp.Name)
+		case seen[nsp.Name]:
+			return fmt.Errorf("namespace %s: configured twice", nsp.Name)
+		case !slices.Contains([]authMode{authNone, authToken, authMTLS}, nsp.Auth):
+			return fmt.Errorf("namespace %s: unknown auth %q", nsp.Name, nsp.Auth)
+		case nsp.MaxPayload <= 0:
+			return fmt.Errorf("namespace %s: maxPayload must be positive", nsp.Name)
+		}
+		seen[nsp.Name] = true
+		for _, event := range nsp.Events {
+			if _, ok := eventHandlers[event]; !ok {
+				return fmt.Errorf("namespace %s: unknown event %q", nsp.Name, event)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Namespace configuration example - instantiates its namespaces at startup
+// from a configuration, instead of wiring each of them by hand.
+//
+// Features:
+//   - /public, open to anyone, echoing payloads of up to 1KB
+//   - /trusted, requiring a token, with whoami and broadcast, up to 64KB
+//   - /internal, requiring a client certificate, up to 1MB
+//   - Events outside the ones of a namespace refused with an error
+//
+// CONFIG is a JSON file replacing the default namespaces. With TLS_CERT and
+// TLS_KEY the server is served over TLS, and with CLIENT_CA it also verifies
+// the client certificates, which /internal requires.
+
+// validTokens simulates the tokens of the token auth, by user name.
+var validTokens = map[string]string{
+	"token-alice": "Alice",
+	"token-bob":   "Bob",
+}
+
+func main() {
+	config := defaultNamespaces
+	if path := os.Getenv("CONFIG"); path != "" {
+		var err error
+		if config, err = loadConfig(path); err != nil {
+			log.Fatalf("Failed to load the configuration: %v", err)
+		}
+	}
+
+	tlsConfig, err := serverTLSConfig(os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY"), os.Getenv("CLIENT_CA"))
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+
+	httpServer := types.NewWebServer(nil)
+	server, err := newServer(httpServer, config, validTokens)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	if tlsConfig == nil {
+		httpServer.Listen(addr, nil)
+	} else {
+		tlsServer := &http.Server{Addr: addr, Handler: httpServer, TLSConfig: tlsConfig}
+		go func() {
+			if err := tlsServer.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Failed to serve: %v", err)
+			}
+		}()
+		defer tlsServer.Close()
+	}
+	for _, nsp := range config {
+		fmt.Printf("Namespace %s: auth %s, payloads up to %d bytes, events %v\n", nsp.Name, nsp.Auth, nsp.MaxPayload, nsp.Events)
+	}
+	fmt.Printf("Namespace configuration server listening on %s (TLS: %t)\n", addr, tlsConfig != nil)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
+
+// serverTLSConfig loads the certificate certFile and its key keyFile, and the
+// client CA caFile, if any. It returns nil without a certificate.
+func serverTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	switch {
+	case certFile == "" && keyFile == "" && caFile == "":
+		return nil, nil
+	case certFile == "" || keyFile == "":
+		return nil, errors.New("TLS_CERT and TLS_KEY must be set together, and CLIENT_CA requires them")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		// The certificate is optional, so that /public and /trusted are
+		// served on the same listener, and /internal checks it
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// errPayloadTooLarge is the error of the events whose arguments exceed the
+// MaxPayload of their namespace.
+var errPayloadTooLarge = errors.New("payload too large")
+
+// errUnknownEvent is the error of the events the namespace has no handler for.
+var errUnknownEvent = errors.New("unknown event")
+
+// identity is the socket data set by the middleware of a namespace.
+type identity struct {
+	name string
+	auth authMode
+}
+
+// eventHandler handles an event whose arguments passed the payload limit, and
+// returns the arguments of the acknowledgement.
+type eventHandler func(client *io.Socket, args []any) ([]any, error)
+
+// eventHandlers are the handlers a namespace can register, by event name.
+var eventHandlers = map[string]eventHandler{
+	// echo acknowledges its arguments
+	"echo": func(_ *io.Socket, args []any) ([]any, error) {
+		return args, nil
+	},
+	// whoami acknowledges the identity set by the middleware
+	"whoami": func(client *io.Socket, _ []any) ([]any, error) {
+		id := client.Data().(identity)
+		return []any{map[string]any{
+			"name":      id.name,
+			"auth":      id.auth,
+			"namespace": client.Nsp().Name(),
+		}}, nil
+	},
+	// broadcast sends its arguments as a "message" to the other sockets of
+	// the namespace
+	"broadcast": func(client *io.Socket, args []any) ([]any, error) {
+		id := client.Data().(identity)
+		client.Broadcast().Emit("message", map[string]any{"from": id.name, "args": args})
+		return []any{map[string]any{"ok": true}}, nil
+	},
+}
+
+// registerNamespace creates the namespace of cfg on server, with the
+// middleware of its auth mode and the handlers of its events, each of them
+// behind its payload limit.
+func registerNamespace(server *io.Server, cfg namespaceConfig, tokens map[string]string) {
+	nsp := server.Of(cfg.Name, nil)
+	nsp.Use(authenticate(cfg.Auth, tokens))
+
+	nsp.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		for _, event := range cfg.Events {
+			client.On(event, handle(client, cfg, eventHandlers[event]))
+		}
+
+		// The events without a handler in this namespace are refused, rather than left
+		// unacknowledged
+		client.OnAny(func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			if event, _ := args[0].(string); !slices.Contains(cfg.Events, event) {
+				_, ack := splitAck(args[1:])
+				reply(ack, nil, fmt.Errorf("%w %q", errUnknownEvent, event))
+			}
+		})
+	})
+}
+
+// authenticate returns the middleware of mode, which sets the identity of the
+// sockets it lets in.
+func authenticate(mode authMode, tokens map[string]string) io.NamespaceMiddleware {
+	refuse := func(next func(*io.ExtendedError), message string) {
+		next(io.NewExtendedError("authentication error", map[string]any{"message": message}))
+	}
+
+	switch mode {
+	case authToken:
+		return func(client *io.Socket, next func(*io.ExtendedError)) {
+			token, _ := client.Handshake().Auth["token"].(string)
+			name, ok := tokens[token]
+			if !ok {
+				refuse(next, "invalid token")
+				return
+			}
+			client.SetData(identity{name: name, auth: authToken})
+			next(nil)
+		}
+	case authMTLS:
+		return func(client *io.Socket, next func(*io.ExtendedError)) {
+			// The TLS state of the handshake request, whose certificate the
+			// server verified against its client CA
+			state := client.Conn().Request().Request().TLS
+			if state == nil || len(state.VerifiedChains) == 0 {
+				refuse(next, "client certificate required")
+				return
+			}
+			client.SetData(identity{name: state.VerifiedChains[0][0].Subject.CommonName, auth: authMTLS})
+			next(nil)
+		}
+	default:
+		return func(client *io.Socket, next func(*io.ExtendedError)) {
+			client.SetData(identity{name: "anonymous", auth: authNone})
+			next(nil)
+		}
+	}
+}
+
+// handle returns the listener running fn on the events of client within the
+// payload limit of cfg.
+func handle(client *io.Socket, cfg namespaceConfig, fn eventHandler) func(...any) {
+	return func(args ...any) {
+		args, ack := splitAck(args)
+		if size := payloadSize(args); size > cfg.MaxPayload {
+			log.Printf("%s: refused an event of %d bytes from %s", cfg.Name, size, client.Id())
+			reply(ack, nil, fmt.Errorf("%w: %d bytes, at most %d", errPayloadTooLarge, size, cfg.MaxPayload))
+			return
+		}
+		result, err := fn(client, args)
+		reply(ack, result, err)
+	}
+}
+
+// splitAck separates the acknowledgement callback, if any, from the arguments
+// of an event.
+func splitAck(args []any) ([]any, io.Ack) {
+	if len(args) > 0 {
+		if ack, ok := args[len(args)-1].(io.Ack); ok {
+			return args[:len(args)-1], ack
+		}
+	}
+	return args, nil
+}
+
+// reply acknowledges result, or {error} when err is not nil, when the client
+// asked for an acknowledgement.
+func reply(ack io.Ack, result []any, err error) {
+	if ack == nil {
+		return
+	}
+	if err != nil {
+		result = []any{map[string]any{"error": err.Error()}}
+	}
+	ack(result, nil)
+}
+
+// payloadSize returns the size of the JSON encoding of args, or the largest
+// size when they cannot be encoded, so that they are refused.
+func payloadSize(args []any) int {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return math.MaxInt
+	}
+	return len(data)
+}
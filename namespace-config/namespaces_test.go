@@ -0,0 +1,400 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// authority is a certificate authority issuing the certificates of a test.
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+// newAuthority creates a self-signed certificate authority.
+func newAuthority(t *testing.T, name string) *authority {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &authority{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for name, for a server on 127.0.0.1 or for a
+// client.
+func (a *authority) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// pki holds the certificates of a test: the server's, and the ones of a
+// client trusted by the server and of a client it does not trust.
+type pki struct {
+	roots     *x509.CertPool
+	server    *tls.Config
+	trusted   tls.Certificate
+	untrusted tls.Certificate
+}
+
+func newPKI(t *testing.T) *pki {
+	t.Helper()
+
+	serverCA := newAuthority(t, "server CA")
+	clientCA := newAuthority(t, "client CA")
+	otherCA := newAuthority(t, "other CA")
+	return &pki{
+		roots: serverCA.pool,
+		server: &tls.Config{
+			Certificates: []tls.Certificate{serverCA.issue(t, "127.0.0.1", x509.ExtKeyUsageServerAuth)},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    clientCA.pool,
+		},
+		trusted:   clientCA.issue(t, "billing-worker", x509.ExtKeyUsageClientAuth),
+		untrusted: otherCA.issue(t, "intruder", x509.ExtKeyUsageClientAuth),
+	}
+}
+
+// setupServer starts the server with the default namespaces over TLS, and
+// returns its address.
+func setupServer(t *testing.T, p *pki) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer, err := newServer(httpServer, defaultNamespaces, validTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(tls.NewListener(ln, p.server))
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// client is a socket connected to a namespace, and the messages it received.
+type client struct {
+	*io_client.Socket
+	messages chan map[string]any
+}
+
+// connectClient connects a client to nsp with auth and the client certificate
+// cert, if any, and returns the reason of the refusal when it is refused.
+// Websocket only, as the polling upgrade occasionally stalls the connection.
+func connectClient(t *testing.T, addr string, p *pki, nsp string, auth map[string]any, cert *tls.Certificate) (*client, error) {
+	t.Helper()
+
+	tlsConfig := &tls.Config{RootCAs: p.roots}
+	if cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+	managerOpts.SetTLSClientConfig(tlsConfig)
+
+	sockOpts := io_client.DefaultSocketOptions()
+	if auth != nil {
+		sockOpts.SetAuth(auth)
+	}
+
+	manager := io_client.NewManager("https://"+addr, managerOpts)
+	c := &client{
+		Socket:   manager.Socket(nsp, sockOpts),
+		messages: make(chan map[string]any, 10),
+	}
+	t.Cleanup(func() { c.Disconnect() })
+
+	c.On("message", func(args ...any) {
+		message, _ := args[0].(map[string]any)
+		c.messages <- message
+	})
+
+	connected := make(chan error, 1)
+	c.Once("connect", func(...any) { connected <- nil })
+	c.Once("connect_error", func(args ...any) {
+		err := errors.New("connection refused")
+		if extended, ok := args[0].(*types.ExtendedError); ok {
+			data, _ := extended.Data.(map[string]any)
+			err = fmt.Errorf("%s: %v", extended.Message, data["message"])
+		} else if e, ok := args[0].(error); ok {
+			err = e
+		}
+		connected <- err
+	})
+	c.Connect()
+
+	select {
+	case err := <-connected:
+		return c, err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client of %s neither connected nor was refused", nsp)
+		return nil, nil
+	}
+}
+
+// call emits event and returns the first argument of the acknowledgement, nil
+// when it has none.
+func (c *client) call(t *testing.T, event string, args ...any) any {
+	t.Helper()
+
+	reply := make(chan any, 1)
+	c.EmitWithAck(event, args...)(func(args []any, _ error) {
+		var value any
+		if len(args) > 0 {
+			value = args[0]
+		}
+		reply <- value
+	})
+
+	select {
+	case value := <-reply:
+		return value
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s was not acknowledged", event)
+		return nil
+	}
+}
+
+// Each namespace applies its own middleware, so that the same credentials are
+// enough for one namespace and not for another.
+func TestNamespacePolicies(t *testing.T) {
+	p := newPKI(t)
+	addr := setupServer(t, p)
+
+	alice := map[string]any{"token": "token-alice"}
+	for _, tc := range []struct {
+		name  string
+		nsp   string
+		auth  map[string]any
+		cert  *tls.Certificate
+		error string
+		// the identity acknowledged by whoami, when the namespace has it
+		identity string
+	}{
+		{name: "public without credentials", nsp: "/public"},
+		{name: "trusted with a token", nsp: "/trusted", auth: alice, identity: "Alice"},
+		{name: "trusted without a token", nsp: "/trusted", error: "authentication error: invalid token"},
+		{name: "trusted with an unknown token", nsp: "/trusted", auth: map[string]any{"token": "token-eve"}, error: "authentication error: invalid token"},
+		{name: "trusted with a certificate only", nsp: "/trusted", cert: &p.trusted, error: "authentication error: invalid token"},
+		{name: "internal with a certificate", nsp: "/internal", cert: &p.trusted, identity: "billing-worker"},
+		{name: "internal with a token only", nsp: "/internal", auth: alice, error: "authentication error: client certificate required"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := connectClient(t, addr, p, tc.nsp, tc.auth, tc.cert)
+			if tc.error != "" {
+				if err == nil || err.Error() != tc.error {
+					t.Fatalf("expected %q, got %v", tc.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected to connect, got %v", err)
+			}
+			if tc.identity == "" {
+				return
+			}
+			reply, _ := c.call(t, "whoami").(map[string]any)
+			if reply["name"] != tc.identity || reply["namespace"] != tc.nsp {
+				t.Errorf("expected %s on %s, got %v", tc.identity, tc.nsp, reply)
+			}
+		})
+	}
+
+	// The server refuses the TLS handshake itself, before any namespace
+	t.Run("internal with an untrusted certificate", func(t *testing.T) {
+		if _, err := connectClient(t, addr, p, "/internal", nil, &p.untrusted); err == nil {
+			t.Fatal("expected the connection to be refused")
+		}
+	})
+}
+
+// The payload limit of each namespace is checked before its handlers run.
+func TestPayloadLimits(t *testing.T) {
+	p := newPKI(t)
+	addr := setupServer(t, p)
+
+	clients := map[string]*client{}
+	for nsp, auth := range map[string]map[string]any{
+		"/public":   nil,
+		"/trusted":  {"token": "token-bob"},
+		"/internal": nil,
+	} {
+		c, err := connectClient(t, addr, p, nsp, auth, &p.trusted)
+		if err != nil {
+			t.Fatalf("%s: %v", nsp, err)
+		}
+		clients[nsp] = c
+	}
+
+	for _, tc := range []struct {
+		nsp      string
+		size     int
+		accepted bool
+	}{
+		{"/public", 500, true},
+		{"/public", 2 << 10, false},
+		{"/trusted", 2 << 10, true},
+		{"/trusted", 100 << 10, false},
+		{"/internal", 100 << 10, true},
+	} {
+		payload := strings.Repeat("x", tc.size)
+		reply := clients[tc.nsp].call(t, "echo", payload)
+		if tc.accepted {
+			if reply != payload {
+				t.Errorf("%s: expected the %d bytes payload back, got %.40v", tc.nsp, tc.size, reply)
+			}
+			continue
+		}
+		result, _ := reply.(map[string]any)
+		if message, _ := result["error"].(string); !strings.HasPrefix(message, errPayloadTooLarge.Error()+": ") {
+			t.Errorf("%s: expected the %d bytes payload to be refused, got %.40v", tc.nsp, tc.size, reply)
+		}
+	}
+}
+
+// Only the events of its configuration are handled by a namespace, and its
+// broadcasts stay in it.
+func TestNamespaceEvents(t *testing.T) {
+	p := newPKI(t)
+	addr := setupServer(t, p)
+
+	public, err := connectClient(t, addr, p, "/public", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []string{"whoami", "broadcast"} {
+		result, _ := public.call(t, event).(map[string]any)
+		if result["error"] != fmt.Sprintf("unknown event %q", event) {
+			t.Errorf("expected %s to be unknown on /public, got %v", event, result)
+		}
+	}
+
+	alice, err := connectClient(t, addr, p, "/trusted", map[string]any{"token": "token-alice"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := connectClient(t, addr, p, "/trusted", map[string]any{"token": "token-bob"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal, err := connectClient(t, addr, p, "/internal", nil, &p.trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := alice.call(t, "broadcast", "hello").(map[string]any); result["ok"] != true {
+		t.Fatalf("expected the broadcast to be acknowledged, got %v", result)
+	}
+	select {
+	case message := <-bob.messages:
+		if message["from"] != "Alice" {
+			t.Errorf("expected a message from Alice, got %v", message)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the broadcast did not reach the other socket of /trusted")
+	}
+	// The acknowledgement of another call orders it after the broadcast
+	internal.call(t, "echo", "ping")
+	select {
+	case message := <-internal.messages:
+		t.Errorf("expected no message on /internal, got %v", message)
+	default:
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := namespaceConfig{Name: "/a", Auth: authNone, MaxPayload: 1, Events: []string{"echo"}}
+	with := func(edit func(*namespaceConfig)) []namespaceConfig {
+		nsp := valid
+		edit(&nsp)
+		return []namespaceConfig{nsp}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		config []namespaceConfig
+		error  string
+	}{
+		{"default", defaultNamespaces, ""},
+		{"empty", nil, "no namespace configured"},
+		{"main namespace", with(func(n *namespaceConfig) { n.Name = "/" }), "name must start with a slash"},
+		{"relative name", with(func(n *namespaceConfig) { n.Name = "a" }), "name must start with a slash"},
+		{"duplicate", []namespaceConfig{valid, valid}, "configured twice"},
+		{"unknown auth", with(func(n *namespaceConfig) { n.Auth = "basic" }), `unknown auth "basic"`},
+		{"no payload", with(func(n *namespaceConfig) { n.MaxPayload = 0 }), "maxPayload must be positive"},
+		{"unknown event", with(func(n *namespaceConfig) { n.Events = []string{"rm -rf"} }), `unknown event "rm -rf"`},
+	} {
+		err := validateConfig(tc.config)
+		switch {
+		case tc.error == "" && err != nil:
+			t.Errorf("%s: expected no error, got %v", tc.name, err)
+		case tc.error != "" && (err == nil || !strings.Contains(err.Error(), tc.error)):
+			t.Errorf("%s: expected %q, got %v", tc.name, tc.error, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// newServer creates a Socket.IO server with the namespaces of config, whose
+// token auth accepts tokens, each mapped to a user name.
+func newServer(httpServer *types.HttpServer, config []namespaceConfig, tokens map[string]string) (*io.Server, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	options := io.DefaultServerOptions()
+	options.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, options)
+	for _, nsp := range config {
+		registerNamespace(server, nsp, tokens)
+	}
+	return server, nil
+}
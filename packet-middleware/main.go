@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Packet middleware example - validates and rewrites every incoming event
+// with the per-socket middlewares of client.Use.
+//
+// Features:
+//   - Legacy event names rewritten, "msg" becoming "message"
+//   - Events whose first argument is not an object with a "v" field rejected
+//   - Rejections reported to the client, through its acknowledgement or a
+//     "packet-rejected" event, which the library does not do
+//   - Every step logged, to show the order of the namespace middleware, the
+//     packet middlewares and the handlers
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, func(sid, step string) {
+		log.Printf("%s: %s", sid, step)
+	})
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Packet middleware server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
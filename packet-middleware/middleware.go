@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// legacyEvents maps the event names of older clients to the current ones.
+var legacyEvents = map[string]string{
+	"msg": "message",
+}
+
+// packetError is the error of a packet rejected by validatePacket, which the
+// socket receives as an "error" event.
+type packetError struct {
+	event  string
+	reason string
+	// ack is the acknowledgement the client asked for, if any
+	ack io.Ack
+}
+
+func (e *packetError) Error() string {
+	return fmt.Sprintf("invalid packet %q: %s", e.event, e.reason)
+}
+
+// normalizeEvents returns a packet middleware renaming the events of
+// legacyEvents in place, so that the middlewares after it and the handlers
+// see the current name.
+func normalizeEvents(step func(string)) io.SocketMiddleware {
+	return func(event []any, next func(error)) {
+		name, _ := event[0].(string)
+		if current, ok := legacyEvents[name]; ok {
+			step("normalize " + name)
+			event[0] = current
+		}
+		next(nil)
+	}
+}
+
+// validatePacket returns a packet middleware rejecting the events whose first
+// argument is not an object with a "v" field, the version of the payload.
+func validatePacket(step func(string)) io.SocketMiddleware {
+	return func(event []any, next func(error)) {
+		name, _ := event[0].(string)
+		step("validate " + name)
+
+		args := event[1:]
+		var ack io.Ack
+		if len(args) > 0 {
+			if fn, ok := args[len(args)-1].(io.Ack); ok {
+				args, ack = args[:len(args)-1], fn
+			}
+		}
+
+		reason := ""
+		if len(args) == 0 {
+			reason = "missing payload"
+		} else if payload, ok := args[0].(map[string]any); !ok {
+			reason = "payload is not an object"
+		} else if _, ok := payload["v"]; !ok {
+			reason = `payload has no "v" field`
+		}
+		if reason != "" {
+			step("reject " + name)
+			next(&packetError{event: name, reason: reason, ack: ack})
+			return
+		}
+		next(nil)
+	}
+}
+
+// reportRejections tells the client about the packets its middlewares
+// rejected. The library drops them, and only emits an "error" event on the
+// server side socket: without this listener, the client would get neither an
+// error nor a disconnection, and its acknowledgement would never be called.
+func reportRejections(client *io.Socket) {
+	client.On("error", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		err, _ := args[0].(error)
+		var rejected *packetError
+		if !errors.As(err, &rejected) {
+			return
+		}
+		reply := map[string]any{"error": rejected.Error(), "event": rejected.event}
+		if rejected.ack != nil {
+			rejected.ack([]any{reply}, nil)
+			return
+		}
+		client.Emit("packet-rejected", reply)
+	})
+}
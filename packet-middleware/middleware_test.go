@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// setupServer starts the server and returns its address, and the steps it
+// traces.
+func setupServer(t *testing.T) (string, chan string) {
+	t.Helper()
+
+	steps := make(chan string, 100)
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, func(_, step string) { steps <- step })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String(), steps
+}
+
+// client is a connected socket and the rejections it was told about.
+type client struct {
+	*io_client.Socket
+	rejected chan map[string]any
+}
+
+// connectClient connects a client. Websocket only, as the polling upgrade
+// occasionally stalls the connection.
+func connectClient(t *testing.T, addr string) *client {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	c := &client{
+		Socket:   manager.Socket("/", io_client.DefaultSocketOptions()),
+		rejected: make(chan map[string]any, 10),
+	}
+	t.Cleanup(func() { c.Disconnect() })
+
+	c.On("packet-rejected", func(args ...any) {
+		reply, _ := args[0].(map[string]any)
+		c.rejected <- reply
+	})
+
+	connected := make(chan struct{})
+	c.Once("connect", func(...any) { close(connected) })
+	c.Connect()
+
+	select {
+	case <-connected:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// call emits event and returns the first argument of the acknowledgement.
+func (c *client) call(t *testing.T, event string, args ...any) map[string]any {
+	t.Helper()
+
+	reply := make(chan map[string]any, 1)
+	c.EmitWithAck(event, args...)(func(args []any, _ error) {
+		value, _ := args[0].(map[string]any)
+		reply <- value
+	})
+
+	select {
+	case value := <-reply:
+		return value
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s was not acknowledged", event)
+		return nil
+	}
+}
+
+// expectSteps checks that the server traced exactly want since the last call.
+// The steps of a packet are all traced before its acknowledgement is sent.
+func expectSteps(t *testing.T, steps chan string, want ...string) {
+	t.Helper()
+
+	var got []string
+	for {
+		select {
+		case step := <-steps:
+			got = append(got, step)
+			continue
+		default:
+		}
+		break
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the steps %q, got %q", want, got)
+	}
+}
+
+// The namespace middleware runs once, when the socket connects, and the
+// packet middlewares before the handler of every event.
+func TestValidPacket(t *testing.T) {
+	addr, steps := setupServer(t)
+	c := connectClient(t, addr)
+
+	for range 2 {
+		reply := c.call(t, "message", map[string]any{"v": 1, "text": "hello"})
+		received, _ := reply["received"].(map[string]any)
+		if reply["ok"] != true || received["text"] != "hello" {
+			t.Fatalf("expected the message to be handled, got %v", reply)
+		}
+	}
+	expectSteps(t, steps, "connect",
+		"validate message", "handle message",
+		"validate message", "handle message",
+	)
+}
+
+// A legacy event name is rewritten before it is validated, and reaches the
+// handler of the current name.
+func TestLegacyEventName(t *testing.T) {
+	addr, steps := setupServer(t)
+	c := connectClient(t, addr)
+	expectSteps(t, steps, "connect")
+
+	reply := c.call(t, "msg", map[string]any{"v": 1, "text": "hello"})
+	if reply["ok"] != true {
+		t.Fatalf("expected msg to be handled as message, got %v", reply)
+	}
+	expectSteps(t, steps, "normalize msg", "validate message", "handle message")
+
+	// The rewrite does not exempt the event from the validation
+	reply = c.call(t, "msg", "hello")
+	if reply["event"] != "message" {
+		t.Errorf("expected the rejection of message, got %v", reply)
+	}
+	expectSteps(t, steps, "normalize msg", "validate message", "reject message")
+}
+
+// A rejected packet never reaches its handler, and the socket stays connected.
+func TestInvalidPacket(t *testing.T) {
+	addr, steps := setupServer(t)
+	c := connectClient(t, addr)
+	expectSteps(t, steps, "connect")
+
+	for _, tc := range []struct {
+		name   string
+		args   []any
+		reason string
+	}{
+		{"without payload", nil, "missing payload"},
+		{"with a string", []any{"hello"}, "payload is not an object"},
+		{"without version", []any{map[string]any{"text": "hello"}}, `payload has no "v" field`},
+	} {
+		reply := c.call(t, "message", tc.args...)
+		if message, _ := reply["error"].(string); !strings.HasSuffix(message, tc.reason) {
+			t.Errorf("%s: expected %q, got %v", tc.name, tc.reason, reply)
+		}
+		expectSteps(t, steps, "validate message", "reject message")
+	}
+
+	// Without an acknowledgement, the rejection comes as an event
+	c.Emit("message", "hello")
+	select {
+	case reply := <-c.rejected:
+		if reply["event"] != "message" || reply["error"] != `invalid packet "message": payload is not an object` {
+			t.Errorf("expected the rejection of message, got %v", reply)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the rejection was not reported")
+	}
+	expectSteps(t, steps, "validate message", "reject message")
+
+	if !c.Connected() {
+		t.Fatal("expected the socket to stay connected")
+	}
+	if reply := c.call(t, "message", map[string]any{"v": 2}); reply["ok"] != true {
+		t.Errorf("expected the next valid packet to be handled, got %v", reply)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// newServer creates a Socket.IO server validating the packets of its sockets,
+// and calling trace with the id of the socket and each step a connection or
+// a packet goes through.
+func newServer(httpServer *types.HttpServer, trace func(sid, step string)) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+
+	// The namespace middleware runs once per socket, when it connects, and
+	// the packet middlewares on every event it sends afterwards
+	server.Use(func(client *io.Socket, next func(*io.ExtendedError)) {
+		trace(string(client.Id()), "connect")
+		next(nil)
+	})
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+		step := func(step string) { trace(string(client.Id()), step) }
+
+		// In order: the events are renamed before they are validated
+		client.Use(normalizeEvents(step))
+		client.Use(validatePacket(step))
+		reportRejections(client)
+
+		client.On("message", func(args ...any) {
+			step("handle message")
+			if len(args) < 2 {
+				return
+			}
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{map[string]any{"ok": true, "received": args[0]}}, nil)
+			}
+		})
+	})
+	return server
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+const testAdminToken = "test-admin-token"
+
+// setupServer starts the server and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, testAdminToken)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// client is a socket and the greetings it receives.
+type client struct {
+	*io_client.Socket
+	greetings chan map[string]any
+}
+
+// newClient returns a socket of namespace, with the given handshake auth,
+// which is not connected yet. Websocket only, as the polling upgrade
+// occasionally stalls the connection.
+func newClient(t *testing.T, addr, namespace string, auth map[string]any) *client {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	socketOpts := io_client.DefaultSocketOptions()
+	socketOpts.SetAuth(auth)
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	c := &client{
+		Socket:    manager.Socket(namespace, socketOpts),
+		greetings: make(chan map[string]any, 10),
+	}
+	t.Cleanup(func() { c.Disconnect() })
+	c.On("personal-greeting", func(args ...any) {
+		if len(args) > 0 {
+			greeting, _ := args[0].(map[string]any)
+			c.greetings <- greeting
+		}
+	})
+	return c
+}
+
+// connectClient connects a socket of namespace.
+func connectClient(t *testing.T, addr, namespace string, auth map[string]any) *client {
+	t.Helper()
+
+	c := newClient(t, addr, namespace, auth)
+	connected := make(chan struct{}, 1)
+	c.Once("connect", func(...any) { connected <- struct{}{} })
+	c.Connect()
+
+	select {
+	case <-connected:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// triggerGreetings has admin trigger the greetings, and returns the number of
+// sockets greeted.
+func triggerGreetings(t *testing.T, admin *client) float64 {
+	t.Helper()
+
+	reply := make(chan map[string]any, 1)
+	admin.EmitWithAck("greet-everyone")(func(args []any, err error) {
+		result := map[string]any{}
+		if len(args) > 0 {
+			result, _ = args[0].(map[string]any)
+		}
+		reply <- result
+	})
+
+	select {
+	case result := <-reply:
+		greeted, _ := result["greeted"].(float64)
+		return greeted
+	case <-time.After(3 * time.Second):
+		t.Fatal("greet-everyone was not acknowledged")
+		return 0
+	}
+}
+
+func TestPersonalGreeting(t *testing.T) {
+	addr := setupServer(t)
+	clients := []*client{
+		connectClient(t, addr, "/", nil),
+		connectClient(t, addr, "/", nil),
+		connectClient(t, addr, "/", nil),
+	}
+	time.Sleep(50 * time.Millisecond)
+	admin := connectClient(t, addr, adminNamespace, map[string]any{"token": testAdminToken})
+
+	// The admin socket is in its own namespace, and not greeted
+	if greeted := triggerGreetings(t, admin); greeted != 3 {
+		t.Fatalf("expected 3 sockets greeted, got %v", greeted)
+	}
+
+	for i, c := range clients {
+		select {
+		case greeting := <-c.greetings:
+			if greeting["id"] != string(c.Id()) {
+				t.Fatalf("client %d: expected a greeting for %s, got %v", i, c.Id(), greeting)
+			}
+			if ms, ok := greeting["connectedMs"].(float64); !ok || ms < 50 || ms > 5000 {
+				t.Fatalf("client %d: expected the time since its connection, got %v", i, greeting["connectedMs"])
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("client %d: expected a greeting", i)
+		}
+	}
+
+	// Each client got its own greeting only
+	time.Sleep(100 * time.Millisecond)
+	for i, c := range append(clients, admin) {
+		select {
+		case greeting := <-c.greetings:
+			t.Fatalf("client %d: unexpected greeting %v", i, greeting)
+		default:
+		}
+	}
+}
+
+func TestPersonalGreetingDisconnected(t *testing.T) {
+	addr := setupServer(t)
+	stays := connectClient(t, addr, "/", nil)
+	leaves := connectClient(t, addr, "/", nil)
+	admin := connectClient(t, addr, adminNamespace, map[string]any{"token": testAdminToken})
+
+	// The server sees the disconnection shortly after the client, every
+	// attempt until then greets both
+	leaves.Disconnect()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		greeted := triggerGreetings(t, admin)
+		select {
+		case greeting := <-stays.greetings:
+			if greeting["id"] != string(stays.Id()) {
+				t.Fatalf("expected a greeting for %s, got %v", stays.Id(), greeting)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("expected a greeting")
+		}
+		if greeted == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the disconnected client to be left out")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	addr := setupServer(t)
+
+	for _, auth := range []map[string]any{nil, {"token": "wrong"}} {
+		c := newClient(t, addr, adminNamespace, auth)
+		refused := make(chan any, 1)
+		c.Once("connect_error", func(args ...any) {
+			if len(args) > 0 {
+				refused <- args[0]
+			}
+		})
+		c.Connect()
+
+		select {
+		case err := <-refused:
+			if e, ok := err.(error); !ok || e.Error() != "unauthorized" {
+				t.Fatalf("auth %v: expected unauthorized, got %v", auth, err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("auth %v: expected the connection to be refused", auth)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Personalized emit example - sends every connected socket its own event,
+// with a payload built for it, instead of one broadcast shared by all.
+//
+// Features:
+//   - "greet-everyone" on the /admin namespace triggers the greetings
+//   - Each socket of the main namespace gets a "personal-greeting" with its
+//     own id and how long it has been connected
+//   - The sockets are snapshotted before the emits
+//
+// The admins authenticate with the token of the ADMIN_TOKEN environment
+// variable, or a random one printed at startup.
+
+func main() {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		adminToken = rand.Text()
+		fmt.Printf("ADMIN_TOKEN is not set, the admin token is %s\n", adminToken)
+	}
+
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, adminToken)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Personalized emit server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/subtle"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// adminNamespace is the namespace of the clients allowed to trigger the
+// greetings.
+const adminNamespace = "/admin"
+
+// newServer creates a Socket.IO server greeting each socket of its main
+// namespace individually when an admin asks for it.
+func newServer(httpServer *types.HttpServer, adminToken string) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+
+	admin := server.Of(adminNamespace, nil)
+	admin.Use(func(s *io.Socket, next func(*io.ExtendedError)) {
+		token, _ := s.Handshake().Auth["token"].(string)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			next(io.NewExtendedError("unauthorized", map[string]any{"message": "invalid admin token"}))
+			return
+		}
+		next(nil)
+	})
+	admin.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the admin emits 'greet-everyone', greet every socket of the
+		// main namespace, and acknowledge with the number of sockets greeted
+		client.On("greet-everyone", func(args ...any) {
+			greeted := greetEveryone(server.Sockets())
+			if len(args) > 0 {
+				if ack, ok := args[len(args)-1].(io.Ack); ok {
+					ack([]any{map[string]any{"greeted": greeted}}, nil)
+				}
+			}
+		})
+	})
+	return server
+}
+
+// greetEveryone emits "personal-greeting" to each socket of nsp, with its own
+// id and how long it has been connected, in milliseconds, and returns the
+// number of sockets greeted.
+//
+// The sockets are copied out of the map of the namespace before the emits.
+// The map is not locked while iterated, and the sockets come and go
+// meanwhile, so no iteration is a consistent snapshot: the copy fixes the set
+// of sockets greeted and counted, and keeps the emits, and whatever they
+// trigger, out of the iteration. Guarding the map with a lock of one's own
+// instead would hold every connection and disconnection behind the emits. A
+// socket connecting after the copy is not greeted, and the greeting of one
+// disconnecting after it is dropped.
+func greetEveryone(nsp io.Namespace) int {
+	sockets := nsp.Sockets().Values()
+	now := time.Now()
+	for _, client := range sockets {
+		// The handshake is built when the socket connects to the namespace,
+		// Issued is in milliseconds since the epoch
+		connectedAt := time.UnixMilli(client.Handshake().Issued)
+		client.Emit("personal-greeting", map[string]any{
+			"id":          client.Id(),
+			"connectedMs": now.Sub(connectedAt).Milliseconds(),
+		})
+	}
+	return len(sockets)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Presence example - tracks which users are online, whatever the number of
+// connections, or browser tabs, each of them has open.
+//
+// Features:
+//   - Clients authenticate with a user id, and join the room of their user
+//   - "user-online" broadcast to the lobby on the first connection of a user,
+//     and "user-offline" on its last disconnection
+//   - "who-is-online" acknowledged with the ids of the users online
+//
+// Connect with the user id in the auth:
+//
+//	opts := io_client.DefaultSocketOptions()
+//	opts.SetAuth(map[string]any{"userId": "alice"})
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Presence server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// presence counts the open connections of each user, so that a user with
+// several tabs comes online with the first one and goes offline with the last
+// one. The counts are updated with compare-and-swap loops, without a lock
+// around the map.
+type presence struct {
+	// counts maps each online user id to its number of connections, never 0.
+	counts sync.Map
+}
+
+// connect counts a new connection of user, and reports whether it is the
+// first one.
+func (p *presence) connect(user string) bool {
+	for {
+		count, loaded := p.counts.LoadOrStore(user, 1)
+		if !loaded {
+			return true
+		}
+		// Fails when the last connection left in the meantime, and the next
+		// attempt stores a new count
+		if p.counts.CompareAndSwap(user, count, count.(int)+1) {
+			return false
+		}
+	}
+}
+
+// disconnect counts a closed connection of user, and reports whether it was
+// the last one.
+func (p *presence) disconnect(user string) bool {
+	for {
+		count, ok := p.counts.Load(user)
+		if !ok {
+			return false
+		}
+		if count == 1 {
+			if p.counts.CompareAndDelete(user, 1) {
+				return true
+			}
+			continue
+		}
+		if p.counts.CompareAndSwap(user, count, count.(int)-1) {
+			return false
+		}
+	}
+}
+
+// online returns the sorted ids of the users online.
+func (p *presence) online() []string {
+	users := []string{}
+	p.counts.Range(func(user, _ any) bool {
+		users = append(users, user.(string))
+		return true
+	})
+	slices.Sort(users)
+	return users
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// setupServer starts the server and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// connectClient connects a client of user, with a connection of its own.
+// Websocket only, as the polling upgrade occasionally stalls the connection.
+func connectClient(t *testing.T, addr, user string) *io_client.Socket {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	sockOpts := io_client.DefaultSocketOptions()
+	sockOpts.SetAuth(map[string]any{"userId": user})
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	client := manager.Socket("/", sockOpts)
+	t.Cleanup(func() { client.Disconnect() })
+
+	connected := make(chan struct{}, 1)
+	client.Once("connect", func(...any) { connected <- struct{}{} })
+	client.Connect()
+
+	select {
+	case <-connected:
+		return client
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not connect", user)
+		return nil
+	}
+}
+
+// collect returns a channel receiving the user id of each event.
+func collect(client *io_client.Socket, event string) <-chan string {
+	ch := make(chan string, 10)
+	client.On(types.EventName(event), func(args ...any) {
+		if len(args) > 0 {
+			payload, _ := args[0].(map[string]any)
+			user, _ := payload["userId"].(string)
+			ch <- user
+		}
+	})
+	return ch
+}
+
+// expectOnline checks the snapshot of who-is-online. Its acknowledgement
+// follows any presence event sent to client before the request.
+func expectOnline(t *testing.T, client *io_client.Socket, expected ...string) {
+	t.Helper()
+
+	var once sync.Once
+	reply := make(chan []any, 1)
+	client.EmitWithAck("who-is-online")(func(args []any, err error) {
+		once.Do(func() { reply <- args })
+	})
+
+	select {
+	case args := <-reply:
+		if got, want := fmt.Sprint(args), fmt.Sprint([]any{expected}); got != want {
+			t.Fatalf("expected %s online, got %s", want, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("who-is-online was not acknowledged")
+	}
+}
+
+// expectEvent waits for the user id of an event.
+func expectEvent(t *testing.T, events <-chan string, event, user string) {
+	t.Helper()
+
+	select {
+	case got := <-events:
+		if got != user {
+			t.Fatalf("expected %s for %s, got %s", event, user, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected %s for %s", event, user)
+	}
+}
+
+// expectNoEvent checks that no event arrived.
+func expectNoEvent(t *testing.T, events <-chan string, event string, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case user := <-events:
+		t.Fatalf("unexpected %s for %s", event, user)
+	case <-time.After(wait):
+	}
+}
+
+func TestPresence(t *testing.T) {
+	addr := setupServer(t)
+
+	b := connectClient(t, addr, "b")
+	online := collect(b, "user-online")
+	offline := collect(b, "user-offline")
+	expectOnline(t, b, "b")
+
+	// The first connection of a brings it online
+	a1 := connectClient(t, addr, "a")
+	expectEvent(t, online, "user-online", "a")
+	expectOnline(t, b, "a", "b")
+
+	// Its second one changes nothing
+	a2 := connectClient(t, addr, "a")
+	expectOnline(t, b, "a", "b")
+	expectNoEvent(t, online, "user-online", 0)
+
+	// a stays online while one of its connections is open
+	a1.Disconnect()
+	expectNoEvent(t, offline, "user-offline", 500*time.Millisecond)
+	expectOnline(t, b, "a", "b")
+
+	a2.Disconnect()
+	expectEvent(t, offline, "user-offline", "a")
+	expectOnline(t, b, "b")
+}
+
+func TestPresenceRequiresUserId(t *testing.T) {
+	addr := setupServer(t)
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+	client := io_client.NewManager("http://"+addr, managerOpts).Socket("/", io_client.DefaultSocketOptions())
+	t.Cleanup(func() { client.Disconnect() })
+
+	errors := make(chan any, 1)
+	client.Once("connect_error", func(args ...any) { errors <- args[0] })
+	client.Connect()
+
+	select {
+	case err := <-errors:
+		if fmt.Sprint(err) != "authentication error" {
+			t.Fatalf("expected an authentication error, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected connect_error")
+	}
+}
+
+func TestPresenceCounts(t *testing.T) {
+	var p presence
+
+	// Concurrent connections and disconnections of the same user come online
+	// and go offline once each time the count leaves and returns to 0
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		first, last int
+	)
+	for range 50 {
+		wg.Go(func() {
+			for range 100 {
+				isFirst := p.connect("a")
+				isLast := p.disconnect("a")
+				mu.Lock()
+				if isFirst {
+					first++
+				}
+				if isLast {
+					last++
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	if first != last || first == 0 {
+		t.Fatalf("expected as many first connections as last ones, got %d and %d", first, last)
+	}
+	if users := p.online(); len(users) != 0 {
+		t.Fatalf("expected no user online, got %v", users)
+	}
+}
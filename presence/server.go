@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// lobby is the room every client joins, where the presence changes are
+// broadcast.
+const lobby io.Room = "lobby"
+
+// userRoomPrefix prefixes the room of each user, e.g. "user:alice", which
+// every connection of the user joins.
+const userRoomPrefix = "user:"
+
+// newServer creates a Socket.IO server tracking the presence of the users of
+// its clients.
+func newServer(httpServer *types.HttpServer) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	users := &presence{}
+
+	// The user id comes from the auth, as a real application would get it
+	// from a verified token
+	server.Use(func(client *io.Socket, next func(*io.ExtendedError)) {
+		user, _ := client.Handshake().Auth["userId"].(string)
+		if user == "" {
+			next(io.NewExtendedError("authentication error", map[string]any{
+				"message": "no user id provided",
+			}))
+			return
+		}
+		next(nil)
+	})
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		user := client.Handshake().Auth["userId"].(string)
+		client.Join(io.Room(userRoomPrefix+user), lobby)
+		if users.connect(user) {
+			fmt.Printf("User %q online\n", user)
+			client.To(lobby).Emit("user-online", map[string]any{"userId": user})
+		}
+
+		// When the client emits 'who-is-online', acknowledge with the ids of
+		// the users online
+		client.On("who-is-online", func(args ...any) {
+			if len(args) > 0 {
+				if ack, ok := args[len(args)-1].(io.Ack); ok {
+					ack([]any{users.online()}, nil)
+				}
+			}
+		})
+
+		// The socket is still in its rooms while disconnecting, and has left
+		// them all once disconnected
+		client.On("disconnecting", func(...any) {
+			for _, room := range client.Rooms().Keys() {
+				user, ok := strings.CutPrefix(string(room), userRoomPrefix)
+				if ok && users.disconnect(user) {
+					fmt.Printf("User %q offline\n", user)
+					client.To(lobby).Emit("user-offline", map[string]any{"userId": user})
+				}
+			}
+		})
+	})
+	return server
+}
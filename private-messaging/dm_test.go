@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// setupServer starts the server and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// client is a connected socket and the direct messages it receives.
+type client struct {
+	*io_client.Socket
+	dms chan map[string]any
+}
+
+// connectClient connects a client. Websocket only, as the polling upgrade
+// occasionally stalls the connection.
+func connectClient(t *testing.T, addr string) *client {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	c := &client{
+		Socket: manager.Socket("/", io_client.DefaultSocketOptions()),
+		dms:    make(chan map[string]any, 10),
+	}
+	t.Cleanup(func() { c.Disconnect() })
+	c.On("dm", func(args ...any) {
+		if len(args) > 0 {
+			dm, _ := args[0].(map[string]any)
+			c.dms <- dm
+		}
+	})
+
+	connected := make(chan struct{}, 1)
+	c.Once("connect", func(...any) { connected <- struct{}{} })
+	c.Connect()
+
+	select {
+	case <-connected:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// dm sends a direct message and returns its acknowledgement.
+func (c *client) dm(t *testing.T, target, text string) map[string]any {
+	t.Helper()
+
+	reply := make(chan map[string]any, 1)
+	c.EmitWithAck("dm", target, text)(func(args []any, err error) {
+		result := map[string]any{}
+		if len(args) > 0 {
+			result, _ = args[0].(map[string]any)
+		}
+		reply <- result
+	})
+
+	select {
+	case result := <-reply:
+		return result
+	case <-time.After(3 * time.Second):
+		t.Fatal("dm was not acknowledged")
+		return nil
+	}
+}
+
+// expectDM checks the next direct message of c.
+func (c *client) expectDM(t *testing.T, from, text string) {
+	t.Helper()
+
+	select {
+	case dm := <-c.dms:
+		if dm["from"] != from || dm["text"] != text {
+			t.Fatalf("expected %q from %s, got %v", text, from, dm)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected %q from %s", text, from)
+	}
+}
+
+// expectNoDM checks that c received no direct message.
+func (c *client) expectNoDM(t *testing.T) {
+	t.Helper()
+
+	select {
+	case dm := <-c.dms:
+		t.Fatalf("unexpected dm %v", dm)
+	default:
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	addr := setupServer(t)
+	alice := connectClient(t, addr)
+	bob := connectClient(t, addr)
+	carol := connectClient(t, addr)
+
+	if result := alice.dm(t, string(bob.Id()), "hi bob"); result["delivered"] != true {
+		t.Fatalf("expected the dm to bob to be delivered, got %v", result)
+	}
+	bob.expectDM(t, string(alice.Id()), "hi bob")
+
+	if result := bob.dm(t, string(alice.Id()), "hi alice"); result["delivered"] != true {
+		t.Fatalf("expected the dm to alice to be delivered, got %v", result)
+	}
+	alice.expectDM(t, string(bob.Id()), "hi alice")
+
+	// The acknowledgement of a last message to carol follows every message
+	// sent to her before, had there been any
+	if result := bob.dm(t, string(carol.Id()), "hi carol"); result["delivered"] != true {
+		t.Fatalf("expected the dm to carol to be delivered, got %v", result)
+	}
+	carol.expectDM(t, string(bob.Id()), "hi carol")
+	for _, c := range []*client{alice, bob, carol} {
+		c.expectNoDM(t)
+	}
+}
+
+func TestDirectMessageErrors(t *testing.T) {
+	addr := setupServer(t)
+	alice := connectClient(t, addr)
+	bob := connectClient(t, addr)
+
+	for _, tc := range []struct {
+		name   string
+		target string
+		err    string
+	}{
+		{"made-up sid", "not-a-socket-id", "unknown target"},
+		{"self", string(alice.Id()), "cannot message yourself"},
+		{"empty target", "", "expected a target and a text"},
+	} {
+		result := alice.dm(t, tc.target, "hello")
+		if result["delivered"] != false || result["error"] != tc.err {
+			t.Errorf("%s: expected delivered=false with %q, got %v", tc.name, tc.err, result)
+		}
+	}
+
+	// A disconnected target is unknown too
+	target := string(bob.Id())
+	bob.Disconnect()
+	deadline := time.Now().Add(3 * time.Second)
+	for alice.dm(t, target, "still there?")["delivered"] != false {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the dm to a disconnected client to be undelivered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	alice.expectNoDM(t)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Private messaging example - sends a message to one specific client, through
+// the room every socket joins under its own id.
+//
+// Features:
+//   - "dm" delivered to the target socket only, with the id of the sender
+//   - The sender's acknowledgement reports whether the target is connected
+//   - Messages to oneself refused, and unknown targets reported as undelivered
+//
+// Clients learn their own id on connection, and share it with the clients
+// that should be able to reach them.
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Private messaging server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// newServer creates a Socket.IO server relaying direct messages between its
+// clients.
+func newServer(httpServer *types.HttpServer) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'dm', with the id of the target socket and
+		// the text, send it to the room of the target, which only the target
+		// is in, and acknowledge whether it was there
+		client.On("dm", func(args ...any) {
+			var ack io.Ack
+			if len(args) > 0 {
+				ack, _ = args[len(args)-1].(io.Ack)
+			}
+			reply := func(result map[string]any) {
+				if ack != nil {
+					ack([]any{result}, nil)
+				}
+			}
+
+			if len(args) < 2 {
+				reply(map[string]any{"delivered": false, "error": "expected a target and a text"})
+				return
+			}
+			target, _ := args[0].(string)
+			text, ok := args[1].(string)
+			switch {
+			case target == "" || !ok:
+				reply(map[string]any{"delivered": false, "error": "expected a target and a text"})
+				return
+			case io.SocketId(target) == client.Id():
+				reply(map[string]any{"delivered": false, "error": "cannot message yourself"})
+				return
+			}
+
+			// Emitting to an empty room silently does nothing, so the adapter
+			// is asked first. The local adapter only knows the sockets of this
+			// server: behind a cluster adapter, FetchSockets would tell.
+			room := io.Room(target)
+			members, ok := server.Sockets().Adapter().Rooms().Load(room)
+			if !ok || members.Len() == 0 {
+				reply(map[string]any{"delivered": false, "error": "unknown target"})
+				return
+			}
+
+			server.To(room).Emit("dm", map[string]any{
+				"from": client.Id(),
+				"text": text,
+			})
+			reply(map[string]any{"delivered": true})
+		})
+	})
+	return server
+}
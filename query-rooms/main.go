@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Query rooms example - joins each client to the rooms it names in the query
+// of its handshake, such as ?rooms=news,alerts, as soon as it connects.
+//
+// Features:
+//   - Comma-separated "rooms" query parameter, possibly repeated
+//   - Names checked against a whitelist pattern, and at most 10 rooms joined
+//   - "joined-rooms" event listing the rooms joined and the names rejected,
+//     with the reason
+//   - Broadcasts into the rooms reaching the client with no further action
+//
+// The query is read once per connection: a client changing its rooms
+// reconnects with a new query.
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Query rooms server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
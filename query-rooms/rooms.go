@@ -0,0 +1,51 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// roomName is the pattern of the room names clients may join: lowercase
+// letters, digits, dashes and underscores, starting with a letter or a digit.
+// It leaves out dots and slashes, which names used as paths or keys elsewhere
+// could abuse, and whitespace and control characters, which would end up in
+// logs.
+var roomName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// maxRooms is the number of rooms a client may join from the query.
+const maxRooms = 10
+
+// rejection is a requested name that was not joined, and why.
+type rejection struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// parseRooms splits the "rooms" query values, comma-separated and possibly
+// repeated, into the valid names, without duplicates, and the rejected ones.
+// The names for which reserved returns true are rejected.
+func parseRooms(values []string, reserved func(name string) bool) (rooms []string, rejected []rejection) {
+	// Encoded as empty arrays rather than null
+	rooms, rejected = []string{}, []rejection{}
+
+	seen := map[string]bool{}
+	for _, value := range values {
+		for name := range strings.SplitSeq(value, ",") {
+			name = strings.TrimSpace(name)
+			switch {
+			case name == "" || seen[name]:
+				continue
+			case !roomName.MatchString(name):
+				rejected = append(rejected, rejection{Name: name, Reason: "invalid name"})
+			case reserved(name):
+				rejected = append(rejected, rejection{Name: name, Reason: "reserved name"})
+			case len(rooms) == maxRooms:
+				rejected = append(rejected, rejection{Name: name, Reason: "too many rooms"})
+			default:
+				rooms = append(rooms, name)
+			}
+			seen[name] = true
+		}
+	}
+	return rooms, rejected
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// setupServer starts the server and returns it with its address.
+func setupServer(t *testing.T) (*io.Server, string) {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ioServer, ln.Addr().String()
+}
+
+// connectClient connects a client with query, and returns it with its
+// "joined-rooms" event and the channel of its messages. Websocket only, as
+// the polling upgrade occasionally stalls the connection.
+func connectClient(t *testing.T, addr string, query url.Values) (*io_client.Socket, map[string]any, <-chan map[string]any) {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+	managerOpts.SetQuery(query)
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	client := manager.Socket("/", io_client.DefaultSocketOptions())
+	t.Cleanup(func() { client.Disconnect() })
+
+	joined := make(chan map[string]any, 1)
+	client.Once("joined-rooms", func(args ...any) {
+		result, _ := args[0].(map[string]any)
+		joined <- result
+	})
+	messages := make(chan map[string]any, 10)
+	client.On("message", func(args ...any) {
+		message, _ := args[0].(map[string]any)
+		messages <- message
+	})
+	client.Connect()
+
+	select {
+	case result := <-joined:
+		return client, result, messages
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected joined-rooms")
+		return nil, nil, nil
+	}
+}
+
+// expectMessage waits for the next message.
+func expectMessage(t *testing.T, messages <-chan map[string]any, room, text string) {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		if message["room"] != room || message["text"] != text {
+			t.Fatalf("expected %q in %s, got %v", text, room, message)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected %q in %s", text, room)
+	}
+}
+
+func TestJoinFromQuery(t *testing.T) {
+	server, addr := setupServer(t)
+	_, result, messages := connectClient(t, addr, url.Values{"rooms": {"news,alerts,../etc"}})
+
+	expected := map[string]any{
+		"joined":   []any{"news", "alerts"},
+		"rejected": []any{map[string]any{"name": "../etc", "reason": "invalid name"}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+
+	// Broadcasts into the rooms reach the client with no further action
+	for _, room := range []string{"news", "alerts"} {
+		server.To(io.Room(room)).Emit("message", map[string]any{"room": room, "text": "hello " + room})
+		expectMessage(t, messages, room, "hello "+room)
+	}
+	server.To("etc").Emit("message", map[string]any{"room": "etc", "text": "hello etc"})
+	select {
+	case message := <-messages:
+		t.Fatalf("unexpected message %v", message)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestPublish(t *testing.T) {
+	_, addr := setupServer(t)
+	alice, _, aliceMessages := connectClient(t, addr, url.Values{"rooms": {"news"}})
+	_, _, bobMessages := connectClient(t, addr, url.Values{"rooms": {"news"}})
+	_, _, carolMessages := connectClient(t, addr, url.Values{"rooms": {"alerts"}})
+
+	// Only the members of a room publish into it, and the others receive it
+	alice.Emit("publish", "alerts", "not a member")
+	alice.Emit("publish", "news", "breaking")
+	expectMessage(t, bobMessages, "news", "breaking")
+	for _, messages := range []<-chan map[string]any{aliceMessages, carolMessages} {
+		select {
+		case message := <-messages:
+			t.Fatalf("unexpected message %v", message)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func TestParseRooms(t *testing.T) {
+	many := make([]string, maxRooms+1)
+	for i := range many {
+		many[i] = "room" + string(rune('a'+i))
+	}
+	noneReserved := func(string) bool { return false }
+
+	for _, tc := range []struct {
+		name     string
+		values   []string
+		reserved func(string) bool
+		rooms    []string
+		rejected []rejection
+	}{
+		{"none", nil, noneReserved, []string{}, []rejection{}},
+		{"repeated and duplicated", []string{"news, alerts", "news", ",,"}, noneReserved, []string{"news", "alerts"}, []rejection{}},
+		{"invalid", []string{"../etc,News,a b,-x,a:b," + strings.Repeat("x", 33)}, noneReserved, []string{}, []rejection{
+			{"../etc", "invalid name"},
+			{"News", "invalid name"},
+			{"a b", "invalid name"},
+			{"-x", "invalid name"},
+			{"a:b", "invalid name"},
+			{strings.Repeat("x", 33), "invalid name"},
+		}},
+		{"reserved", []string{"news,sid"}, func(name string) bool { return name == "sid" }, []string{"news"}, []rejection{{"sid", "reserved name"}}},
+		{"too many", []string{strings.Join(many, ",")}, noneReserved, many[:maxRooms], []rejection{{many[maxRooms], "too many rooms"}}},
+	} {
+		rooms, rejected := parseRooms(tc.values, tc.reserved)
+		if !reflect.DeepEqual(rooms, tc.rooms) || !reflect.DeepEqual(rejected, tc.rejected) {
+			t.Errorf("%s: expected %v %v, got %v %v", tc.name, tc.rooms, tc.rejected, rooms, rejected)
+		}
+	}
+}
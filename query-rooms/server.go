@@ -0,0 +1,61 @@
+package main
+
+import (
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// newServer creates a Socket.IO server joining each client to the rooms of
+// the "rooms" query parameter of its handshake.
+func newServer(httpServer *types.HttpServer) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+
+	// Every socket is in a private room named after its id, which no client
+	// may join
+	isSocket := func(name string) bool {
+		_, ok := server.Sockets().Sockets().Load(io.SocketId(name))
+		return ok
+	}
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// The query values are lists, as a parameter may be repeated
+		values, _ := client.Handshake().Query["rooms"].([]string)
+		rooms, rejected := parseRooms(values, isSocket)
+		for _, room := range rooms {
+			client.Join(io.Room(room))
+		}
+		client.Emit("joined-rooms", map[string]any{
+			"joined":   rooms,
+			"rejected": rejected,
+		})
+
+		// When the client emits 'publish', with a room it is in and a text,
+		// send the text to the other members of the room
+		client.On("publish", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			room, _ := args[0].(string)
+			text, ok := args[1].(string)
+			if !ok || !client.Rooms().Has(io.Room(room)) {
+				return
+			}
+			client.To(io.Room(room)).Emit("message", map[string]any{
+				"room": room,
+				"text": text,
+			})
+		})
+	})
+	return server
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// Realtime restart example - closes the Socket.IO server of an HTTP
+// application, and opens a fresh one, while the HTTP server keeps serving.
+//
+// Features:
+//   - POST /admin/close-realtime disconnects the clients and unmounts the
+//     engine, new handshakes being answered with 404
+//   - GET /status keeps serving, and reports "realtime: disabled"
+//   - POST /admin/open-realtime mounts a fresh Socket.IO server in place
+//
+// The Socket.IO server is created without HTTP server, and its engine served
+// by the application's own mux, so that closing it leaves the HTTP server
+// running. The admin routes require a bearer token.
+
+func main() {
+	app := newApp(adminToken())
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	server := &http.Server{Addr: addr, Handler: app}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	fmt.Printf("Realtime restart server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	app.closeRealtime()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = server.Shutdown(ctx)
+}
+
+// adminToken returns the token the admin routes require, from the ADMIN_TOKEN
+// environment variable. Without it, a random token is generated and printed,
+// so that the admin routes are never open with a guessable token.
+func adminToken() string {
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		return token
+	}
+	token := rand.Text()
+	fmt.Printf("ADMIN_TOKEN is not set, the admin routes require the generated token %s\n", token)
+	return token
+}
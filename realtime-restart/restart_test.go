@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// testAdminToken is the token the admin routes of the test application
+// require.
+const testAdminToken = "test-admin-token"
+
+// setupServer starts the application and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	app := newApp(testAdminToken)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: app}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		app.closeRealtime()
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// client is a socket, the generation of the server that welcomed it, and the
+// reason of its disconnection.
+type client struct {
+	*io_client.Socket
+	welcome      chan float64
+	disconnected chan string
+}
+
+// connectClient connects a socket to the main namespace, which is welcomed
+// once connected. Websocket only, as the polling upgrade occasionally stalls
+// the connection.
+func connectClient(t *testing.T, addr string) *client {
+	t.Helper()
+
+	opts := io_client.DefaultManagerOptions()
+	opts.SetReconnection(false)
+	opts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, opts)
+	c := &client{
+		Socket:       manager.Socket("/", io_client.DefaultSocketOptions()),
+		welcome:      make(chan float64, 10),
+		disconnected: make(chan string, 10),
+	}
+	t.Cleanup(func() { c.Disconnect() })
+	c.On("welcome", func(args ...any) {
+		if len(args) > 0 {
+			welcome, _ := args[0].(map[string]any)
+			generation, _ := welcome["generation"].(float64)
+			c.welcome <- generation
+		}
+	})
+	c.On("disconnect", func(args ...any) {
+		reason := ""
+		if len(args) > 0 {
+			reason, _ = args[0].(string)
+		}
+		c.disconnected <- reason
+	})
+	return c
+}
+
+// expectWelcome waits for the welcome of c, and checks the generation of the
+// server it comes from.
+func expectWelcome(t *testing.T, c *client, generation float64) {
+	t.Helper()
+
+	select {
+	case got := <-c.welcome:
+		if got != generation {
+			t.Fatalf("expected a welcome from generation %v, got %v", generation, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+	}
+}
+
+// request sends a request to the application, and returns the status and
+// body of the answer.
+func request(t *testing.T, method, url string) (int, string) {
+	t.Helper()
+
+	return requestWithToken(t, method, url, "")
+}
+
+// admin posts to an admin route of the application with the admin token, and
+// returns the status and body of the answer.
+func admin(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	return requestWithToken(t, http.MethodPost, url, testAdminToken)
+}
+
+// requestWithToken sends a request to the application with token as a bearer
+// token, or without authorization when token is empty, and returns the status
+// and body of the answer.
+func requestWithToken(t *testing.T, method, url, token string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestCloseRealtime(t *testing.T) {
+	addr := setupServer(t)
+	base := "http://" + addr
+
+	c := connectClient(t, addr)
+	expectWelcome(t, c, 1)
+	if status, body := request(t, http.MethodGet, base+"/status"); status != http.StatusOK || body != "realtime: enabled (generation 1, 1 clients)\n" {
+		t.Fatalf("expected the realtime layer to be enabled, got %d %q", status, body)
+	}
+
+	if status, body := admin(t, base+"/admin/close-realtime"); status != http.StatusOK {
+		t.Fatalf("expected the realtime layer to be closed, got %d %q", status, body)
+	}
+
+	// The server doesn't send a DISCONNECT packet, the client sees its
+	// connection closed
+	select {
+	case reason := <-c.disconnected:
+		if reason != "transport close" {
+			t.Fatalf("expected the connection to be closed, got %q", reason)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the client to be disconnected")
+	}
+
+	// The engine is unmounted, the handshakes are refused
+	if status, body := request(t, http.MethodGet, base+realtimePath+"?EIO=4&transport=polling"); status != http.StatusNotFound {
+		t.Fatalf("expected the handshake to be refused with 404, got %d %q", status, body)
+	}
+
+	// The rest of the application keeps serving
+	if status, body := request(t, http.MethodGet, base+"/status"); status != http.StatusOK || body != "realtime: disabled\n" {
+		t.Fatalf("expected the realtime layer to be disabled, got %d %q", status, body)
+	}
+	if status, _ := admin(t, base+"/admin/close-realtime"); status != http.StatusConflict {
+		t.Fatalf("expected closing twice to conflict, got %d", status)
+	}
+}
+
+func TestReopenRealtime(t *testing.T) {
+	addr := setupServer(t)
+	base := "http://" + addr
+
+	first := connectClient(t, addr)
+	expectWelcome(t, first, 1)
+	if status, _ := admin(t, base+"/admin/open-realtime"); status != http.StatusConflict {
+		t.Fatalf("expected opening twice to conflict, got %d", status)
+	}
+	admin(t, base+"/admin/close-realtime")
+	select {
+	case <-first.disconnected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the client to be disconnected")
+	}
+
+	if status, body := admin(t, base+"/admin/open-realtime"); status != http.StatusOK {
+		t.Fatalf("expected the realtime layer to be opened, got %d %q", status, body)
+	}
+
+	// A brand-new server welcomes the new connection
+	second := connectClient(t, addr)
+	expectWelcome(t, second, 2)
+	if status, body := request(t, http.MethodGet, base+"/status"); status != http.StatusOK || body != "realtime: enabled (generation 2, 1 clients)\n" {
+		t.Fatalf("expected a fresh realtime layer, got %d %q", status, body)
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	addr := setupServer(t)
+	base := "http://" + addr
+
+	c := connectClient(t, addr)
+	expectWelcome(t, c, 1)
+
+	for _, token := range []string{"", "wrong-token", testAdminToken + "x"} {
+		if status, _ := requestWithToken(t, http.MethodPost, base+"/admin/close-realtime", token); status != http.StatusUnauthorized {
+			t.Fatalf("token %q: expected status 401, got %d", token, status)
+		}
+	}
+
+	// The realtime layer, and the client connected to it, are left alone
+	if status, body := request(t, http.MethodGet, base+"/status"); status != http.StatusOK || body != "realtime: enabled (generation 1, 1 clients)\n" {
+		t.Fatalf("expected the realtime layer to be enabled, got %d %q", status, body)
+	}
+	select {
+	case reason := <-c.disconnected:
+		t.Fatalf("expected the client to stay connected, got %q", reason)
+	default:
+	}
+}
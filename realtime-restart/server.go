@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// realtimePath is the path the Socket.IO server is mounted at.
+const realtimePath = "/socket.io/"
+
+// app is an HTTP application with a realtime layer that can be closed and
+// opened again while the rest of it keeps serving.
+type app struct {
+	mux *http.ServeMux
+	// adminToken is the bearer token the admin routes require
+	adminToken string
+
+	mu sync.Mutex
+	// server is the current Socket.IO server, nil while the realtime layer is
+	// closed, and handler its engine
+	server  *io.Server
+	handler http.Handler
+	// generation counts the Socket.IO servers created
+	generation int
+}
+
+// newApp creates the application, with its realtime layer open, and admin
+// routes that require adminToken.
+func newApp(adminToken string) *app {
+	a := &app{mux: http.NewServeMux(), adminToken: adminToken}
+	a.mux.HandleFunc(realtimePath, a.serveRealtime)
+	a.mux.HandleFunc("GET /status", a.serveStatus)
+	a.mux.HandleFunc("POST /admin/close-realtime", a.requireAdmin(a.serveClose))
+	a.mux.HandleFunc("POST /admin/open-realtime", a.requireAdmin(a.serveOpen))
+	a.openRealtime()
+	return a
+}
+
+func (a *app) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// newRealtimeServer creates a Socket.IO server without HTTP server, whose
+// engine is served by the app: closing it then only closes its sockets and
+// engine. Given an HTTP server, Close would close that server too, and the
+// whole application with it.
+func newRealtimeServer(generation int) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(nil, config)
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+		client.Emit("welcome", map[string]any{"generation": generation})
+	})
+	return server
+}
+
+// openRealtime creates a fresh Socket.IO server and mounts its engine, and
+// reports whether it did, false when the realtime layer is already open.
+func (a *app) openRealtime() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.server != nil {
+		return false
+	}
+	a.generation++
+	a.server = newRealtimeServer(a.generation)
+	a.handler = a.server.ServeHandler(nil)
+	return true
+}
+
+// closeRealtime unmounts the engine of the Socket.IO server, then closes the
+// server, which disconnects its sockets with the reason "server shutting
+// down" and closes their connections. It reports whether it did, false when
+// the realtime layer is already closed.
+func (a *app) closeRealtime() bool {
+	a.mu.Lock()
+	server := a.server
+	a.server, a.handler = nil, nil
+	a.mu.Unlock()
+
+	if server == nil {
+		return false
+	}
+	// Unmounted first, so that no handshake reaches the engine while it
+	// closes
+	server.Close(nil)
+	return true
+}
+
+// serveRealtime passes the requests of the realtime path to the engine of the
+// current server, and answers 404 while there is none.
+func (a *app) serveRealtime(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	handler := a.handler
+	a.mu.Unlock()
+
+	if handler == nil {
+		http.Error(w, "realtime disabled", http.StatusNotFound)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// serveStatus reports the state of the realtime layer, and serves whether it
+// is open or not.
+func (a *app) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	a.mu.Lock()
+	server, generation := a.server, a.generation
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if server == nil {
+		fmt.Fprintln(w, "realtime: disabled")
+		return
+	}
+	fmt.Fprintf(w, "realtime: enabled (generation %d, %d clients)\n", generation, server.Engine().ClientsCount())
+}
+
+// requireAdmin wraps an admin route, which the public port serves along with
+// the rest of the application: the requests without the admin token as a
+// bearer token are answered with 401. The tokens are compared in constant
+// time, so that the response time does not tell how much of a guess was
+// right.
+func (a *app) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// serveClose closes the realtime layer, answering 409 when it is closed
+// already.
+func (a *app) serveClose(w http.ResponseWriter, _ *http.Request) {
+	if !a.closeRealtime() {
+		http.Error(w, "realtime already disabled", http.StatusConflict)
+		return
+	}
+	fmt.Fprintln(w, "realtime closed")
+}
+
+// serveOpen opens the realtime layer with a fresh server, answering 409 when
+// it is open already.
+func (a *app) serveOpen(w http.ResponseWriter, _ *http.Request) {
+	if !a.openRealtime() {
+		http.Error(w, "realtime already enabled", http.StatusConflict)
+		return
+	}
+	fmt.Fprintln(w, "realtime opened")
+}
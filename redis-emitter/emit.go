@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+
+	rds "github.com/redis/go-redis/v9"
+	"github.com/zishang520/socket.io/adapters/redis/v3"
+	redis_emitter "github.com/zishang520/socket.io/adapters/redis/v3/emitter"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// runEmit publishes a single event, given by the flags, and exits.
+func runEmit(args []string) error {
+	fs := flag.NewFlagSet("emit", flag.ContinueOnError)
+	url := fs.String("redis", redisURL(), "Redis URL (env REDIS_URL)")
+	nsp := fs.String("nsp", "/", "namespace of the clients")
+	room := fs.String("room", "", "room of the clients, every client of the namespace when empty")
+	event := fs.String("event", "", "event name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *event == "" {
+		return errors.New("event must not be empty")
+	}
+
+	opts, err := rds.ParseURL(*url)
+	if err != nil {
+		return err
+	}
+	rdb := rds.NewClient(opts)
+	defer rdb.Close()
+
+	return emit(redis.NewRedisClient(context.Background(), rdb), *nsp, *room, *event, parseArgs(fs.Args())...)
+}
+
+// emit publishes event with args to the clients of nsp in room, or to every
+// client of nsp when room is empty. The servers that use the Redis adapter
+// deliver it to their own clients.
+func emit(client *redis.RedisClient, nsp, room, event string, args ...any) error {
+	emitter := redis_emitter.NewEmitter(client, redis_emitter.DefaultEmitterOptions()).Of(nsp)
+	if room == "" {
+		return emitter.Emit(event, args...)
+	}
+	return emitter.To(io.Room(room)).Emit(event, args...)
+}
+
+// parseArgs decodes each argument as JSON, and keeps the ones that are not
+// valid JSON as strings.
+func parseArgs(args []string) []any {
+	values := make([]any, 0, len(args))
+	for _, arg := range args {
+		var value any
+		if err := json.Unmarshal([]byte(arg), &value); err != nil {
+			value = arg
+		}
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Redis emitter example - a process that is not a Socket.IO server, such as a
+// worker or a cron job, sends events to the clients of a cluster of servers
+// through the Redis adapter.
+//
+// Features:
+//   - A server that uses the Redis adapter, so that it delivers the events
+//     published to Redis by any server or emitter
+//   - An emitter that publishes an event to a room, or to every client, of a
+//     namespace, without serving anything
+//
+// Usage:
+//
+//	go run . server -addr :3000
+//	go run . emit -room news -event headline '"Hello"' '{"id":1}'
+
+// defaultRedisURL is the Redis server both commands connect to when REDIS_URL
+// is not set.
+const defaultRedisURL = "redis://localhost:6379/0"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "server":
+		err = runServer(os.Args[2:])
+	case "emit":
+		err = runEmit(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: redis-emitter server [flags]")
+	fmt.Fprintln(os.Stderr, "       redis-emitter emit -event name [-room room] [flags] [json args...]")
+	os.Exit(2)
+}
+
+// redisURL returns the REDIS_URL environment variable, or defaultRedisURL.
+func redisURL() string {
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		return url
+	}
+	return defaultRedisURL
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	rds "github.com/redis/go-redis/v9"
+	"github.com/zishang520/socket.io/adapters/redis/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// newRedisClient connects to the Redis server of REDIS_URL, and skips the test
+// when it is not set.
+func newRedisClient(t *testing.T) *redis.RedisClient {
+	t.Helper()
+
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		t.Skip("REDIS_URL is not set")
+	}
+	opts, err := rds.ParseURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := rds.NewClient(opts)
+	t.Cleanup(func() { rdb.Close() })
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return redis.NewRedisClient(context.Background(), rdb)
+}
+
+// setupServer starts a server using the Redis adapter and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	srv := newServer(httpServer, newRedisClient(t))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		srv.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// connectRaw opens a websocket connection to addr and joins the main namespace.
+func connectRaw(t *testing.T, addr string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/socket.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if data := read(t, conn); !strings.HasPrefix(data, "0") {
+		t.Fatalf("expected an Engine.IO handshake, got %q", data)
+	}
+	write(t, conn, "40")
+	if data := read(t, conn); !strings.HasPrefix(data, "40") {
+		t.Fatalf("expected a Socket.IO handshake, got %q", data)
+	}
+	return conn
+}
+
+func write(t *testing.T, conn *websocket.Conn, data string) {
+	t.Helper()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns the next packet, answering PINGs along the way.
+func read(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) == "2" {
+			write(t, conn, "3")
+			continue
+		}
+		return string(data)
+	}
+}
+
+func TestEmitter(t *testing.T) {
+	addr := setupServer(t)
+	emitterClient := newRedisClient(t)
+
+	member := connectRaw(t, addr)
+	other := connectRaw(t, addr)
+
+	write(t, member, `421["join","news"]`)
+	if data := read(t, member); data != `431["news"]` {
+		t.Fatalf("expected the join acknowledgement, got %q", data)
+	}
+
+	// The adapter subscribes to Redis asynchronously, so publish until the
+	// event is delivered
+	done, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			if err := emit(emitterClient, "/", "news", "headline", parseArgs([]string{`"hello"`, `{"id":1}`})...); err != nil {
+				t.Error(err)
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	data := read(t, member)
+	var event []any
+	if !strings.HasPrefix(data, "42") || json.Unmarshal([]byte(data[2:]), &event) != nil {
+		t.Fatalf("expected an event, got %q", data)
+	}
+	if expected := []any{"headline", "hello", map[string]any{"id": float64(1)}}; !reflect.DeepEqual(event, expected) {
+		t.Errorf("expected %v, got %v", expected, event)
+	}
+
+	// Clients outside the room receive nothing, their next packet is the
+	// acknowledgement of their own request
+	write(t, other, `421["join","sports"]`)
+	if data := read(t, other); data != `431["sports"]` {
+		t.Errorf("expected no event outside the room, got %q", data)
+	}
+}
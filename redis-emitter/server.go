@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	rds "github.com/redis/go-redis/v9"
+	"github.com/zishang520/socket.io/adapters/redis/v3"
+	redis_adapter "github.com/zishang520/socket.io/adapters/redis/v3/adapter"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// runServer runs a Socket.IO server that delivers the events published to
+// Redis, until interrupted.
+func runServer(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	addr := fs.String("addr", ":3000", "listen address")
+	url := fs.String("redis", redisURL(), "Redis URL (env REDIS_URL)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts, err := rds.ParseURL(*url)
+	if err != nil {
+		return err
+	}
+	rdb := rds.NewClient(opts)
+	defer rdb.Close()
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, redis.NewRedisClient(context.Background(), rdb))
+
+	httpServer.Listen(*addr, nil)
+	fmt.Printf("Redis emitter server listening on %s, adapter on %s\n", *addr, *url)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+	return nil
+}
+
+// newServer creates a Socket.IO server that uses the Redis adapter over
+// client, and lets clients join the rooms the emitter targets.
+func newServer(httpServer *types.HttpServer, client *redis.RedisClient) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+	config.SetAdapter(&redis_adapter.RedisAdapterBuilder{
+		Redis: client,
+		Opts:  redis_adapter.DefaultRedisAdapterOptions(),
+	})
+
+	server := io.NewServer(httpServer, config)
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'join', add it to the room, which the
+		// emitter can then target
+		client.On("join", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			room, ok := args[0].(string)
+			if !ok || room == "" {
+				return
+			}
+			client.Join(io.Room(room))
+
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{room}, nil)
+			}
+		})
+
+		// When the client emits 'leave', remove it from the room
+		client.On("leave", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			if room, ok := args[0].(string); ok {
+				client.Leave(io.Room(room))
+			}
+		})
+	})
+	return server
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+const (
+	// sendPath is the HTTP route that queues a message for a socket.
+	sendPath = "/api/send-reliable"
+	// sizePath is the HTTP route that reports the size of the outbox of a
+	// socket.
+	sizePath = "/api/outbox-size"
+)
+
+// maxSendBody limits the size of the requests to sendPath.
+const maxSendBody = 1 << 20
+
+// sendRequest is the body of a request to sendPath.
+type sendRequest struct {
+	Sid  string `json:"sid"`
+	Data any    `json:"data"`
+}
+
+// sendHandler serves sendPath: it accepts POST requests with a JSON body
+// {"sid", "data"} and queues the data in the outbox of the socket. It replies
+// 202 with {"id"}, the id of the message, and 404 when the socket is not
+// connected.
+func sendHandler(outboxes *types.Map[io.SocketId, *outbox]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req sendRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSendBody))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Sid == "" {
+			http.Error(w, "sid is required", http.StatusBadRequest)
+			return
+		}
+
+		out, ok := outboxes.Load(io.SocketId(req.Sid))
+		if !ok {
+			http.Error(w, "unknown socket: "+req.Sid, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": out.push(req.Data)})
+	})
+}
+
+// sizeHandler serves sizePath: it replies to GET requests for ?sid= with
+// {"size"}, the number of messages of the socket not acknowledged yet, and
+// 404 when the socket is not connected.
+func sizeHandler(outboxes *types.Map[io.SocketId, *outbox]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sid := r.URL.Query().Get("sid")
+		out, ok := outboxes.Load(io.SocketId(sid))
+		if !ok {
+			http.Error(w, "unknown socket: "+sid, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"size": out.size()})
+	})
+}
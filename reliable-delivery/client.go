@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// runClient connects to the server and prints each message it delivers, once,
+// until interrupted.
+func runClient(args []string) error {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:3000", "server URL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := io_client.Connect(*url, nil)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	in := &inbox{deliver: func(id uint64, data any) {
+		fmt.Printf("message %d: %v\n", id, data)
+	}}
+	client.On("message", in.handle)
+
+	// Every socket has its own outbox, whose ids start over
+	client.On("connect", func(...any) {
+		in.reset()
+		fmt.Printf("Connected as %s\n", client.Id())
+	})
+	client.On("disconnect", func(args ...any) {
+		fmt.Printf("Disconnected: %v\n", args)
+	})
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+	return nil
+}
+
+// inbox deduplicates the messages of an outbox. A message is sent again when
+// its acknowledgement is lost, after the client handled it: the inbox
+// acknowledges every message, but only delivers the ones it did not see yet.
+//
+// An outbox sends its messages one at a time, in the order of their ids, so
+// the last id delivered is enough to tell a retry.
+type inbox struct {
+	mu      sync.Mutex
+	last    uint64
+	deliver func(id uint64, data any)
+}
+
+// handle handles the arguments of a "message" event.
+func (in *inbox) handle(args ...any) {
+	if len(args) == 0 {
+		return
+	}
+	ack, _ := args[len(args)-1].(io.Ack)
+
+	msg, _ := args[0].(map[string]any)
+	id, ok := msg["id"].(float64)
+	if !ok || id < 1 {
+		return
+	}
+
+	in.mu.Lock()
+	if uint64(id) > in.last {
+		in.last = uint64(id)
+		in.deliver(uint64(id), msg["data"])
+	}
+	in.mu.Unlock()
+
+	if ack != nil {
+		ack(nil, nil)
+	}
+}
+
+// reset forgets the messages seen, for a new socket.
+func (in *inbox) reset() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.last = 0
+}
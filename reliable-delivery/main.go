@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Reliable delivery example - the server delivers each message to a client at
+// least once, sending it again until the client acknowledges it, and the
+// client drops the duplicates.
+//
+// Features:
+//   - An outbox per socket, with ids increasing from 1, delivered one message
+//     at a time with a 1s acknowledgement timeout
+//   - Up to 3 retries with an exponential backoff, after which the client is
+//     disconnected
+//   - A client acknowledging every message but handling each id once
+//   - POST /api/send-reliable to queue a message, and GET /api/outbox-size or
+//     the "outbox-size" event to look at the outbox
+//
+// Usage:
+//
+//	go run . server -addr :3000
+//	go run . client -url http://localhost:3000
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "server":
+		err = runServer(os.Args[2:])
+	case "client":
+		err = runClient(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: reliable-delivery server [flags]")
+	fmt.Fprintln(os.Stderr, "       reliable-delivery client [flags]")
+	os.Exit(2)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// retryPolicy is how the messages of an outbox are retried.
+type retryPolicy struct {
+	// ackTimeout is how long to wait for the acknowledgement of an attempt
+	ackTimeout time.Duration
+	// retries is the number of attempts after the first one, before giving
+	// up on the client
+	retries int
+	// backoff is the delay before the first retry, doubled before each next
+	// one
+	backoff time.Duration
+}
+
+// defaultPolicy waits 1s for each attempt and retries 3 times.
+var defaultPolicy = retryPolicy{
+	ackTimeout: time.Second,
+	retries:    3,
+	backoff:    250 * time.Millisecond,
+}
+
+var (
+	errExhausted = errors.New("no acknowledgement after all retries")
+	errClosed    = errors.New("outbox closed")
+)
+
+// message is a message of an outbox, with its id.
+type message struct {
+	ID   uint64
+	Data any
+}
+
+// outbox holds the messages waiting to be acknowledged by one socket. They
+// are delivered one at a time, in the order of their ids.
+type outbox struct {
+	mu      sync.Mutex
+	nextID  uint64
+	pending []message
+
+	// wake is signalled when a message is pushed, and done closed when the
+	// socket disconnects
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+// push queues data and returns the id of its message, increasing from 1.
+func (o *outbox) push(data any) uint64 {
+	o.mu.Lock()
+	o.nextID++
+	id := o.nextID
+	o.pending = append(o.pending, message{ID: id, Data: data})
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return id
+}
+
+// size returns the number of messages not acknowledged yet.
+func (o *outbox) size() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// front returns the oldest message not acknowledged yet.
+func (o *outbox) front() (message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.pending) == 0 {
+		return message{}, false
+	}
+	return o.pending[0], true
+}
+
+// pop removes the oldest message, once acknowledged.
+func (o *outbox) pop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending[0] = message{}
+	o.pending = o.pending[1:]
+}
+
+// close stops the delivery. The pending messages are dropped with the outbox.
+func (o *outbox) close() {
+	o.closeOnce.Do(func() { close(o.done) })
+}
+
+// deliver sends the messages of the outbox to client until it is closed, and
+// disconnects the client when a message is still not acknowledged after all
+// retries.
+//
+// It is the only goroutine emitting to client: Timeout sets a flag of the
+// socket that the next emit consumes, so emits with a timeout must not run
+// concurrently on a socket.
+func (o *outbox) deliver(client *io.Socket, policy retryPolicy) {
+	for {
+		msg, ok := o.front()
+		if !ok {
+			select {
+			case <-o.wake:
+				continue
+			case <-o.done:
+				return
+			}
+		}
+
+		switch err := o.send(client, msg, policy); err {
+		case nil:
+			o.pop()
+		case errExhausted:
+			log.Printf("Disconnecting %s: message %d: %v", client.Id(), msg.ID, err)
+			client.Disconnect(true)
+			return
+		default:
+			return
+		}
+	}
+}
+
+// send emits msg until client acknowledges it, backing off between attempts.
+func (o *outbox) send(client *io.Socket, msg message, policy retryPolicy) error {
+	backoff := policy.backoff
+	for attempt := 0; ; attempt++ {
+		acked := make(chan error, 1)
+		client.Timeout(policy.ackTimeout).EmitWithAck("message", map[string]any{
+			"id":   msg.ID,
+			"data": msg.Data,
+		})(func(_ []any, err error) { acked <- err })
+
+		select {
+		case err := <-acked:
+			if err == nil {
+				return nil
+			}
+		case <-o.done:
+			return errClosed
+		}
+
+		if attempt == policy.retries {
+			return errExhausted
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-o.done:
+			return errClosed
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// testPolicy keeps the tests short.
+var testPolicy = retryPolicy{
+	ackTimeout: 300 * time.Millisecond,
+	retries:    3,
+	backoff:    50 * time.Millisecond,
+}
+
+// setupServer starts the server and returns its URL.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, testPolicy)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return "http://" + ln.Addr().String()
+}
+
+// connectClient connects a client handling the "message" events with handler.
+// Websocket only, as the polling upgrade occasionally stalls the connection.
+func connectClient(t *testing.T, url string, handler func(...any)) *io_client.Socket {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager(url, managerOpts)
+	client := manager.Socket("/", io_client.DefaultSocketOptions())
+	t.Cleanup(func() { client.Disconnect() })
+	client.On("message", handler)
+
+	connected := make(chan struct{}, 1)
+	client.Once("connect", func(...any) { connected <- struct{}{} })
+	client.Connect()
+
+	select {
+	case <-connected:
+		return client
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// send posts body to sendPath and returns the response.
+func send(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+
+	res, err := http.Post(url+sendPath, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// sendReliable queues data for the socket sid and returns the id of the
+// message.
+func sendReliable(t *testing.T, url string, sid string, data any) uint64 {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]any{"sid": sid, "data": data})
+	res := send(t, url, string(body))
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", res.StatusCode)
+	}
+	var reply struct{ ID uint64 }
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply.ID
+}
+
+// outboxSize returns the size of the outbox of the socket sid over HTTP.
+func outboxSize(t *testing.T, url string, sid string) int {
+	t.Helper()
+
+	res, err := http.Get(url + sizePath + "?sid=" + sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+	var reply struct{ Size int }
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply.Size
+}
+
+// outboxGone reports whether the server has no outbox for the socket sid.
+func outboxGone(t *testing.T, url, sid string) bool {
+	t.Helper()
+
+	res, err := http.Get(url + sizePath + "?sid=" + sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode == http.StatusNotFound
+}
+
+func TestRetriedDelivery(t *testing.T) {
+	url := setupServer(t)
+
+	var (
+		mu        sync.Mutex
+		attempts  = map[float64]int{}
+		delivered []any
+	)
+	in := &inbox{deliver: func(id uint64, data any) {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, data)
+	}}
+
+	// The client handles the first attempt of each message, but its
+	// acknowledgement is lost: the retry is acknowledged, and not handled
+	// again
+	client := connectClient(t, url, func(args ...any) {
+		msg, _ := args[0].(map[string]any)
+		id, _ := msg["id"].(float64)
+		mu.Lock()
+		attempts[id]++
+		first := attempts[id] == 1
+		mu.Unlock()
+		if first {
+			args = args[:len(args)-1]
+		}
+		in.handle(args...)
+	})
+
+	for i, data := range []string{"a", "b", "c"} {
+		if id := sendReliable(t, url, client.Id(), data); id != uint64(i+1) {
+			t.Fatalf("expected message %d, got %d", i+1, id)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for outboxSize(t, url, client.Id()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the outbox to drain")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []any{"a", "b", "c"}; !slices.Equal(delivered, want) {
+		t.Fatalf("expected %v delivered once, in order, got %v", want, delivered)
+	}
+	for id, n := range attempts {
+		if n != 2 {
+			t.Errorf("expected message %v to be sent twice, got %d", id, n)
+		}
+	}
+
+	// The client can look at its outbox too
+	size := make(chan any, 1)
+	client.EmitWithAck("outbox-size")(func(args []any, err error) {
+		if err == nil && len(args) > 0 {
+			size <- args[0]
+		}
+	})
+	select {
+	case n := <-size:
+		if n != float64(0) {
+			t.Fatalf("expected an empty outbox, got %v", n)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("outbox-size was not acknowledged")
+	}
+}
+
+func TestDeliveryExhausted(t *testing.T) {
+	url := setupServer(t)
+
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	client := connectClient(t, url, func(...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+	})
+	disconnected := make(chan struct{}, 1)
+	client.On("disconnect", func(...any) { disconnected <- struct{}{} })
+
+	// The client never acknowledges, and is dropped after the retries
+	sid := client.Id()
+	sendReliable(t, url, sid, "lost")
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the client to be disconnected")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 1+testPolicy.retries {
+		t.Fatalf("expected %d attempts, got %d", 1+testPolicy.retries, attempts)
+	}
+
+	// The outbox is dropped with the socket
+	deadline := time.Now().Add(3 * time.Second)
+	for !outboxGone(t, url, sid) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the outbox to be dropped")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSendReliableErrors(t *testing.T) {
+	url := setupServer(t)
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"unknown socket", `{"sid":"not-a-socket-id","data":1}`, http.StatusNotFound},
+		{"no sid", `{"data":1}`, http.StatusBadRequest},
+		{"invalid JSON", `{`, http.StatusBadRequest},
+		{"unknown field", `{"sid":"x","room":"y"}`, http.StatusBadRequest},
+	} {
+		if res := send(t, url, tc.body); res.StatusCode != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, res.StatusCode)
+		}
+	}
+
+	if !outboxGone(t, url, "not-a-socket-id") {
+		t.Fatal("expected no outbox for an unknown socket")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// runServer runs the server until interrupted.
+func runServer(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	addr := fs.String("addr", ":3000", "listen address")
+	ackTimeout := fs.Duration("ack-timeout", defaultPolicy.ackTimeout, "how long to wait for the acknowledgement of a message")
+	retries := fs.Int("retries", defaultPolicy.retries, "number of times a message is sent again before disconnecting the client")
+	backoff := fs.Duration("backoff", defaultPolicy.backoff, "delay before the first retry, doubled before each next one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *ackTimeout <= 0 || *retries < 0 || *backoff < 0 {
+		return errors.New("ack-timeout must be positive, retries and backoff not negative")
+	}
+
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, retryPolicy{
+		ackTimeout: *ackTimeout,
+		retries:    *retries,
+		backoff:    *backoff,
+	})
+
+	httpServer.Listen(*addr, nil)
+	fmt.Printf("Reliable delivery server listening on %s\n", *addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+	return nil
+}
+
+// newServer creates a Socket.IO server delivering the messages queued
+// through its HTTP routes to its clients, at least once.
+func newServer(httpServer *types.HttpServer, policy retryPolicy) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	outboxes := &types.Map[io.SocketId, *outbox]{}
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		out := newOutbox()
+		outboxes.Store(client.Id(), out)
+		go out.deliver(client, policy)
+
+		// When the client emits 'outbox-size', reply with the number of its
+		// messages not acknowledged yet
+		client.On("outbox-size", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{out.size()}, nil)
+			}
+		})
+
+		// The messages not acknowledged yet are dropped with the socket: a
+		// client reconnecting gets a new socket, and a new outbox
+		client.On("disconnect", func(...any) {
+			outboxes.Delete(client.Id())
+			out.close()
+		})
+	})
+
+	httpServer.Handle(sendPath, sendHandler(outboxes))
+	httpServer.Handle(sizePath, sizeHandler(outboxes))
+	return server
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// tickInterval is the period of the ticks of the server clock.
+const tickInterval = time.Second
+
+// subscribers is the room of the clients receiving "tick-sub".
+const subscribers io.Room = "subscribers"
+
+// clock is a goroutine emitting the time to the clients of server, outside of
+// any event handler.
+//
+// Emitting from an arbitrary goroutine is safe through the broadcast
+// operators, such as server.Emit or server.To(room).Emit: each call builds its
+// own operator, and the adapter guards its rooms. Keep in mind that:
+//   - The modifiers of a socket, such as client.Timeout or client.Compress,
+//     set flags shared by its next emit, and must not be used from another
+//     goroutine than its handlers; the operator modifiers, such as
+//     server.Timeout, return a copy and are safe.
+//   - The events emitted here are not ordered with the ones the handlers emit
+//     at the same time, even to the same client.
+//   - The engine writes the options of a broadcast packet while sending it to
+//     each recipient, which the race detector reports when several clients
+//     receive it concurrently. The writes store the same value, so this is
+//     harmless, but it shows up under -race.
+//   - The clock must be stopped before the server is closed, so that it does
+//     not emit into closing sockets.
+type clock struct {
+	server   *io.Server
+	interval time.Duration
+
+	stopOnce sync.Once
+	done     chan struct{}
+	// stopped is closed once run returned
+	stopped chan struct{}
+}
+
+// startClock starts the clock of server, ticking every interval.
+func startClock(server *io.Server, interval time.Duration) *clock {
+	c := &clock{
+		server:   server,
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// run emits the time, in milliseconds since the epoch, on every tick: "tick"
+// to every client and "tick-sub" to the subscribers.
+func (c *clock) run() {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			ms := now.UnixMilli()
+			c.server.Emit("tick", ms)
+			c.server.To(subscribers).Emit("tick-sub", ms)
+		}
+	}
+}
+
+// stop stops the clock and waits for its goroutine to return, so that no tick
+// is emitted once it returned. It may be called several times.
+func (c *clock) stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+	<-c.stopped
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+const testInterval = 50 * time.Millisecond
+
+// setupServer starts the server and returns it with its address.
+func setupServer(t *testing.T) (*clockServer, string) {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, testInterval)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ioServer, ln.Addr().String()
+}
+
+// connectClient connects a client and returns it with the channels of its
+// "tick" and "tick-sub" events. Websocket only, as the polling upgrade
+// occasionally stalls the connection.
+func connectClient(t *testing.T, addr string) (*io_client.Socket, <-chan float64, <-chan float64) {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	client := manager.Socket("/", io_client.DefaultSocketOptions())
+	t.Cleanup(func() { client.Disconnect() })
+
+	ticks, subTicks := make(chan float64, 100), make(chan float64, 100)
+	for event, ch := range map[types.EventName]chan float64{"tick": ticks, "tick-sub": subTicks} {
+		client.On(event, func(args ...any) {
+			if ms, ok := args[0].(float64); ok {
+				ch <- ms
+			}
+		})
+	}
+
+	connected := make(chan struct{})
+	client.Once("connect", func(...any) { close(connected) })
+	client.Connect()
+
+	select {
+	case <-connected:
+		return client, ticks, subTicks
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil, nil, nil
+	}
+}
+
+// expectTicks waits for n ticks of event, with increasing timestamps.
+func expectTicks(t *testing.T, event string, ticks <-chan float64, n int) {
+	t.Helper()
+
+	last := 0.0
+	for range n {
+		select {
+		case ms := <-ticks:
+			if ms <= last {
+				t.Fatalf("%s: expected a timestamp after %v, got %v", event, last, ms)
+			}
+			last = ms
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %d %s events", n, event)
+		}
+	}
+}
+
+// clockRunning reports whether a clock goroutine is running.
+func clockRunning() bool {
+	buf := make([]byte, 1<<20)
+	return strings.Contains(string(buf[:runtime.Stack(buf, true)]), ".(*clock).run(")
+}
+
+func TestClock(t *testing.T) {
+	server, addr := setupServer(t)
+	client, ticks, subTicks := connectClient(t, addr)
+
+	subscribed := make(chan struct{})
+	client.EmitWithAck("subscribe")(func([]any, error) { close(subscribed) })
+	select {
+	case <-subscribed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("subscribe was not acknowledged")
+	}
+
+	expectTicks(t, "tick", ticks, 3)
+	expectTicks(t, "tick-sub", subTicks, 3)
+
+	// Closing the server stops the clock goroutine, after which no tick is
+	// emitted
+	server.Close(nil)
+	deadline := time.Now().Add(3 * time.Second)
+	for clockRunning() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the clock goroutine to exit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for len(ticks) > 0 {
+		<-ticks
+	}
+	select {
+	case ms := <-ticks:
+		t.Fatalf("unexpected tick %v after the shutdown", ms)
+	case <-time.After(3 * testInterval):
+	}
+}
+
+func TestUnsubscribed(t *testing.T) {
+	_, addr := setupServer(t)
+	_, ticks, subTicks := connectClient(t, addr)
+
+	// Every client receives the ticks, but only the subscribers tick-sub
+	expectTicks(t, "tick", ticks, 3)
+	select {
+	case ms := <-subTicks:
+		t.Fatalf("unexpected tick-sub %v", ms)
+	default:
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Server clock example - emits the time every second from a goroutine started
+// with the server, outside of any event handler.
+//
+// Features:
+//   - "tick" broadcast to every client, and "tick-sub" to the room of the
+//     clients that emitted "subscribe"
+//   - Emits from the goroutine through the broadcast operators, which are safe
+//     to use concurrently with the handlers
+//   - Clock stopped, and its goroutine waited for, before the server closes
+//
+// See clock.go for the caveats of emitting from other goroutines.
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, tickInterval)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Server clock listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// clockServer is a Socket.IO server with its clock.
+type clockServer struct {
+	*io.Server
+	clock *clock
+}
+
+// newServer creates a Socket.IO server emitting the time every interval.
+func newServer(httpServer *types.HttpServer, interval time.Duration) *clockServer {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		// When the client emits 'subscribe', add it to the subscribers and
+		// acknowledge
+		client.On("subscribe", func(args ...any) {
+			client.Join(subscribers)
+			if len(args) == 0 {
+				return
+			}
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{true}, nil)
+			}
+		})
+
+		// When the client emits 'unsubscribe', remove it from the
+		// subscribers and acknowledge
+		client.On("unsubscribe", func(args ...any) {
+			client.Leave(subscribers)
+			if len(args) == 0 {
+				return
+			}
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{true}, nil)
+			}
+		})
+	})
+
+	return &clockServer{Server: server, clock: startClock(server, interval)}
+}
+
+// Close stops the clock, then closes the server.
+func (s *clockServer) Close(fn func(error)) {
+	s.clock.stop()
+	s.Server.Close(fn)
+}
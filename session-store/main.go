@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Session store example - keeps the state of a user across its connections in
+// an external store, rather than with connection state recovery.
+//
+// Features:
+//   - Middleware loading the session named by the "sessionId" of the
+//     handshake auth, and attaching it to the socket
+//   - A new session, sent in a "session" event, for first-time clients and
+//     unknown session ids
+//   - Preferences set and read with "set-pref" and "get-pref"
+//   - The session written back on "disconnecting", when it changed
+//   - A store interface with an in-memory implementation, which a Redis one
+//     can replace
+//
+// Connection state recovery restores a socket that reconnects within a
+// couple of minutes, from the memory of the server it was connected to. A
+// session store keeps the state for as long as it likes, in a place every
+// server can reach.
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, newMemoryStore())
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Session store server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// storeTimeout bounds each call to the session store.
+const storeTimeout = 5 * time.Second
+
+// userState is the session of a socket, attached with SetData. Its handlers
+// may run concurrently, hence the mutex.
+type userState struct {
+	mu      sync.Mutex
+	session session
+	// dirty is set when the session changed since it was loaded
+	dirty bool
+	// issued is set when the session was created for this socket
+	issued bool
+}
+
+// newServer creates a Socket.IO server keeping the preferences of its users
+// in store, across their connections.
+func newServer(httpServer *types.HttpServer, store sessionStore) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+
+	// Load the session named by the handshake auth, or create one for the
+	// clients without a session, or with one the store does not know
+	server.Use(func(client *io.Socket, next func(*io.ExtendedError)) {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+
+		state := &userState{}
+		id, _ := client.Handshake().Auth["sessionId"].(string)
+		if id != "" {
+			s, ok, err := store.Load(ctx, id)
+			if err != nil {
+				log.Printf("Failed to load session: %v", err)
+				// Refusing the connection beats handing out a new session:
+				// the client keeps its id and tries again later
+				next(io.NewExtendedError("session store unavailable", map[string]any{"retry": true}))
+				return
+			}
+			state.session = s
+			state.issued = !ok
+		} else {
+			state.issued = true
+		}
+		if state.issued {
+			state.session = session{ID: randomID(), UserID: randomID()}
+			state.dirty = true
+		}
+		if state.session.Prefs == nil {
+			state.session.Prefs = map[string]string{}
+		}
+
+		client.SetData(state)
+		next(nil)
+	})
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+		state, ok := client.Data().(*userState)
+		if !ok {
+			return
+		}
+
+		// A new session is sent to the client, which passes its id in the
+		// handshake auth of its next connections
+		if state.issued {
+			client.Emit("session", map[string]any{
+				"sessionId": state.session.ID,
+				"userId":    state.session.UserID,
+			})
+		}
+
+		// When the client emits 'set-pref', with a key and a value, store
+		// the preference in its session and acknowledge
+		client.On("set-pref", func(args ...any) {
+			if len(args) < 3 {
+				return
+			}
+			ack, ok := args[len(args)-1].(io.Ack)
+			if !ok {
+				return
+			}
+			key, _ := args[0].(string)
+			value, ok := args[1].(string)
+			if key == "" || !ok {
+				ack([]any{false}, nil)
+				return
+			}
+
+			state.mu.Lock()
+			state.session.Prefs[key] = value
+			state.dirty = true
+			state.mu.Unlock()
+			ack([]any{true}, nil)
+		})
+
+		// When the client emits 'get-pref', with a key, acknowledge with the
+		// preference, or null when it has none
+		client.On("get-pref", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			ack, ok := args[len(args)-1].(io.Ack)
+			if !ok {
+				return
+			}
+			key, _ := args[0].(string)
+
+			state.mu.Lock()
+			value, ok := state.session.Prefs[key]
+			state.mu.Unlock()
+			if !ok {
+				ack([]any{nil}, nil)
+				return
+			}
+			ack([]any{value}, nil)
+		})
+
+		// Write the session back when the socket leaves, if it changed. A
+		// client reconnecting before the write completes loads the previous
+		// version.
+		client.On("disconnecting", func(...any) {
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			if !state.dirty {
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			defer cancel()
+			if err := store.Save(ctx, state.session); err != nil {
+				log.Printf("Failed to save session of user %s: %v", state.session.UserID, err)
+				return
+			}
+			state.dirty = false
+		})
+	})
+	return server
+}
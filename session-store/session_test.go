@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// setupServer starts the server with store and returns its address.
+func setupServer(t *testing.T, store sessionStore) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, store)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// client is a connected socket and the sessions it was issued.
+type client struct {
+	*io_client.Socket
+	sessions chan map[string]any
+}
+
+// connectClient connects a client with the session sessionId, none when
+// empty. Websocket only, as the polling upgrade occasionally stalls the
+// connection.
+func connectClient(t *testing.T, addr, sessionId string) *client {
+	t.Helper()
+
+	c, err := dial(t, addr, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// dial connects a client with the session sessionId, and returns the error
+// of the connection.
+func dial(t *testing.T, addr, sessionId string) (*client, error) {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	sockOpts := io_client.DefaultSocketOptions()
+	if sessionId != "" {
+		sockOpts.SetAuth(map[string]any{"sessionId": sessionId})
+	}
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	c := &client{
+		Socket:   manager.Socket("/", sockOpts),
+		sessions: make(chan map[string]any, 1),
+	}
+	t.Cleanup(func() { c.Disconnect() })
+	c.On("session", func(args ...any) {
+		if len(args) > 0 {
+			s, _ := args[0].(map[string]any)
+			c.sessions <- s
+		}
+	})
+
+	connected := make(chan error, 1)
+	c.Once("connect", func(...any) { connected <- nil })
+	c.Once("connect_error", func(args ...any) {
+		err := errors.New("connect_error")
+		if len(args) > 0 {
+			if e, ok := args[0].(error); ok {
+				err = e
+			}
+		}
+		connected <- err
+	})
+	c.Connect()
+
+	select {
+	case err := <-connected:
+		return c, err
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil, nil
+	}
+}
+
+// expectSession waits for the session issued to c.
+func (c *client) expectSession(t *testing.T) (sessionId, userId string) {
+	t.Helper()
+
+	select {
+	case s := <-c.sessions:
+		sessionId, _ = s["sessionId"].(string)
+		userId, _ = s["userId"].(string)
+		if sessionId == "" || userId == "" {
+			t.Fatalf("expected a session and user id, got %v", s)
+		}
+		return sessionId, userId
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected a session")
+		return "", ""
+	}
+}
+
+// call emits event and returns the first argument of the acknowledgement.
+func (c *client) call(t *testing.T, event string, args ...any) any {
+	t.Helper()
+
+	reply := make(chan any, 1)
+	c.EmitWithAck(event, args...)(func(args []any, _ error) {
+		var value any
+		if len(args) > 0 {
+			value = args[0]
+		}
+		reply <- value
+	})
+
+	select {
+	case value := <-reply:
+		return value
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s was not acknowledged", event)
+		return nil
+	}
+}
+
+func TestSessionAcrossReconnects(t *testing.T) {
+	store := newMemoryStore()
+	addr := setupServer(t, store)
+
+	first := connectClient(t, addr, "")
+	sessionId, userId := first.expectSession(t)
+	if ok := first.call(t, "set-pref", "theme", "dark"); ok != true {
+		t.Fatalf("expected set-pref to succeed, got %v", ok)
+	}
+	if theme := first.call(t, "get-pref", "theme"); theme != "dark" {
+		t.Fatalf("expected dark, got %v", theme)
+	}
+
+	// The session is written back once the server handles the disconnection
+	first.Disconnect()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		s, ok, _ := store.Load(context.Background(), sessionId)
+		if ok && s.Prefs["theme"] == "dark" {
+			if s.UserID != userId {
+				t.Fatalf("expected user %s, got %s", userId, s.UserID)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the session to be saved")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	second := connectClient(t, addr, sessionId)
+	if theme := second.call(t, "get-pref", "theme"); theme != "dark" {
+		t.Fatalf("expected dark after reconnecting, got %v", theme)
+	}
+	select {
+	case s := <-second.sessions:
+		t.Fatalf("expected the session to be kept, got %v", s)
+	default:
+	}
+}
+
+func TestUnknownSession(t *testing.T) {
+	addr := setupServer(t, newMemoryStore())
+
+	c := connectClient(t, addr, "made-up")
+	sessionId, _ := c.expectSession(t)
+	if sessionId == "made-up" {
+		t.Fatal("expected a new session id")
+	}
+	if theme := c.call(t, "get-pref", "theme"); theme != nil {
+		t.Fatalf("expected no preference, got %v", theme)
+	}
+}
+
+func TestSetPrefErrors(t *testing.T) {
+	addr := setupServer(t, newMemoryStore())
+	c := connectClient(t, addr, "")
+
+	for _, args := range [][]any{{"", "dark"}, {"theme", 42}} {
+		if ok := c.call(t, "set-pref", args...); ok != false {
+			t.Errorf("%v: expected set-pref to fail, got %v", args, ok)
+		}
+	}
+}
+
+// failingStore is a store whose backend is down.
+type failingStore struct{}
+
+func (failingStore) Load(context.Context, string) (session, bool, error) {
+	return session{}, false, errors.New("connection refused")
+}
+
+func (failingStore) Save(context.Context, session) error {
+	return errors.New("connection refused")
+}
+
+func TestStoreUnavailable(t *testing.T) {
+	addr := setupServer(t, failingStore{})
+
+	_, err := dial(t, addr, "some-session")
+	if err == nil || err.Error() != "session store unavailable" {
+		t.Fatalf("expected the connection to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"maps"
+	"sync"
+)
+
+// session is the state of a user, kept across its connections.
+type session struct {
+	ID     string            `json:"id"`
+	UserID string            `json:"userId"`
+	Prefs  map[string]string `json:"prefs"`
+}
+
+// sessionStore persists the sessions, by id.
+//
+// Sessions are passed by value, and an implementation must not keep a
+// reference to their maps: a networked store, such as Redis with the JSON
+// encoding of a session under "session:<id>", gets copies anyway. Every call
+// may fail or wait on the network, hence the context and the errors.
+type sessionStore interface {
+	// Load returns the session id, and false when there is none
+	Load(ctx context.Context, id string) (session, bool, error)
+	// Save creates or replaces the session
+	Save(ctx context.Context, s session) error
+}
+
+// memoryStore is a sessionStore keeping the sessions in memory.
+type memoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{sessions: map[string]session{}}
+}
+
+func (m *memoryStore) Load(_ context.Context, id string) (session, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	s.Prefs = maps.Clone(s.Prefs)
+	return s, ok, nil
+}
+
+func (m *memoryStore) Save(_ context.Context, s session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.Prefs = maps.Clone(s.Prefs)
+	m.sessions[s.ID] = s
+	return nil
+}
+
+// randomID returns 16 random bytes in hexadecimal. Session ids are secrets:
+// whoever holds one gets the session.
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Task progress example - runs long tasks in server-side goroutines, which
+// stream their progress to the socket that started them.
+//
+// Features:
+//   - "start-task" with a client-supplied id, several tasks per socket
+//   - "task-progress" from 0 to 100% every 100ms, then "task-done"
+//   - Tasks aborted by "cancel-task" or when the socket disconnects, through
+//     a context per task, so that no goroutine outlives its socket
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer, defaultConfig)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Task progress server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
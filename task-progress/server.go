@@ -0,0 +1,65 @@
+package main
+
+import (
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// newServer creates a Socket.IO server running the tasks its clients start,
+// at the pace of config.
+func newServer(httpServer *types.HttpServer, config taskConfig) *io.Server {
+	serverConfig := io.DefaultServerOptions()
+	serverConfig.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, serverConfig)
+
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+		tasks := newTaskRunner(client, config)
+
+		// When the client emits 'start-task', with a task id, start the task
+		// and acknowledge with {ok: true}, or with {error} when it cannot
+		// start
+		client.On("start-task", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			ack, ok := args[len(args)-1].(io.Ack)
+			if !ok {
+				return
+			}
+			id, _ := args[0].(string)
+			if err := tasks.start(id); err != nil {
+				ack([]any{map[string]any{"error": err.Error()}}, nil)
+				return
+			}
+			ack([]any{map[string]any{"ok": true}}, nil)
+		})
+
+		// When the client emits 'cancel-task', with a task id, cancel the
+		// task and acknowledge with whether it was running
+		client.On("cancel-task", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			ack, ok := args[len(args)-1].(io.Ack)
+			if !ok {
+				return
+			}
+			id, _ := args[0].(string)
+			ack([]any{tasks.cancel(id)}, nil)
+		})
+
+		// The tasks of a socket stop with it
+		client.On("disconnect", func(...any) {
+			tasks.close()
+		})
+	})
+	return server
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// maxTasks is the number of tasks a socket may run at once.
+const maxTasks = 5
+
+var (
+	errNoTaskID     = errors.New("missing task id")
+	errTaskRunning  = errors.New("task already running")
+	errTooManyTasks = errors.New("too many tasks")
+	errDisconnected = errors.New("socket disconnected")
+)
+
+// taskConfig is the pace of the tasks: their progress grows by step percent
+// every interval.
+type taskConfig struct {
+	interval time.Duration
+	step     int
+}
+
+// defaultConfig completes a task in 5 seconds.
+var defaultConfig = taskConfig{interval: 100 * time.Millisecond, step: 2}
+
+// taskRunner runs the tasks of a socket, each in a goroutine of its own with a
+// context cancelled by "cancel-task" or by the disconnection of the socket.
+type taskRunner struct {
+	client *io.Socket
+	config taskConfig
+
+	mu sync.Mutex
+	// cancels are the running tasks, by id
+	cancels map[string]context.CancelFunc
+	// closed is set once the socket disconnected, after which no task starts
+	closed bool
+}
+
+func newTaskRunner(client *io.Socket, config taskConfig) *taskRunner {
+	return &taskRunner{
+		client:  client,
+		config:  config,
+		cancels: map[string]context.CancelFunc{},
+	}
+}
+
+// start starts the task id.
+func (r *taskRunner) start(id string) error {
+	if id == "" {
+		return errNoTaskID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.closed:
+		return errDisconnected
+	case r.cancels[id] != nil:
+		return errTaskRunning
+	case len(r.cancels) == maxTasks:
+		return errTooManyTasks
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancels[id] = cancel
+	go r.run(ctx, id)
+	return nil
+}
+
+// cancel cancels the task id, and reports whether it was running.
+func (r *taskRunner) cancel(id string) bool {
+	r.mu.Lock()
+	cancel := r.cancels[id]
+	r.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	return true
+}
+
+// close cancels every task, and refuses the next ones.
+func (r *taskRunner) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, cancel := range r.cancels {
+		cancel()
+	}
+}
+
+// run emits the progress of the task id until it completes or ctx is
+// cancelled. Socket.Emit is safe to call from this goroutine, as long as no
+// modifier, such as Timeout, is used concurrently on the socket.
+func (r *taskRunner) run(ctx context.Context, id string) {
+	defer func() {
+		r.mu.Lock()
+		r.cancels[id]()
+		delete(r.cancels, id)
+		r.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(r.config.interval)
+	defer ticker.Stop()
+
+	for percent := 0; ; percent = min(percent+r.config.step, 100) {
+		r.client.Emit("task-progress", map[string]any{"taskId": id, "percent": percent})
+		if percent == 100 {
+			r.client.Emit("task-done", map[string]any{"taskId": id})
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			// Emitting to a disconnected socket is a no-op
+			r.client.Emit("task-cancelled", map[string]any{"taskId": id})
+			return
+		case <-ticker.C:
+		}
+	}
+}
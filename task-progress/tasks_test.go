@@ -0,0 +1,251 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// testConfig completes a task in 2 seconds.
+var testConfig = taskConfig{interval: 20 * time.Millisecond, step: 1}
+
+// setupServer starts the server and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer, testConfig)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// event is a task event received by a client.
+type event struct {
+	name    string
+	taskId  string
+	percent float64
+}
+
+// client is a connected socket and the task events it received.
+type client struct {
+	*io_client.Socket
+	events chan event
+}
+
+// connectClient connects a client. Websocket only, as the polling upgrade
+// occasionally stalls the connection.
+func connectClient(t *testing.T, addr string) *client {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	c := &client{
+		Socket: manager.Socket("/", io_client.DefaultSocketOptions()),
+		events: make(chan event, 1000),
+	}
+	t.Cleanup(func() { c.Disconnect() })
+
+	for _, name := range []types.EventName{"task-progress", "task-done", "task-cancelled"} {
+		c.On(name, func(args ...any) {
+			data, _ := args[0].(map[string]any)
+			taskId, _ := data["taskId"].(string)
+			percent, _ := data["percent"].(float64)
+			c.events <- event{string(name), taskId, percent}
+		})
+	}
+
+	connected := make(chan struct{})
+	c.Once("connect", func(...any) { close(connected) })
+	c.Connect()
+
+	select {
+	case <-connected:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// call emits event and returns the first argument of the acknowledgement.
+func (c *client) call(t *testing.T, event string, args ...any) any {
+	t.Helper()
+
+	reply := make(chan any, 1)
+	c.EmitWithAck(event, args...)(func(args []any, _ error) {
+		reply <- args[0]
+	})
+
+	select {
+	case value := <-reply:
+		return value
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s was not acknowledged", event)
+		return nil
+	}
+}
+
+// startTask starts the task id.
+func (c *client) startTask(t *testing.T, id string) {
+	t.Helper()
+
+	if reply, _ := c.call(t, "start-task", id).(map[string]any); reply["ok"] != true {
+		t.Fatalf("expected %s to start, got %v", id, reply)
+	}
+}
+
+// expectProgress waits for n increasing progress events of the task id,
+// skipping the events of the other tasks.
+func (c *client) expectProgress(t *testing.T, id string, n int) {
+	t.Helper()
+
+	last := -1.0
+	for n > 0 {
+		select {
+		case e := <-c.events:
+			if e.taskId != id {
+				continue
+			}
+			if e.name != "task-progress" || e.percent <= last {
+				t.Fatalf("expected progress of %s after %v, got %+v", id, last, e)
+			}
+			last = e.percent
+			n--
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected progress of %s", id)
+		}
+	}
+}
+
+// expectEvent waits for the event name of the task id, skipping its progress
+// and the events of the other tasks.
+func (c *client) expectEvent(t *testing.T, id, name string) {
+	t.Helper()
+
+	for {
+		select {
+		case e := <-c.events:
+			if e.taskId == id && e.name == name {
+				return
+			}
+			if e.taskId == id && e.name != "task-progress" {
+				t.Fatalf("expected %s of %s, got %+v", name, id, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %s of %s", name, id)
+		}
+	}
+}
+
+// expectSilence fails if an event of the task id arrives within d.
+func (c *client) expectSilence(t *testing.T, id string, d time.Duration) {
+	t.Helper()
+
+	timeout := time.After(d)
+	for {
+		select {
+		case e := <-c.events:
+			if e.taskId == id {
+				t.Fatalf("unexpected event %+v", e)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// taskGoroutines returns the number of goroutines running a task.
+func taskGoroutines() int {
+	buf := make([]byte, 1<<20)
+	return strings.Count(string(buf[:runtime.Stack(buf, true)]), ".(*taskRunner).run(")
+}
+
+// expectGoroutines waits for the number of task goroutines to return to
+// baseline, for less time than a task takes to complete.
+func expectGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for taskGoroutines() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d task goroutines, got %d", baseline, taskGoroutines())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCancelTask(t *testing.T) {
+	addr := setupServer(t)
+	c := connectClient(t, addr)
+	baseline := taskGoroutines()
+
+	c.startTask(t, "a")
+	c.startTask(t, "b")
+	if reply, _ := c.call(t, "start-task", "a").(map[string]any); reply["error"] != errTaskRunning.Error() {
+		t.Fatalf("expected a to be running, got %v", reply)
+	}
+	c.expectProgress(t, "a", 3)
+
+	if ok := c.call(t, "cancel-task", "a"); ok != true {
+		t.Fatalf("expected a to be cancelled, got %v", ok)
+	}
+	c.expectEvent(t, "a", "task-cancelled")
+	c.expectSilence(t, "a", 10*testConfig.interval)
+	if ok := c.call(t, "cancel-task", "a"); ok != false {
+		t.Fatalf("expected a to be gone, got %v", ok)
+	}
+
+	// The other task of the socket runs on, until it completes
+	c.expectEvent(t, "b", "task-done")
+	expectGoroutines(t, baseline)
+}
+
+func TestDisconnectMidTask(t *testing.T) {
+	addr := setupServer(t)
+	c := connectClient(t, addr)
+	baseline := taskGoroutines()
+
+	c.startTask(t, "a")
+	c.startTask(t, "b")
+	c.expectProgress(t, "a", 3)
+
+	c.Disconnect()
+	expectGoroutines(t, baseline)
+}
+
+func TestStartTaskErrors(t *testing.T) {
+	addr := setupServer(t)
+	c := connectClient(t, addr)
+
+	if reply, _ := c.call(t, "start-task", "").(map[string]any); reply["error"] != errNoTaskID.Error() {
+		t.Fatalf("expected %v, got %v", errNoTaskID, reply)
+	}
+	for i := range maxTasks {
+		c.startTask(t, string(rune('a'+i)))
+	}
+	if reply, _ := c.call(t, "start-task", "z").(map[string]any); reply["error"] != errTooManyTasks.Error() {
+		t.Fatalf("expected %v, got %v", errTooManyTasks, reply)
+	}
+}
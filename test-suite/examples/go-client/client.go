@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// errAckTimeout is returned when an acknowledgement does not arrive in time.
+var errAckTimeout = errors.New("acknowledgement timed out")
+
+// event is an event received by the client on the namespace nsp, or by its
+// manager when nsp is empty.
+type event struct {
+	nsp  string
+	name string
+	args []any
+}
+
+func (e event) String() string {
+	source := e.nsp
+	if source == "" {
+		source = "manager"
+	}
+	return fmt.Sprintf("%s %s %v", source, e.name, e.args)
+}
+
+// client holds a socket on the main namespace and one on /custom, sharing the
+// connection of a single manager.
+type client struct {
+	main   *io_client.Socket
+	custom *io_client.Socket
+}
+
+// newClient creates the sockets of a client of the test server at url, which
+// send token in their handshake auth, reconnect following policy, and report
+// the "auth" and "message-back" events they receive, and the lifecycle events
+// of their manager and of themselves, to onEvent. They connect with connect.
+func newClient(url, token string, policy reconnectPolicy, onEvent func(event)) *client {
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	policy.apply(managerOpts)
+	// Websocket only, as the polling upgrade occasionally stalls the
+	// connection
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+	manager := io_client.NewManager(url, managerOpts)
+
+	socket := func(nsp string) *io_client.Socket {
+		opts := io_client.DefaultSocketOptions()
+		opts.SetAuth(map[string]any{"token": token})
+		s := manager.Socket(nsp, opts)
+		for _, name := range []string{"auth", "message-back"} {
+			s.On(types.EventName(name), func(args ...any) {
+				onEvent(event{nsp: nsp, name: name, args: args})
+			})
+		}
+		return s
+	}
+	c := &client{main: socket("/"), custom: socket("/custom")}
+	observe(manager, map[string]*io_client.Socket{"/": c.main, "/custom": c.custom}, onEvent)
+	return c
+}
+
+// connect connects both sockets, and fails when either of them is refused or
+// not connected within timeout.
+func (c *client) connect(timeout time.Duration) error {
+	if err := connectSocket(c.main, "/", timeout); err != nil {
+		return err
+	}
+	return connectSocket(c.custom, "/custom", timeout)
+}
+
+// connectSocket connects s to the namespace nsp, waiting at most timeout.
+func connectSocket(s *io_client.Socket, nsp string, timeout time.Duration) error {
+	connected := make(chan error, 1)
+	s.Once("connect", func(...any) { connected <- nil })
+	s.Once("connect_error", func(args ...any) {
+		connected <- fmt.Errorf("connect %s: %v", nsp, args)
+	})
+	s.Connect()
+
+	select {
+	case err := <-connected:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("connect %s: timed out", nsp)
+	}
+}
+
+// send emits a "message" event on the main namespace, which the server sends
+// back as "message-back".
+func (c *client) send(args ...any) error {
+	return c.main.Emit("message", args...)
+}
+
+// call emits a "message-with-ack" event on the main namespace, and returns the
+// arguments it is acknowledged with.
+func (c *client) call(timeout time.Duration, args ...any) ([]any, error) {
+	reply := make(chan []any, 1)
+	c.main.EmitWithAck("message-with-ack", args...)(func(args []any, err error) {
+		if err == nil {
+			reply <- args
+		}
+	})
+
+	select {
+	case args := <-reply:
+		return args, nil
+	case <-time.After(timeout):
+		return nil, errAckTimeout
+	}
+}
+
+// close disconnects both sockets, which closes the connection of the manager
+// once the last one leaves.
+func (c *client) close() {
+	c.custom.Disconnect()
+	c.main.Disconnect()
+}
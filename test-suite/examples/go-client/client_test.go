@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"app/servers/testserver"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// token is the only token the embedded server accepts, for the user alice.
+const token = "secret-token"
+
+// startServer starts the test server embedded on addr, requiring token, until
+// the test ends.
+func startServer(t *testing.T, addr string) *socket.Server {
+	t.Helper()
+
+	server, _, err := testserver.New(addr, testserver.WithAuth(map[string]string{token: "alice"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close(nil) })
+	return server
+}
+
+// setupServer starts the test server on a free address, and returns it along
+// with its URL.
+func setupServer(t *testing.T) (*socket.Server, string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	return startServer(t, addr), "http://" + addr
+}
+
+// received passes the application events to events, leaving out the
+// lifecycle ones.
+func received(events chan event) func(event) {
+	return func(e event) {
+		if e.name == "auth" || e.name == "message-back" {
+			events <- e
+		}
+	}
+}
+
+// expectEvent waits for the next event received by the client.
+func expectEvent(t *testing.T, events chan event, want event) {
+	t.Helper()
+
+	select {
+	case got := <-events:
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected %v", want)
+	}
+}
+
+// The client gets the outcomes the raw protocol tests of the test suite check,
+// which makes sure the client and server packages agree.
+func TestClient(t *testing.T) {
+	server, url := setupServer(t)
+	events := make(chan event, 10)
+	c := newClient(url, token, defaultReconnectPolicy, received(events))
+	if err := c.connect(3 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The handshake auth of each namespace is echoed back on connection
+	auth := []any{map[string]any{"token": token}}
+	expectEvent(t, events, event{nsp: "/", name: "auth", args: auth})
+	expectEvent(t, events, event{nsp: "/custom", name: "auth", args: auth})
+
+	if err := c.send("hello", float64(1), map[string]any{"2": []any{false}}); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, event{nsp: "/", name: "message-back", args: []any{"hello", float64(1), map[string]any{"2": []any{false}}}})
+
+	if err := c.custom.Emit("message", "hello /custom"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, event{nsp: "/custom", name: "message-back", args: []any{"hello /custom"}})
+
+	args, err := c.call(3*time.Second, 1, "2", map[string]any{"3": []any{false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{float64(1), "2", map[string]any{"3": []any{false}}}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("expected the acknowledgement %v, got %v", want, args)
+	}
+
+	// Both namespaces share the connection, which is closed once they leave
+	if c.main.Io() != c.custom.Io() {
+		t.Fatal("expected both namespaces to share the manager")
+	}
+	reasons := make(chan string, 2)
+	for _, s := range []*io_client.Socket{c.main, c.custom} {
+		s.Once("disconnect", func(args ...any) {
+			reason, _ := args[0].(string)
+			reasons <- reason
+		})
+	}
+	c.close()
+	for range 2 {
+		if reason := <-reasons; reason != "io client disconnect" {
+			t.Fatalf("expected the client to leave, got %q", reason)
+		}
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for server.Engine().ClientsCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the server did not release the connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A client whose token is unknown is refused the main namespace.
+func TestClientUnauthorized(t *testing.T) {
+	_, url := setupServer(t)
+	c := newClient(url, "unknown-token", defaultReconnectPolicy, func(event) {})
+	defer c.close()
+
+	err := c.connect(3 * time.Second)
+	if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("expected the main namespace to be refused, got %v", err)
+	}
+}
+
+// timeline records the lifecycle events of a client, as "manager reconnect"
+// or "/custom connect".
+type timeline struct {
+	mu     sync.Mutex
+	labels []string
+}
+
+func (tl *timeline) record(e event) {
+	if e.name == "auth" || e.name == "message-back" {
+		return
+	}
+	source := e.nsp
+	if source == "" {
+		source = "manager"
+	}
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.labels = append(tl.labels, source+" "+e.name)
+}
+
+// index returns the position of the first label at or after from, or -1.
+func (tl *timeline) index(label string, from int) int {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if from > len(tl.labels) {
+		return -1
+	}
+	if i := slices.Index(tl.labels[from:], label); i >= 0 {
+		return from + i
+	}
+	return -1
+}
+
+// waitFor waits until label is recorded at or after from, and returns its
+// position.
+func (tl *timeline) waitFor(t *testing.T, label string, from int) int {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if i := tl.index(label, from); i >= 0 {
+			return i
+		}
+		if time.Now().After(deadline) {
+			tl.mu.Lock()
+			defer tl.mu.Unlock()
+			t.Fatalf("expected %q after position %d, got %q", label, from, tl.labels)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fastReconnection retries quickly, so that the tests don't wait for the
+// delays of the default policy.
+var fastReconnection = reconnectPolicy{
+	delay:    50 * time.Millisecond,
+	maxDelay: 200 * time.Millisecond,
+	jitter:   0.5,
+	timeout:  200 * time.Millisecond,
+}
+
+// The client reconnects once the server is back, and both namespaces resume
+// with the listeners registered when the client was created.
+func TestReconnection(t *testing.T) {
+	server, url := setupServer(t)
+	events := make(chan event, 10)
+	tl := &timeline{}
+	c := newClient(url, token, fastReconnection, func(e event) {
+		tl.record(e)
+		received(events)(e)
+	})
+	defer c.close()
+	if err := c.connect(3 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		<-events
+	}
+
+	// The server goes away, and the attempts fail until it is back
+	server.Close(nil)
+	lost := tl.waitFor(t, "/ disconnect", 0)
+	attempt := tl.waitFor(t, "manager reconnect_attempt", lost)
+	tl.waitFor(t, "manager reconnect_error", attempt)
+	startServer(t, strings.TrimPrefix(url, "http://"))
+
+	reconnected := tl.waitFor(t, "manager reconnect", attempt)
+	tl.waitFor(t, "/ connect", reconnected)
+	tl.waitFor(t, "/custom connect", reconnected)
+
+	// The namespaces are authenticated again, and echo once per message
+	auth := []any{map[string]any{"token": token}}
+	got := []event{<-events, <-events}
+	if !slices.ContainsFunc(got, func(e event) bool { return e.nsp == "/" && reflect.DeepEqual(e.args, auth) }) ||
+		!slices.ContainsFunc(got, func(e event) bool { return e.nsp == "/custom" && reflect.DeepEqual(e.args, auth) }) {
+		t.Fatalf("expected the auth of both namespaces, got %v", got)
+	}
+	if err := c.send("hello again"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, event{nsp: "/", name: "message-back", args: []any{"hello again"}})
+	if err := c.custom.Emit("message", "hello again /custom"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, event{nsp: "/custom", name: "message-back", args: []any{"hello again /custom"}})
+	select {
+	case e := <-events:
+		t.Fatalf("expected a single echo, got %v too", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// The client gives up after the attempts of its policy.
+func TestReconnectionFailed(t *testing.T) {
+	server, url := setupServer(t)
+	tl := &timeline{}
+	policy := fastReconnection
+	policy.attempts = 2
+	c := newClient(url, token, policy, tl.record)
+	defer c.close()
+	if err := c.connect(3 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close(nil)
+	lost := tl.waitFor(t, "/ disconnect", 0)
+	first := tl.waitFor(t, "manager reconnect_attempt", lost)
+	second := tl.waitFor(t, "manager reconnect_attempt", first+1)
+	failed := tl.waitFor(t, "manager reconnect_failed", second)
+	if i := tl.index("manager reconnect_attempt", second+1); i >= 0 && i < failed {
+		t.Fatal("expected the client to give up after 2 attempts")
+	}
+	if c.main.Connected() || c.custom.Connected() {
+		t.Fatal("expected the sockets to stay disconnected")
+	}
+}
+
+// Events emitted while the server is down are echoed in order once the
+// client is back when buffered, and never when volatile.
+func TestOfflineEmits(t *testing.T) {
+	for _, tt := range []struct {
+		strategy offlineStrategy
+		want     []event
+	}{
+		{bufferOffline, []event{
+			{nsp: "/", name: "message-back", args: []any{"offline 1"}},
+			{nsp: "/", name: "message-back", args: []any{"offline 2"}},
+			{nsp: "/", name: "message-back", args: []any{"offline 3"}},
+		}},
+		{volatileOffline, nil},
+	} {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			server, url := setupServer(t)
+			events := make(chan event, 10)
+			tl := &timeline{}
+			c := newClient(url, token, fastReconnection, func(e event) {
+				tl.record(e)
+				received(events)(e)
+			})
+			defer c.close()
+			if err := c.connect(3 * time.Second); err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				<-events
+			}
+
+			server.Close(nil)
+			lost := tl.waitFor(t, "/ disconnect", 0)
+			for i := 1; i <= 3; i++ {
+				if err := c.sendWith(tt.strategy, fmt.Sprintf("offline %d", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			startServer(t, strings.TrimPrefix(url, "http://"))
+			reconnected := tl.waitFor(t, "manager reconnect", lost)
+			tl.waitFor(t, "/ connect", reconnected)
+
+			var got []event
+			timeout := time.After(500 * time.Millisecond)
+			for done := false; !done; {
+				select {
+				case e := <-events:
+					if e.name == "message-back" {
+						got = append(got, e)
+					}
+				case <-timeout:
+					done = true
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected the echoes %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Go client example - talks to the test server through the Socket.IO Go
+// client, where the test suite speaks the raw protocol.
+//
+// Features:
+//   - Token sent in the handshake auth, echoed back by the "auth" event
+//   - "message" sent back as "message-back", "message-with-ack" acknowledged
+//     with its arguments
+//   - /custom connected as a second namespace, over the same connection
+//   - Reconnection with exponential backoff and jitter, whose timeline is
+//     printed from the lifecycle events of the manager and the sockets
+//   - Events emitted while disconnected either buffered until the client is
+//     back, or dropped as volatile events
+//   - Clean disconnection on SIGINT
+//
+// Start the test server with `go run ./servers` first.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:3000", "URL of the test server")
+	token := flag.String("token", "example-token", "token sent in the handshake auth")
+	timeout := flag.Duration("timeout", 5*time.Second, "how long to wait for the connection and the acknowledgement")
+	strategyName := flag.String("strategy", string(bufferOffline), "what to do with the events emitted while disconnected, buffer or volatile")
+	policy := defaultReconnectPolicy
+	flag.IntVar(&policy.attempts, "reconnect-attempts", policy.attempts, "reconnection attempts before giving up, 0 for ever")
+	flag.DurationVar(&policy.delay, "reconnect-delay", policy.delay, "wait before the first reconnection attempt, doubled at each attempt")
+	flag.DurationVar(&policy.maxDelay, "reconnect-max-delay", policy.maxDelay, "longest wait between reconnection attempts")
+	flag.Float64Var(&policy.jitter, "reconnect-jitter", policy.jitter, "randomization of the waits, e.g. 0.5 for ±50%")
+	flag.DurationVar(&policy.timeout, "connect-timeout", policy.timeout, "how long each connection attempt may take")
+	flag.Parse()
+	if err := policy.validate(); err != nil {
+		log.Fatal(err)
+	}
+	strategy, err := parseOfflineStrategy(*strategyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c := newClient(*url, *token, policy, func(e event) { log.Printf("Received %s", e) })
+	if err := c.connect(*timeout); err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	log.Printf("Connected to / as %s and to /custom as %s", c.main.Id(), c.custom.Id())
+
+	if err := c.send("hello"); err != nil {
+		log.Fatalf("Failed to send the message: %v", err)
+	}
+	if err := c.custom.Emit("message", "hello /custom"); err != nil {
+		log.Fatalf("Failed to send the message: %v", err)
+	}
+	args, err := c.call(*timeout, 1, "2", map[string]any{"3": []any{false}})
+	if err != nil {
+		log.Fatalf("message-with-ack: %v", err)
+	}
+	log.Printf("message-with-ack acknowledged with %v", args)
+
+	// The namespaces connect again by themselves once the manager has
+	// reconnected, with the listeners registered above
+	c.main.Io().On("reconnect", func(...any) {
+		if err := c.send("hello again"); err != nil {
+			log.Printf("Failed to send the message: %v", err)
+		}
+	})
+	// Events emitted while the connection is lost are echoed once the client
+	// is back when buffered, and never when volatile
+	c.main.On("disconnect", func(...any) {
+		if !c.main.Active() {
+			return
+		}
+		for i := 1; i <= 3; i++ {
+			if err := c.sendWith(strategy, fmt.Sprintf("offline %d", i)); err != nil {
+				log.Printf("Failed to send the message: %v", err)
+			}
+		}
+		log.Printf("Sent 3 messages while disconnected, with the %s strategy", strategy)
+	})
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Disconnecting...")
+	c.close()
+}
@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// offlineStrategy decides what becomes of the events emitted while the client
+// is disconnected.
+type offlineStrategy string
+
+const (
+	// bufferOffline queues the events, and sends them in order once the
+	// namespace has connected again. This is the default of the client.
+	bufferOffline offlineStrategy = "buffer"
+	// volatileOffline drops the events, as with the volatile flag, which
+	// suits values that are stale by the time the client is back.
+	volatileOffline offlineStrategy = "volatile"
+)
+
+// parseOfflineStrategy returns the strategy named s.
+func parseOfflineStrategy(s string) (offlineStrategy, error) {
+	switch strategy := offlineStrategy(s); strategy {
+	case bufferOffline, volatileOffline:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown strategy %q, expected %q or %q", s, bufferOffline, volatileOffline)
+}
+
+// sendWith emits a "message" event on the main namespace like send, but
+// marked volatile under volatileOffline, so that it is dropped rather than
+// buffered when the client is not connected.
+func (c *client) sendWith(strategy offlineStrategy, args ...any) error {
+	s := c.main
+	if strategy == volatileOffline {
+		// The client only drops volatile events when the transport is not
+		// writable, and a closed websocket transport still reports itself
+		// writable, so the events would be buffered anyway
+		if !s.Connected() {
+			return nil
+		}
+		s = s.Volatile()
+	}
+	return s.Emit("message", args...)
+}
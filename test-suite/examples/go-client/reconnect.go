@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// reconnectPolicy configures how the manager reconnects once the connection
+// is lost: up to attempts times, 0 meaning for ever, waiting delay before the
+// first attempt and twice as long before each of the next ones, up to
+// maxDelay, every wait being randomized by ±jitter, e.g. 0.5 for ±50%. Each
+// attempt, like the first connection, fails after timeout.
+//
+// The timeout matters over websocket only: the client misses the errors of
+// the websocket dial, such as a refused connection, and an attempt only fails
+// once it times out.
+type reconnectPolicy struct {
+	attempts int
+	delay    time.Duration
+	maxDelay time.Duration
+	jitter   float64
+	timeout  time.Duration
+}
+
+// defaultReconnectPolicy matches the defaults of the client library, but for
+// the timeout, 20s there.
+var defaultReconnectPolicy = reconnectPolicy{
+	delay:    time.Second,
+	maxDelay: 5 * time.Second,
+	jitter:   0.5,
+	timeout:  2 * time.Second,
+}
+
+func (p reconnectPolicy) validate() error {
+	switch {
+	case p.attempts < 0:
+		return errors.New("reconnection attempts must not be negative")
+	case p.delay <= 0 || p.maxDelay < p.delay:
+		return errors.New("reconnection delay must be positive, and at most the max delay")
+	case p.jitter < 0 || p.jitter >= 1:
+		return errors.New("reconnection jitter must be in [0, 1)")
+	case p.timeout <= 0:
+		return errors.New("connection timeout must be positive")
+	}
+	return nil
+}
+
+// apply sets the policy on the options of a manager. The library takes the
+// delays in milliseconds.
+func (p reconnectPolicy) apply(opts *io_client.ManagerOptions) {
+	opts.SetReconnection(true)
+	if p.attempts > 0 {
+		opts.SetReconnectionAttempts(float64(p.attempts))
+	}
+	opts.SetReconnectionDelay(float64(p.delay.Milliseconds()))
+	opts.SetReconnectionDelayMax(float64(p.maxDelay.Milliseconds()))
+	opts.SetRandomizationFactor(p.jitter)
+	opts.SetTimeout(p.timeout)
+}
+
+// managerEvents are the lifecycle events of the manager, which concern the
+// connection shared by the namespaces.
+var managerEvents = []types.EventName{"reconnect_attempt", "reconnect", "reconnect_error", "reconnect_failed"}
+
+// socketEvents are the lifecycle events of each socket.
+var socketEvents = []types.EventName{"connect", "connect_error", "disconnect"}
+
+// observe reports the lifecycle events of the manager, with an empty
+// namespace, and of the sockets to onEvent. The listeners are registered once
+// and stay across reconnections, like the ones of the application events:
+// registering them in a "connect" listener would add them again on every
+// reconnection.
+func observe(manager *io_client.Manager, sockets map[string]*io_client.Socket, onEvent func(event)) {
+	for _, name := range managerEvents {
+		manager.On(name, func(args ...any) {
+			onEvent(event{name: string(name), args: args})
+		})
+	}
+	for nsp, s := range sockets {
+		for _, name := range socketEvents {
+			s.On(name, func(args ...any) {
+				onEvent(event{nsp: nsp, name: string(name), args: args})
+			})
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// reconnection is the outcome of a reconnection: whether the server recovered
+// the session, and the events it replayed, received while disconnected.
+type reconnection struct {
+	recovered bool
+	replayed  [][]any
+}
+
+// subscriber is a socket of the main namespace, which reports each of its
+// reconnections.
+type subscriber struct {
+	socket *io_client.Socket
+
+	mu       sync.Mutex
+	offline  bool
+	replayed [][]any
+}
+
+// newSocket creates a socket of the main namespace of the server at url,
+// whose manager reconnects once downtime has passed after the connection is
+// lost. It connects with connect.
+func newSocket(url string, downtime time.Duration) *io_client.Socket {
+	opts := io_client.DefaultManagerOptions()
+	opts.SetAutoConnect(false)
+	opts.SetReconnectionDelay(float64(downtime.Milliseconds()))
+	opts.SetReconnectionDelayMax(float64(downtime.Milliseconds()))
+	opts.SetRandomizationFactor(0)
+	opts.SetTimeout(2 * time.Second)
+	// Websocket only, as the polling upgrade occasionally stalls the
+	// connection
+	opts.SetTransports(types.NewSet(io_client.WebSocket))
+	return io_client.NewManager(url, opts).Socket("/", nil)
+}
+
+// newSubscriber creates a subscriber of the server at url, which reconnects
+// after downtime and passes the outcome of each reconnection to
+// onReconnect.
+func newSubscriber(url string, downtime time.Duration, onReconnect func(reconnection)) *subscriber {
+	sub := &subscriber{socket: newSocket(url, downtime)}
+
+	// The events replayed on recovery arrive before the CONNECT packet, and
+	// the client delivers them right before "connect"
+	sub.socket.On("disconnect", func(...any) {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		sub.offline = true
+		sub.replayed = nil
+	})
+	sub.socket.OnAny(func(args ...any) {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		if sub.offline {
+			sub.replayed = append(sub.replayed, withoutOffset(args))
+		}
+	})
+	sub.socket.On("connect", func(...any) {
+		sub.mu.Lock()
+		if !sub.offline {
+			sub.mu.Unlock()
+			return
+		}
+		r := reconnection{recovered: sub.socket.Recovered(), replayed: sub.replayed}
+		sub.offline = false
+		sub.replayed = nil
+		sub.mu.Unlock()
+		onReconnect(r)
+	})
+	return sub
+}
+
+// withoutOffset drops the offset a recovery-enabled server appends to the
+// arguments of every event, which the client keeps to resume from.
+func withoutOffset(args []any) []any {
+	if len(args) < 2 {
+		return args
+	}
+	return args[:len(args)-1]
+}
+
+// connect connects s, waiting at most timeout.
+func connect(s *io_client.Socket, timeout time.Duration) error {
+	connected := make(chan error, 1)
+	s.Once("connect", func(...any) { connected <- nil })
+	s.Once("connect_error", func(args ...any) {
+		connected <- fmt.Errorf("connect: %v", args)
+	})
+	s.Connect()
+
+	select {
+	case err := <-connected:
+		return err
+	case <-time.After(timeout):
+		return errors.New("connect: timed out")
+	}
+}
+
+// join joins s to room, and waits until the server has processed it.
+func join(s *io_client.Socket, room string, timeout time.Duration) error {
+	joined := make(chan struct{}, 1)
+	s.Once("my-rooms", func(...any) { joined <- struct{}{} })
+	if err := s.Emit("join-room", room); err != nil {
+		return err
+	}
+	if err := s.Emit("my-rooms"); err != nil {
+		return err
+	}
+
+	select {
+	case <-joined:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("join: timed out")
+	}
+}
+
+// publish emits event to the other sockets of room through the server.
+func publish(s *io_client.Socket, room, event string, args ...any) error {
+	return s.Emit("room-broadcast", append([]any{room, event}, args...)...)
+}
+
+// dropConnection closes the connection of the manager of s underneath the
+// socket, which reports it as a "forced close" and reconnects, while the
+// server sees a closed transport and keeps the session for recovery.
+func dropConnection(s *io_client.Socket) {
+	s.Io().Engine().Close()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"app/servers/testserver"
+)
+
+// setupServer starts the test server with connection state recovery for
+// maxDisconnection, on a free address until the test ends, and returns its
+// URL.
+func setupServer(t *testing.T, maxDisconnection time.Duration) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server, _, err := testserver.New(addr, testserver.WithConnectionStateRecovery(maxDisconnection, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close(nil) })
+	return "http://" + addr
+}
+
+// dropAndPublish connects a subscriber to url in the room "news", drops its
+// connection for downtime, publishes a headline to the room meanwhile, and
+// returns the outcome of the reconnection along with the number of headlines
+// the subscriber received in all.
+func dropAndPublish(t *testing.T, url string, downtime time.Duration) (reconnection, *atomic.Int32) {
+	t.Helper()
+
+	reconnected := make(chan reconnection, 1)
+	sub := newSubscriber(url, downtime, func(r reconnection) { reconnected <- r })
+	t.Cleanup(func() { sub.socket.Disconnect() })
+	var headlines atomic.Int32
+	sub.socket.OnAny(func(args ...any) {
+		if args[0] == "headline" {
+			headlines.Add(1)
+		}
+	})
+	if err := connect(sub.socket, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := join(sub.socket, "news", 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	id := sub.socket.Id()
+
+	publisher := newSocket(url, downtime)
+	t.Cleanup(func() { publisher.Disconnect() })
+	if err := connect(publisher, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	dropConnection(sub.socket)
+	if err := publish(publisher, "news", "headline", "while offline"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-reconnected:
+		if r.recovered != (sub.socket.Id() == id) {
+			t.Fatalf("expected the id to be kept only on recovery, got %s then %s, recovered: %v", id, sub.socket.Id(), r.recovered)
+		}
+		// Leave time for a second delivery of the headline
+		time.Sleep(200 * time.Millisecond)
+		return r, &headlines
+	case <-time.After(downtime + 3*time.Second):
+		t.Fatal("the subscriber did not reconnect")
+		return reconnection{}, nil
+	}
+}
+
+// A subscriber back within the recovery window keeps its session, and gets
+// the broadcast it missed exactly once.
+func TestRecovery(t *testing.T) {
+	url := setupServer(t, 2*time.Second)
+	r, headlines := dropAndPublish(t, url, 200*time.Millisecond)
+
+	if !r.recovered {
+		t.Fatal("expected the session to be recovered")
+	}
+	if want := [][]any{{"headline", "while offline"}}; !reflect.DeepEqual(r.replayed, want) {
+		t.Fatalf("expected the replayed events %v, got %v", want, r.replayed)
+	}
+	if n := headlines.Load(); n != 1 {
+		t.Fatalf("expected the headline once, got it %d times", n)
+	}
+}
+
+// A subscriber back after the recovery window starts a new session, without
+// the room nor the broadcast it missed.
+func TestRecoveryExpired(t *testing.T) {
+	url := setupServer(t, 300*time.Millisecond)
+	r, headlines := dropAndPublish(t, url, time.Second)
+
+	if r.recovered {
+		t.Fatal("expected a new session")
+	}
+	if len(r.replayed) != 0 {
+		t.Fatalf("expected no replayed events, got %v", r.replayed)
+	}
+	if n := headlines.Load(); n != 0 {
+		t.Fatalf("expected no headline, got %d", n)
+	}
+}
@@ -0,0 +1,73 @@
+// Recovery client example - shows connection state recovery from the side of
+// the Socket.IO Go client, against the test server started with -recovery.
+//
+// Features:
+//   - Offset of the last received event tracked by the client, and sent back
+//     with the session id when it reconnects
+//   - Connection dropped underneath the socket, reconnected after -downtime
+//   - Events broadcast to the room of the socket while it is offline, by a
+//     second client
+//   - Recovered flag and replayed events printed after the reconnection, and
+//     the room joined again when the session was not recovered
+//
+// Start the test server with `go run servers/cmd.go -recovery 2s` first, and
+// compare -downtime 1s with -downtime 3s.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:3000", "URL of the test server")
+	room := flag.String("room", "news", "room the subscriber joins")
+	downtime := flag.Duration("downtime", time.Second, "how long the subscriber stays offline before reconnecting")
+	count := flag.Int("count", 3, "events broadcast to the room while the subscriber is offline")
+	timeout := flag.Duration("timeout", 5*time.Second, "how long to wait for the connections and the reconnection")
+	flag.Parse()
+
+	reconnected := make(chan reconnection, 1)
+	sub := newSubscriber(*url, *downtime, func(r reconnection) { reconnected <- r })
+	if err := connect(sub.socket, *timeout); err != nil {
+		log.Fatalf("Failed to connect the subscriber: %v", err)
+	}
+	defer sub.socket.Disconnect()
+	if err := join(sub.socket, *room, *timeout); err != nil {
+		log.Fatalf("Failed to join %s: %v", *room, err)
+	}
+	log.Printf("Subscriber %s joined %s", sub.socket.Id(), *room)
+
+	publisher := newSocket(*url, *downtime)
+	if err := connect(publisher, *timeout); err != nil {
+		log.Fatalf("Failed to connect the publisher: %v", err)
+	}
+	defer publisher.Disconnect()
+
+	log.Printf("Dropping the connection of the subscriber for %v", *downtime)
+	dropConnection(sub.socket)
+	for i := 1; i <= *count; i++ {
+		if err := publish(publisher, *room, "headline", fmt.Sprintf("while offline %d", i)); err != nil {
+			log.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	select {
+	case r := <-reconnected:
+		log.Printf("Reconnected as %s, recovered: %v, %d events replayed", sub.socket.Id(), r.recovered, len(r.replayed))
+		for _, args := range r.replayed {
+			log.Printf("Replayed %v", args)
+		}
+		// A new session starts with the socket's own room only
+		if !r.recovered {
+			if err := join(sub.socket, *room, *timeout); err != nil {
+				log.Fatalf("Failed to join %s again: %v", *room, err)
+			}
+			log.Printf("Joined %s again", *room)
+		}
+	case <-time.After(*downtime + *timeout):
+		log.Fatal("The subscriber did not reconnect")
+	}
+}
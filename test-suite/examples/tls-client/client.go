@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/zishang520/socket.io/clients/engine/v3/transports"
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// transports maps the names of the -transport flag to the client transports.
+var transportsByName = map[string]transports.TransportCtor{
+	"polling":   io_client.Polling,
+	"websocket": io_client.WebSocket,
+}
+
+// tlsConfig returns the TLS configuration of the client: the certificates of
+// the PEM file caFile as the only trusted roots, or no verification at all
+// when insecure.
+func tlsConfig(caFile string, insecure bool) (*tls.Config, error) {
+	if insecure {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	if caFile == "" {
+		return nil, errors.New("a CA file is required, unless the verification is disabled")
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+// checkCertificate completes a TLS handshake with the server at rawURL using
+// config, and returns the verification error, if any. The client reports a
+// certificate it does not trust as a failed request with polling, and misses
+// it with websocket until the connection times out, so the error is surfaced
+// here first.
+func checkCertificate(rawURL string, config *tls.Config, timeout time.Duration) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "wss" {
+		return nil
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", host, config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// bearer returns the dial headers carrying token, sent with every HTTP request
+// of the polling transport and with the websocket handshake.
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// connect connects to the main namespace of the server at url over the given
+// transport, with config and headers, and fails once timeout expires.
+func connect(rawURL string, transport transports.TransportCtor, config *tls.Config, headers http.Header, timeout time.Duration) (*io_client.Socket, error) {
+	if err := checkCertificate(rawURL, config, timeout); err != nil {
+		return nil, err
+	}
+
+	opts := io_client.DefaultManagerOptions()
+	opts.SetAutoConnect(false)
+	opts.SetReconnection(false)
+	opts.SetTimeout(timeout)
+	opts.SetTransports(types.NewSet(transport))
+	opts.SetTLSClientConfig(config)
+	opts.SetExtraHeaders(headers)
+	s := io_client.NewManager(rawURL, opts).Socket("/", nil)
+
+	connected := make(chan error, 1)
+	s.Once("connect", func(...any) { connected <- nil })
+	s.Once("connect_error", func(args ...any) {
+		connected <- fmt.Errorf("connect: %v", args)
+	})
+	s.Connect()
+
+	select {
+	case err := <-connected:
+		if err != nil {
+			s.Disconnect()
+			return nil, err
+		}
+		return s, nil
+	case <-time.After(timeout):
+		s.Disconnect()
+		return nil, errors.New("connect: timed out")
+	}
+}
+
+// echo emits a "message" event, and returns the arguments the server sends
+// back with "message-back".
+func echo(s *io_client.Socket, timeout time.Duration, args ...any) ([]any, error) {
+	reply := make(chan []any, 1)
+	s.Once("message-back", func(args ...any) { reply <- args })
+	if err := s.Emit("message", args...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case args := <-reply:
+		return args, nil
+	case <-time.After(timeout):
+		return nil, errors.New("message-back: timed out")
+	}
+}
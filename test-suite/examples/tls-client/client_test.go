@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"app/servers/testserver"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// token is the only bearer token the embedded server accepts.
+const token = "secret-token"
+
+// requireBearer rejects the clients whose handshake lacks the Authorization
+// header of token.
+func requireBearer(client *socket.Socket, next func(*socket.ExtendedError)) {
+	if client.Handshake().Headers.Header().Get("Authorization") != "Bearer "+token {
+		next(socket.NewExtendedError("unauthorized", map[string]any{"code": "unauthorized"}))
+		return
+	}
+	next(nil)
+}
+
+// setupServer starts the test server over TLS with a certificate of its own
+// authority, on a free address, and returns its URL along with the PEM file of
+// the authority.
+func setupServer(t *testing.T) (string, string) {
+	t.Helper()
+
+	cert, err := testserver.SelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server, _, err := testserver.New(addr,
+		testserver.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		testserver.WithMiddleware(requireBearer),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close(nil) })
+	return "https://" + addr, writeCA(t, cert.Leaf)
+}
+
+// writeCA writes ca as a PEM file, and returns its path.
+func writeCA(t *testing.T, ca *x509.Certificate) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// The client trusts the pinned authority and sends the bearer token over both
+// transports.
+func TestTLSClient(t *testing.T) {
+	url, caFile := setupServer(t)
+	config, err := tlsConfig(caFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, transport := range transportsByName {
+		t.Run(name, func(t *testing.T) {
+			s, err := connect(url, transport, config, bearer(token), 3*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Disconnect()
+
+			args, err := echo(s, 3*time.Second, "hello", float64(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []any{"hello", float64(1)}; !reflect.DeepEqual(args, want) {
+				t.Fatalf("expected the echo %v, got %v", want, args)
+			}
+		})
+	}
+}
+
+// A server whose certificate is signed by another authority is refused with a
+// certificate error, well before the connection would time out.
+func TestTLSClientUnknownAuthority(t *testing.T) {
+	url, _ := setupServer(t)
+	other, err := testserver.SelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := tlsConfig(writeCA(t, other.Leaf), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, transport := range transportsByName {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			_, err := connect(url, transport, config, bearer(token), 3*time.Second)
+			var unknown x509.UnknownAuthorityError
+			if !errors.As(err, &unknown) {
+				t.Fatalf("expected a certificate error, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("expected the error right away, got it after %v", elapsed)
+			}
+		})
+	}
+}
+
+// The server refuses a client without the bearer token, which shows the
+// header is what lets the client in.
+func TestTLSClientUnauthorized(t *testing.T) {
+	url, caFile := setupServer(t)
+	config, err := tlsConfig(caFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = connect(url, transportsByName["websocket"], config, bearer("unknown-token"), 3*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("expected the connection to be refused, got %v", err)
+	}
+}
+
+// Disabling the verification connects to any server, and a CA file is
+// required otherwise.
+func TestTLSConfig(t *testing.T) {
+	url, _ := setupServer(t)
+	config, err := tlsConfig("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := connect(url, transportsByName["websocket"], config, bearer(token), 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Disconnect()
+
+	if _, err := tlsConfig("", false); err == nil {
+		t.Fatal("expected a CA file to be required")
+	}
+}
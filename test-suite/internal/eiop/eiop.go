@@ -0,0 +1,123 @@
+// Package eiop encodes and decodes the packets of the Engine.IO protocol,
+// revision 4: one packet per websocket or webtransport frame, and payloads of
+// packets separated by 0x1e over HTTP long-polling.
+package eiop
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Type is the type of a packet, sent as its first character.
+type Type byte
+
+const (
+	Open Type = iota
+	Close
+	Ping
+	Pong
+	Message
+	Upgrade
+	Noop
+)
+
+var typeNames = [...]string{"open", "close", "ping", "pong", "message", "upgrade", "noop"}
+
+func (t Type) String() string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return fmt.Sprintf("Type(%d)", byte(t))
+}
+
+// Separator is the record separator between the packets of a polling
+// payload.
+const Separator = '\x1e'
+
+// ErrEmpty is returned for a packet without even a type.
+var ErrEmpty = errors.New("empty packet")
+
+// Packet is an Engine.IO packet. A binary packet is always a message, whose
+// Data is sent as is in a binary frame, and base64 encoded behind a "b"
+// within a polling payload.
+type Packet struct {
+	Type     Type
+	Data     []byte
+	IsBinary bool
+}
+
+// String returns the packet as it is encoded within a polling payload, e.g.
+// "4hello" or "bAQIDBA==", to show it in messages.
+func (p Packet) String() string {
+	return string(encodeRecord(nil, p))
+}
+
+// Encode returns the frame of p, to be sent as a binary frame when
+// p.IsBinary, as a text frame otherwise.
+func Encode(p Packet) []byte {
+	if p.IsBinary {
+		return bytes.Clone(p.Data)
+	}
+	return append([]byte{'0' + byte(p.Type)}, p.Data...)
+}
+
+// Decode returns the packet of frame, a binary one when it came in a binary
+// frame. The Data of the packet shares the memory of frame.
+func Decode(frame []byte, binary bool) (Packet, error) {
+	if binary {
+		return Packet{Type: Message, Data: frame, IsBinary: true}, nil
+	}
+	if len(frame) == 0 {
+		return Packet{}, ErrEmpty
+	}
+	if frame[0] < '0' || frame[0] > '0'+byte(Noop) {
+		return Packet{}, fmt.Errorf("unknown packet type %q", frame[0])
+	}
+	return Packet{Type: Type(frame[0] - '0'), Data: frame[1:]}, nil
+}
+
+// EncodePayload returns the polling payload of packets.
+func EncodePayload(packets []Packet) []byte {
+	var payload []byte
+	for i, p := range packets {
+		if i > 0 {
+			payload = append(payload, Separator)
+		}
+		payload = encodeRecord(payload, p)
+	}
+	return payload
+}
+
+// DecodePayload returns the packets of a polling payload. The Data of the
+// text packets shares the memory of payload.
+func DecodePayload(payload []byte) ([]Packet, error) {
+	var packets []Packet
+	for i, record := range bytes.Split(payload, []byte{Separator}) {
+		var (
+			p   Packet
+			err error
+		)
+		if encoded, ok := bytes.CutPrefix(record, []byte("b")); ok {
+			var data []byte
+			data, err = base64.StdEncoding.AppendDecode(nil, encoded)
+			p = Packet{Type: Message, Data: data, IsBinary: true}
+		} else {
+			p, err = Decode(record, false)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("packet %d: %w", i, err)
+		}
+		packets = append(packets, p)
+	}
+	return packets, nil
+}
+
+// encodeRecord appends p, as encoded within a polling payload, to dst.
+func encodeRecord(dst []byte, p Packet) []byte {
+	if p.IsBinary {
+		return base64.StdEncoding.AppendEncode(append(dst, 'b'), p.Data)
+	}
+	return append(append(dst, '0'+byte(p.Type)), p.Data...)
+}
@@ -0,0 +1,124 @@
+package eiop
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func text(t Type, data string) Packet {
+	return Packet{Type: t, Data: []byte(data)}
+}
+
+func binary(data ...byte) Packet {
+	return Packet{Type: Message, Data: data, IsBinary: true}
+}
+
+// The vectors come from the examples of the protocol specification, and from
+// frames captured off the Go test server of this module. None was captured
+// off the JavaScript reference server, so the captured ones only pin what
+// this server sends.
+func TestPacket(t *testing.T) {
+	tests := []struct {
+		name   string
+		frame  string
+		binary bool
+		want   Packet
+	}{
+		{"spec open", `0{"sid":"lv_VI97HAXpY6yYWAAAC","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000,"maxPayload":1000000}`, false,
+			text(Open, `{"sid":"lv_VI97HAXpY6yYWAAAC","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000,"maxPayload":1000000}`)},
+		{"spec close", "1", false, text(Close, "")},
+		{"spec ping", "2", false, text(Ping, "")},
+		{"spec pong", "3", false, text(Pong, "")},
+		{"spec probe ping", "2probe", false, text(Ping, "probe")},
+		{"spec probe pong", "3probe", false, text(Pong, "probe")},
+		{"spec text message", "4hello", false, text(Message, "hello")},
+		{"spec binary message", "\x01\x02\x03\x04", true, binary(1, 2, 3, 4)},
+		{"spec upgrade", "5", false, text(Upgrade, "")},
+		{"spec noop", "6", false, text(Noop, "")},
+		{"spec utf-8 message", "4€", false, text(Message, "€")},
+		{"captured open", `0{"maxPayload":1000000,"pingInterval":25000,"pingTimeout":20000,"sid":"G24_hAeXKRHL9gAAAAAAAAAA","upgrades":["websocket"]}`, false,
+			text(Open, `{"maxPayload":1000000,"pingInterval":25000,"pingTimeout":20000,"sid":"G24_hAeXKRHL9gAAAAAAAAAA","upgrades":["websocket"]}`)},
+		{"captured socket.io connect", `40{"sid":"0ZIFGwJZ4870jgAAAAAAAAAB"}`, false, text(Message, `0{"sid":"0ZIFGwJZ4870jgAAAAAAAAAB"}`)},
+		{"captured binary event", `451-["binary-echo",{"_placeholder":true,"num":0}]`, false, text(Message, `51-["binary-echo",{"_placeholder":true,"num":0}]`)},
+		{"empty binary message", "", true, binary()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode([]byte(tt.frame), tt.binary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Type != tt.want.Type || got.IsBinary != tt.want.IsBinary || !bytes.Equal(got.Data, tt.want.Data) {
+				t.Fatalf("expected %v %q, got %v %q", tt.want.Type, tt.want.Data, got.Type, got.Data)
+			}
+			if frame := Encode(tt.want); string(frame) != tt.frame {
+				t.Fatalf("expected the frame %q, got %q", tt.frame, frame)
+			}
+		})
+	}
+}
+
+func TestPacketErrors(t *testing.T) {
+	for _, frame := range []string{"", "7", "x", "/"} {
+		if p, err := Decode([]byte(frame), false); err == nil {
+			t.Fatalf("%q: expected an error, got %v", frame, p)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []Packet
+	}{
+		{"spec text", "4hello\x1e4€", []Packet{text(Message, "hello"), text(Message, "€")}},
+		{"spec binary", "4€\x1ebAQIDBA==", []Packet{text(Message, "€"), binary(1, 2, 3, 4)}},
+		{"spec ping then message", "2\x1e4hello", []Packet{text(Ping, ""), text(Message, "hello")}},
+		{"single packet", "6", []Packet{text(Noop, "")}},
+		{"captured connect and auth", "40{\"sid\":\"0ZIFGwJZ4870jgAAAAAAAAAB\"}\x1e42[\"auth\",{}]",
+			[]Packet{text(Message, `0{"sid":"0ZIFGwJZ4870jgAAAAAAAAAB"}`), text(Message, `2["auth",{}]`)}},
+		{"captured binary ack", "451-[\"binary-echo\",{\"_placeholder\":true,\"num\":0}]\x1ebAQIDBA==",
+			[]Packet{text(Message, `51-["binary-echo",{"_placeholder":true,"num":0}]`), binary(1, 2, 3, 4)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodePayload([]byte(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			if payload := EncodePayload(tt.want); string(payload) != tt.payload {
+				t.Fatalf("expected the payload %q, got %q", tt.payload, payload)
+			}
+		})
+	}
+}
+
+func TestPayloadErrors(t *testing.T) {
+	for _, payload := range []string{"", "4hello\x1e", "\x1e4hello", "4hello\x1e7", "b!!"} {
+		if packets, err := DecodePayload([]byte(payload)); err == nil {
+			t.Fatalf("%q: expected an error, got %v", payload, packets)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, tt := range []struct {
+		p    Packet
+		want string
+	}{
+		{text(Pong, "probe"), "3probe"},
+		{binary(1, 2, 3, 4), "bAQIDBA=="},
+	} {
+		if got := tt.p.String(); got != tt.want {
+			t.Fatalf("expected %q, got %q", tt.want, got)
+		}
+	}
+	if got := Upgrade.String(); got != "upgrade" {
+		t.Fatalf("expected upgrade, got %q", got)
+	}
+}
@@ -0,0 +1,153 @@
+//go:build msgpack
+
+package test_suite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"app/internal/eiop"
+	"app/servers/testserver"
+
+	"github.com/coder/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackPacket is a Socket.IO packet as encoded by the msgpack parser.
+type msgpackPacket struct {
+	Type int     `msgpack:"type"`
+	Nsp  string  `msgpack:"nsp"`
+	Data any     `msgpack:"data,omitempty"`
+	Id   *uint64 `msgpack:"id,omitempty"`
+}
+
+// writeMsgpack sends packet as a binary frame.
+func writeMsgpack(ctx context.Context, t *testing.T, c *websocket.Conn, packet msgpackPacket) []byte {
+	t.Helper()
+
+	data, err := msgpack.Marshal(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(ctx, websocket.MessageBinary, data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readMsgpack returns the next packet, answering PINGs along the way. Every
+// Socket.IO packet must arrive as a binary frame.
+func readMsgpack(ctx context.Context, t *testing.T, c *websocket.Conn) msgpackPacket {
+	t.Helper()
+
+	for {
+		msgType, data, err := c.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgType == websocket.MessageText {
+			if p, err := eiop.Decode(data, false); err != nil || p.Type != eiop.Ping {
+				t.Fatalf("unexpected text frame %q", data)
+			}
+			if err := c.Write(ctx, websocket.MessageText, []byte("3")); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		var packet msgpackPacket
+		if err := msgpack.Unmarshal(data, &packet); err != nil {
+			t.Fatalf("invalid msgpack packet %x: %v", data, err)
+		}
+		return packet
+	}
+}
+
+// connectMsgpack joins the main namespace, and skips the "auth" event that
+// follows.
+func connectMsgpack(ctx context.Context, t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	c, _ := openWebSocketSession(ctx, t)
+	writeMsgpack(ctx, t, c, msgpackPacket{Type: 0, Nsp: "/"})
+
+	packet := readMsgpack(ctx, t, c)
+	if sid, _ := packet.Data.(map[string]any)["sid"].(string); packet.Type != 0 || sid == "" {
+		t.Fatalf("expected a CONNECT packet, got %+v", packet)
+	}
+	if packet := readMsgpack(ctx, t, c); packet.Type != 2 {
+		t.Fatalf(`expected the "auth" event, got %+v`, packet)
+	}
+	return c
+}
+
+// The msgpack variant runs embedded. Run it with go test -tags msgpack.
+func TestMsgpackParser(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithParser(testserver.MsgpackParser()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	args := []any{"hello", 42, 1.5, true, nil, []byte{0xde, 0xad}, map[string]any{"nested": []any{"a", 1}}}
+
+	t.Run("should echo an event", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c := connectMsgpack(ctx, t)
+		defer c.CloseNow()
+
+		sent := writeMsgpack(ctx, t, c, msgpackPacket{Type: 2, Nsp: "/", Data: append([]any{"message"}, args...)})
+
+		// Decode what was sent the same way, so that both sides hold the
+		// types msgpack decodes to
+		var expected msgpackPacket
+		if err := msgpack.Unmarshal(sent, &expected); err != nil {
+			t.Fatal(err)
+		}
+		expected.Data.([]any)[0] = "message-back"
+
+		if packet := readMsgpack(ctx, t, c); !reflect.DeepEqual(packet, expected) {
+			t.Errorf("expected %+v, got %+v", expected, packet)
+		}
+	})
+
+	t.Run("should acknowledge an event", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c := connectMsgpack(ctx, t)
+		defer c.CloseNow()
+
+		id := uint64(7)
+		sent := writeMsgpack(ctx, t, c, msgpackPacket{Type: 2, Nsp: "/", Data: append([]any{"message-with-ack"}, args...), Id: &id})
+
+		var expected msgpackPacket
+		if err := msgpack.Unmarshal(sent, &expected); err != nil {
+			t.Fatal(err)
+		}
+		expected.Type = 3
+		expected.Data = expected.Data.([]any)[1:]
+
+		if packet := readMsgpack(ctx, t, c); !reflect.DeepEqual(packet, expected) {
+			t.Errorf("expected %+v, got %+v", expected, packet)
+		}
+	})
+
+	t.Run("should close the connection on a JSON packet", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.CloseNow()
+
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		expectClose(ctx, t, c)
+	})
+}
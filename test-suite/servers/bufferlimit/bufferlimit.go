@@ -0,0 +1,353 @@
+// Package bufferlimit finds the maxHttpBufferSize a Socket.IO server enforces,
+// by sending it growing messages over websocket or HTTP long-polling until
+// one is refused, then searching for the threshold between the last message
+// accepted and the first one refused.
+package bufferlimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"app/servers/throughput"
+
+	"github.com/coder/websocket"
+)
+
+// Overhead is the framing of the messages sent, in bytes: the Engine.IO and
+// Socket.IO packet types, the event name and the JSON around the payload, as
+// in 42["probe","<payload>"].
+const Overhead = len(`42["probe",""]`)
+
+// Config describes a probe of the server at URL over Transport, "websocket"
+// or "polling". The payloads start at Start bytes and double until one is
+// refused, up to Max bytes.
+type Config struct {
+	// URL is the Socket.IO endpoint, e.g. http://localhost:3000/socket.io/.
+	URL       string
+	Transport string
+	Start     int
+	Max       int
+}
+
+// Result is the outcome of a probe. Limit is the size of the largest message
+// accepted, framing included, which is the maxHttpBufferSize of the server,
+// and Payload the size of its payload alone. Refusal tells how the server
+// refused the messages over the limit, e.g. "close 1009 (message too big)".
+type Result struct {
+	Transport string `json:"transport"`
+	Limit     int    `json:"limit"`
+	Payload   int    `json:"payload"`
+	Overhead  int    `json:"overhead"`
+	Attempts  int    `json:"attempts"`
+	Refusal   string `json:"refusal"`
+}
+
+// WriteText writes the result in a human readable form.
+func (r Result) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s: limit %d bytes (%d bytes of payload and %d of framing), found in %d attempts\nrefused with %s\n",
+		r.Transport, r.Limit, r.Payload, r.Overhead, r.Attempts, r.Refusal)
+	return err
+}
+
+// WriteJSON writes the result as a JSON object.
+func (r Result) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// Probe sends cfg.Start bytes of payload to cfg.URL over cfg.Transport, and
+// doubles the payload until the server refuses it, then halves the interval
+// between the largest payload accepted and the smallest refused until they
+// are one byte apart. A refused websocket message drops the connection, the
+// next attempt opening another one, while a refused polling request leaves
+// the session open.
+func Probe(ctx context.Context, cfg Config) (Result, error) {
+	if cfg.Start <= 0 || cfg.Max < cfg.Start {
+		return Result{}, errors.New("start must be positive and max must not be lower than start")
+	}
+
+	var p prober
+	switch cfg.Transport {
+	case "websocket":
+		endpoint, err := throughput.WebsocketURL(cfg.URL)
+		if err != nil {
+			return Result{}, err
+		}
+		p = &wsProber{endpoint: endpoint}
+	case "polling":
+		pp, err := dialPolling(ctx, cfg.URL)
+		if err != nil {
+			return Result{}, err
+		}
+		p = pp
+	default:
+		return Result{}, fmt.Errorf("unknown transport %q, expected websocket or polling", cfg.Transport)
+	}
+	defer p.close()
+
+	result := Result{Transport: cfg.Transport, Overhead: Overhead}
+	try := func(size int) (bool, error) {
+		result.Attempts++
+		accepted, refusal, err := p.try(ctx, message(size))
+		if err != nil {
+			return false, fmt.Errorf("payload of %d bytes: %w", size, err)
+		}
+		if !accepted && result.Refusal == "" {
+			result.Refusal = refusal
+		}
+		return accepted, nil
+	}
+
+	// accepted is the largest payload accepted, 0 when none was yet, and
+	// refused the smallest one refused
+	accepted, refused := 0, 0
+	for size := cfg.Start; refused == 0; size = min(2*size, cfg.Max) {
+		ok, err := try(size)
+		if err != nil {
+			return Result{}, err
+		}
+		if ok {
+			if size == cfg.Max {
+				return Result{}, fmt.Errorf("no limit found up to %d bytes of payload", cfg.Max)
+			}
+			accepted = size
+		} else {
+			refused = size
+		}
+	}
+	for refused-accepted > 1 {
+		size := accepted + (refused-accepted)/2
+		ok, err := try(size)
+		if err != nil {
+			return Result{}, err
+		}
+		if ok {
+			accepted = size
+		} else {
+			refused = size
+		}
+	}
+	if accepted == 0 {
+		return Result{}, errors.New("the server refused the smallest payload, even an empty one")
+	}
+
+	result.Payload = accepted
+	result.Limit = accepted + Overhead
+	return result, nil
+}
+
+// message returns a "probe" event with size bytes of payload.
+func message(size int) string {
+	return `42["probe","` + strings.Repeat("a", size) + `"]`
+}
+
+// prober sends the messages of a probe.
+type prober interface {
+	// try sends message, and reports whether the server accepted it, or
+	// how it refused it.
+	try(ctx context.Context, message string) (accepted bool, refusal string, err error)
+	close()
+}
+
+// wsProber sends each message over websocket, then a "message-with-ack"
+// event: the server handles the messages of a connection in order, so the
+// acknowledgement means the message was read. The connection is opened again
+// after a refusal.
+type wsProber struct {
+	endpoint string
+	c        *websocket.Conn
+	ackID    int
+}
+
+func (p *wsProber) try(ctx context.Context, message string) (bool, string, error) {
+	if p.c == nil {
+		c, err := throughput.Connect(ctx, p.endpoint, 0)
+		if err != nil {
+			return false, "", err
+		}
+		p.c = c
+	}
+
+	p.ackID++
+	err := p.c.Write(ctx, websocket.MessageText, []byte(message))
+	if err == nil {
+		err = p.c.Write(ctx, websocket.MessageText, fmt.Appendf(nil, `42%d["message-with-ack"]`, p.ackID))
+	}
+	if err != nil {
+		// The server does not read a message over the limit, and drops the
+		// connection right after its close frame, before a large message is
+		// written, which fails the write: the close frame is still there
+		// to be read, after the packets sent before it
+		readCtx, cancel := context.WithTimeout(ctx, time.Second)
+		readErr := error(nil)
+		for readErr == nil {
+			_, _, readErr = p.c.Read(readCtx)
+		}
+		cancel()
+		if websocket.CloseStatus(readErr) != -1 {
+			err = readErr
+		}
+	} else {
+		_, err = throughput.Read(ctx, p.c, fmt.Sprintf("43%d[", p.ackID))
+	}
+	if err == nil {
+		return true, "", nil
+	}
+
+	p.close()
+	if websocket.CloseStatus(err) == websocket.StatusMessageTooBig {
+		return false, "close 1009 (message too big)", nil
+	}
+	return false, "", err
+}
+
+func (p *wsProber) close() {
+	if p.c != nil {
+		p.c.CloseNow()
+		p.c = nil
+	}
+}
+
+// pollingProber sends each message with its own POST on a polling session,
+// which the server answers with 200 once it has read the packets, or 413
+// without reading them. Meanwhile a GET request is kept pending, to answer the
+// pings of the server.
+type pollingProber struct {
+	endpoint string
+	client   *http.Client
+	// mu serializes the POST requests, as the server refuses overlapping
+	// ones
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// dialPolling opens an Engine.IO session over HTTP long-polling, and connects
+// it to the main namespace.
+func dialPolling(ctx context.Context, raw string) (*pollingProber, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme in %q", raw)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawQuery = "EIO=4&transport=polling"
+	p := &pollingProber{endpoint: u.String(), client: &http.Client{}}
+
+	status, body, err := p.do(ctx, http.MethodGet, "")
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	var handshake struct {
+		Sid string `json:"sid"`
+	}
+	if status != http.StatusOK || !strings.HasPrefix(body, "0") || json.Unmarshal([]byte(body[1:]), &handshake) != nil || handshake.Sid == "" {
+		return nil, fmt.Errorf("handshake: unexpected answer %d %q", status, body)
+	}
+	p.endpoint += "&sid=" + url.QueryEscape(handshake.Sid)
+
+	if status, _, err := p.do(ctx, http.MethodPost, "40"); err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("connect: status %d, %v", status, err)
+	}
+	status, body, err = p.do(ctx, http.MethodGet, "")
+	if err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("connect: status %d, %v", status, err)
+	}
+	for _, packet := range strings.Split(body, "\x1e") {
+		switch {
+		case strings.HasPrefix(packet, "40"):
+			ctx, p.cancel = context.WithCancel(context.Background())
+			p.done = make(chan struct{})
+			go p.heartbeat(ctx)
+			return p, nil
+		case strings.HasPrefix(packet, "44"):
+			return nil, fmt.Errorf("connection refused: %s", packet[2:])
+		}
+	}
+	return nil, fmt.Errorf("connect: unexpected packets %q", body)
+}
+
+func (p *pollingProber) try(ctx context.Context, message string) (bool, string, error) {
+	status, body, err := p.do(ctx, http.MethodPost, message)
+	switch {
+	case err != nil:
+		return false, "", err
+	case status == http.StatusOK:
+		return true, "", nil
+	case status == http.StatusRequestEntityTooLarge:
+		return false, fmt.Sprintf("HTTP %d %s", status, http.StatusText(status)), nil
+	default:
+		return false, "", fmt.Errorf("unexpected answer %d %q", status, body)
+	}
+}
+
+// heartbeat answers the pings of the server until ctx is done or the session
+// is closed.
+func (p *pollingProber) heartbeat(ctx context.Context) {
+	defer close(p.done)
+	for {
+		status, body, err := p.do(ctx, http.MethodGet, "")
+		if err != nil || status != http.StatusOK {
+			return
+		}
+		for _, packet := range strings.Split(body, "\x1e") {
+			switch packet {
+			case "1":
+				return
+			case "2":
+				if _, _, err := p.do(ctx, http.MethodPost, "3"); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// do sends a request on the session and returns the status and body of the
+// answer.
+func (p *pollingProber) do(ctx context.Context, method, body string) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint, strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	if method == http.MethodPost {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return res.StatusCode, string(data), nil
+}
+
+// close closes the session, as the client would with a CLOSE packet, which
+// also ends the pending GET request.
+func (p *pollingProber) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, _, _ = p.do(ctx, http.MethodPost, "1")
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.cancel()
+		<-p.done
+	}
+}
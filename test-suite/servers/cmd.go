@@ -2,77 +2,530 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"app/servers/healthcheck"
+	"app/servers/metrics"
+	"app/servers/testserver"
+
 	"github.com/zishang520/socket.io/servers/socket/v3"
 	"github.com/zishang520/socket.io/v3/pkg/log"
-	"github.com/zishang520/socket.io/v3/pkg/types"
 )
 
-func Socket(addr string) *socket.Server {
-	config := socket.DefaultServerOptions()
-	config.SetPingInterval(300 * time.Millisecond)
-	config.SetPingTimeout(200 * time.Millisecond)
-	config.SetMaxHttpBufferSize(1000000)
-	config.SetConnectTimeout(1000 * time.Millisecond)
-	config.SetCors(&types.Cors{
-		Origin: "*",
-	})
+// smallBufferSize is the maxHttpBufferSize of the small-buffer server, kept
+// low so that oversized payloads stay cheap to generate. A polling POST larger
+// than the limit is rejected as a whole with 413 and none of its packets are
+// processed, whatever its framing.
+const smallBufferSize = 10000
 
-	httpServer := types.NewWebServer(nil)
-	io := socket.NewServer(httpServer, config)
+// bufferProfiles are the maxHttpBufferSize of the -profile flag: 64KB for
+// chat messages, and 10MB for file transfers sent as a single attachment.
+var bufferProfiles = map[string]int64{
+	"small": 64 << 10,
+	"large": 10 << 20,
+}
 
-	httpServer.Listen(addr, nil)
+// reconnectAfter is the reconnection hint sent to clients on shutdown.
+const reconnectAfter = time.Second
 
-	return io
+// config holds the options of the main server that can be set from the
+// command line or the environment.
+type config struct {
+	addr           string
+	path           string
+	transportNames string
+	transports     []string
+	smallBuffer    string
+	listenUnix     string
+	unixMode       string
+	unixPerm       os.FileMode
+	webTransport   string
+	pingInterval   time.Duration
+	pingTimeout    time.Duration
+	maxBuffer      int64
+	profile        string
+	connectTimeout time.Duration
+	logLevel       slog.Level
+	tlsCert        string
+	tlsKey         string
+	tlsSelfSigned  bool
+	compression    int
+	allowEIO3      bool
+	corsOrigins    string
+	origins        []string
+	cookieName     string
+	cookieSameSite string
+	cookieSecure   bool
+	shutdownGrace  time.Duration
+	drainDelay     time.Duration
+	preStopDelay   time.Duration
+	gracePeriod    time.Duration
+	serveMux       bool
+	recovery       time.Duration
+	skipRecovered  bool
+	parser         string
+	adminUsername  string
+	adminPassword  string
+	adminStats     time.Duration
+	metricsAddr    string
+	statusTimeout  time.Duration
+	pollTimeout    time.Duration
+	nspClosing     bool
+	nspCleanup     bool
+	kickOnPanic    bool
+	maxConns       int
+	allowCIDRs     string
+	allowlist      []netip.Prefix
+	trustProxy     bool
+	auditErrors    int
+	auditWindow    time.Duration
 }
 
-func main() {
-	log.DEBUG.Store(true)
+// parseConfig reads the configuration from args, falling back to the
+// SERVER_* environment variables and then to the values the test suite
+// expects.
+func parseConfig(args []string) (*config, error) {
+	cfg := &config{
+		addr:           ":3000",
+		path:           testserver.DefaultPath,
+		smallBuffer:    ":3001",
+		unixMode:       "0660",
+		pingInterval:   testserver.DefaultPingInterval,
+		pingTimeout:    testserver.DefaultPingTimeout,
+		maxBuffer:      testserver.DefaultMaxHttpBufferSize,
+		connectTimeout: testserver.DefaultConnectTimeout,
+		logLevel:       slog.LevelInfo,
+		shutdownGrace:  5 * time.Second,
+		parser:         "json",
+		adminStats:     testserver.DefaultAdminStatsInterval,
+		auditWindow:    time.Minute,
+	}
 
-	io := Socket(":3000")
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&cfg.addr, "addr", cfg.addr, "listen address of the main server")
+	fs.StringVar(&cfg.smallBuffer, "small-buffer-addr", cfg.smallBuffer, "listen address of the small-buffer server")
+	fs.StringVar(&cfg.listenUnix, "listen-unix", "", "path of a unix socket the main server also listens on, replacing a stale one")
+	fs.StringVar(&cfg.unixMode, "listen-unix-mode", cfg.unixMode, "permissions of the -listen-unix socket, in octal")
+	fs.StringVar(&cfg.webTransport, "webtransport-addr", "", "UDP address the main server also serves HTTP/3 and WebTransport on, usually the port of -addr, which requires TLS")
+	fs.StringVar(&cfg.transportNames, "transports", "", "comma-separated transports both servers accept, e.g. polling behind proxies that break websockets, polling and websocket when empty")
+	fs.StringVar(&cfg.path, "path", cfg.path, "path Socket.IO is served at, the trailing slash being optional")
+	fs.DurationVar(&cfg.pingInterval, "ping-interval", cfg.pingInterval, "Engine.IO ping interval")
+	fs.DurationVar(&cfg.pingTimeout, "ping-timeout", cfg.pingTimeout, "Engine.IO ping timeout")
+	fs.Int64Var(&cfg.maxBuffer, "max-buffer", cfg.maxBuffer, "maxHttpBufferSize in bytes")
+	fs.StringVar(&cfg.profile, "profile", "", "maxHttpBufferSize profile, small for 64KB chat messages or large for 10MB file transfers, in place of -max-buffer")
+	fs.DurationVar(&cfg.connectTimeout, "connect-timeout", cfg.connectTimeout, "delay before a client without namespace is closed")
+	fs.TextVar(&cfg.logLevel, "log-level", cfg.logLevel, "minimum level of the logs, debug, info, warn or error")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", "", "certificate file, to serve over TLS")
+	fs.StringVar(&cfg.tlsKey, "tls-key", "", "private key file of -tls-cert")
+	fs.BoolVar(&cfg.tlsSelfSigned, "tls-self-signed", false, "serve over TLS with an in-memory certificate for localhost")
+	fs.IntVar(&cfg.compression, "compression-threshold", 0, "size in bytes from which polling responses and websocket frames are compressed, enabling permessage-deflate, 0 for the engine defaults")
+	fs.BoolVar(&cfg.allowEIO3, "allow-eio3", false, "also accept the Engine.IO 3 clients, EIO=3, of Socket.IO 2.x")
+	fs.StringVar(&cfg.corsOrigins, "cors-origins", "", "comma-separated origins allowed with credentials, e.g. https://app.example.com,https://*.example.com, any origin without credentials when empty")
+	fs.StringVar(&cfg.cookieName, "cookie-name", "", "name of the cookie set on handshakes, for sticky sessions, disabled when empty")
+	fs.StringVar(&cfg.cookieSameSite, "cookie-samesite", "lax", "SameSite attribute of -cookie-name, lax, strict or none")
+	fs.BoolVar(&cfg.cookieSecure, "cookie-secure", false, "mark -cookie-name Secure, which -cookie-samesite none requires")
+	fs.DurationVar(&cfg.shutdownGrace, "shutdown-grace", cfg.shutdownGrace, "how long to wait for clients to disconnect on shutdown")
+	fs.DurationVar(&cfg.drainDelay, "drain-delay", 0, "how long /readyz answers 503 on shutdown before clients are disconnected, counted in -shutdown-grace")
+	fs.DurationVar(&cfg.preStopDelay, "pre-stop-delay", 0, "how long /readyz answers 503 on shutdown before the shutdown notice, with -grace-period")
+	fs.DurationVar(&cfg.gracePeriod, "grace-period", 0, "shut down the way a Kubernetes pod terminates, leaving clients that long after the shutdown notice to disconnect, 0 to disconnect them at once")
+	fs.BoolVar(&cfg.serveMux, "serve-mux", false, "serve through a plain http.ServeMux, next to a /hello route")
+	fs.DurationVar(&cfg.recovery, "recovery", 0, "how long disconnected sessions can be recovered, 0 to disable connection state recovery")
+	fs.BoolVar(&cfg.skipRecovered, "recovery-skip-middlewares", true, "skip the middlewares for recovered sessions")
+	fs.StringVar(&cfg.parser, "parser", cfg.parser, "Socket.IO parser, json or msgpack")
+	fs.StringVar(&cfg.adminUsername, "admin-username", "", "username of the admin UI namespace, which is only registered when set")
+	fs.StringVar(&cfg.adminPassword, "admin-password", "", "password of -admin-username")
+	fs.DurationVar(&cfg.adminStats, "admin-stats-interval", cfg.adminStats, "delay between two server_stats events of the admin UI")
+	fs.DurationVar(&cfg.statusTimeout, "status-timeout", 0, "serve POST /status-report, which asks every client for its status within that timeout, 0 to disable")
+	fs.DurationVar(&cfg.pollTimeout, "poll-timeout", 0, "serve POST /poll-clients?room=, which broadcasts are-you-there and waits that long for the answers, 0 to disable")
+	fs.BoolVar(&cfg.nspClosing, "namespace-closing", false, "serve POST /close-namespace?namespace=, which disconnects the sockets of a namespace and refuses new ones")
+	fs.BoolVar(&cfg.nspCleanup, "namespace-cleanup", false, "delete the namespaces created on demand, e.g. /room-<name>, once their last socket leaves")
+	fs.BoolVar(&cfg.kickOnPanic, "disconnect-on-panic", false, "disconnect the clients whose event handler panicked, after sending them an error event")
+	fs.IntVar(&cfg.maxConns, "max-conns", 0, "refuse new handshakes while that many connections are open, 0 for no limit")
+	fs.StringVar(&cfg.allowCIDRs, "allow-cidr", "", "comma-separated CIDR prefixes the clients must connect from, e.g. 10.0.0.0/8,127.0.0.1/32, any address when empty")
+	fs.BoolVar(&cfg.trustProxy, "trust-proxy", false, "check the last X-Forwarded-For address against -allow-cidr instead of the peer address")
+	fs.IntVar(&cfg.auditErrors, "audit-connection-errors", 0, "log the addresses whose requests are refused that many times within -audit-window, with their user agent, 0 to disable")
+	fs.DurationVar(&cfg.auditWindow, "audit-window", cfg.auditWindow, "window of -audit-connection-errors")
+	fs.StringVar(&cfg.metricsAddr, "metrics-addr", "", "listen address of the Prometheus /metrics endpoint of the main server, disabled when empty")
 
-	io.On("connection", func(clients ...any) {
-		if len(clients) == 0 {
-			return
+	// Environment variables are applied first, so that flags take precedence
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := "SERVER_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		f.Usage += " (env " + name + ")"
+		if value, ok := os.LookupEnv(name); ok && err == nil {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", value, name, e)
+			}
 		}
-		client, ok := clients[0].(*socket.Socket)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if cfg.profile != "" {
+		size, ok := bufferProfiles[cfg.profile]
 		if !ok {
-			return
+			return nil, fmt.Errorf("unknown profile %q, expected small or large", cfg.profile)
 		}
+		maxBufferSet := false
+		fs.Visit(func(f *flag.Flag) { maxBufferSet = maxBufferSet || f.Name == "max-buffer" })
+		if maxBufferSet {
+			return nil, errors.New("profile cannot be combined with max-buffer")
+		}
+		cfg.maxBuffer = size
+	}
 
-		defer client.Emit("auth", client.Handshake().Auth)
-
-		client.On("message", func(args ...any) {
-			client.Emit("message-back", args...)
-		})
+	mode, err := strconv.ParseUint(cfg.unixMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return nil, fmt.Errorf("invalid listen-unix-mode %q", cfg.unixMode)
+	}
+	cfg.unixPerm = os.FileMode(mode)
 
-		client.On("message-with-ack", func(args ...any) {
-			if len(args) > 0 {
-				if ack, ok := args[len(args)-1].(socket.Ack); ok {
-					ack(args[:len(args)-1], nil)
-				}
+	switch {
+	case cfg.addr == "" || cfg.smallBuffer == "":
+		return nil, errors.New("addr and small-buffer-addr must not be empty")
+	case (cfg.tlsCert == "") != (cfg.tlsKey == ""):
+		return nil, errors.New("tls-cert and tls-key must be set together")
+	case cfg.tlsSelfSigned && cfg.tlsCert != "":
+		return nil, errors.New("tls-self-signed cannot be combined with tls-cert")
+	case cfg.webTransport != "" && cfg.tlsCert == "" && !cfg.tlsSelfSigned:
+		return nil, errors.New("webtransport-addr requires tls-cert or tls-self-signed")
+	case cfg.cookieName == "" && (cfg.cookieSameSite != "lax" || cfg.cookieSecure):
+		return nil, errors.New("cookie-samesite and cookie-secure require cookie-name")
+	case cfg.cookieSameSite != "lax" && cfg.cookieSameSite != "strict" && cfg.cookieSameSite != "none":
+		return nil, fmt.Errorf("unknown cookie-samesite %q", cfg.cookieSameSite)
+	case cfg.cookieSameSite == "none" && !cfg.cookieSecure:
+		return nil, errors.New("cookie-samesite none requires cookie-secure")
+	case cfg.shutdownGrace <= 0:
+		return nil, errors.New("shutdown-grace must be positive")
+	case cfg.drainDelay < 0 || cfg.drainDelay >= cfg.shutdownGrace:
+		return nil, errors.New("drain-delay must not be negative and must be shorter than shutdown-grace")
+	case cfg.preStopDelay < 0 || cfg.gracePeriod < 0:
+		return nil, errors.New("pre-stop-delay and grace-period must not be negative")
+	case cfg.preStopDelay > 0 && cfg.gracePeriod == 0:
+		return nil, errors.New("pre-stop-delay requires grace-period")
+	case cfg.drainDelay > 0 && cfg.gracePeriod > 0:
+		return nil, errors.New("drain-delay cannot be combined with grace-period, use pre-stop-delay")
+	case cfg.recovery < 0:
+		return nil, errors.New("recovery must not be negative")
+	case cfg.parser != "json" && cfg.parser != "msgpack":
+		return nil, fmt.Errorf("unknown parser %q", cfg.parser)
+	case (cfg.adminUsername == "") != (cfg.adminPassword == ""):
+		return nil, errors.New("admin-username and admin-password must be set together")
+	case cfg.adminStats <= 0:
+		return nil, errors.New("admin-stats-interval must be positive")
+	case cfg.statusTimeout < 0:
+		return nil, errors.New("status-timeout must not be negative")
+	case cfg.pollTimeout < 0:
+		return nil, errors.New("poll-timeout must not be negative")
+	case cfg.compression < 0:
+		return nil, errors.New("compression-threshold must not be negative")
+	case cfg.maxConns < 0:
+		return nil, errors.New("max-conns must not be negative")
+	case cfg.trustProxy && cfg.allowCIDRs == "":
+		return nil, errors.New("trust-proxy requires allow-cidr")
+	case cfg.auditErrors < 0:
+		return nil, errors.New("audit-connection-errors must not be negative")
+	case cfg.auditWindow <= 0:
+		return nil, errors.New("audit-window must be positive")
+	}
+	if cfg.transportNames != "" {
+		for _, name := range strings.Split(cfg.transportNames, ",") {
+			cfg.transports = append(cfg.transports, strings.TrimSpace(name))
+		}
+	}
+	if cfg.corsOrigins != "" {
+		for _, origin := range strings.Split(cfg.corsOrigins, ",") {
+			cfg.origins = append(cfg.origins, strings.TrimSpace(origin))
+		}
+	}
+	if cfg.allowCIDRs != "" {
+		for _, cidr := range strings.Split(cfg.allowCIDRs, ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid allow-cidr: %w", err)
 			}
-		})
-	})
-
-	io.Of("/custom", nil).On("connection", func(clients ...any) {
-		if len(clients) == 0 {
-			return
+			cfg.allowlist = append(cfg.allowlist, prefix)
 		}
-		client, ok := clients[0].(*socket.Socket)
-		if !ok {
-			return
+	}
+	return cfg, nil
+}
+
+// tlsConfig loads the certificate to serve with, or returns nil when TLS is
+// disabled.
+func (cfg *config) tlsConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case cfg.tlsSelfSigned:
+		cert, err = testserver.SelfSignedCertificate()
+	case cfg.tlsCert != "":
+		cert, err = tls.LoadX509KeyPair(cfg.tlsCert, cfg.tlsKey)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// cookie returns the cookie to set on handshakes, or nil when disabled.
+func (cfg *config) cookie() *http.Cookie {
+	if cfg.cookieName == "" {
+		return nil
+	}
+	sameSite := map[string]http.SameSite{
+		"lax":    http.SameSiteLaxMode,
+		"strict": http.SameSiteStrictMode,
+		"none":   http.SameSiteNoneMode,
+	}[cfg.cookieSameSite]
+	return &http.Cookie{Name: cfg.cookieName, SameSite: sameSite, Secure: cfg.cookieSecure}
+}
+
+// options returns the testserver options matching the configuration, logging
+// to logger.
+func (cfg *config) options(tlsConfig *tls.Config, logger *slog.Logger) []testserver.Option {
+	opts := []testserver.Option{
+		testserver.WithPath(cfg.path),
+		testserver.WithPingInterval(cfg.pingInterval),
+		testserver.WithPingTimeout(cfg.pingTimeout),
+		testserver.WithMaxHttpBufferSize(cfg.maxBuffer),
+		testserver.WithConnectTimeout(cfg.connectTimeout),
+		testserver.WithDrainDelay(cfg.drainDelay),
+		testserver.WithMaxConnections(cfg.maxConns),
+		testserver.WithLogger(logger),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, testserver.WithTLS(tlsConfig))
+	}
+	if cfg.transports != nil {
+		opts = append(opts, testserver.WithTransports(cfg.transports...))
+	}
+	if cookie := cfg.cookie(); cookie != nil {
+		opts = append(opts, testserver.WithCookie(cookie))
+	}
+	if cfg.compression > 0 {
+		opts = append(opts, testserver.WithCompression(cfg.compression))
+	}
+	if cfg.allowEIO3 {
+		opts = append(opts, testserver.WithAllowEIO3())
+	}
+	if cfg.origins != nil {
+		opts = append(opts, testserver.WithAllowedOrigins(cfg.origins...))
+	}
+	if cfg.recovery > 0 {
+		opts = append(opts, testserver.WithConnectionStateRecovery(cfg.recovery, cfg.skipRecovered))
+	}
+	if cfg.parser == "msgpack" {
+		opts = append(opts, testserver.WithParser(testserver.MsgpackParser()))
+	}
+	if cfg.statusTimeout > 0 {
+		opts = append(opts, testserver.WithStatusReports(cfg.statusTimeout))
+	}
+	if cfg.pollTimeout > 0 {
+		opts = append(opts, testserver.WithClientPolling(cfg.pollTimeout))
+	}
+	if cfg.nspClosing {
+		opts = append(opts, testserver.WithNamespaceClosing())
+	}
+	if cfg.nspCleanup {
+		opts = append(opts, testserver.WithNamespaceCleanup())
+	}
+	if cfg.kickOnPanic {
+		opts = append(opts, testserver.WithDisconnectOnPanic())
+	}
+	if cfg.allowlist != nil {
+		opts = append(opts, testserver.WithAllowlist(cfg.allowlist, cfg.trustProxy))
+	}
+	if cfg.auditErrors > 0 {
+		opts = append(opts, testserver.WithConnectionErrorAudit(cfg.auditErrors, cfg.auditWindow))
+	}
+	if cfg.adminUsername != "" {
+		opts = append(opts, testserver.WithAdminUI(cfg.adminUsername, cfg.adminPassword, cfg.adminStats))
+	}
+	return opts
+}
+
+// start starts a test server on addr, through a plain http.ServeMux when
+// configured.
+func (cfg *config) start(addr string, opts ...testserver.Option) (*socket.Server, error) {
+	if cfg.serveMux {
+		io, _, err := testserver.NewServeMux(addr, opts...)
+		return io, err
+	}
+	io, _, err := testserver.New(addr, opts...)
+	return io, err
+}
+
+func main() {
+	// The healthcheck mode probes a running server with the same binary, as
+	// the container image may hold nothing else, and takes its own flags
+	if len(os.Args) > 1 && (os.Args[1] == "-healthcheck" || os.Args[1] == "--healthcheck") {
+		os.Exit(healthcheck.Run(os.Args[2:], os.Stdout))
+	}
+
+	cfg, err := parseConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.logLevel}))
+	fail := func(msg string, err error) {
+		logger.Error(msg, slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// The library logs through its own loggers, created before main runs and
+	// writing unstructured lines to stderr. Their debug messages, printed when
+	// log.DEBUG is set and the DEBUG environment variable matches their
+	// namespace, e.g. DEBUG='socket.io:*', are only let through at debug level.
+	log.DEBUG.Store(cfg.logLevel <= slog.LevelDebug)
+
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		fail("invalid TLS configuration", err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	// The metrics are served on their own listener, opened first so that an
+	// address in use is reported before the servers start
+	opts := cfg.options(tlsConfig, logger.With(slog.String("server", "main")))
+	var metricsServer *http.Server
+	var metricsListener net.Listener
+	if cfg.metricsAddr != "" {
+		metricsListener, err = net.Listen("tcp", cfg.metricsAddr)
+		if err != nil {
+			fail("failed to serve the metrics", err)
 		}
-		defer client.Emit("auth", client.Handshake().Auth)
-	})
+		m := metrics.New()
+		opts = append(opts,
+			testserver.WithInstrumentation(m.Instrument),
+			testserver.WithConnectionErrorObserver(m.ObserveConnectionError),
+		)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Handler())
+		metricsServer = &http.Server{Handler: mux}
+	}
+	if cfg.listenUnix != "" {
+		opts = append(opts, testserver.WithUnixSocket(cfg.listenUnix, cfg.unixPerm))
+	}
+	if cfg.webTransport != "" {
+		opts = append(opts, testserver.WithWebTransport(cfg.webTransport))
+	}
 
+	// Caught from now on, so that a signal received while the servers start
+	// still shuts them down, and removes the unix socket
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer stop()
 
+	io, err := cfg.start(cfg.addr, opts...)
+	if err != nil {
+		fail("failed to start the test server", err)
+	}
+
+	smallBufferLogger := logger.With(slog.String("server", "small-buffer"))
+	smallBuffer, err := cfg.start(cfg.smallBuffer, append(cfg.options(tlsConfig, smallBufferLogger), testserver.WithMaxHttpBufferSize(smallBufferSize))...)
+	if err != nil {
+		_ = testserver.Shutdown(context.Background(), io, 0)
+		fail("failed to start the small-buffer server", err)
+	}
+
+	// The path as normalized by the server, the endpoint clients must use
+	logger.Info("test server listening",
+		slog.String("url", scheme+"://"+cfg.addr),
+		slog.String("endpoint", scheme+"://"+cfg.addr+io.Path()+"/"),
+		slog.String("unix", cfg.listenUnix),
+		slog.String("webtransport", cfg.webTransport),
+		slog.String("transports", cfg.transportNames),
+		slog.String("cors_origins", cfg.corsOrigins),
+		slog.Duration("ping_interval", cfg.pingInterval),
+		slog.Duration("ping_timeout", cfg.pingTimeout),
+		slog.Int64("max_buffer", cfg.maxBuffer),
+		slog.String("profile", cfg.profile),
+		slog.Duration("connect_timeout", cfg.connectTimeout),
+		slog.Bool("serve_mux", cfg.serveMux),
+		slog.Duration("recovery", cfg.recovery),
+		slog.String("parser", cfg.parser),
+		slog.Bool("admin_ui", cfg.adminUsername != ""),
+		slog.Int("max_conns", cfg.maxConns),
+		slog.Int("compression_threshold", cfg.compression),
+		slog.Bool("allow_eio3", cfg.allowEIO3),
+		slog.Bool("namespace_cleanup", cfg.nspCleanup),
+		slog.Bool("disconnect_on_panic", cfg.kickOnPanic),
+		slog.String("allow_cidr", cfg.allowCIDRs),
+	)
+	logger.Info("small-buffer server listening",
+		slog.String("url", scheme+"://"+cfg.smallBuffer),
+		slog.String("endpoint", scheme+"://"+cfg.smallBuffer+smallBuffer.Path()+"/"),
+		slog.Int64("max_buffer", smallBufferSize),
+	)
+
+	// As completed by the engine, the header every handshake response carries
+	if cookie := io.Engine().Opts().Cookie(); cookie != nil {
+		logger.Info("handshake cookie", slog.String("set_cookie", cookie.String()))
+	}
+
+	if metricsServer != nil {
+		go func() {
+			if err := metricsServer.Serve(metricsListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("failed to serve the metrics", slog.Any("error", err))
+			}
+		}()
+		logger.Info("metrics served", slog.String("url", "http://"+cfg.metricsAddr+"/metrics"))
+	}
+
 	<-ctx.Done()
-	io.Close(nil)
+	stop()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	if cfg.gracePeriod > 0 {
+		// Terminate logs each of its phases, and is bounded by its own delays
+		for i, server := range []*socket.Server{io, smallBuffer} {
+			wg.Go(func() {
+				errs[i] = testserver.Terminate(context.Background(), server, cfg.preStopDelay, cfg.gracePeriod)
+			})
+		}
+	} else {
+		logger.Info("shutting down", slog.Duration("grace", cfg.shutdownGrace))
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdownGrace)
+		defer cancel()
+		for i, server := range []*socket.Server{io, smallBuffer} {
+			wg.Go(func() { errs[i] = testserver.Shutdown(ctx, server, reconnectAfter) })
+		}
+	}
+	wg.Wait()
+	if metricsServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdownGrace)
+		defer cancel()
+		errs = append(errs, metricsServer.Shutdown(ctx))
+	}
+	if err := errors.Join(errs...); err != nil {
+		logger.Error("shutdown", slog.Any("error", err))
+	}
 }
@@ -0,0 +1,48 @@
+// Command echobench measures the binary echo throughput of a test server over
+// websocket, for each attachment size, and prints one JSON object per size.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"app/servers/testserver"
+	"app/servers/throughput"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:3000"+testserver.DefaultPath, "Socket.IO endpoint of the server")
+	sizes := flag.String("size", "1KB,64KB,1MB", "comma-separated attachment sizes, in bytes or with a KB or MB suffix")
+	count := flag.Int("count", 1000, "number of round trips per size")
+	concurrency := flag.Int("concurrency", 4, "number of connections sharing the round trips")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, s := range strings.Split(*sizes, ",") {
+		size, err := throughput.ParseSize(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		result, err := throughput.Run(ctx, throughput.Config{
+			URL:         *url,
+			Size:        size,
+			Count:       *count,
+			Concurrency: *concurrency,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "size %d: %v\n", size, err)
+			os.Exit(1)
+		}
+		_ = enc.Encode(result)
+	}
+}
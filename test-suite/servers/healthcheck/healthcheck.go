@@ -0,0 +1,249 @@
+// Package healthcheck probes a Socket.IO server through a real Engine.IO
+// handshake, for container health checks and exec probes. Unlike a bare TCP
+// check, which passes as soon as anything listens on the port, it fails when
+// the server is served at another path, refuses handshakes or is stuck before
+// answering them.
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the endpoint probed when none is given, the one of the test
+// server on its default address.
+const DefaultURL = "http://localhost:3000/socket.io/"
+
+// Config describes a probe of the Engine.IO endpoint URL. With Namespace, the
+// session is also connected to that namespace, and with ExpectUpgrade, the
+// server must offer that upgrade, e.g. "websocket".
+type Config struct {
+	URL           string
+	Namespace     string
+	ExpectUpgrade string
+}
+
+// Result is the outcome of a successful probe, the fields of the open packet.
+type Result struct {
+	SID          string
+	Upgrades     []string
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+	MaxPayload   int64
+	Namespace    string
+	Elapsed      time.Duration
+}
+
+// String returns the result on a single line.
+func (r Result) String() string {
+	s := fmt.Sprintf("sid=%s upgrades=%s ping_interval=%v ping_timeout=%v max_payload=%d",
+		r.SID, strings.Join(r.Upgrades, ","), r.PingInterval, r.PingTimeout, r.MaxPayload)
+	if r.Namespace != "" {
+		s += " namespace=" + r.Namespace
+	}
+	return s + fmt.Sprintf(" in %v", r.Elapsed.Round(time.Millisecond))
+}
+
+// Check opens an Engine.IO session over HTTP long-polling, the transport every
+// server accepts by default, validates the open packet, then connects to
+// cfg.Namespace, if any, and closes the session.
+func Check(ctx context.Context, cfg Config) (Result, error) {
+	started := time.Now()
+	endpoint, err := pollingURL(cfg.URL)
+	if err != nil {
+		return Result{}, err
+	}
+
+	status, body, err := do(ctx, http.MethodGet, endpoint, "")
+	if err != nil {
+		return Result{}, fmt.Errorf("handshake: %w", err)
+	}
+	if status != http.StatusOK {
+		return Result{}, fmt.Errorf("handshake: unexpected status %d %s", status, strings.TrimSpace(body))
+	}
+	result, err := parseOpen(body)
+	if err != nil {
+		return Result{}, fmt.Errorf("handshake: %w", err)
+	}
+	if cfg.ExpectUpgrade != "" && !slices.Contains(result.Upgrades, cfg.ExpectUpgrade) {
+		return Result{}, fmt.Errorf("handshake: upgrade to %s not offered, got %q", cfg.ExpectUpgrade, result.Upgrades)
+	}
+
+	session := endpoint + "&sid=" + url.QueryEscape(result.SID)
+	// Like a client leaving, so that the server doesn't wait for the ping
+	// timeout to release the session
+	defer do(context.WithoutCancel(ctx), http.MethodPost, session, "1")
+
+	if cfg.Namespace != "" {
+		if err := connect(ctx, session, cfg.Namespace); err != nil {
+			return Result{}, fmt.Errorf("connect %s: %w", cfg.Namespace, err)
+		}
+		result.Namespace = cfg.Namespace
+	}
+	result.Elapsed = time.Since(started)
+	return result, nil
+}
+
+// Run is the healthcheck command: it parses args, runs the probe and writes a
+// one-line result to stdout, returning the exit code, 0 when healthy and 1
+// otherwise, including for invalid arguments.
+func Run(args []string, stdout io.Writer) int {
+	cfg := Config{}
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	fs.StringVar(&cfg.URL, "url", DefaultURL, "Engine.IO endpoint of the server")
+	fs.StringVar(&cfg.Namespace, "namespace", "", "namespace to connect to after the handshake, e.g. /, none when empty")
+	fs.StringVar(&cfg.ExpectUpgrade, "expect-upgrade", "", "transport the server must offer an upgrade to, e.g. websocket")
+	timeout := fs.Duration("timeout", 5*time.Second, "time the whole probe may take")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stdout, "unhealthy: unexpected arguments %q\n", fs.Args())
+		return 1
+	}
+	if cfg.Namespace != "" && !strings.HasPrefix(cfg.Namespace, "/") {
+		fmt.Fprintf(stdout, "unhealthy: namespace %q must start with /\n", cfg.Namespace)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	result, err := Check(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(stdout, "unhealthy: %s: %v\n", cfg.URL, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "healthy: %s: %s\n", cfg.URL, result)
+	return 0
+}
+
+// pollingURL returns the URL of a new long-polling session at the endpoint
+// raw.
+func pollingURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme in %q", raw)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawQuery = "EIO=4&transport=polling"
+	return u.String(), nil
+}
+
+// parseOpen validates the open packet starting the payload body.
+func parseOpen(body string) (Result, error) {
+	packet, _, _ := strings.Cut(body, "\x1e")
+	if !strings.HasPrefix(packet, "0") {
+		return Result{}, fmt.Errorf("expected an open packet, got %q", packet)
+	}
+	var open struct {
+		SID          string   `json:"sid"`
+		Upgrades     []string `json:"upgrades"`
+		PingInterval int64    `json:"pingInterval"`
+		PingTimeout  int64    `json:"pingTimeout"`
+		MaxPayload   int64    `json:"maxPayload"`
+	}
+	if err := json.Unmarshal([]byte(packet[1:]), &open); err != nil {
+		return Result{}, fmt.Errorf("invalid open packet %q: %w", packet, err)
+	}
+	switch {
+	case open.SID == "":
+		return Result{}, fmt.Errorf("open packet without sid: %q", packet)
+	case open.PingInterval <= 0 || open.PingTimeout <= 0:
+		return Result{}, fmt.Errorf("open packet without ping interval or timeout: %q", packet)
+	case open.MaxPayload <= 0:
+		return Result{}, fmt.Errorf("open packet without max payload: %q", packet)
+	}
+	return Result{
+		SID:          open.SID,
+		Upgrades:     open.Upgrades,
+		PingInterval: time.Duration(open.PingInterval) * time.Millisecond,
+		PingTimeout:  time.Duration(open.PingTimeout) * time.Millisecond,
+		MaxPayload:   open.MaxPayload,
+	}, nil
+}
+
+// connect sends the CONNECT packet of nsp over the session and polls until
+// the server accepts or refuses it, answering the pings meanwhile.
+func connect(ctx context.Context, session, nsp string) error {
+	prefix := "40"
+	if nsp != "/" {
+		prefix += nsp + ","
+	}
+	if err := post(ctx, session, prefix); err != nil {
+		return err
+	}
+
+	refused := "44" + strings.TrimPrefix(prefix, "40")
+	for {
+		status, body, err := do(ctx, http.MethodGet, session, "")
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("unexpected status %d %s", status, strings.TrimSpace(body))
+		}
+		for _, packet := range strings.Split(body, "\x1e") {
+			switch {
+			case strings.HasPrefix(packet, prefix):
+				return nil
+			case strings.HasPrefix(packet, refused):
+				return fmt.Errorf("refused: %s", strings.TrimPrefix(packet, refused))
+			case packet == "1":
+				return errors.New("session closed by the server")
+			case packet == "2":
+				if err := post(ctx, session, "3"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// post sends payload over the session.
+func post(ctx context.Context, session, payload string) error {
+	status, body, err := do(ctx, http.MethodPost, session, payload)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected status %d %s", status, strings.TrimSpace(body))
+	}
+	return nil
+}
+
+// do sends the request and returns the status and body of its response.
+func do(ctx context.Context, method, url, payload string) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
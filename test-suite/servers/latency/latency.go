@@ -0,0 +1,389 @@
+// Package latency measures the round trip time of a single Socket.IO
+// connection, through the "message-with-ack" event of the test server, over
+// websocket or HTTP long-polling, so that the overhead of each transport
+// shows.
+package latency
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"app/servers/throughput"
+
+	"github.com/coder/websocket"
+)
+
+// Config describes a run: Warmup round trips left out of the results, then
+// Iterations measured ones, with at most Inflight acknowledgements awaited at
+// once, over Transport, "websocket" or "polling".
+type Config struct {
+	// URL is the Socket.IO endpoint, e.g. http://localhost:3000/socket.io/.
+	URL        string
+	Transport  string
+	Iterations int
+	Warmup     int
+	Inflight   int
+}
+
+// Bucket counts the round trips up to Le, e.g. "1ms", or "+Inf" for the last
+// bucket.
+type Bucket struct {
+	Le    string `json:"le"`
+	Count int    `json:"count"`
+}
+
+// Result is the outcome of a run, with the round trip times in milliseconds.
+type Result struct {
+	Transport  string   `json:"transport"`
+	Iterations int      `json:"iterations"`
+	Inflight   int      `json:"inflight"`
+	Min        float64  `json:"min_ms"`
+	P50        float64  `json:"p50_ms"`
+	P95        float64  `json:"p95_ms"`
+	P99        float64  `json:"p99_ms"`
+	Max        float64  `json:"max_ms"`
+	Histogram  []Bucket `json:"histogram"`
+}
+
+// bounds are the upper bounds of the buckets of the histogram, the last one
+// being unbounded.
+var bounds = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+// WriteText writes the result in a human readable form, with one bar per
+// bucket of the histogram, up to the last one holding round trips.
+func (r Result) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d round trips, %d in flight\n", r.Transport, r.Iterations, r.Inflight)
+	fmt.Fprintf(&b, "min %.3fms  p50 %.3fms  p95 %.3fms  p99 %.3fms  max %.3fms\n", r.Min, r.P50, r.P95, r.P99, r.Max)
+	last := -1
+	for i, bucket := range r.Histogram {
+		if bucket.Count > 0 {
+			last = i
+		}
+	}
+	for _, bucket := range r.Histogram[:last+1] {
+		bar := int(math.Ceil(40 * float64(bucket.Count) / float64(r.Iterations)))
+		line := fmt.Sprintf("  <= %-7s %6d %s", bucket.Le, bucket.Count, strings.Repeat("#", bar))
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the result as a JSON object.
+func (r Result) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// Run connects to cfg.URL over cfg.Transport, and times cfg.Warmup plus
+// cfg.Iterations round trips of "message-with-ack", keeping up to
+// cfg.Inflight of them in flight. The times are taken with the monotonic
+// clock, and only the last cfg.Iterations are counted.
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	if cfg.Iterations <= 0 || cfg.Warmup < 0 || cfg.Inflight <= 0 {
+		return Result{}, errors.New("iterations and inflight must be positive, warmup must not be negative")
+	}
+
+	var (
+		c   conn
+		err error
+	)
+	switch cfg.Transport {
+	case "websocket":
+		c, err = dialWebSocket(ctx, cfg.URL)
+	case "polling":
+		c, err = dialPolling(ctx, cfg.URL)
+	default:
+		return Result{}, fmt.Errorf("unknown transport %q, expected websocket or polling", cfg.Transport)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	defer c.close()
+
+	total := cfg.Warmup + cfg.Iterations
+	var (
+		mu    sync.Mutex
+		sent  = make([]time.Time, total)
+		times = make([]time.Duration, 0, cfg.Iterations)
+		slots = make(chan struct{}, cfg.Inflight)
+		done  = make(chan error, 1)
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		received := 0
+		for received < total {
+			packets, err := c.receive(ctx)
+			if err != nil {
+				done <- err
+				return
+			}
+			for _, packet := range packets {
+				switch {
+				case packet == "2":
+					if err := c.send(ctx, "3"); err != nil {
+						done <- err
+						return
+					}
+				case strings.HasPrefix(packet, "43"):
+					id, ok := ackID(packet)
+					if !ok || id >= total {
+						continue
+					}
+					mu.Lock()
+					elapsed := time.Since(sent[id])
+					mu.Unlock()
+					if id >= cfg.Warmup {
+						times = append(times, elapsed)
+					}
+					received++
+					<-slots
+				case strings.HasPrefix(packet, "1"):
+					done <- errors.New("the server closed the session")
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+
+	for id := range total {
+		select {
+		case slots <- struct{}{}:
+		case err := <-done:
+			if err == nil {
+				err = errors.New("unexpected acknowledgement")
+			}
+			return Result{}, err
+		}
+		mu.Lock()
+		sent[id] = time.Now()
+		mu.Unlock()
+		if err := c.send(ctx, fmt.Sprintf(`42%d["message-with-ack",%d]`, id, id)); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := <-done; err != nil {
+		return Result{}, err
+	}
+
+	result := summarize(times)
+	result.Transport = cfg.Transport
+	result.Iterations = cfg.Iterations
+	result.Inflight = cfg.Inflight
+	return result, nil
+}
+
+// ackID returns the id of an ACK packet, e.g. 12 for 4312[12].
+func ackID(packet string) (int, bool) {
+	digits := strings.TrimPrefix(packet, "43")
+	end := strings.IndexByte(digits, '[')
+	if end <= 0 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(digits[:end])
+	return id, err == nil
+}
+
+// summarize returns the extremes, the percentiles, with the nearest rank
+// method, and the histogram of times.
+func summarize(times []time.Duration) Result {
+	sorted := slices.Clone(times)
+	slices.Sort(sorted)
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		return milliseconds(sorted[max(rank, 1)-1])
+	}
+
+	histogram := make([]Bucket, len(bounds)+1)
+	for i, bound := range bounds {
+		histogram[i].Le = bound.String()
+	}
+	histogram[len(bounds)].Le = "+Inf"
+	for _, d := range sorted {
+		i, _ := slices.BinarySearch(bounds, d)
+		histogram[i].Count++
+	}
+
+	return Result{
+		Min:       milliseconds(sorted[0]),
+		P50:       percentile(50),
+		P95:       percentile(95),
+		P99:       percentile(99),
+		Max:       milliseconds(sorted[len(sorted)-1]),
+		Histogram: histogram,
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// conn is a Socket.IO connection to the main namespace, which sends and
+// receives Engine.IO packets.
+type conn interface {
+	send(ctx context.Context, packet string) error
+	// receive returns the next packets from the server.
+	receive(ctx context.Context) ([]string, error)
+	close()
+}
+
+// wsConn is a conn over websocket, one packet per message.
+type wsConn struct {
+	c *websocket.Conn
+}
+
+func dialWebSocket(ctx context.Context, raw string) (*wsConn, error) {
+	endpoint, err := throughput.WebsocketURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	c, err := throughput.Connect(ctx, endpoint, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &wsConn{c: c}, nil
+}
+
+func (c *wsConn) send(ctx context.Context, packet string) error {
+	return c.c.Write(ctx, websocket.MessageText, []byte(packet))
+}
+
+func (c *wsConn) receive(ctx context.Context) ([]string, error) {
+	typ, data, err := c.c.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if typ != websocket.MessageText {
+		return nil, nil
+	}
+	return []string{string(data)}, nil
+}
+
+func (c *wsConn) close() {
+	c.c.CloseNow()
+}
+
+// pollingConn is a conn over HTTP long-polling: each packet is sent with its
+// own POST, one at a time as the server refuses overlapping ones, and the
+// packets are received in batches by GET requests.
+type pollingConn struct {
+	endpoint string
+	client   *http.Client
+	mu       sync.Mutex
+}
+
+// dialPolling opens an Engine.IO session over HTTP long-polling, and connects
+// it to the main namespace.
+func dialPolling(ctx context.Context, raw string) (*pollingConn, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme in %q", raw)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawQuery = "EIO=4&transport=polling"
+	c := &pollingConn{endpoint: u.String(), client: &http.Client{}}
+
+	packets, err := c.receive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	var handshake struct {
+		Sid string `json:"sid"`
+	}
+	if len(packets) == 0 || !strings.HasPrefix(packets[0], "0") || json.Unmarshal([]byte(packets[0][1:]), &handshake) != nil || handshake.Sid == "" {
+		return nil, fmt.Errorf("handshake: unexpected packets %q", packets)
+	}
+	c.endpoint += "&sid=" + url.QueryEscape(handshake.Sid)
+
+	if err := c.send(ctx, "40"); err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	for {
+		packets, err := c.receive(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("connect: %w", err)
+		}
+		for _, packet := range packets {
+			switch {
+			case strings.HasPrefix(packet, "40"):
+				return c, nil
+			case strings.HasPrefix(packet, "44"):
+				return nil, fmt.Errorf("connection refused: %s", packet[2:])
+			}
+		}
+	}
+}
+
+func (c *pollingConn) send(ctx context.Context, packet string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, strings.NewReader(packet))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST: %s", res.Status)
+	}
+	return nil
+}
+
+func (c *pollingConn) receive(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET: %s %s", res.Status, bytes.TrimSpace(body))
+	}
+	return strings.Split(string(body), "\x1e"), nil
+}
+
+// close closes the session, as the client would with a CLOSE packet.
+func (c *pollingConn) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = c.send(ctx, "1")
+}
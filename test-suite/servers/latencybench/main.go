@@ -0,0 +1,53 @@
+// Command latencybench measures the round trip time of a single connection to
+// a test server, for each transport, and prints the percentiles along with a
+// histogram.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"app/servers/latency"
+	"app/servers/testserver"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:3000"+testserver.DefaultPath, "Socket.IO endpoint of the server")
+	transports := flag.String("transport", "websocket,polling", "comma-separated transports to measure, websocket or polling")
+	iterations := flag.Int("iterations", 1000, "number of round trips measured per transport")
+	warmup := flag.Int("warmup", 100, "number of round trips left out before the measured ones")
+	inflight := flag.Int("inflight", 1, "number of acknowledgements awaited at once, 1 for one round trip at a time")
+	format := flag.String("format", "text", "format of the results, text or json")
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected text or json\n", *format)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, transport := range strings.Split(*transports, ",") {
+		result, err := latency.Run(ctx, latency.Config{
+			URL:        *url,
+			Transport:  strings.TrimSpace(transport),
+			Iterations: *iterations,
+			Warmup:     *warmup,
+			Inflight:   *inflight,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", transport, err)
+			os.Exit(1)
+		}
+		if *format == "json" {
+			_ = result.WriteJSON(os.Stdout)
+		} else {
+			_ = result.WriteText(os.Stdout)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package load puts a Socket.IO server under the load of many websocket
+// clients, each emitting "message" at a fixed rate and timing the
+// "message-back" echo of the test server, to find how many clients it can
+// handle.
+package load
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"app/servers/throughput"
+
+	"github.com/coder/websocket"
+)
+
+// echoGrace is how long the clients wait for their last echoes once the run
+// is over, before counting them as timed out.
+const echoGrace = time.Second
+
+// Config describes a run: Connections clients, opened evenly over RampUp,
+// each emitting Rate messages per second carrying PayloadBytes bytes, until
+// Duration has passed after the ramp-up.
+type Config struct {
+	// URL is the Socket.IO endpoint, e.g. http://localhost:3000/socket.io/.
+	URL          string
+	Connections  int
+	Rate         float64
+	PayloadBytes int
+	Duration     time.Duration
+	RampUp       time.Duration
+}
+
+// Latency sums up the round trips from the emit to the echo, in
+// milliseconds.
+type Latency struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// Summary is the outcome of a run. Rate is the number of echoes received per
+// second over the whole run, ramp-up included, and Errors counts the errors
+// by type: "dial", "handshake" and "connect" for the clients that could not
+// connect, "write" and "read" for the ones whose connection failed, and
+// "timeout" for the messages not echoed before the end of the run.
+type Summary struct {
+	Connections int            `json:"connections"`
+	Connected   int            `json:"connected"`
+	Failed      int            `json:"failed"`
+	Sent        int            `json:"sent"`
+	Received    int            `json:"received"`
+	Seconds     float64        `json:"seconds"`
+	Rate        float64        `json:"rate"`
+	Latency     Latency        `json:"latency_ms"`
+	Errors      map[string]int `json:"errors"`
+}
+
+// ErrorCount returns the number of errors of every type.
+func (s Summary) ErrorCount() int {
+	n := 0
+	for _, count := range s.Errors {
+		n += count
+	}
+	return n
+}
+
+// WriteText writes the summary in a human readable form.
+func (s Summary) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "connections: %d connected, %d failed, of %d\n", s.Connected, s.Failed, s.Connections)
+	fmt.Fprintf(&b, "messages:    %d sent, %d echoed in %.1fs, %.1f/s\n", s.Sent, s.Received, s.Seconds, s.Rate)
+	fmt.Fprintf(&b, "latency:     p50 %.2fms, p95 %.2fms, p99 %.2fms, max %.2fms\n", s.Latency.P50, s.Latency.P95, s.Latency.P99, s.Latency.Max)
+	if len(s.Errors) == 0 {
+		b.WriteString("errors:      none\n")
+	} else {
+		types := make([]string, 0, len(s.Errors))
+		for typ := range s.Errors {
+			types = append(types, typ)
+		}
+		slices.Sort(types)
+		b.WriteString("errors:     ")
+		for _, typ := range types {
+			fmt.Fprintf(&b, " %s %d", typ, s.Errors[typ])
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the summary as a JSON object.
+func (s Summary) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// recorder collects the outcome of the clients.
+type recorder struct {
+	mu        sync.Mutex
+	connected int
+	sent      int
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+func (r *recorder) error(typ string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[typ] += n
+}
+
+// Run opens cfg.Connections websocket clients to cfg.URL, connects them to
+// the main namespace, and has each of them emit "message" at cfg.Rate until
+// the run is over, timing each round trip. Canceling ctx ends the run early,
+// with the summary of what was measured so far.
+func Run(ctx context.Context, cfg Config) (Summary, error) {
+	if cfg.Connections <= 0 || cfg.Rate <= 0 || cfg.PayloadBytes < 0 || cfg.Duration <= 0 || cfg.RampUp < 0 {
+		return Summary{}, errors.New("connections, rate and duration must be positive, payload bytes and ramp-up must not be negative")
+	}
+	endpoint, err := throughput.WebsocketURL(cfg.URL)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	start := time.Now()
+	runCtx, cancel := context.WithDeadline(ctx, start.Add(cfg.RampUp+cfg.Duration))
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		rec      = &recorder{errors: make(map[string]int)}
+		payload  = strings.Repeat("x", cfg.PayloadBytes)
+		interval = time.Duration(float64(time.Second) / cfg.Rate)
+	)
+	for i := range cfg.Connections {
+		delay := cfg.RampUp * time.Duration(i) / time.Duration(cfg.Connections)
+		wg.Go(func() {
+			select {
+			case <-time.After(time.Until(start.Add(delay))):
+			case <-runCtx.Done():
+				return
+			}
+			runClient(runCtx, endpoint, interval, payload, rec)
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	summary := Summary{
+		Connections: cfg.Connections,
+		Connected:   rec.connected,
+		Failed:      rec.errors["dial"] + rec.errors["handshake"] + rec.errors["connect"],
+		Sent:        rec.sent,
+		Received:    len(rec.latencies),
+		Seconds:     elapsed,
+		Rate:        float64(len(rec.latencies)) / elapsed,
+		Latency:     summarize(rec.latencies),
+		Errors:      rec.errors,
+	}
+	return summary, nil
+}
+
+// runClient connects a client to endpoint, and emits a message every
+// interval until ctx is done, then waits for the last echoes.
+func runClient(ctx context.Context, endpoint string, interval time.Duration, payload string, rec *recorder) {
+	c, typ, err := connect(ctx, endpoint, len(payload))
+	if err != nil {
+		if ctx.Err() == nil {
+			rec.error(typ, 1)
+		}
+		return
+	}
+	defer c.CloseNow()
+	rec.mu.Lock()
+	rec.connected++
+	rec.mu.Unlock()
+
+	var (
+		mu       sync.Mutex
+		pending  = make(map[int]time.Time)
+		finished bool
+	)
+	// The echoes outlive ctx, which only ends the emits
+	readCtx, cancelRead := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRead()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			packet, err := throughput.Read(readCtx, c, `42["message-back",`)
+			if err != nil {
+				if readCtx.Err() == nil {
+					rec.error("read", 1)
+				}
+				return
+			}
+			var args []any
+			if json.Unmarshal([]byte(packet[2:]), &args) != nil || len(args) < 2 {
+				continue
+			}
+			id, ok := args[1].(float64)
+			if !ok {
+				continue
+			}
+			mu.Lock()
+			sent, ok := pending[int(id)]
+			delete(pending, int(id))
+			drained := finished && len(pending) == 0
+			mu.Unlock()
+			if ok {
+				rec.mu.Lock()
+				rec.latencies = append(rec.latencies, time.Since(sent))
+				rec.mu.Unlock()
+			}
+			if drained {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+emit:
+	for id := 0; ctx.Err() == nil; id++ {
+		// The first message is emitted as soon as the client is connected
+		if id > 0 {
+			select {
+			case <-ctx.Done():
+				break emit
+			case <-done:
+				// The connection failed, the reader has recorded why
+				break emit
+			case <-ticker.C:
+			}
+		}
+		mu.Lock()
+		pending[id] = time.Now()
+		mu.Unlock()
+		if err := c.Write(readCtx, websocket.MessageText, fmt.Appendf(nil, `42["message",%d,%q]`, id, payload)); err != nil {
+			mu.Lock()
+			delete(pending, id)
+			mu.Unlock()
+			rec.error("write", 1)
+			break
+		}
+		rec.mu.Lock()
+		rec.sent++
+		rec.mu.Unlock()
+	}
+
+	mu.Lock()
+	finished = true
+	drained := len(pending) == 0
+	mu.Unlock()
+	if !drained {
+		select {
+		case <-done:
+		case <-time.After(echoGrace):
+		}
+	}
+	cancelRead()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pending) > 0 {
+		rec.error("timeout", len(pending))
+	}
+}
+
+// connect opens an Engine.IO session over websocket and connects it to the
+// main namespace, giving up once ctx is done. It returns the type of the
+// error on failure.
+func connect(ctx context.Context, endpoint string, payloadBytes int) (*websocket.Conn, string, error) {
+	c, _, err := websocket.Dial(ctx, endpoint, nil)
+	if err != nil {
+		return nil, "dial", err
+	}
+	// The text packets other than the messages stay well below 32KB
+	c.SetReadLimit(int64(max(2*payloadBytes, 32<<10)))
+
+	if _, err := throughput.Read(ctx, c, "0"); err != nil {
+		c.CloseNow()
+		return nil, "handshake", err
+	}
+	if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+		c.CloseNow()
+		return nil, "connect", err
+	}
+	if _, err := throughput.Read(ctx, c, "40"); err != nil {
+		c.CloseNow()
+		return nil, "connect", err
+	}
+	return c, "", nil
+}
+
+// summarize returns the percentiles of latencies, with the nearest rank
+// method.
+func summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		return milliseconds(sorted[max(rank, 1)-1])
+	}
+	return Latency{
+		P50: percentile(50),
+		P95: percentile(95),
+		P99: percentile(99),
+		Max: milliseconds(sorted[len(sorted)-1]),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
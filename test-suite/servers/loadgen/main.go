@@ -0,0 +1,57 @@
+// Command loadgen opens many websocket clients to a test server, has each of
+// them emit "message" at a fixed rate, and prints a summary of the
+// connections, the achieved rate, the round trip latencies and the errors.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"app/servers/load"
+	"app/servers/testserver"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:3000"+testserver.DefaultPath, "Socket.IO endpoint of the server")
+	connections := flag.Int("connections", 100, "number of clients")
+	rate := flag.Float64("rate", 1, "messages per second emitted by each client")
+	payloadBytes := flag.Int("payload-bytes", 100, "size of the string each message carries")
+	duration := flag.Duration("duration", 10*time.Second, "how long the clients emit once all are connected")
+	rampUp := flag.Duration("ramp-up", 0, "time over which the clients are connected evenly")
+	format := flag.String("format", "text", "format of the summary, text or json")
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected text or json\n", *format)
+		os.Exit(2)
+	}
+
+	// SIGINT ends the run early, still printing the summary
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary, err := load.Run(ctx, load.Config{
+		URL:          *url,
+		Connections:  *connections,
+		Rate:         *rate,
+		PayloadBytes: *payloadBytes,
+		Duration:     *duration,
+		RampUp:       *rampUp,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *format == "json" {
+		_ = summary.WriteJSON(os.Stdout)
+	} else {
+		_ = summary.WriteText(os.Stdout)
+	}
+	if summary.ErrorCount() > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,178 @@
+// Package metrics exposes Prometheus metrics of a Socket.IO server. It is kept
+// apart from testserver, so that the server itself does not depend on the
+// Prometheus client.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zishang520/socket.io/parsers/engine/v3/packet"
+	"github.com/zishang520/socket.io/servers/engine/v3"
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Metrics collects the metrics of a single Socket.IO server in its own
+// registry, so that several servers of a process don't share them.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	connections    *prometheus.CounterVec
+	disconnections *prometheus.CounterVec
+	packets        *prometheus.CounterVec
+	bytes          *prometheus.CounterVec
+	refused        *prometheus.CounterVec
+
+	io *socket.Server
+	// namespaces holds every namespace of io, by name
+	namespaces types.Map[string, socket.Namespace]
+}
+
+var (
+	socketsDesc = prometheus.NewDesc(
+		"socketio_connected_sockets",
+		"Number of sockets currently connected to a namespace.",
+		[]string{"namespace"}, nil,
+	)
+	clientsDesc = prometheus.NewDesc(
+		"engineio_connected_clients",
+		"Number of Engine.IO connections currently open.",
+		nil, nil,
+	)
+)
+
+// New returns metrics to register on a server with Instrument.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		connections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "socketio_connections_total",
+			Help: "Number of sockets that connected to a namespace.",
+		}, []string{"namespace"}),
+		disconnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "socketio_disconnections_total",
+			Help: "Number of sockets that disconnected from a namespace, by reason.",
+		}, []string{"namespace", "reason"}),
+		packets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "engineio_packets_total",
+			Help: "Number of Engine.IO packets, heartbeats included, by direction.",
+		}, []string{"direction"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "engineio_bytes_total",
+			Help: "Size of the payloads of the Engine.IO packets, by direction. Framing is not included.",
+		}, []string{"direction"}),
+		refused: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "engineio_connection_errors_total",
+			Help: "Number of requests refused before a connection, by Engine.IO error code and reason.",
+		}, []string{"code", "reason"}),
+	}
+	m.registry.MustRegister(m.connections, m.disconnections, m.packets, m.bytes, m.refused, m)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Instrument collects the metrics of io. It must be called once, before the
+// namespaces other than the main one are created, which
+// testserver.WithInstrumentation does.
+func (m *Metrics) Instrument(io *socket.Server) {
+	m.io = io
+	m.observe(io.Sockets())
+	_ = io.On("new_namespace", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		if nsp, ok := args[0].(socket.Namespace); ok {
+			m.observe(nsp)
+		}
+	})
+
+	_ = io.Engine().On("connection", func(conns ...any) {
+		if len(conns) == 0 {
+			return
+		}
+		conn, ok := conns[0].(engine.Socket)
+		if !ok {
+			return
+		}
+		_ = conn.On("packet", func(packets ...any) {
+			m.count("received", packets)
+		})
+		_ = conn.On("packetCreate", func(packets ...any) {
+			m.count("sent", packets)
+		})
+	})
+}
+
+// ObserveConnectionError counts a request refused with code and reason, which
+// testserver.WithConnectionErrorObserver passes to it.
+func (m *Metrics) ObserveConnectionError(code int, reason string) {
+	m.refused.WithLabelValues(strconv.Itoa(code), reason).Inc()
+}
+
+// observe counts the connections and disconnections of nsp.
+func (m *Metrics) observe(nsp socket.Namespace) {
+	name := nsp.Name()
+	m.namespaces.Store(name, nsp)
+	_ = nsp.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*socket.Socket)
+		if !ok {
+			return
+		}
+		m.connections.WithLabelValues(name).Inc()
+		client.On("disconnect", func(args ...any) {
+			reason := "unknown"
+			if len(args) > 0 {
+				if r, ok := args[0].(string); ok {
+					reason = r
+				}
+			}
+			m.disconnections.WithLabelValues(name, reason).Inc()
+		})
+	})
+}
+
+// count records a packet of the "packet" or "packetCreate" event.
+func (m *Metrics) count(direction string, packets []any) {
+	if len(packets) == 0 {
+		return
+	}
+	p, ok := packets[0].(*packet.Packet)
+	if !ok {
+		return
+	}
+	m.packets.WithLabelValues(direction).Inc()
+	// The payload is read later by the transport, its unread length is its
+	// size
+	if data, ok := p.Data.(interface{ Len() int }); ok {
+		m.bytes.WithLabelValues(direction).Add(float64(data.Len()))
+	}
+}
+
+// Describe implements prometheus.Collector for the gauges, which are read
+// from the server on every scrape.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- socketsDesc
+	ch <- clientsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	if m.io == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(clientsDesc, prometheus.GaugeValue, float64(m.io.Engine().ClientsCount()))
+	m.namespaces.Range(func(name string, nsp socket.Namespace) bool {
+		ch <- prometheus.MustNewConstMetric(socketsDesc, prometheus.GaugeValue, float64(nsp.Sockets().Len()), name)
+		return true
+	})
+}
@@ -0,0 +1,62 @@
+// Command probelimit finds the maxHttpBufferSize a test server enforces, for
+// each transport, by sending it growing messages, and prints the threshold
+// found along with how the server refused the messages over it.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"app/servers/bufferlimit"
+	"app/servers/testserver"
+	"app/servers/throughput"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:3000"+testserver.DefaultPath, "Socket.IO endpoint of the server")
+	transports := flag.String("transport", "websocket,polling", "comma-separated transports to probe, websocket or polling")
+	start := flag.String("start", "1KB", "payload of the first message, with an optional KB or MB suffix")
+	maxSize := flag.String("max", "64MB", "largest payload sent, with an optional KB or MB suffix")
+	format := flag.String("format", "text", "format of the results, text or json")
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, expected text or json\n", *format)
+		os.Exit(2)
+	}
+	startSize, err := throughput.ParseSize(*start)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	maxPayload, err := throughput.ParseSize(*maxSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, transport := range strings.Split(*transports, ",") {
+		result, err := bufferlimit.Probe(ctx, bufferlimit.Config{
+			URL:       *url,
+			Transport: strings.TrimSpace(transport),
+			Start:     startSize,
+			Max:       maxPayload,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", transport, err)
+			os.Exit(1)
+		}
+		if *format == "json" {
+			_ = result.WriteJSON(os.Stdout)
+		} else {
+			_ = result.WriteText(os.Stdout)
+		}
+	}
+}
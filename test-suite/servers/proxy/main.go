@@ -0,0 +1,64 @@
+// Command proxy runs two test servers behind a sticky reverse proxy, the way
+// several server processes sit behind one address in production.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"app/servers/stickyproxy"
+	"app/servers/testserver"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+func main() {
+	addr := flag.String("addr", ":4000", "listen address of the proxy")
+	backends := flag.String("backends", "127.0.0.1:4001,127.0.0.1:4002", "comma-separated listen addresses of the test servers")
+	roundRobin := flag.Bool("round-robin", false, "route each request to the next server, to demonstrate the failure without stickiness")
+	flag.Parse()
+
+	mode := stickyproxy.Sticky
+	if *roundRobin {
+		mode = stickyproxy.RoundRobin
+	}
+
+	var servers []*socket.Server
+	var urls []*url.URL
+	for _, backend := range strings.Split(*backends, ",") {
+		io, _, err := testserver.New(backend)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		servers = append(servers, io)
+		urls = append(urls, &url.URL{Scheme: "http", Host: backend})
+	}
+
+	server := &http.Server{Addr: *addr, Handler: stickyproxy.New(mode, urls...)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}()
+	fmt.Printf("Proxy listening on http://%s (round robin %t), test servers on %s\n", *addr, *roundRobin, *backends)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	fmt.Println("Shutting down...")
+	_ = server.Close()
+	for _, io := range servers {
+		io.Close(nil)
+	}
+}
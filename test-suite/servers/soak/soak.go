@@ -0,0 +1,439 @@
+// Package soak keeps many websocket clients connected to a Socket.IO server
+// for a long time, answering the heartbeats, emitting a low rate of
+// "message" events and reconnecting a share of them now and then, and
+// records every protocol error or unexpected disconnection, to find the slow
+// leaks of the server and of the libraries.
+package soak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"app/servers/throughput"
+
+	"github.com/coder/websocket"
+)
+
+// echoTimeout is how long a client waits for the echo of a message before
+// reporting it.
+const echoTimeout = 5 * time.Second
+
+// retryDelay is how long a client waits before connecting again after a
+// failure.
+const retryDelay = time.Second
+
+// Config describes a soak: Connections clients kept connected for Duration,
+// each emitting Rate messages per second, with a share Churn of them
+// reconnected every ChurnInterval, and the stats logged every
+// StatsInterval.
+type Config struct {
+	// URL is the Socket.IO endpoint, e.g. http://localhost:3000/socket.io/.
+	URL           string
+	Connections   int
+	Duration      time.Duration
+	Rate          float64
+	Churn         float64
+	ChurnInterval time.Duration
+	StatsInterval time.Duration
+	// AdminUsername and AdminPassword, when set, are the credentials of the
+	// Admin UI namespace of the server, whose "server_stats" give the number
+	// of sockets the server counts.
+	AdminUsername string
+	AdminPassword string
+	// RSS, when set, returns the resident set size of the server, e.g.
+	// ProcessRSS for a server embedded in the same process.
+	RSS    func() (int64, error)
+	Logger *slog.Logger
+}
+
+// Stats is a sample of the state of a soak. ServerSockets is -1 when the
+// server does not report it, and RSS 0.
+type Stats struct {
+	Seconds       float64 `json:"seconds"`
+	Active        int     `json:"active"`
+	Reconnects    int     `json:"reconnects"`
+	Errors        int     `json:"errors"`
+	ServerSockets int     `json:"server_sockets"`
+	RSS           int64   `json:"rss"`
+}
+
+// Incident is a protocol error or an unexpected disconnection of a client.
+// Kind is one of "connect", "disconnect", "protocol" and "timeout".
+type Incident struct {
+	Time   time.Time `json:"time"`
+	Client int       `json:"client"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+}
+
+// Report is the outcome of a soak, with the stats sampled every
+// StatsInterval, and at the end. Reconnects is the one of the last sample.
+type Report struct {
+	Connections int        `json:"connections"`
+	Reconnects  int        `json:"reconnects"`
+	Incidents   []Incident `json:"incidents"`
+	Samples     []Stats    `json:"samples"`
+}
+
+// soak holds the state shared by the clients of a run.
+type soak struct {
+	cfg      Config
+	endpoint string
+	logger   *slog.Logger
+
+	active        atomic.Int64
+	reconnects    atomic.Int64
+	serverSockets atomic.Int64
+
+	mu        sync.Mutex
+	incidents []Incident
+}
+
+// incident records an incident of the client id, and logs it.
+func (s *soak) incident(id int, kind, detail string) {
+	s.logger.Warn("soak incident", slog.Int("client", id), slog.String("kind", kind), slog.String("detail", detail))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.incidents = append(s.incidents, Incident{Time: time.Now(), Client: id, Kind: kind, Detail: detail})
+}
+
+// Run connects cfg.Connections clients to cfg.URL and keeps them busy until
+// cfg.Duration has passed or ctx is canceled, then disconnects them and
+// returns the report.
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	if cfg.Connections <= 0 || cfg.Duration <= 0 || cfg.Rate <= 0 || cfg.ChurnInterval <= 0 || cfg.StatsInterval <= 0 {
+		return Report{}, errors.New("connections, duration, rate, churn interval and stats interval must be positive")
+	}
+	if cfg.Churn < 0 || cfg.Churn > 1 {
+		return Report{}, errors.New("churn must be between 0 and 1")
+	}
+	endpoint, err := throughput.WebsocketURL(cfg.URL)
+	if err != nil {
+		return Report{}, err
+	}
+	s := &soak{cfg: cfg, endpoint: endpoint, logger: cfg.Logger}
+	if s.logger == nil {
+		s.logger = slog.New(slog.DiscardHandler)
+	}
+	s.serverSockets.Store(-1)
+
+	start := time.Now()
+	runCtx, cancel := context.WithDeadline(ctx, start.Add(cfg.Duration))
+	defer cancel()
+	// The clients stop once the last sample is taken
+	clientsCtx, stopClients := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopClients()
+
+	var wg sync.WaitGroup
+	if cfg.AdminUsername != "" {
+		wg.Go(func() { s.watchServer(clientsCtx) })
+	}
+	churns := make([]chan struct{}, cfg.Connections)
+	for id := range churns {
+		churns[id] = make(chan struct{}, 1)
+		wg.Go(func() { s.runClient(clientsCtx, id, churns[id]) })
+	}
+
+	report := Report{Connections: cfg.Connections}
+	var sampledAt time.Time
+	sample := func() {
+		sampledAt = time.Now()
+		stats := s.stats(sampledAt.Sub(start))
+		report.Samples = append(report.Samples, stats)
+		s.logger.Info("soak stats",
+			slog.Duration("elapsed", sampledAt.Sub(start).Round(time.Second)),
+			slog.Int("active", stats.Active),
+			slog.Int("reconnects", stats.Reconnects),
+			slog.Int("errors", stats.Errors),
+			slog.Int("server_sockets", stats.ServerSockets),
+			slog.Int64("rss", stats.RSS),
+		)
+	}
+
+	churnTicker := time.NewTicker(cfg.ChurnInterval)
+	defer churnTicker.Stop()
+	statsTicker := time.NewTicker(cfg.StatsInterval)
+	defer statsTicker.Stop()
+	picked := int(math.Ceil(cfg.Churn * float64(cfg.Connections)))
+loop:
+	for {
+		select {
+		case <-runCtx.Done():
+			break loop
+		case <-churnTicker.C:
+			for _, id := range rand.Perm(cfg.Connections)[:picked] {
+				select {
+				case churns[id] <- struct{}{}:
+				default:
+				}
+			}
+		case <-statsTicker.C:
+			sample()
+		}
+	}
+	// The last tick may have just sampled the end of the soak
+	if time.Since(sampledAt) > cfg.StatsInterval/2 {
+		sample()
+	}
+	stopClients()
+	wg.Wait()
+
+	report.Reconnects = report.Samples[len(report.Samples)-1].Reconnects
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report.Incidents = append([]Incident{}, s.incidents...)
+	return report, nil
+}
+
+// stats samples the state of the soak.
+func (s *soak) stats(elapsed time.Duration) Stats {
+	s.mu.Lock()
+	incidents := len(s.incidents)
+	s.mu.Unlock()
+
+	stats := Stats{
+		Seconds:       elapsed.Seconds(),
+		Active:        int(s.active.Load()),
+		Reconnects:    int(s.reconnects.Load()),
+		Errors:        incidents,
+		ServerSockets: int(s.serverSockets.Load()),
+	}
+	if s.cfg.RSS != nil {
+		if rss, err := s.cfg.RSS(); err == nil {
+			stats.RSS = rss
+		}
+	}
+	return stats
+}
+
+// outcome tells why a session of a client ended.
+type outcome int
+
+const (
+	// stopped means the soak is over
+	stopped outcome = iota
+	// churned means the client was picked to reconnect
+	churned
+	// failed means the session ended on an incident
+	failed
+)
+
+// runClient keeps the client id connected until ctx is done, connecting it
+// again when churn receives, or after a failure.
+func (s *soak) runClient(ctx context.Context, id int, churn chan struct{}) {
+	for ctx.Err() == nil {
+		c, err := throughput.Connect(ctx, s.endpoint, 0)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.incident(id, "connect", err.Error())
+				sleep(ctx, retryDelay)
+			}
+			continue
+		}
+
+		s.active.Add(1)
+		result := s.session(ctx, id, c, churn)
+		s.active.Add(-1)
+		switch result {
+		case churned:
+			s.reconnects.Add(1)
+		case failed:
+			sleep(ctx, retryDelay)
+		}
+	}
+}
+
+// session drives the connection c of the client id: it answers the
+// heartbeats, emits "message" at the configured rate and checks the echoes,
+// until ctx is done, churn receives, or an incident.
+func (s *soak) session(ctx context.Context, id int, c *websocket.Conn, churn chan struct{}) outcome {
+	defer c.CloseNow()
+
+	var (
+		mu      sync.Mutex
+		pending = make(map[int]time.Time)
+		closing atomic.Bool
+		done    = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for {
+			typ, data, err := c.Read(context.Background())
+			if err != nil {
+				if !closing.Load() {
+					s.incident(id, "disconnect", fmt.Sprintf("connection lost: %v", err))
+				}
+				return
+			}
+			packet := string(data)
+			if typ != websocket.MessageText {
+				s.incident(id, "protocol", "unexpected binary message")
+				return
+			}
+			switch {
+			case packet == "2":
+				if err := c.Write(context.Background(), websocket.MessageText, []byte("3")); err != nil && !closing.Load() {
+					s.incident(id, "disconnect", fmt.Sprintf("heartbeat not answered: %v", err))
+					return
+				}
+			case strings.HasPrefix(packet, `42["message-back",`):
+				var args []any
+				if err := json.Unmarshal([]byte(packet[2:]), &args); err != nil || len(args) != 2 {
+					s.incident(id, "protocol", fmt.Sprintf("invalid echo %q", packet))
+					return
+				}
+				seq, _ := args[1].(float64)
+				mu.Lock()
+				_, ok := pending[int(seq)]
+				delete(pending, int(seq))
+				mu.Unlock()
+				if !ok {
+					s.incident(id, "protocol", fmt.Sprintf("unexpected echo %q", packet))
+					return
+				}
+			case strings.HasPrefix(packet, "42["):
+				// Other events, such as "auth" on connection
+			case packet == "41":
+				s.incident(id, "disconnect", "disconnected by the server")
+				return
+			case packet == "1":
+				s.incident(id, "disconnect", "session closed by the server")
+				return
+			default:
+				s.incident(id, "protocol", fmt.Sprintf("unexpected packet %q", packet))
+				return
+			}
+		}
+	}()
+
+	// leave disconnects the socket and closes the connection, which the
+	// reader must not report
+	leave := func(result outcome) outcome {
+		closing.Store(true)
+		_ = c.Write(context.Background(), websocket.MessageText, []byte("41"))
+		_ = c.Close(websocket.StatusNormalClosure, "")
+		<-done
+		return result
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.cfg.Rate))
+	defer ticker.Stop()
+	for seq := 0; ; seq++ {
+		select {
+		case <-ctx.Done():
+			return leave(stopped)
+		case <-churn:
+			return leave(churned)
+		case <-done:
+			return failed
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		mu.Lock()
+		pending[seq] = now
+		var late []int
+		for pendingSeq, sent := range pending {
+			if now.Sub(sent) > echoTimeout {
+				late = append(late, pendingSeq)
+				delete(pending, pendingSeq)
+			}
+		}
+		mu.Unlock()
+		for _, pendingSeq := range late {
+			s.incident(id, "timeout", fmt.Sprintf("message %d not echoed within %v", pendingSeq, echoTimeout))
+		}
+
+		if err := c.Write(ctx, websocket.MessageText, fmt.Appendf(nil, `42["message",%d]`, seq)); err != nil {
+			if ctx.Err() != nil {
+				return leave(stopped)
+			}
+			closing.Store(true)
+			s.incident(id, "disconnect", fmt.Sprintf("emit failed: %v", err))
+			c.CloseNow()
+			<-done
+			return failed
+		}
+	}
+}
+
+// watchServer follows the number of sockets of the main namespace in the
+// "server_stats" events of the Admin UI namespace, until ctx is done. A
+// server without the namespace is logged once, and left unwatched.
+func (s *soak) watchServer(ctx context.Context) {
+	c, _, err := websocket.Dial(ctx, s.endpoint, nil)
+	if err != nil {
+		s.logger.Warn("admin namespace unavailable", slog.Any("error", err))
+		return
+	}
+	defer c.CloseNow()
+	auth, _ := json.Marshal(map[string]string{"username": s.cfg.AdminUsername, "password": s.cfg.AdminPassword})
+	if _, err := throughput.Read(ctx, c, "0"); err == nil {
+		err = c.Write(ctx, websocket.MessageText, append([]byte("40/admin,"), auth...))
+	}
+	if err == nil {
+		_, err = throughput.Read(ctx, c, "40/admin,")
+	}
+	if err != nil {
+		s.logger.Warn("admin namespace unavailable", slog.Any("error", err))
+		return
+	}
+
+	for {
+		packet, err := throughput.Read(ctx, c, `42/admin,["server_stats",`)
+		if err != nil {
+			return
+		}
+		var event []json.RawMessage
+		var stats struct {
+			Namespaces []struct {
+				Name         string `json:"name"`
+				SocketsCount int    `json:"socketsCount"`
+			} `json:"namespaces"`
+		}
+		if json.Unmarshal([]byte(strings.TrimPrefix(packet, "42/admin,")), &event) != nil || len(event) < 2 || json.Unmarshal(event[1], &stats) != nil {
+			continue
+		}
+		for _, nsp := range stats.Namespaces {
+			if nsp.Name == "/" {
+				s.serverSockets.Store(int64(nsp.SocketsCount))
+			}
+		}
+	}
+}
+
+// ProcessRSS returns the resident set size of the current process, on Linux.
+func ProcessRSS() (int64, error) {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected /proc/self/statm %q", statm)
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
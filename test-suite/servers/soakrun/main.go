@@ -0,0 +1,89 @@
+// Command soakrun keeps many clients connected to a test server for hours,
+// reconnecting some of them every minute, and logs a stats line along the
+// way, to find the slow leaks. With -embedded, it runs the test server in the
+// same process, and also reports its resident set size.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"app/servers/soak"
+	"app/servers/testserver"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:3000"+testserver.DefaultPath, "Socket.IO endpoint of the server, unless -embedded")
+	embedded := flag.Bool("embedded", false, "run the test server in this process, with the Admin UI namespace, and report its RSS")
+	connections := flag.Int("connections", 100, "number of clients")
+	duration := flag.Duration("duration", time.Hour, "how long the soak lasts")
+	rate := flag.Float64("rate", 0.2, "messages per second emitted by each client")
+	churn := flag.Float64("churn", 0.05, "share of the clients reconnected every -churn-interval")
+	churnInterval := flag.Duration("churn-interval", time.Minute, "delay between two reconnections of a share of the clients")
+	statsInterval := flag.Duration("stats-interval", 30*time.Second, "delay between two stats lines")
+	adminUsername := flag.String("admin-username", "", "username of the Admin UI namespace, to log the socket count of the server")
+	adminPassword := flag.String("admin-password", "", "password of the Admin UI namespace")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	cfg := soak.Config{
+		URL:           *url,
+		Connections:   *connections,
+		Duration:      *duration,
+		Rate:          *rate,
+		Churn:         *churn,
+		ChurnInterval: *churnInterval,
+		StatsInterval: *statsInterval,
+		AdminUsername: *adminUsername,
+		AdminPassword: *adminPassword,
+		Logger:        logger,
+	}
+
+	if *embedded {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fail(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+
+		cfg.AdminUsername, cfg.AdminPassword = "soak", rand.Text()
+		server, _, err := testserver.New(addr,
+			testserver.WithAdminUI(cfg.AdminUsername, cfg.AdminPassword, testserver.DefaultAdminStatsInterval),
+			testserver.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})).With(slog.String("server", "embedded"))),
+		)
+		if err != nil {
+			fail(err)
+		}
+		defer server.Close(nil)
+		cfg.URL = "http://" + addr + testserver.DefaultPath
+		cfg.RSS = soak.ProcessRSS
+	}
+
+	// SIGINT ends the soak early, still reporting it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := soak.Run(ctx, cfg)
+	if err != nil {
+		fail(err)
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(report)
+	if len(report.Incidents) > 0 {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
@@ -0,0 +1,118 @@
+// Package stickyproxy is a reverse proxy in front of several Socket.IO
+// servers. Long-polling sends each request of a session separately, and only
+// the server that created the session knows it, so without sticky routing
+// requests fail with "Session ID unknown".
+package stickyproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Mode selects how requests are routed to the backends.
+type Mode int
+
+const (
+	// Sticky routes the requests of a session to the backend that created
+	// it, and handshakes by a hash of the client's IP address.
+	Sticky Mode = iota
+	// RoundRobin routes each request to the next backend, which breaks
+	// long-polling sessions and only serves to demonstrate the failure.
+	RoundRobin
+)
+
+// Proxy routes Engine.IO requests to its backends.
+type Proxy struct {
+	mode     Mode
+	backends []*httputil.ReverseProxy
+	next     atomic.Uint64
+	// sessions maps the sid of each session to the index of its backend. A
+	// session is forgotten once its backend rejects its sid.
+	sessions types.Map[string, int]
+}
+
+// New returns a proxy routing to backends with the given mode.
+func New(mode Mode, backends ...*url.URL) *Proxy {
+	p := &Proxy{mode: mode}
+	for i, backend := range backends {
+		proxy := httputil.NewSingleHostReverseProxy(backend)
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			return p.learn(resp, i)
+		}
+		p.backends = append(p.backends, proxy)
+	}
+	return p
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.backends[p.pick(r)].ServeHTTP(w, r)
+}
+
+// pick returns the index of the backend for r.
+func (p *Proxy) pick(r *http.Request) int {
+	if p.mode == RoundRobin {
+		return int((p.next.Add(1) - 1) % uint64(len(p.backends)))
+	}
+	if sid := r.URL.Query().Get("sid"); sid != "" {
+		if i, ok := p.sessions.Load(sid); ok {
+			return i
+		}
+		// The session is unknown to the proxy, e.g. after a restart, so any
+		// backend is as good as another, provided it is always the same
+		return p.hash(sid)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return p.hash(host)
+}
+
+func (p *Proxy) hash(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.backends)))
+}
+
+// learn records the sid of the sessions created by the backend i from their
+// handshake, and forgets the sessions the backend no longer knows. The sid is
+// picked by the backend, so hashing it could not lead back to the backend.
+func (p *Proxy) learn(resp *http.Response, i int) error {
+	sid := resp.Request.URL.Query().Get("sid")
+	switch {
+	case sid != "":
+		if resp.StatusCode == http.StatusBadRequest {
+			p.sessions.Delete(sid)
+		}
+		return nil
+	case resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "":
+		// Websocket handshakes switch protocols, and keep their backend
+		// for the whole connection anyway
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// The handshake of a polling session is an OPEN packet, 0{"sid":...}
+	var handshake struct {
+		Sid string `json:"sid"`
+	}
+	if len(body) > 1 && body[0] == '0' && json.Unmarshal(body[1:], &handshake) == nil && handshake.Sid != "" {
+		p.sessions.Store(handshake.Sid, i)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package testserver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/engine/v3"
+	"github.com/zishang520/socket.io/servers/engine/v3/transports"
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// AdminNamespace is the namespace the Socket.IO Admin UI connects to.
+const AdminNamespace = "/admin"
+
+// DefaultAdminStatsInterval is the delay between two "server_stats" events,
+// the one of the reference implementation.
+const DefaultAdminStatsInterval = 2 * time.Second
+
+// startedAt approximates the start of the process, for the uptime.
+var startedAt = time.Now()
+
+type adminOptions struct {
+	username      string
+	password      string
+	statsInterval time.Duration
+}
+
+// WithAdminUI registers the AdminNamespace of the Socket.IO Admin UI
+// (@socket.io/admin-ui), so that the hosted dashboard can monitor the server.
+// Clients must send the credentials in their handshake auth, as
+// {"username":...,"password":...}. Connected clients receive "config", then
+// "server_stats" every statsInterval, and can make sockets join or leave
+// rooms, or disconnect them. Besides the events of the Admin UI, they can ask
+// for the last ConnectionErrorHistory requests the server refused with a
+// "connection_errors" event, acknowledged with the list, oldest first, of
+//
+//	{"time":...,"code":1,"reason":"unknown_sid","message":"Session ID unknown",
+//	 "url":...,"transport":"polling","remoteAddr":...,"userAgent":...}
+//
+// the codes and reasons being the ones of logConnectionErrors.
+func WithAdminUI(username, password string, statsInterval time.Duration) Option {
+	return func(o *options) {
+		o.admin = &adminOptions{username: username, password: password, statsInterval: statsInterval}
+	}
+}
+
+// adminFeatures lists the features of the Admin UI the namespace supports.
+var adminFeatures = []string{"JOIN", "LEAVE", "DISCONNECT"}
+
+// adminUI serves the Admin UI namespace of a server.
+type adminUI struct {
+	io     *socket.Server
+	opts   *adminOptions
+	logger *slog.Logger
+	// on registers the handlers of the admins, see SafeHandler
+	on func(client *socket.Socket, event string, fn func(...any))
+	// namespaces holds every namespace of io, by name
+	namespaces types.Map[string, socket.Namespace]
+	// refused lists the requests refused before a connection
+	refused *refusals
+}
+
+// instrument registers the Admin UI namespace on io, and returns it. It must be
+// called before the other namespaces are created, to list them in the stats.
+func instrument(io *socket.Server, o *options, refused *refusals) socket.Namespace {
+	a := &adminUI{io: io, opts: o.admin, logger: o.logger, on: o.on, refused: refused}
+	a.namespaces.Store("/", io.Sockets())
+	_ = io.On("new_namespace", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		if nsp, ok := args[0].(socket.Namespace); ok {
+			a.namespaces.Store(nsp.Name(), nsp)
+		}
+	})
+
+	admin := io.Of(AdminNamespace, nil)
+	admin.Use(a.authenticate)
+	_ = admin.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*socket.Socket)
+		if !ok {
+			return
+		}
+		client.Emit("config", map[string]any{"supportedFeatures": adminFeatures})
+		a.handleFeatures(client)
+		a.on(client, "connection_errors", func(args ...any) {
+			if len(args) > 0 {
+				if ack, ok := args[len(args)-1].(socket.Ack); ok {
+					ack([]any{a.refused.recent()}, nil)
+				}
+			}
+		})
+
+		// Each admin gets its own timer, stopped when it disconnects, which
+		// also happens when the server closes
+		done := make(chan struct{})
+		var once sync.Once
+		client.On("disconnect", func(...any) {
+			once.Do(func() { close(done) })
+		})
+		go func() {
+			ticker := time.NewTicker(a.opts.statsInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					client.Emit("server_stats", a.serverStats())
+				}
+			}
+		}()
+	})
+	return admin
+}
+
+// authenticate checks the credentials of the handshake auth, rejecting the
+// client with "invalid credentials" as the reference implementation does.
+func (a *adminUI) authenticate(client *socket.Socket, next func(*socket.ExtendedError)) {
+	auth := client.Handshake().Auth
+	username, _ := auth["username"].(string)
+	password, _ := auth["password"].(string)
+	if subtle.ConstantTimeCompare([]byte(username), []byte(a.opts.username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(a.opts.password)) != 1 {
+		a.logger.Warn("invalid admin credentials", slog.String("remote_addr", client.Handshake().Address))
+		next(socket.NewExtendedError("invalid credentials", nil))
+		return
+	}
+	next(nil)
+}
+
+// handleFeatures registers the handlers of adminFeatures. Each of them targets
+// the sockets of a namespace matching a filter, a socket id or a room.
+func (a *adminUI) handleFeatures(client *socket.Socket) {
+	a.on(client, "_join", func(args ...any) {
+		// nsp, room, filter
+		if len(args) < 3 {
+			return
+		}
+		room, _ := args[1].(string)
+		if op, ok := a.target(args[0], args[2]); ok && room != "" {
+			op.SocketsJoin(socket.Room(room))
+		}
+	})
+	a.on(client, "_leave", func(args ...any) {
+		// nsp, room, filter
+		if len(args) < 3 {
+			return
+		}
+		room, _ := args[1].(string)
+		if op, ok := a.target(args[0], args[2]); ok && room != "" {
+			op.SocketsLeave(socket.Room(room))
+		}
+	})
+	a.on(client, "_disconnect", func(args ...any) {
+		// nsp, close, filter
+		if len(args) < 3 {
+			return
+		}
+		closeConn, _ := args[1].(bool)
+		if op, ok := a.target(args[0], args[2]); ok {
+			op.DisconnectSockets(closeConn)
+		}
+	})
+}
+
+// target returns the sockets of the existing namespace nsp matching filter.
+func (a *adminUI) target(nsp, filter any) (*socket.BroadcastOperator, bool) {
+	name, _ := nsp.(string)
+	room, _ := filter.(string)
+	namespace, ok := a.namespaces.Load(name)
+	if !ok || room == "" {
+		return nil, false
+	}
+	return namespace.In(socket.Room(room)), true
+}
+
+// serverStats returns the payload of the "server_stats" event.
+func (a *adminUI) serverStats() map[string]any {
+	hostname, _ := os.Hostname()
+
+	var pollingClients int
+	a.io.Engine().Clients().Range(func(_ string, client engine.Socket) bool {
+		if client.Transport().Name() == transports.POLLING {
+			pollingClients++
+		}
+		return true
+	})
+
+	var namespaces []map[string]any
+	a.namespaces.Range(func(name string, nsp socket.Namespace) bool {
+		namespaces = append(namespaces, map[string]any{"name": name, "socketsCount": nsp.Sockets().Len()})
+		return true
+	})
+	slices.SortFunc(namespaces, func(x, y map[string]any) int {
+		return strings.Compare(x["name"].(string), y["name"].(string))
+	})
+
+	return map[string]any{
+		"serverId":            fmt.Sprintf("%s#%d", hostname, os.Getpid()),
+		"hostname":            hostname,
+		"pid":                 os.Getpid(),
+		"uptime":              time.Since(startedAt).Seconds(),
+		"clientsCount":        a.io.Engine().ClientsCount(),
+		"pollingClientsCount": pollingClients,
+		"namespaces":          namespaces,
+	}
+}
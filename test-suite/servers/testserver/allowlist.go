@@ -0,0 +1,73 @@
+package testserver
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// errAddressNotAllowed is the message of the handshakes refused by
+// WithAllowlist.
+var errAddressNotAllowed = errors.New("address not allowed")
+
+// allowlist holds the prefixes of WithAllowlist.
+type allowlist struct {
+	prefixes   []netip.Prefix
+	trustProxy bool
+}
+
+// WithAllowlist refuses the handshakes of the clients whose address is in none
+// of prefixes, every client when prefixes is empty. With trustProxy, the
+// address is the last entry of X-Forwarded-For, the one added by the proxy in
+// front of the server, falling back to the peer address without the header.
+// Without it, the header is ignored, as any client can send it.
+//
+// The check runs in the AllowRequest hook of the engine, before any session
+// exists: no sid is allocated and nothing is held for the refused client. A
+// refused polling handshake gets the engine's 403 answer:
+//
+//	{"code":4,"message":"address not allowed"}
+//
+// while a refused websocket upgrade gets a plain 400 with the same message.
+// A namespace middleware, such as the one of WithAuth, runs much later: the
+// Engine.IO session is open by then, and the middleware can only refuse the
+// namespace, with a CONNECT_ERROR packet sent over that session.
+func WithAllowlist(prefixes []netip.Prefix, trustProxy bool) Option {
+	return func(o *options) {
+		o.allowlist = &allowlist{prefixes: slices.Clone(prefixes), trustProxy: trustProxy}
+	}
+}
+
+// clientAddr returns the address of the client of r.
+func (a *allowlist) clientAddr(r *http.Request) (netip.Addr, error) {
+	if a.trustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			hops := strings.Split(values[len(values)-1], ",")
+			return netip.ParseAddr(strings.TrimSpace(hops[len(hops)-1]))
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return netip.ParseAddr(host)
+}
+
+// check refuses the requests of the clients outside the allowlist, and the
+// ones whose address cannot be parsed.
+func (a *allowlist) check(r *http.Request) error {
+	addr, err := a.clientAddr(r)
+	if err != nil {
+		return errAddressNotAllowed
+	}
+	addr = addr.Unmap()
+	for _, prefix := range a.prefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return errAddressNotAllowed
+}
@@ -0,0 +1,42 @@
+package testserver
+
+import (
+	"log/slog"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// WithAuth requires clients to send, in the handshake auth, a token that
+// tokens maps to a user id. The user id is stored as the socket's data, which
+// the "whoami" event returns. Every connection attempt is logged, and rejected
+// clients receive a CONNECT_ERROR whose data is {"code":"unauthorized"}.
+func WithAuth(tokens map[string]string) Option {
+	return func(o *options) {
+		o.middlewares = append(o.middlewares, o.logAttempt, authenticate(tokens))
+	}
+}
+
+// logAttempt logs every connection attempt, accepted or not, as it runs before
+// the middlewares that may reject it. It is a method so that the logger is the
+// one of WithLogger, whichever order the options are given in.
+func (o *options) logAttempt(client *socket.Socket, next func(*socket.ExtendedError)) {
+	o.logger.Debug("connection attempt",
+		slog.String("namespace", client.Nsp().Name()),
+		slog.String("remote_addr", client.Handshake().Address),
+	)
+	next(nil)
+}
+
+// authenticate resolves the handshake token to a user id.
+func authenticate(tokens map[string]string) socket.NamespaceMiddleware {
+	return func(client *socket.Socket, next func(*socket.ExtendedError)) {
+		token, _ := client.Handshake().Auth["token"].(string)
+		user, ok := tokens[token]
+		if !ok {
+			next(socket.NewExtendedError("unauthorized", map[string]any{"code": "unauthorized"}))
+			return
+		}
+		client.SetData(user)
+		next(nil)
+	}
+}
@@ -0,0 +1,82 @@
+package testserver
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// catchAllControls are the events of countEvents itself, which it doesn't
+// count so that the counters only reflect the other traffic.
+var catchAllControls = []string{"stats", "off-any"}
+
+// countEvents counts the events of the socket with catch-all listeners. The
+// "stats" event replies with {"incoming", "outgoing", "events"}: the number of
+// events received and emitted, and the names of the received ones in order.
+// The "off-any" event removes both listeners, after which the counters stop,
+// and acknowledges it.
+//
+// Incoming listeners get the event name then its arguments, with the
+// acknowledgement callback last when the client expects one, while outgoing
+// listeners get the event name then its arguments, without callback.
+func countEvents(o *options, client *socket.Socket) {
+	var mu sync.Mutex
+	var events []string
+	outgoing := 0
+
+	onIncoming := func(args ...any) {
+		name, _ := args[0].(string)
+		if slices.Contains(catchAllControls, name) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, name)
+	}
+	onOutgoing := func(args ...any) {
+		if o.logger.Enabled(context.Background(), slog.LevelDebug) {
+			name, _ := args[0].(string)
+			o.logger.Debug("event sent",
+				slog.String("sid", string(client.Id())),
+				slog.String("event", name),
+				slog.Any("args", args[1:]),
+			)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		outgoing++
+	}
+	client.OnAny(onIncoming)
+	client.OnAnyOutgoing(onOutgoing)
+
+	o.on(client, "stats", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		ack, ok := args[len(args)-1].(socket.Ack)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		stats := map[string]any{"incoming": len(events), "outgoing": outgoing, "events": slices.Clone(events)}
+		mu.Unlock()
+		ack([]any{stats}, nil)
+	})
+
+	// OffAny compares listeners by their code pointer, so it removes every
+	// listener created by the same function literal, here only this one. The
+	// catch-all listeners run as soon as an event is decoded, before the
+	// previous events are handled, hence the acknowledgement
+	o.on(client, "off-any", func(args ...any) {
+		client.OffAny(onIncoming)
+		client.OffAnyOutgoing(onOutgoing)
+		if len(args) > 0 {
+			if ack, ok := args[len(args)-1].(socket.Ack); ok {
+				ack(nil, nil)
+			}
+		}
+	})
+}
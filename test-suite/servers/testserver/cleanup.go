@@ -0,0 +1,55 @@
+package testserver
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// WithNamespaceCleanup deletes the namespaces created on demand by the
+// regexps of WithNamespaces, such as the children of RoomNamespaces, once
+// their last socket leaves, with the CleanupEmptyChildNamespaces option of the
+// server. Without it, every name a client ever connected to keeps its
+// namespace, which leaks memory when a namespace is created per document or
+// room. The next client connecting to a deleted name creates it again, as
+// new, its rooms included.
+func WithNamespaceCleanup() Option {
+	return func(o *options) { o.namespaceCleanup = true }
+}
+
+// trackChild returns a "connection" handler of a regexp namespace, recording
+// the child namespace each socket connected to until it is deleted.
+func (st *state) trackChild(o *options) func(...any) {
+	return func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*socket.Socket)
+		if !ok {
+			return
+		}
+		nsp := client.Nsp()
+		st.children.LoadOrStore(nsp.Name(), nsp)
+		if !o.namespaceCleanup {
+			return
+		}
+
+		// The server deletes the namespace when the socket is removed from
+		// it, before emitting "disconnect"
+		client.On("disconnect", func(...any) {
+			if nsp.Sockets().Len() == 0 && st.children.CompareAndDelete(nsp.Name(), nsp) {
+				o.logger.Info("namespace deleted", slog.String("namespace", nsp.Name()))
+			}
+		})
+	}
+}
+
+// childNamespaces returns the names of the namespaces created on demand and
+// not deleted since, sorted.
+func (st *state) childNamespaces() []string {
+	// Encoded as an empty array rather than null
+	names := append([]string{}, st.children.Keys()...)
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,95 @@
+package testserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// CloseNamespacePath is the route that closes a namespace, with POST.
+const CloseNamespacePath = "/close-namespace"
+
+// WithNamespaceClosing serves CloseNamespacePath, which closes the namespace
+// of the "namespace" query parameter with CloseNamespace, and responds with
+// the number of sockets it disconnected:
+//
+//	{"namespace":"/custom","disconnected":1}
+func WithNamespaceClosing() Option {
+	return func(o *options) { o.namespaceClosing = true }
+}
+
+// rejectClosed is the first middleware of the namespace name, refusing the
+// sockets once the namespace is closed.
+func (st *state) rejectClosed(name string) socket.NamespaceMiddleware {
+	return func(_ *socket.Socket, next func(*socket.ExtendedError)) {
+		if closed, _ := st.closedNamespaces.Load(name); closed {
+			next(socket.NewExtendedError("namespace closed", map[string]any{"code": "namespace_closed"}))
+			return
+		}
+		next(nil)
+	}
+}
+
+// CloseNamespace closes one of the namespaces given by name to WithNamespaces,
+// while the rest of the server keeps running. The library cannot remove a
+// namespace, so from then on its middleware refuses new sockets with a
+// CONNECT_ERROR carrying {"code":"namespace_closed"}. Each connected socket
+// then receives a "namespace-closing" event, followed by a DISCONNECT packet,
+// and its connection stays open for the other namespaces. It returns the
+// number of sockets disconnected, 0 when the namespace was already closed.
+func CloseNamespace(io *socket.Server, name string) (int, error) {
+	st, ok := states.Load(io)
+	if !ok {
+		return 0, errors.New("testserver: server not built by New or NewServeMux, or shut down")
+	}
+	nsp, ok := st.namespaces.Load(name)
+	if !ok {
+		return 0, fmt.Errorf("testserver: unknown namespace %q", name)
+	}
+	if closed, _ := st.closedNamespaces.Swap(name, true); closed {
+		return 0, nil
+	}
+
+	notice := map[string]any{"namespace": name}
+	disconnected := 0
+	nsp.Sockets().Range(func(_ socket.SocketId, client *socket.Socket) bool {
+		client.Emit("namespace-closing", notice)
+		client.Disconnect(false)
+		disconnected++
+		return true
+	})
+	return disconnected, nil
+}
+
+// namespaceClosing serves CloseNamespacePath, other requests go to handler.
+func namespaceClosing(io *socket.Server, o *options, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != CloseNamespacePath {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Query().Get("namespace")
+		disconnected, err := CloseNamespace(io, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		o.logger.Info("namespace closed",
+			slog.String("namespace", name),
+			slog.Int("disconnected", disconnected),
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"namespace": name, "disconnected": disconnected})
+	})
+}
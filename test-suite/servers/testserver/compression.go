@@ -0,0 +1,26 @@
+package testserver
+
+import "strings"
+
+// bigPayload is the reply to the "big-payload" event, 200KB of repeated text
+// that compresses to a few hundred bytes.
+var bigPayload = strings.Repeat("socket.io compression ", 200*1024/len("socket.io compression "))
+
+// WithCompression configures both compression knobs of the engine with the
+// same positive threshold, in bytes, below which messages are sent as is:
+//
+//   - httpCompression compresses the polling responses, with the first of
+//     gzip, deflate, br and zstd the request accepts. The engine enables it by
+//     default, with a threshold of 1024 bytes.
+//   - perMessageDeflate negotiates the permessage-deflate websocket
+//     extension, and compresses the frames. The engine disables it by
+//     default. The websocket library only supports it without context
+//     takeover, so that no compression window is kept per connection between
+//     messages: each frame costs more CPU, but an idle connection holds no
+//     compression memory.
+//
+// Neither affects what the clients send, which they compress on their own
+// terms.
+func WithCompression(threshold int) Option {
+	return func(o *options) { o.compression = threshold }
+}
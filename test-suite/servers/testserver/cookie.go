@@ -0,0 +1,32 @@
+package testserver
+
+import (
+	"errors"
+	"net/http"
+)
+
+// WithCookie makes the engine set cookie on the responses of polling
+// sessions, the handshake included, for the load balancers that pin the
+// clients of a session to one server with it. Websocket handshakes do not
+// carry it. The engine fills in what cookie leaves unset: the name "io", the
+// path "/", and SameSite=Lax, and always makes it HttpOnly. The cookie carries
+// no value, only its attributes and presence matter.
+//
+// A cross-site page only sends the cookie back with SameSite=None, which
+// browsers only accept along with Secure, so over TLS.
+func WithCookie(cookie *http.Cookie) Option {
+	return func(o *options) { o.cookie = cookie }
+}
+
+// validateCookie rejects the cookies browsers would drop.
+func validateCookie(cookie *http.Cookie) error {
+	if cookie.SameSite == http.SameSiteNoneMode && !cookie.Secure {
+		return errors.New("a cookie with SameSite=None must be Secure")
+	}
+	// The engine names unnamed cookies
+	named := *cookie
+	if named.Name == "" {
+		named.Name = "io"
+	}
+	return named.Valid()
+}
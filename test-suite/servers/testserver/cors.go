@@ -0,0 +1,130 @@
+package testserver
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// originPattern is one of the origins of WithAllowedOrigins, whose host may
+// match any of its subdomains.
+type originPattern struct {
+	scheme   string
+	host     string
+	port     string
+	wildcard bool
+}
+
+// origins holds the patterns of WithAllowedOrigins, or the error of the
+// first invalid one, reported by New.
+type origins struct {
+	patterns []originPattern
+	err      error
+}
+
+// WithAllowedOrigins replaces the wildcard CORS policy of the server with a
+// list of origins, such as "https://app.example.com" or
+// "https://*.example.com", which matches any subdomain of example.com but not
+// example.com itself. Scheme and port must match exactly.
+//
+// The requests to the Socket.IO path from an allowed origin get the CORS
+// headers with credentials, so that browsers send the cookies along, while
+// those from any other origin are answered 403, with no CORS headers:
+//
+//	{"code":4,"message":"origin not allowed"}
+//
+// websocket handshakes included, which browsers send with an Origin but never
+// check against CORS, and logged with the reason cors. The requests without an
+// Origin, from non-browser clients or same-origin pages, are always let
+// through. The types.Cors options of the engine cannot make this per-origin
+// decision: they send the credentials header to every origin, and a literal
+// "false" origin to the refused ones.
+func WithAllowedOrigins(patterns ...string) Option {
+	return func(o *options) {
+		o.origins = &origins{}
+		for _, pattern := range patterns {
+			p, err := parseOriginPattern(pattern)
+			if err != nil {
+				o.origins.err = err
+				return
+			}
+			o.origins.patterns = append(o.origins.patterns, p)
+		}
+	}
+}
+
+// parseOriginPattern parses an origin, whose host may start with "*.".
+func parseOriginPattern(pattern string) (originPattern, error) {
+	u, err := url.Parse(pattern)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return originPattern{}, fmt.Errorf("origin %q must be scheme://host[:port]", pattern)
+	}
+	p := originPattern{scheme: u.Scheme, host: strings.ToLower(u.Hostname()), port: u.Port()}
+	if host, ok := strings.CutPrefix(p.host, "*."); ok {
+		p.host, p.wildcard = host, true
+	}
+	if p.host == "" || strings.Contains(p.host, "*") {
+		return originPattern{}, fmt.Errorf("origin %q may only start with a *. wildcard", pattern)
+	}
+	return p, nil
+}
+
+// allowed reports whether origin, the Origin header of a request, matches one
+// of the patterns.
+func (o *origins) allowed(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Path != "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, p := range o.patterns {
+		if u.Scheme != p.scheme || u.Port() != p.port {
+			continue
+		}
+		if p.wildcard && strings.HasSuffix(host, "."+p.host) || !p.wildcard && host == p.host {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigins applies the CORS policy of WithAllowedOrigins to the
+// requests to the Socket.IO path of io, answering the preflight requests
+// itself, and passes the others to handler. The refusals are recorded in st.
+func allowedOrigins(io *socket.Server, o *options, st *state, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, io.Path()+"/") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if !o.origins.allowed(origin) {
+			st.refused.add(r, 4, "cors", "origin not allowed", slog.String("origin", origin))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"code":4,"message":"origin not allowed"}`)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET,POST")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,491 @@
+package testserver
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// disconnectRecordTTL bounds how long the disconnect reason and rooms of a
+// socket are kept when nobody queries them, so that long soak and load runs
+// do not grow the records without bound.
+const disconnectRecordTTL = time.Minute
+
+// disconnectReasons records why each socket disconnected, keyed by socket id, so
+// that the reason classification can be queried by the test suite. A record is
+// removed once queried, or after disconnectRecordTTL.
+var disconnectReasons types.Map[socket.SocketId, string]
+
+// expire removes the record of id from m after disconnectRecordTTL.
+func expire[V any](m *types.Map[socket.SocketId, V], id socket.SocketId) {
+	time.AfterFunc(disconnectRecordTTL, func() { m.Delete(id) })
+}
+
+// recordDisconnectReason stores the reason of the socket's disconnection.
+func recordDisconnectReason(client *socket.Socket) {
+	client.On("disconnect", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		if reason, ok := args[0].(string); ok {
+			disconnectReasons.Store(client.Id(), reason)
+			expire(&disconnectReasons, client.Id())
+		}
+	})
+}
+
+// disconnectRooms records the rooms each socket was still in when
+// "disconnect" was emitted, keyed by socket id: none, as the socket leaves
+// them all before. Records are removed like those of disconnectReasons.
+var disconnectRooms types.Map[socket.SocketId, []socket.Room]
+
+// announceDeparture emits "member-leaving" with the socket id to the other
+// members of each room of the socket, on "disconnecting", while the socket is
+// still in its rooms. The rooms left on "disconnect" are recorded and logged.
+func announceDeparture(o *options, client *socket.Socket) {
+	client.On("disconnecting", func(...any) {
+		for _, room := range client.Rooms().Keys() {
+			// Every socket is in the room of its own id, which nobody else is in
+			if room == socket.Room(client.Id()) {
+				continue
+			}
+			client.To(room).Emit("member-leaving", map[string]any{"id": client.Id(), "room": room})
+		}
+	})
+	client.On("disconnect", func(...any) {
+		// Encoded as an empty array rather than null
+		rooms := append([]socket.Room{}, client.Rooms().Keys()...)
+		disconnectRooms.Store(client.Id(), rooms)
+		expire(&disconnectRooms, client.Id())
+		o.logger.Debug("rooms on disconnect", slog.String("sid", string(client.Id())), slog.Any("rooms", rooms))
+	})
+}
+
+// kick disconnects the socket and closes the underlying connection once the
+// DISCONNECT packet has been written. Over websocket, Disconnect(true) would
+// close the transport before the packet is written: the engine socket
+// (engine v3.0.1) emits "drain", on which Close closes the transport, as soon
+// as the packets are handed to the transport, while the websocket transport
+// only queues them for its writer goroutine. Polling already appends the
+// close packet to the pending payload.
+func kick(client *socket.Socket) {
+	conn := client.Conn()
+	transport := conn.Transport()
+	if transport.Name() != "websocket" {
+		client.Disconnect(true)
+		return
+	}
+
+	client.Disconnect(false)
+
+	// The transport is writable again, and emits "ready", each time its
+	// writer goroutine has written a payload, the DISCONNECT one included. The
+	// listener is removed once it closed the connection, as "ready" fires on
+	// every later write.
+	var (
+		once      sync.Once
+		closeConn func(...any)
+	)
+	closeConn = func(...any) {
+		if transport.Writable() {
+			once.Do(func() {
+				transport.RemoveListener("ready", closeConn)
+				conn.Close(false)
+			})
+		}
+	}
+	_ = transport.On("ready", closeConn)
+	closeConn()
+}
+
+// echoedHeaders lists the handshake headers sent back by the "headers" event.
+var echoedHeaders = []string{"X-Test-Id", "User-Agent", "X-Forwarded-For"}
+
+// floodCount and floodPayload shape the burst of "seq" events sent in reply to
+// the "flood" event.
+const floodCount = 5000
+
+var floodPayload = strings.Repeat("x", 1000)
+
+// DynamicNamespaces matches the namespaces created on demand, e.g. /dynamic-42.
+var DynamicNamespaces = regexp.MustCompile(`^/dynamic-\d+$`)
+
+// RoomNamespaces matches the namespaces created on demand whose sockets join
+// a room named after the namespace, e.g. /room-lobby and its room
+// "/room-lobby".
+var RoomNamespaces = regexp.MustCompile(`^/room-[a-z0-9]+$`)
+
+// handle registers the test suite's event handlers on io, and tracks the
+// connected sockets in st.
+func handle(io *socket.Server, o *options, st *state) {
+	logOversizeMessages(io, o)
+	logConnectionErrors(io, st.refused)
+	for _, fn := range o.instruments {
+		fn(io)
+	}
+
+	for _, fn := range o.middlewares {
+		io.Use(fn)
+	}
+	for _, fn := range o.nspMiddlewares["/"] {
+		io.Use(fn)
+	}
+
+	_ = io.On("connection", st.track)
+	_ = io.On("connection", logConnection(o.logger))
+	_ = io.On("new_namespace", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		if nsp, ok := args[0].(socket.Namespace); ok {
+			o.logger.Info("namespace created", slog.String("namespace", nsp.Name()))
+		}
+	})
+	if o.admin != nil {
+		admin := instrument(io, o, st.refused)
+		st.admin = admin
+		_ = admin.On("connection", st.track)
+		_ = admin.On("connection", logConnection(o.logger))
+	}
+
+	io.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*socket.Socket)
+		if !ok {
+			return
+		}
+
+		defer client.Emit("auth", client.Handshake().Auth)
+
+		recordDisconnectReason(client)
+		announceDeparture(o, client)
+		middlewareTrace(o, client)
+		countEvents(o, client)
+		if o.recovery != nil {
+			logRecovery(o, client)
+		}
+
+		o.on(client, "message", func(args ...any) {
+			client.Emit("message-back", args...)
+		})
+
+		o.on(client, "message-with-ack", func(args ...any) {
+			if len(args) > 0 {
+				if ack, ok := args[len(args)-1].(socket.Ack); ok {
+					ack(args[:len(args)-1], nil)
+				}
+			}
+		})
+
+		// Echoes its arguments, usually one binary attachment, back at once:
+		// through the acknowledgement when there is one, as a "binary-echo"
+		// event otherwise
+		o.on(client, "binary-echo", func(args ...any) {
+			if len(args) > 0 {
+				if ack, ok := args[len(args)-1].(socket.Ack); ok {
+					ack(args[:len(args)-1], nil)
+					return
+				}
+			}
+			client.Emit("binary-echo", args...)
+		})
+
+		o.on(client, "volatile-ping", func(args ...any) {
+			client.Volatile().Emit("volatile-pong", args...)
+		})
+
+		o.on(client, "broadcast-with-ack", func(args ...any) {
+			timeout := 500 * time.Millisecond
+			if len(args) > 0 {
+				if ms, ok := args[0].(float64); ok {
+					timeout = time.Duration(ms) * time.Millisecond
+				}
+			}
+
+			io.Timeout(timeout).EmitWithAck("broadcast-ack-request")(func(responses []any, err error) {
+				client.Emit("ack-summary", map[string]any{
+					"responses": len(responses),
+					"timedOut":  err != nil,
+				})
+			})
+		})
+
+		o.on(client, "ask-with-timeout", func(...any) {
+			client.Timeout(200 * time.Millisecond).EmitWithAck("ask")(func(args []any, err error) {
+				if err != nil {
+					client.Emit("ask-result", map[string]any{"timedOut": true})
+					return
+				}
+				var value any
+				if len(args) > 0 {
+					value = args[0]
+				}
+				client.Emit("ask-result", map[string]any{"timedOut": false, "value": value})
+			})
+		})
+
+		o.on(client, "join-room", func(args ...any) {
+			for _, arg := range args {
+				if room, ok := arg.(string); ok {
+					client.Join(socket.Room(room))
+				}
+			}
+		})
+
+		o.on(client, "leave-room", func(args ...any) {
+			for _, arg := range args {
+				if room, ok := arg.(string); ok {
+					client.Leave(socket.Room(room))
+				}
+			}
+		})
+
+		o.on(client, "my-rooms", func(...any) {
+			client.Emit("my-rooms", client.Rooms().Keys())
+		})
+
+		// ["room-broadcast", room, event, args...] emits the event to the other
+		// sockets of the room
+		o.on(client, "room-broadcast", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			room, ok := args[0].(string)
+			if !ok {
+				return
+			}
+			if event, ok := args[1].(string); ok {
+				client.To(socket.Room(room)).Emit(event, args[2:]...)
+			}
+		})
+
+		o.on(client, "list-sockets", func(args ...any) {
+			fetch := io.FetchSockets()
+			if len(args) > 0 {
+				if room, ok := args[0].(string); ok {
+					fetch = io.In(socket.Room(room)).FetchSockets()
+				}
+			}
+
+			fetch(func(sockets []*socket.RemoteSocket, err error) {
+				if err != nil {
+					o.logger.Error("failed to fetch sockets", slog.String("sid", string(client.Id())), slog.Any("error", err))
+					// Reply anyway so the requester sees the failure instead of waiting
+					client.Emit("sockets-list", []any{}, err.Error())
+					return
+				}
+				list := make([]map[string]any, 0, len(sockets))
+				for _, s := range sockets {
+					list = append(list, map[string]any{
+						"id":    s.Id(),
+						"rooms": s.Rooms().Keys(),
+					})
+				}
+				client.Emit("sockets-list", list)
+			})
+		})
+
+		o.on(client, "query", func(...any) {
+			client.Emit("query", client.Handshake().Query)
+		})
+
+		o.on(client, "headers", func(...any) {
+			headers := make(map[string]any, len(echoedHeaders))
+			for _, name := range echoedHeaders {
+				if value, ok := client.Handshake().Headers[name]; ok {
+					headers[name] = value
+				}
+			}
+			client.Emit("headers", headers)
+		})
+
+		o.on(client, "address", func(...any) {
+			client.Emit("address", client.Handshake().Address)
+		})
+
+		// The handshake fields without an event of their own. Issued is in
+		// milliseconds since the epoch, although documented in seconds, and
+		// Xdomain is set whenever the request had an Origin header, even one
+		// matching the host
+		o.on(client, "handshake-info", func(...any) {
+			handshake := client.Handshake()
+			client.Emit("handshake-info", map[string]any{
+				"issued":  handshake.Issued,
+				"time":    handshake.Time,
+				"url":     handshake.Url,
+				"secure":  handshake.Secure,
+				"xdomain": handshake.Xdomain,
+				"address": handshake.Address,
+			})
+		})
+
+		o.on(client, "whoami", func(args ...any) {
+			if len(args) > 0 {
+				if ack, ok := args[len(args)-1].(socket.Ack); ok {
+					ack([]any{client.Data()}, nil)
+				}
+			}
+		})
+
+		o.on(client, "dynamic-namespaces", func(args ...any) {
+			if len(args) > 0 {
+				if ack, ok := args[len(args)-1].(socket.Ack); ok {
+					ack([]any{st.childNamespaces()}, nil)
+				}
+			}
+		})
+
+		// ["live-stats", {"sidRooms": true}, ack] acknowledges a snapshot of
+		// the server, see liveStats
+		o.on(client, "live-stats", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			ack, ok := args[len(args)-1].(socket.Ack)
+			if !ok {
+				return
+			}
+			var sidRooms bool
+			if opts, ok := args[0].(map[string]any); ok {
+				sidRooms, _ = opts["sidRooms"].(bool)
+			}
+			ack([]any{st.liveStats(io, sidRooms)}, nil)
+		})
+
+		// Observable in the network tab of the browser, see WithCompression
+		o.on(client, "big-payload", func(...any) {
+			client.Emit("big-payload", bigPayload)
+		})
+
+		o.on(client, "flood", func(...any) {
+			for i := range floodCount {
+				client.Emit("seq", i, floodPayload)
+			}
+		})
+
+		o.on(client, "kick-me", func(...any) {
+			kick(client)
+		})
+
+		// Panics on purpose, to check that SafeHandler keeps the server up
+		o.on(client, "panic-please", func(...any) {
+			panic("panic-please")
+		})
+
+		o.on(client, "kick-soft", func(...any) {
+			client.Disconnect(false)
+			// The socket has left its own room, so this event must not be delivered
+			io.To(socket.Room(client.Id())).Emit("after-kick")
+		})
+
+		o.on(client, "last-disconnect-reason", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			ack, ok := args[len(args)-1].(socket.Ack)
+			if !ok {
+				return
+			}
+			sid, _ := args[0].(string)
+			if reason, ok := disconnectReasons.LoadAndDelete(socket.SocketId(sid)); ok {
+				ack([]any{reason}, nil)
+			} else {
+				ack([]any{nil}, nil)
+			}
+		})
+
+		o.on(client, "last-disconnect-rooms", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			ack, ok := args[len(args)-1].(socket.Ack)
+			if !ok {
+				return
+			}
+			sid, _ := args[0].(string)
+			if rooms, ok := disconnectRooms.LoadAndDelete(socket.SocketId(sid)); ok {
+				ack([]any{rooms}, nil)
+			} else {
+				ack([]any{nil}, nil)
+			}
+		})
+	})
+
+	for _, name := range o.namespaces {
+		nsp := io.Of(name, nil)
+		if name, ok := name.(string); ok {
+			st.namespaces.Store(name, nsp)
+			nsp.Use(st.rejectClosed(name))
+		}
+		for _, fn := range o.middlewares {
+			nsp.Use(fn)
+		}
+		if name, ok := name.(string); ok {
+			for _, fn := range o.nspMiddlewares[name] {
+				nsp.Use(fn)
+			}
+		}
+		_ = nsp.On("connection", st.track)
+		_ = nsp.On("connection", logConnection(o.logger))
+		if _, ok := name.(*regexp.Regexp); ok {
+			_ = nsp.On("connection", st.trackChild(o))
+		}
+		if name == any(RoomNamespaces) {
+			_ = nsp.On("connection", joinNamespaceRoom(o))
+		}
+		_ = nsp.On("connection", onNamespaceConnection(o))
+	}
+}
+
+// joinNamespaceRoom returns a "connection" handler joining the socket to the
+// room named after its namespace, the child of RoomNamespaces it connected to,
+// and listing its rooms on "my-rooms".
+func joinNamespaceRoom(o *options) func(...any) {
+	return func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*socket.Socket)
+		if !ok {
+			return
+		}
+		client.Join(socket.Room(client.Nsp().Name()))
+
+		o.on(client, "my-rooms", func(...any) {
+			client.Emit("my-rooms", client.Rooms().Keys())
+		})
+	}
+}
+
+// onNamespaceConnection returns the "connection" handler registering the
+// handlers shared by the namespaces other than the main one.
+func onNamespaceConnection(o *options) func(...any) {
+	return func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*socket.Socket)
+		if !ok {
+			return
+		}
+		defer client.Emit("auth", client.Handshake().Auth)
+
+		recordDisconnectReason(client)
+		middlewareTrace(o, client)
+
+		o.on(client, "message", func(args ...any) {
+			client.Emit("message-back", args...)
+		})
+
+		o.on(client, "kick-me", func(...any) {
+			kick(client)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package testserver
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Paths of the health endpoints, served next to the Socket.IO handler.
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// health serves HealthzPath, which answers 200 as long as the process serves
+// requests, and ReadyzPath, which answers 503 once Shutdown or Terminate has
+// begun so that load balancers stop routing new clients before the connected
+// ones are drained. Other requests go to handler.
+func health(st *state, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case HealthzPath:
+			fmt.Fprintln(w, "ok")
+		case ReadyzPath:
+			if st.unready.Load() {
+				http.Error(w, "draining", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
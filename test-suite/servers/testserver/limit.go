@@ -0,0 +1,31 @@
+package testserver
+
+import "errors"
+
+// errServerFull is the message of the handshakes refused by
+// WithMaxConnections.
+var errServerFull = errors.New("server is full")
+
+// WithMaxConnections refuses new handshakes while n Engine.IO connections are
+// open, 0 meaning no limit. The sessions already open keep working, and a
+// handshake is accepted again as soon as one of them closes. The check runs in
+// the AllowRequest hook of the engine, so a refused polling handshake gets
+// the engine's 403 answer, with the reason as message:
+//
+//	{"code":4,"message":"server is full"}
+//
+// while a refused websocket upgrade gets a plain 400 with the same message.
+// The count is the one of the engine, which only grows once a handshake
+// completes, so concurrent handshakes may briefly go over the limit.
+func WithMaxConnections(n int) Option {
+	return func(o *options) { o.maxConnections = n }
+}
+
+// checkCapacity refuses new handshakes once the engine holds maxConnections
+// clients.
+func (st *state) checkCapacity() error {
+	if st.maxConnections > 0 && st.io.Engine().ClientsCount() >= uint64(st.maxConnections) {
+		return errServerFull
+	}
+	return nil
+}
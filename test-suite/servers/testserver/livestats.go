@@ -0,0 +1,59 @@
+package testserver
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// liveStats returns the payload of the "live-stats" event, a snapshot of io
+// read from the library rather than from counters of its own:
+//
+//	{"clients":3,"uptime":12.5,"namespaces":[{"name":"/","sockets":2,"rooms":{"lobby":2}},...]}
+//
+// clients is the number of Engine.IO connections, which several namespaces
+// share, and uptime is in seconds. The namespaces are sorted by name: the
+// main one, those of WithNamespaces, the children of its regexps until
+// deleted, and the Admin UI one. Each socket is in a room named after its id,
+// whose rooms are only listed with sidRooms.
+func (st *state) liveStats(io *socket.Server, sidRooms bool) map[string]any {
+	namespaces := []socket.Namespace{io.Sockets()}
+	st.namespaces.Range(func(_ string, nsp socket.Namespace) bool {
+		namespaces = append(namespaces, nsp)
+		return true
+	})
+	st.children.Range(func(_ string, nsp socket.Namespace) bool {
+		namespaces = append(namespaces, nsp)
+		return true
+	})
+	if st.admin != nil {
+		namespaces = append(namespaces, st.admin)
+	}
+	slices.SortFunc(namespaces, func(x, y socket.Namespace) int { return strings.Compare(x.Name(), y.Name()) })
+
+	stats := make([]map[string]any, 0, len(namespaces))
+	for _, nsp := range namespaces {
+		adapter := nsp.Adapter()
+		rooms := map[string]int{}
+		adapter.Rooms().Range(func(room socket.Room, sids *types.Set[socket.SocketId]) bool {
+			if _, ok := adapter.Sids().Load(socket.SocketId(room)); !ok || sidRooms {
+				rooms[string(room)] = sids.Len()
+			}
+			return true
+		})
+		stats = append(stats, map[string]any{
+			"name":    nsp.Name(),
+			"sockets": nsp.Sockets().Len(),
+			"rooms":   rooms,
+		})
+	}
+
+	return map[string]any{
+		"clients":    io.Engine().ClientsCount(),
+		"uptime":     time.Since(startedAt).Seconds(),
+		"namespaces": stats,
+	}
+}
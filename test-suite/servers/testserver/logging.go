@@ -0,0 +1,166 @@
+package testserver
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/engine/v3"
+	"github.com/zishang520/socket.io/servers/engine/v3/transports"
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// WithLogger sets the logger of the application events: every connection,
+// with the Engine.IO protocol revision of its client, transport upgrade and
+// disconnection at info level, every event received at debug level, the
+// errors of the sockets, the requests refused before a connection, see
+// logConnectionErrors, and the payloads over maxHttpBufferSize, see
+// logOversizeMessages, at warn level. It defaults to discarding them. The
+// library's own loggers are separate, see cmd.go for how the server silences
+// them.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// logConnection returns a "connection" handler logging the lifecycle of each
+// socket of a namespace.
+func logConnection(logger *slog.Logger) func(...any) {
+	return func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*socket.Socket)
+		if !ok {
+			return
+		}
+
+		connectedAt := time.Now()
+		logger := logger.With(
+			slog.String("sid", string(client.Id())),
+			slog.String("namespace", client.Nsp().Name()),
+		)
+		logger.Info("connection established",
+			slog.String("transport", client.Conn().Transport().Name()),
+			slog.Int("protocol", client.Conn().Protocol()),
+			slog.String("remote_addr", client.Handshake().Address),
+		)
+
+		// Clients start with polling and may upgrade once, to websocket or
+		// webtransport
+		_ = client.Conn().On("upgrade", func(args ...any) {
+			if len(args) == 0 {
+				return
+			}
+			if transport, ok := args[0].(transports.Transport); ok {
+				logger.Info("transport upgraded", slog.String("transport", transport.Name()))
+			}
+		})
+
+		client.On("disconnect", func(args ...any) {
+			reason := ""
+			if len(args) > 0 {
+				reason, _ = args[0].(string)
+			}
+			logger.Info("disconnected",
+				slog.String("reason", reason),
+				slog.Duration("duration", time.Since(connectedAt)),
+			)
+		})
+
+		client.On("error", func(args ...any) {
+			var err any
+			if len(args) > 0 {
+				err = args[0]
+			}
+			logger.Error("socket error", slog.Any("error", err))
+		})
+
+		client.OnAny(func(args ...any) {
+			if len(args) == 0 || !logger.Enabled(context.Background(), slog.LevelDebug) {
+				return
+			}
+			name, _ := args[0].(string)
+			logger.Debug("event received",
+				slog.String("event", name),
+				slog.Int("bytes", payloadSize(args[1:])),
+			)
+		})
+	}
+}
+
+// payloadSize approximates the size of the arguments of an event with the
+// length of their JSON encoding, leaving out the acknowledgement callback.
+func payloadSize(args []any) int {
+	if len(args) > 0 {
+		if _, ok := args[len(args)-1].(socket.Ack); ok {
+			args = args[:len(args)-1]
+		}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return -1
+	}
+	return len(data)
+}
+
+// logConnectionErrors logs the requests the engine refuses, which clients
+// only see as a 400 or 403, or a closed websocket, with the code and message
+// of the engine, a reason telling apart the failures sharing a code, the
+// request URL and transport, and the details of the engine:
+//
+//	code  reason                     cause
+//	0     unknown_transport          transport missing, unknown or disabled
+//	1     unknown_sid                session closed, or on another server
+//	2     bad_handshake_method       handshake other than a GET
+//	3     invalid_origin             Origin header with invalid characters
+//	3     transport_mismatch         request on another transport than the session
+//	3     transport_handshake_error  websocket handshake without an upgrade
+//	4     unsupported_protocol       EIO missing or other than 4, without WithAllowEIO3
+//	4     forbidden                  refused by max connections, allowlist or drain
+//
+// WithAllowedOrigins refuses the disallowed origins before the engine, and
+// logs them the same way, with code 4 and reason cors. Each of them is also
+// recorded in refused, see refusals.
+func logConnectionErrors(io *socket.Server, refused *refusals) {
+	_ = io.Engine().On("connection_error", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		e, ok := args[0].(*types.ErrorMessage)
+		if !ok || e.CodeMessage == nil || e.Req == nil {
+			return
+		}
+
+		attrs := make([]slog.Attr, 0, len(e.Context))
+		for key, value := range e.Context {
+			if key != "name" {
+				attrs = append(attrs, slog.Any(key, value))
+			}
+		}
+		refused.add(e.Req.Request(), e.Code, connectionErrorReason(e), e.Message, attrs...)
+	})
+}
+
+// connectionErrorReason names the cause of e, telling apart the codes the
+// engine uses for several of them.
+func connectionErrorReason(e *types.ErrorMessage) string {
+	switch e.CodeMessage {
+	case engine.UNKNOWN_TRANSPORT:
+		return "unknown_transport"
+	case engine.UNKNOWN_SID:
+		return "unknown_sid"
+	case engine.BAD_HANDSHAKE_METHOD:
+		return "bad_handshake_method"
+	case engine.UNSUPPORTED_PROTOCOL_VERSION:
+		return "unsupported_protocol"
+	case engine.FORBIDDEN:
+		return "forbidden"
+	}
+	if name, ok := e.Context["name"].(string); ok {
+		return strings.ToLower(name)
+	}
+	return "bad_request"
+}
@@ -0,0 +1,52 @@
+package testserver
+
+import (
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// traces records the labels of the Trace middlewares each socket went
+// through, in order, until its connection closes.
+var traces types.Map[*socket.Socket, []string]
+
+// Trace returns a middleware that appends label to the socket's middleware
+// trace, which the "middleware-trace" event returns.
+func Trace(label string) socket.NamespaceMiddleware {
+	return func(client *socket.Socket, next func(*socket.ExtendedError)) {
+		trace, loaded := traces.Load(client)
+		if !loaded {
+			// Rejected sockets never disconnect, so wait for the connection
+			_ = client.Conn().Once("close", func(...any) {
+				traces.Delete(client)
+			})
+		}
+		traces.Store(client, append(trace, label))
+		next(nil)
+	}
+}
+
+// RequireRole returns a middleware that rejects clients whose handshake auth
+// role is not role with a CONNECT_ERROR whose data is {"code":"forbidden"}.
+func RequireRole(role string) socket.NamespaceMiddleware {
+	return func(client *socket.Socket, next func(*socket.ExtendedError)) {
+		if actual, _ := client.Handshake().Auth["role"].(string); actual != role {
+			next(socket.NewExtendedError("forbidden", map[string]any{"code": "forbidden"}))
+			return
+		}
+		next(nil)
+	}
+}
+
+// middlewareTrace replies to the "middleware-trace" event with the socket's
+// middleware trace.
+func middlewareTrace(o *options, client *socket.Socket) {
+	o.on(client, "middleware-trace", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		if ack, ok := args[len(args)-1].(socket.Ack); ok {
+			trace, _ := traces.Load(client)
+			ack([]any{append([]string{}, trace...)}, nil)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package testserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zishang520/socket.io/parsers/socket/v3/parser"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// MsgpackParser returns a parser that encodes every packet as a MessagePack
+// map {"type", "nsp", "data", "id"} sent in a single binary frame, the format
+// of the JavaScript socket.io-msgpack-parser package. Binary data needs no
+// attachments, MessagePack carries it natively.
+func MsgpackParser() parser.Parser {
+	return msgpackParser{}
+}
+
+// WithParser replaces the default JSON parser of the server, e.g. with
+// MsgpackParser. Clients must use the same parser.
+func WithParser(p parser.Parser) Option {
+	return func(o *options) { o.parser = p }
+}
+
+type msgpackParser struct{}
+
+func (msgpackParser) NewEncoder() parser.Encoder {
+	return msgpackEncoder{}
+}
+
+func (msgpackParser) NewDecoder() parser.Decoder {
+	return &msgpackDecoder{EventEmitter: types.NewEventEmitter()}
+}
+
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) Encode(packet *parser.Packet) []types.BufferInterface {
+	data, err := msgpack.Marshal(packet)
+	if err != nil {
+		// The packets are built by the server from values it sends itself.
+		// The parser is built apart from the server, hence the default logger
+		slog.Error("failed to encode packet", slog.Any("packet", packet), slog.Any("error", err))
+		return nil
+	}
+	return []types.BufferInterface{types.NewBytesBuffer(data)}
+}
+
+type msgpackDecoder struct {
+	types.EventEmitter
+}
+
+// Add decodes a binary frame and emits the packet as "decoded". Text frames
+// are rejected, they can only come from a client using another parser.
+func (d *msgpackDecoder) Add(data any) error {
+	var buf []byte
+	switch typedData := data.(type) {
+	case []byte:
+		buf = typedData
+	case *types.StringBuffer, string:
+		return errors.New("unexpected text frame")
+	case io.Reader:
+		b, err := io.ReadAll(typedData)
+		if err != nil {
+			return err
+		}
+		buf = b
+	default:
+		return fmt.Errorf("unknown type: %T", data)
+	}
+
+	// Maps with string keys decode as map[string]any, as with the JSON parser
+	var packet parser.Packet
+	if err := msgpack.Unmarshal(buf, &packet); err != nil {
+		return fmt.Errorf("decode error: %w", err)
+	}
+	if !isValidMsgpackPacket(&packet) {
+		return errors.New("invalid format")
+	}
+	d.Emit("decoded", &packet)
+	return nil
+}
+
+func (d *msgpackDecoder) Destroy() {}
+
+// isValidMsgpackPacket applies the checks of socket.io-msgpack-parser: a known
+// packet type, and a payload matching it.
+func isValidMsgpackPacket(packet *parser.Packet) bool {
+	switch packet.Type {
+	case parser.CONNECT:
+		_, ok := packet.Data.(map[string]any)
+		return packet.Data == nil || ok
+	case parser.DISCONNECT:
+		return packet.Data == nil
+	case parser.CONNECT_ERROR:
+		switch packet.Data.(type) {
+		case string, map[string]any:
+			return true
+		}
+		return false
+	case parser.EVENT:
+		args, ok := packet.Data.([]any)
+		if !ok || len(args) == 0 {
+			return false
+		}
+		_, ok = args[0].(string)
+		return ok
+	case parser.ACK:
+		_, ok := packet.Data.([]any)
+		return ok && packet.Id != nil
+	default:
+		return false
+	}
+}
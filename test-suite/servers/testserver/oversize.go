@@ -0,0 +1,66 @@
+package testserver
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/zishang520/socket.io/servers/engine/v3"
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// oversizeMessage is the message of the records logged for the payloads over
+// maxHttpBufferSize.
+const oversizeMessage = "payload over maxHttpBufferSize"
+
+// logOversizeMessages logs the websocket connections dropped for a message
+// over maxHttpBufferSize, at warn level. The websocket library checks the
+// length in the header of each frame against the limit, answers a close frame
+// with code 1009, message too big, without reading the message, and closes
+// the connection. The engine socket is then closed with the reason "transport
+// error", which is also the reason of the "disconnect" event of its Socket.IO
+// sockets, and the error of the library as description: it is the only way to
+// tell the limit from the other transport errors.
+func logOversizeMessages(io *socket.Server, o *options) {
+	_ = io.Engine().On("connection", func(conns ...any) {
+		if len(conns) == 0 {
+			return
+		}
+		conn, ok := conns[0].(engine.Socket)
+		if !ok {
+			return
+		}
+		_ = conn.Once("close", func(args ...any) {
+			if len(args) < 2 {
+				return
+			}
+			if err, ok := args[1].(error); !ok || !errors.Is(err, websocket.ErrReadLimit) {
+				return
+			}
+			reason, _ := args[0].(string)
+			o.logger.Warn(oversizeMessage,
+				slog.String("sid", conn.Id()),
+				slog.String("transport", "websocket"),
+				slog.Int("close_code", websocket.CloseMessageTooBig),
+				slog.String("reason", reason),
+				slog.Int64("max_buffer", o.maxHttpBufferSize),
+				slog.String("remote_addr", conn.RemoteAddress()),
+			)
+		})
+	})
+}
+
+// logOversizeBody logs a polling request refused with 413 for a body over
+// maxHttpBufferSize, at warn level. The session stays open, only the packets
+// of the request are dropped. contentLength is -1 for a chunked body.
+func logOversizeBody(logger *slog.Logger, r *http.Request, contentLength, limit int64) {
+	logger.Warn(oversizeMessage,
+		slog.String("sid", r.URL.Query().Get("sid")),
+		slog.String("transport", r.URL.Query().Get("transport")),
+		slog.Int("status", http.StatusRequestEntityTooLarge),
+		slog.Int64("content_length", contentLength),
+		slog.Int64("max_buffer", limit),
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+}
@@ -0,0 +1,55 @@
+package testserver
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// WithDisconnectOnPanic disconnects the client whose event handler panicked,
+// after sending it the "error" event. By default the client stays connected.
+func WithDisconnectOnPanic() Option {
+	return func(o *options) { o.disconnectOnPanic = true }
+}
+
+// SafeHandler wraps fn, the handler of event on client, so that a panic in it
+// does not crash the server. The library runs the handlers on the goroutine
+// reading the connection, without recovering their panics: a panicking
+// handler would take the whole process down.
+//
+// A recovered panic is logged at error level with the socket id, the event
+// name and the stack, and the client receives an "error" event with
+// {"event", "message"}, then is disconnected when disconnect is set. The
+// acknowledgement the handler did not send is never sent.
+func SafeHandler(logger *slog.Logger, client *socket.Socket, event string, disconnect bool, fn func(...any)) func(...any) {
+	return func(args ...any) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			logger.Error("event handler panicked",
+				slog.String("sid", string(client.Id())),
+				slog.String("namespace", client.Nsp().Name()),
+				slog.String("event", event),
+				slog.String("panic", fmt.Sprint(r)),
+				slog.String("stack", string(debug.Stack())),
+			)
+			client.Emit("error", map[string]any{
+				"event":   event,
+				"message": "internal server error",
+			})
+			if disconnect {
+				kick(client)
+			}
+		}()
+		fn(args...)
+	}
+}
+
+// on registers fn as the handler of event on client, through SafeHandler.
+func (o *options) on(client *socket.Socket, event string, fn func(...any)) {
+	client.On(event, SafeHandler(o.logger, client, event, o.disconnectOnPanic, fn))
+}
@@ -0,0 +1,118 @@
+package testserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// PollClientsPath is the route that polls the clients of a room, with POST.
+const PollClientsPath = "/poll-clients"
+
+// WithClientPolling serves PollClientsPath, which broadcasts an "are-you-there"
+// event with io.Timeout(timeout).EmitWithAck to the sockets of the main
+// namespace in the room of the "room" query parameter, or to all of them
+// without it. The response lists the answers and the sockets that timed out:
+//
+//	{"room":"...","responses":[{"sid":"...","payload":...}],"timeouts":["..."]}
+//
+// Broadcast acknowledgements carry no socket id, so clients must answer with
+// their own, as {"sid":"...", ...}. The sockets of the room no answer names
+// are the ones that timed out, and answers naming no socket of the room are
+// dropped.
+func WithClientPolling(timeout time.Duration) Option {
+	return func(o *options) { o.pollTimeout = timeout }
+}
+
+// pollResponse is the answer of a socket to "are-you-there".
+type pollResponse struct {
+	Sid     string `json:"sid"`
+	Payload any    `json:"payload"`
+}
+
+// pollReport summarizes the answers to "are-you-there".
+type pollReport struct {
+	Room      string         `json:"room"`
+	Responses []pollResponse `json:"responses"`
+	Timeouts  []string       `json:"timeouts"`
+}
+
+// pollClients broadcasts "are-you-there" to the sockets of room, or of the
+// whole main namespace when room is empty, and calls done with the report.
+func pollClients(io *socket.Server, room string, timeout time.Duration, done func(*pollReport)) {
+	report := &pollReport{Room: room, Responses: []pollResponse{}, Timeouts: []string{}}
+
+	// The sockets are listed first, to tell which ones did not answer
+	sockets := io.Sockets().Sockets()
+	pending := map[string]bool{}
+	sockets.Range(func(id socket.SocketId, client *socket.Socket) bool {
+		if room == "" || client.Rooms().Has(socket.Room(room)) {
+			pending[string(id)] = true
+		}
+		return true
+	})
+	// Without any socket there is nothing to wait for
+	if len(pending) == 0 {
+		done(report)
+		return
+	}
+
+	target := io.Timeout(timeout)
+	if room != "" {
+		target = target.To(socket.Room(room))
+	}
+	target.EmitWithAck("are-you-there")(func(responses []any, _ error) {
+		// On timeout, responses holds the answers received until then
+		for _, response := range responses {
+			payload, _ := response.(map[string]any)
+			sid, _ := payload["sid"].(string)
+			if !pending[sid] {
+				continue
+			}
+			delete(pending, sid)
+			report.Responses = append(report.Responses, pollResponse{Sid: sid, Payload: response})
+		}
+		for sid := range pending {
+			report.Timeouts = append(report.Timeouts, sid)
+		}
+
+		// The acknowledgements arrive in any order
+		slices.SortFunc(report.Responses, func(x, y pollResponse) int { return strings.Compare(x.Sid, y.Sid) })
+		slices.Sort(report.Timeouts)
+		done(report)
+	})
+}
+
+// clientPolling serves PollClientsPath, other requests go to handler.
+func clientPolling(io *socket.Server, o *options, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != PollClientsPath {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		reports := make(chan *pollReport, 1)
+		pollClients(io, r.URL.Query().Get("room"), o.pollTimeout, func(report *pollReport) {
+			reports <- report
+		})
+		report := <-reports
+		o.logger.Info("clients polled",
+			slog.String("room", report.Room),
+			slog.Int("responses", len(report.Responses)),
+			slog.Any("timeouts", report.Timeouts),
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,39 @@
+package testserver
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// WithConnectionStateRecovery lets clients that reconnect within
+// maxDisconnectionDuration, with the pid of their session and the offset of
+// the last packet they processed, recover their socket id, rooms and missed
+// packets. With skipMiddlewares, recovered sockets bypass the middlewares.
+func WithConnectionStateRecovery(maxDisconnectionDuration time.Duration, skipMiddlewares bool) Option {
+	return func(o *options) {
+		o.recovery = socket.DefaultConnectionStateRecovery()
+		o.recovery.SetMaxDisconnectionDuration(maxDisconnectionDuration.Milliseconds())
+		o.recovery.SetSkipMiddlewares(skipMiddlewares)
+	}
+}
+
+// logRecovery logs whether the socket recovered its session, and replies to
+// the "recovered" event with the same flag.
+func logRecovery(o *options, client *socket.Socket) {
+	o.logger.Info("session recovery",
+		slog.String("sid", string(client.Id())),
+		slog.String("namespace", client.Nsp().Name()),
+		slog.Bool("recovered", client.Recovered()),
+	)
+
+	o.on(client, "recovered", func(args ...any) {
+		if len(args) == 0 {
+			return
+		}
+		if ack, ok := args[len(args)-1].(socket.Ack); ok {
+			ack([]any{client.Recovered()}, nil)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package testserver
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ConnectionErrorHistory is the number of refused requests kept for the
+// "connection_errors" event of the AdminNamespace.
+const ConnectionErrorHistory = 100
+
+// maxAuditedAddrs bounds the addresses WithConnectionErrorAudit counts the
+// refusals of, the expired ones being dropped once it is reached.
+const maxAuditedAddrs = 1024
+
+// WithConnectionErrorObserver calls fn with the code and reason of every
+// request refused before a connection, see logConnectionErrors, e.g. to count
+// them in metrics without the package depending on them. fn runs on the
+// goroutine of the request and must not block.
+func WithConnectionErrorObserver(fn func(code int, reason string)) Option {
+	return func(o *options) { o.refusalObservers = append(o.refusalObservers, fn) }
+}
+
+type auditOptions struct {
+	threshold int
+	window    time.Duration
+}
+
+// WithConnectionErrorAudit logs a "repeated connection errors" record at warn
+// level, with the address, user agent, code and reason of the last refusal,
+// once the requests of an address have been refused threshold times within
+// window, e.g. a misconfigured client retrying in a loop or a scanner. The
+// window starts with the first refusal of the address, so an address refused
+// without pause is logged at most once per window.
+func WithConnectionErrorAudit(threshold int, window time.Duration) Option {
+	return func(o *options) { o.audit = &auditOptions{threshold: threshold, window: window} }
+}
+
+// refusal is a request refused before a connection.
+type refusal struct {
+	time       time.Time
+	code       int
+	reason     string
+	message    string
+	url        string
+	transport  string
+	remoteAddr string
+	userAgent  string
+}
+
+// auditEntry counts the refusals of an address since start.
+type auditEntry struct {
+	start time.Time
+	count int
+}
+
+// refusals logs the requests a server refuses, and keeps the last
+// ConnectionErrorHistory of them.
+type refusals struct {
+	logger    *slog.Logger
+	observers []func(code int, reason string)
+	audit     *auditOptions
+
+	mu sync.Mutex
+	// history is a ring buffer, next being the index of the oldest entry
+	// once it is full
+	history []refusal
+	next    int
+	// audited holds the refusals of each address, by host
+	audited map[string]*auditEntry
+}
+
+func newRefusals(o *options) *refusals {
+	return &refusals{
+		logger:    o.logger,
+		observers: o.refusalObservers,
+		audit:     o.audit,
+		history:   make([]refusal, 0, ConnectionErrorHistory),
+		audited:   map[string]*auditEntry{},
+	}
+}
+
+// add logs the refused request r at warn level, with attrs, and records it.
+func (rf *refusals) add(r *http.Request, code int, reason, message string, attrs ...slog.Attr) {
+	entry := refusal{
+		time:       time.Now(),
+		code:       code,
+		reason:     reason,
+		message:    message,
+		url:        r.URL.String(),
+		transport:  r.URL.Query().Get("transport"),
+		remoteAddr: r.RemoteAddr,
+		userAgent:  r.UserAgent(),
+	}
+	rf.logger.LogAttrs(r.Context(), slog.LevelWarn, "connection error", append([]slog.Attr{
+		slog.Int("code", code),
+		slog.String("reason", reason),
+		slog.String("message", message),
+		slog.String("url", entry.url),
+		slog.String("transport", entry.transport),
+		slog.String("remote_addr", entry.remoteAddr),
+	}, attrs...)...)
+	for _, fn := range rf.observers {
+		fn(code, reason)
+	}
+
+	rf.mu.Lock()
+	if len(rf.history) < cap(rf.history) {
+		rf.history = append(rf.history, entry)
+	} else {
+		rf.history[rf.next] = entry
+		rf.next = (rf.next + 1) % len(rf.history)
+	}
+	count := rf.count(entry)
+	rf.mu.Unlock()
+
+	if count > 0 {
+		rf.logger.LogAttrs(r.Context(), slog.LevelWarn, "repeated connection errors",
+			slog.String("remote_addr", remoteHost(entry.remoteAddr)),
+			slog.String("user_agent", entry.userAgent),
+			slog.Int("count", count),
+			slog.Duration("window", rf.audit.window),
+			slog.Int("code", code),
+			slog.String("reason", reason),
+		)
+	}
+}
+
+// count counts entry against its address for WithConnectionErrorAudit, and
+// returns the count when it reaches the threshold, 0 otherwise. rf.mu must be
+// held.
+func (rf *refusals) count(entry refusal) int {
+	if rf.audit == nil {
+		return 0
+	}
+	addr := remoteHost(entry.remoteAddr)
+	e, ok := rf.audited[addr]
+	if !ok || entry.time.Sub(e.start) >= rf.audit.window {
+		if !ok && len(rf.audited) >= maxAuditedAddrs {
+			for addr, e := range rf.audited {
+				if entry.time.Sub(e.start) >= rf.audit.window {
+					delete(rf.audited, addr)
+				}
+			}
+		}
+		e = &auditEntry{start: entry.time}
+		rf.audited[addr] = e
+	}
+	e.count++
+	if e.count == rf.audit.threshold {
+		return e.count
+	}
+	return 0
+}
+
+// recent returns the recorded refusals, oldest first, as the payload of the
+// "connection_errors" event.
+func (rf *refusals) recent() []map[string]any {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	list := make([]map[string]any, 0, len(rf.history))
+	for _, r := range slices.Concat(rf.history[rf.next:], rf.history[:rf.next]) {
+		list = append(list, map[string]any{
+			"time":       r.time.UTC().Format(time.RFC3339Nano),
+			"code":       r.code,
+			"reason":     r.reason,
+			"message":    r.message,
+			"url":        r.url,
+			"transport":  r.transport,
+			"remoteAddr": r.remoteAddr,
+			"userAgent":  r.userAgent,
+		})
+	}
+	return list
+}
+
+// remoteHost returns the host of addr, or addr when it has no port.
+func remoteHost(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
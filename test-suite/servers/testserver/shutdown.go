@@ -0,0 +1,127 @@
+package testserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// state tracks what Shutdown and Terminate need to drain a test server.
+type state struct {
+	// unready fails ReadyzPath, and draining refuses new handshakes
+	unready    atomic.Bool
+	draining   atomic.Bool
+	drainDelay time.Duration
+	logger     *slog.Logger
+	// types.Map skips zero-size values when ranging, hence bool over struct{}
+	sockets types.Map[*socket.Socket, bool]
+	server  *http.Server
+	// The namespaces CloseNamespace can close, by name, and the closed ones
+	namespaces       types.Map[string, socket.Namespace]
+	closedNamespaces types.Map[string, bool]
+	// The namespaces created on demand, by name, until deleted
+	children types.Map[string, socket.Namespace]
+	// The Admin UI namespace, when enabled
+	admin socket.Namespace
+	// The server whose engine checkCapacity counts the clients of
+	io             *socket.Server
+	maxConnections int
+	allowlist      *allowlist
+	// The requests refused before a connection
+	refused *refusals
+	// Closes the HTTP/3 server of WithWebTransport, if any
+	closeWebTransport func()
+}
+
+// states holds the state of each running server, keyed by the server.
+var states types.Map[*socket.Server, *state]
+
+// allowRequest refuses new handshakes once the server is draining, from the
+// clients outside the allowlist, or while the server is full.
+func (st *state) allowRequest(ctx *types.HttpContext) error {
+	if st.draining.Load() {
+		return errors.New("server is shutting down")
+	}
+	if st.allowlist != nil {
+		if err := st.allowlist.check(ctx.Request()); err != nil {
+			return err
+		}
+	}
+	return st.checkCapacity()
+}
+
+// track records the connected socket until it disconnects.
+func (st *state) track(clients ...any) {
+	if len(clients) == 0 {
+		return
+	}
+	client, ok := clients[0].(*socket.Socket)
+	if !ok {
+		return
+	}
+	st.sockets.Store(client, true)
+	client.On("disconnect", func(...any) {
+		st.sockets.Delete(client)
+	})
+}
+
+// Shutdown drains a server built by New or NewServeMux. New handshakes are
+// refused and ReadyzPath answers 503, then after the delay of WithDrainDelay
+// every socket receives a "server-shutdown" event whose reconnectAfter field
+// hints, in milliseconds, when to reconnect, followed by a DISCONNECT packet.
+// Websocket connections are closed once the packets are flushed, while
+// long-polling clients are expected to close their session after the
+// DISCONNECT, as closing it from the server drops the pending packets. The
+// servers are closed once all connections are gone, or when ctx is done, in
+// which case the context's error is returned.
+func Shutdown(ctx context.Context, io *socket.Server, reconnectAfter time.Duration) error {
+	st, ok := states.LoadAndDelete(io)
+	if !ok {
+		return errors.New("testserver: server not built by New or NewServeMux, or already shut down")
+	}
+	st.unready.Store(true)
+	st.draining.Store(true)
+
+	var err error
+	if st.drainDelay > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(st.drainDelay):
+		}
+	}
+
+	hint := map[string]any{"reconnectAfter": reconnectAfter.Milliseconds()}
+	st.sockets.Range(func(client *socket.Socket, _ bool) bool {
+		client.Emit("server-shutdown", hint)
+		if client.Conn().Transport().Name() == "websocket" {
+			kick(client)
+		} else {
+			client.Disconnect(false)
+		}
+		return true
+	})
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for err == nil && io.Engine().ClientsCount() > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	io.Close(nil)
+	// Already closed along with io, unless built by NewServeMux
+	_ = st.server.Close()
+	st.closeWebTransport()
+	return err
+}
@@ -0,0 +1,98 @@
+package testserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// StatusReportPath is the route that triggers a status report, with POST.
+const StatusReportPath = "/status-report"
+
+// WithStatusReports serves StatusReportPath, which asks every socket of the
+// main namespace for its status with a "get-status" event, and waits up to
+// timeout for each acknowledgement. The summary is logged, broadcast as a
+// "status-report" event, and returned as the response body:
+//
+//	{"responses":[{"sid":"...","status":...}],"timeouts":["..."]}
+//
+// A client without an answer is listed in the timeouts, the others with the
+// first argument of their acknowledgement.
+func WithStatusReports(timeout time.Duration) Option {
+	return func(o *options) { o.statusTimeout = timeout }
+}
+
+// statusResponse is the answer of a socket to "get-status".
+type statusResponse struct {
+	Sid    string `json:"sid" msgpack:"sid"`
+	Status any    `json:"status" msgpack:"status"`
+}
+
+// statusReport summarizes the answers to "get-status".
+type statusReport struct {
+	Responses []statusResponse `json:"responses" msgpack:"responses"`
+	Timeouts  []string         `json:"timeouts" msgpack:"timeouts"`
+}
+
+// collectStatus asks every socket of the main namespace for its status.
+func collectStatus(io *socket.Server, timeout time.Duration) *statusReport {
+	report := &statusReport{Responses: []statusResponse{}, Timeouts: []string{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	io.Sockets().Sockets().Range(func(id socket.SocketId, client *socket.Socket) bool {
+		wg.Add(1)
+		client.Timeout(timeout).EmitWithAck("get-status")(func(args []any, err error) {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Timeouts = append(report.Timeouts, string(id))
+				return
+			}
+			var status any
+			if len(args) > 0 {
+				status = args[0]
+			}
+			report.Responses = append(report.Responses, statusResponse{Sid: string(id), Status: status})
+		})
+		return true
+	})
+	wg.Wait()
+
+	// The acknowledgements arrive in any order
+	slices.SortFunc(report.Responses, func(x, y statusResponse) int { return strings.Compare(x.Sid, y.Sid) })
+	slices.Sort(report.Timeouts)
+	return report
+}
+
+// statusReports serves StatusReportPath, other requests go to handler.
+func statusReports(io *socket.Server, o *options, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != StatusReportPath {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// With no socket, the report is empty and returned right away
+		report := collectStatus(io, o.statusTimeout)
+		o.logger.Info("status report",
+			slog.Int("responses", len(report.Responses)),
+			slog.Any("timeouts", report.Timeouts),
+		)
+		io.Emit("status-report", report)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,108 @@
+package testserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// terminationFlush bounds how long Terminate waits for the DISCONNECT packets
+// of the sockets it disconnects to be flushed.
+const terminationFlush = time.Second
+
+// Terminate shuts down a server built by New or NewServeMux the way a
+// Kubernetes pod terminates, logging each phase at info level:
+//
+//  1. ReadyzPath answers 503 at once, while handshakes are still accepted for
+//     preStopDelay, the time the load balancers take to stop routing new
+//     clients to the server
+//  2. every socket receives a "server-shutdown" event whose seconds field is
+//     gracePeriod, rounded up, the time it has to finish and disconnect, and
+//     new handshakes are refused from then on
+//  3. the sockets still connected once gracePeriod expires are disconnected
+//  4. the server is closed, and its HTTP listener last, so that HealthzPath
+//     answers until the end
+//
+// Unlike Shutdown, which disconnects every socket once the drain delay
+// elapses, the clients are given the grace period to leave on their own. The
+// pod's terminationGracePeriodSeconds must exceed preStopDelay and
+// gracePeriod together, or the process is killed before the end. When ctx is
+// done, the waits are cut short and the context's error is returned.
+func Terminate(ctx context.Context, io *socket.Server, preStopDelay, gracePeriod time.Duration) error {
+	st, ok := states.LoadAndDelete(io)
+	if !ok {
+		return errors.New("testserver: server not built by New or NewServeMux, or already shut down")
+	}
+
+	st.unready.Store(true)
+	st.logger.Info("termination started, not ready",
+		slog.Duration("pre_stop_delay", preStopDelay),
+		slog.Duration("grace_period", gracePeriod),
+	)
+
+	var err error
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-time.After(preStopDelay):
+	}
+	st.logger.Info("pre-stop delay elapsed")
+
+	seconds := int64(math.Ceil(gracePeriod.Seconds()))
+	notified := 0
+	st.sockets.Range(func(client *socket.Socket, _ bool) bool {
+		client.Emit("server-shutdown", map[string]any{"seconds": seconds})
+		notified++
+		return true
+	})
+	st.logger.Info("shutdown notice sent", slog.Int("sockets", notified), slog.Int64("seconds", seconds))
+	st.draining.Store(true)
+	st.logger.Info("new handshakes refused")
+
+	if err == nil {
+		err = waitForClients(ctx, io, gracePeriod)
+	}
+	if remaining := io.Engine().ClientsCount(); remaining > 0 {
+		st.logger.Info("grace period expired, disconnecting the remaining sockets", slog.Uint64("clients", remaining))
+		st.sockets.Range(func(client *socket.Socket, _ bool) bool {
+			kick(client)
+			return true
+		})
+		if e := waitForClients(ctx, io, terminationFlush); err == nil {
+			err = e
+		}
+	} else {
+		st.logger.Info("every socket disconnected")
+	}
+
+	io.Close(nil)
+	st.logger.Info("socket.io server closed")
+	// Already closed along with io, unless built by NewServeMux
+	_ = st.server.Close()
+	st.closeWebTransport()
+	st.logger.Info("http listener closed")
+	return err
+}
+
+// waitForClients waits until the engine of io holds no client, for at most
+// timeout. It only fails when ctx is done.
+func waitForClients(ctx context.Context, io *socket.Server, timeout time.Duration) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	expired := time.After(timeout)
+
+	for io.Engine().ClientsCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-expired:
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
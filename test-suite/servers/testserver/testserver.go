@@ -0,0 +1,456 @@
+// Package testserver builds the Socket.IO server the test suite runs against,
+// so that it can be started from the command line or embedded in a program.
+package testserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zishang520/socket.io/parsers/socket/v3/parser"
+	"github.com/zishang520/socket.io/servers/engine/v3/transports"
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Default values of the options, the ones the test suite expects.
+const (
+	DefaultPingInterval      = 300 * time.Millisecond
+	DefaultPingTimeout       = 200 * time.Millisecond
+	DefaultMaxHttpBufferSize = 1000000
+	DefaultConnectTimeout    = 1000 * time.Millisecond
+	DefaultPath              = "/socket.io/"
+)
+
+type options struct {
+	pingInterval      time.Duration
+	pingTimeout       time.Duration
+	maxHttpBufferSize int64
+	connectTimeout    time.Duration
+	transports        []string
+	path              string
+	namespaces        []any
+	middlewares       []socket.NamespaceMiddleware
+	nspMiddlewares    map[string][]socket.NamespaceMiddleware
+	recovery          *socket.ConnectionStateRecovery
+	parser            parser.Parser
+	admin             *adminOptions
+	instruments       []func(*socket.Server)
+	drainDelay        time.Duration
+	logger            *slog.Logger
+	statusTimeout     time.Duration
+	pollTimeout       time.Duration
+	namespaceClosing  bool
+	unixSocket        *unixSocket
+	maxConnections    int
+	allowlist         *allowlist
+	tls               *tls.Config
+	webTransport      string
+	cookie            *http.Cookie
+	origins           *origins
+	compression       int
+	allowEIO3         bool
+	namespaceCleanup  bool
+	disconnectOnPanic bool
+	refusalObservers  []func(code int, reason string)
+	audit             *auditOptions
+}
+
+// Option configures the server built by New.
+type Option func(*options)
+
+// WithPingInterval sets the Engine.IO ping interval.
+func WithPingInterval(d time.Duration) Option {
+	return func(o *options) { o.pingInterval = d }
+}
+
+// WithPingTimeout sets the Engine.IO ping timeout.
+func WithPingTimeout(d time.Duration) Option {
+	return func(o *options) { o.pingTimeout = d }
+}
+
+// WithMaxHttpBufferSize sets the maximum size of a message or of a polling
+// request body, in bytes. A websocket message over the limit drops the
+// connection with close code 1009, and a polling request over it is refused
+// with 413, both being logged at warn level, see logOversizeMessages.
+func WithMaxHttpBufferSize(n int64) Option {
+	return func(o *options) { o.maxHttpBufferSize = n }
+}
+
+// WithConnectTimeout sets the delay after which a client that has not joined
+// any namespace is closed.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(o *options) { o.connectTimeout = d }
+}
+
+// WithTransports restricts the transports accepted by the server, e.g.
+// "polling" and "websocket".
+func WithTransports(names ...string) Option {
+	return func(o *options) { o.transports = names }
+}
+
+// WithPath sets the path Socket.IO is served at, DefaultPath by default. The
+// trailing slash is optional: like the engine, the server serves the path and
+// everything below it, "/ws" and "/ws/" both serving "/ws/?EIO=4...". Nothing
+// is served at DefaultPath then.
+func WithPath(path string) Option {
+	return func(o *options) { o.path = path }
+}
+
+// WithNamespaces sets the namespaces registered next to the main one, either
+// names or *regexp.Regexp. Each of them echoes the auth payload and "message"
+// events, and supports "kick-me". It defaults to "/custom", DynamicNamespaces
+// and RoomNamespaces.
+func WithNamespaces(names ...any) Option {
+	return func(o *options) { o.namespaces = names }
+}
+
+// WithMiddleware adds a middleware run for every socket connecting to any of
+// the server's namespaces.
+func WithMiddleware(fn socket.NamespaceMiddleware) Option {
+	return func(o *options) { o.middlewares = append(o.middlewares, fn) }
+}
+
+// WithNamespaceMiddleware adds middlewares run, in order, only for the sockets
+// connecting to the namespace name, "/" or one of the names given to
+// WithNamespaces. They run after the ones added by WithMiddleware.
+func WithNamespaceMiddleware(name string, fns ...socket.NamespaceMiddleware) Option {
+	return func(o *options) {
+		if o.nspMiddlewares == nil {
+			o.nspMiddlewares = map[string][]socket.NamespaceMiddleware{}
+		}
+		o.nspMiddlewares[name] = append(o.nspMiddlewares[name], fns...)
+	}
+}
+
+// WithInstrumentation calls fn with the server before its namespaces other
+// than the main one are created, so that fn can observe every "new_namespace"
+// event, e.g. to collect metrics without the package depending on them.
+func WithInstrumentation(fn func(io *socket.Server)) Option {
+	return func(o *options) { o.instruments = append(o.instruments, fn) }
+}
+
+// WithDrainDelay makes Shutdown wait for d after ReadyzPath starts answering
+// 503 and before disconnecting the clients, leaving load balancers time to
+// notice. The wait ends early when the context of Shutdown is done.
+func WithDrainDelay(d time.Duration) Option {
+	return func(o *options) { o.drainDelay = d }
+}
+
+// WithTLS serves HTTPS and WSS with the given configuration.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) { o.tls = config }
+}
+
+// WithAllowEIO3 also accepts the clients of the third revision of the
+// Engine.IO protocol, EIO=3, used by the Socket.IO 2.x clients, which are
+// refused by default. They are connected to the main namespace on handshake,
+// as they expect.
+func WithAllowEIO3() Option {
+	return func(o *options) { o.allowEIO3 = true }
+}
+
+func (o *options) validate() error {
+	switch {
+	case o.pingInterval <= 0:
+		return errors.New("ping interval must be positive")
+	case o.pingTimeout <= 0:
+		return errors.New("ping timeout must be positive")
+	case o.maxHttpBufferSize <= 0:
+		return errors.New("max http buffer size must be positive")
+	case o.connectTimeout <= 0:
+		return errors.New("connect timeout must be positive")
+	case !strings.HasPrefix(o.path, "/") || strings.Trim(o.path, "/") == "":
+		return fmt.Errorf("path %q must start with a slash and not be the root", o.path)
+	case o.drainDelay < 0:
+		return errors.New("drain delay must not be negative")
+	case o.statusTimeout < 0:
+		return errors.New("status timeout must not be negative")
+	case o.pollTimeout < 0:
+		return errors.New("poll timeout must not be negative")
+	case o.compression < 0:
+		return errors.New("compression threshold must not be negative")
+	case o.maxConnections < 0:
+		return errors.New("max connections must not be negative")
+	case o.webTransport != "" && o.tls == nil:
+		return errors.New("webtransport requires TLS")
+	case o.webTransport != "" && o.transports != nil && !slices.Contains(o.transports, "webtransport"):
+		return errors.New("webtransport is not among the transports")
+	case o.recovery != nil && o.recovery.MaxDisconnectionDuration() <= 0:
+		return errors.New("max disconnection duration must be positive")
+	case o.audit != nil && o.audit.threshold <= 0:
+		return errors.New("connection error audit threshold must be positive")
+	case o.audit != nil && o.audit.window <= 0:
+		return errors.New("connection error audit window must be positive")
+	case o.admin != nil && o.admin.username == "":
+		return errors.New("admin username must not be empty")
+	case o.admin != nil && o.admin.statsInterval <= 0:
+		return errors.New("admin stats interval must be positive")
+	case o.admin != nil && slices.Contains(o.namespaces, any(AdminNamespace)):
+		return fmt.Errorf("namespace %s is reserved for the admin UI", AdminNamespace)
+	}
+	if o.origins != nil && o.origins.err != nil {
+		return o.origins.err
+	}
+	if o.cookie != nil {
+		if err := validateCookie(o.cookie); err != nil {
+			return fmt.Errorf("invalid cookie: %w", err)
+		}
+	}
+	for name := range o.nspMiddlewares {
+		if name != "/" && !slices.Contains(o.namespaces, any(name)) {
+			return fmt.Errorf("middleware for unknown namespace %q", name)
+		}
+	}
+	return nil
+}
+
+func (o *options) serverOptions() (*socket.ServerOptions, error) {
+	config := socket.DefaultServerOptions()
+	config.SetPingInterval(o.pingInterval)
+	config.SetPingTimeout(o.pingTimeout)
+	config.SetMaxHttpBufferSize(o.maxHttpBufferSize)
+	config.SetConnectTimeout(o.connectTimeout)
+	config.SetAllowEIO3(o.allowEIO3)
+	config.SetCleanupEmptyChildNamespaces(o.namespaceCleanup)
+	// The engine adds the trailing slash back when routing
+	config.SetPath(strings.TrimRight(o.path, "/"))
+	if o.origins == nil {
+		config.SetCors(&types.Cors{
+			Origin: "*",
+		})
+	} else {
+		// allowedOrigins applies the policy in place of the engine
+		config.SetCors(nil)
+	}
+	if o.recovery != nil {
+		config.SetConnectionStateRecovery(o.recovery)
+	}
+	if o.parser != nil {
+		config.SetParser(o.parser)
+	}
+	if o.compression > 0 {
+		config.SetHttpCompression(&types.HttpCompression{Threshold: o.compression})
+		config.SetPerMessageDeflate(&types.PerMessageDeflate{Threshold: o.compression})
+	}
+	if o.cookie != nil {
+		// The engine fills in the defaults of the cookie it is given
+		cookie := *o.cookie
+		config.SetCookie(&cookie)
+	}
+
+	transportNames := o.transports
+	if transportNames == nil && o.webTransport != "" {
+		transportNames = []string{"polling", "websocket", "webtransport"}
+	}
+	if transportNames != nil {
+		set := types.NewSet[transports.TransportCtor]()
+		for _, name := range transportNames {
+			ctor, ok := transports.Transports()[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown transport %q", name)
+			}
+			set.Add(ctor)
+		}
+		config.SetTransports(set)
+	}
+	return config, nil
+}
+
+// New starts a test server listening on addr and returns it along with the
+// underlying HTTP server. Closing the Socket.IO server also stops the
+// listener, Shutdown does so after draining the connected clients.
+func New(addr string, opts ...Option) (*socket.Server, *types.HttpServer, error) {
+	o, config, err := newOptions(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	listeners, packetConn, err := listen(addr, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st := &state{drainDelay: o.drainDelay, maxConnections: o.maxConnections, allowlist: o.allowlist, logger: o.logger, refused: newRefusals(o)}
+	config.SetAllowRequest(st.allowRequest)
+
+	httpServer := types.NewWebServer(nil)
+	io := socket.NewServer(httpServer, config)
+	st.io = io
+	handle(io, o, st)
+
+	// The engine is closed by then, and a long-polling request it left
+	// unanswered would block a graceful http.Server shutdown forever
+	st.server = &http.Server{Handler: routes(io, o, st, limitBody(httpServer, o.maxHttpBufferSize, o.logger))}
+	_ = httpServer.On("close", func(...any) {
+		_ = st.server.Close()
+		st.closeWebTransport()
+	})
+	serve(io, o, st, listeners, packetConn)
+
+	return io, httpServer, nil
+}
+
+// NewServeMux starts the same test server as New, but mounts the Socket.IO
+// handler at its path, DefaultPath unless WithPath is given, on a plain
+// http.ServeMux, next to a /hello route, the way an application with its own
+// routes would. Closing the Socket.IO server does not stop the returned
+// http.Server, Shutdown stops both.
+func NewServeMux(addr string, opts ...Option) (*socket.Server, *http.Server, error) {
+	o, config, err := newOptions(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	listeners, packetConn, err := listen(addr, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st := &state{drainDelay: o.drainDelay, maxConnections: o.maxConnections, allowlist: o.allowlist, logger: o.logger, refused: newRefusals(o)}
+	config.SetAllowRequest(st.allowRequest)
+
+	io := socket.NewServer(nil, config)
+	st.io = io
+	mux := http.NewServeMux()
+	// ServeHandler creates the engine, which handle binds to, and handles the
+	// websocket upgrades itself since the mux passes the ResponseWriter through
+	mux.Handle(io.Path()+"/", io.ServeHandler(nil))
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "hello")
+	})
+	handle(io, o, st)
+
+	st.server = &http.Server{Handler: routes(io, o, st, limitBody(mux, o.maxHttpBufferSize, o.logger))}
+	serve(io, o, st, listeners, packetConn)
+
+	return io, st.server, nil
+}
+
+// newOptions applies opts over the defaults the test suite expects.
+func newOptions(opts []Option) (*options, *socket.ServerOptions, error) {
+	o := &options{
+		pingInterval:      DefaultPingInterval,
+		pingTimeout:       DefaultPingTimeout,
+		maxHttpBufferSize: DefaultMaxHttpBufferSize,
+		connectTimeout:    DefaultConnectTimeout,
+		path:              DefaultPath,
+		namespaces:        []any{"/custom", DynamicNamespaces, RoomNamespaces},
+		logger:            slog.New(slog.DiscardHandler),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, nil, err
+	}
+	config, err := o.serverOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	return o, config, nil
+}
+
+// listen opens the listener on addr, and on the unix socket when configured,
+// over TLS when configured, along with the UDP socket of WithWebTransport.
+// Listening before serving reports errors such as an address in use to the
+// caller.
+func listen(addr string, o *options) ([]net.Listener, net.PacketConn, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	listeners := []net.Listener{listener}
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+	if o.unixSocket != nil {
+		listener, err := o.unixSocket.listen()
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	packetConn, err := o.listenWebTransport()
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	if o.tls != nil {
+		for i, listener := range listeners {
+			listeners[i] = tls.NewListener(listener, o.tls)
+		}
+	}
+	return listeners, packetConn, nil
+}
+
+// serve registers the state of io for Shutdown and serves st.server on every
+// listener, all of which closing st.server closes, and over HTTP/3 on
+// packetConn when not nil.
+func serve(io *socket.Server, o *options, st *state, listeners []net.Listener, packetConn net.PacketConn) {
+	st.closeWebTransport = func() {}
+	if packetConn != nil {
+		st.closeWebTransport = serveWebTransport(io, o, packetConn, st.server.Handler)
+	}
+	states.Store(io, st)
+	for _, listener := range listeners {
+		go func() {
+			if err := st.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				panic(err)
+			}
+		}()
+	}
+}
+
+// routes serves the routes enabled by the options next to handler.
+func routes(io *socket.Server, o *options, st *state, handler http.Handler) http.Handler {
+	if o.statusTimeout > 0 {
+		handler = statusReports(io, o, handler)
+	}
+	if o.pollTimeout > 0 {
+		handler = clientPolling(io, o, handler)
+	}
+	if o.namespaceClosing {
+		handler = namespaceClosing(io, o, handler)
+	}
+	if o.origins != nil {
+		handler = allowedOrigins(io, o, st, handler)
+	}
+	return health(st, handler)
+}
+
+// limitBody rejects request bodies of unknown length that exceed limit. The
+// engine only checks the Content-Length header, and otherwise truncates the
+// body and processes the packets that fit. The bodies over the limit are
+// logged, see logOversizeBody, those with a Content-Length being refused by
+// the engine when they carry the packets of a polling session.
+func limitBody(handler http.Handler, limit int64, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength < 0 {
+			body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > limit {
+				logOversizeBody(logger, r, -1, limit)
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+		} else if r.ContentLength > limit && r.Method == http.MethodPost && r.URL.Query().Has("sid") {
+			logOversizeBody(logger, r, r.ContentLength, limit)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,57 @@
+package testserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"time"
+)
+
+// unixSocket holds the socket of WithUnixSocket.
+type unixSocket struct {
+	path string
+	mode os.FileMode
+}
+
+// WithUnixSocket also serves the server on a unix domain socket at path, with
+// the permissions mode, e.g. for a proxy on the same host. A stale socket left
+// at path by a crashed server is removed first, while a socket a server still
+// listens on, or any other kind of file, makes New fail. The socket file is
+// removed when the server is closed or shut down.
+func WithUnixSocket(path string, mode os.FileMode) Option {
+	return func(o *options) { o.unixSocket = &unixSocket{path: path, mode: mode} }
+}
+
+// listen removes a stale socket at the path, then listens on the socket.
+func (u *unixSocket) listen() (net.Listener, error) {
+	info, err := os.Lstat(u.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case info.Mode().Type() != fs.ModeSocket:
+		return nil, fmt.Errorf("%s exists and is not a socket", u.path)
+	default:
+		// Nobody answering means that nobody listens on the socket anymore
+		if conn, err := net.DialTimeout("unix", u.path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", u.path)
+		}
+		if err := os.Remove(u.path); err != nil {
+			return nil, err
+		}
+	}
+
+	// The listener removes the socket file when closed
+	listener, err := net.Listen("unix", u.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(u.path, u.mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,70 @@
+package testserver
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"github.com/zishang520/socket.io/servers/engine/v3"
+	"github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// WithWebTransport also serves the server over HTTP/3 on the UDP address addr,
+// with the certificate of WithTLS, and enables the WebTransport transport,
+// which the handshakes of polling clients then advertise next to websocket.
+// Browsers connect to WebTransport on the port of the page's URL, so addr
+// usually has the port of the TCP address. The polling and websocket
+// transports are left unchanged.
+func WithWebTransport(addr string) Option {
+	return func(o *options) { o.webTransport = addr }
+}
+
+// listenWebTransport opens the UDP socket of WithWebTransport, or returns nil
+// when it is disabled.
+func (o *options) listenWebTransport() (net.PacketConn, error) {
+	if o.webTransport == "" {
+		return nil, nil
+	}
+	return net.ListenPacket("udp", o.webTransport)
+}
+
+// serveWebTransport serves handler over HTTP/3 on conn, except for the
+// WebTransport sessions opened at the path of io, which its engine takes
+// over. The returned function closes the sessions and conn, once
+// however many times it is called.
+func serveWebTransport(io *socket.Server, o *options, conn net.PacketConn, handler http.Handler) func() {
+	server := &webtransport.Server{
+		H3: &http3.Server{TLSConfig: http3.ConfigureTLSConfig(o.tls)},
+		// Like the CORS policy of the other transports
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	webtransport.ConfigureHTTP3Server(server.H3)
+
+	server.H3.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Proto != "webtransport" || !strings.HasPrefix(r.URL.Path, io.Path()+"/") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		// The engine is created when the server is attached or its handler
+		// built, and only its concrete type handles WebTransport sessions
+		eio, ok := io.Engine().(engine.Server)
+		if !ok {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		eio.OnWebTransportSession(types.NewHttpContext(w, r), server)
+	})
+
+	// Serve only returns once closed, the configuration being validated
+	go func() { _ = server.Serve(conn) }()
+
+	// The QUIC listener leaves the connection it was given open
+	return sync.OnceFunc(func() {
+		_ = server.Close()
+		_ = conn.Close()
+	})
+}
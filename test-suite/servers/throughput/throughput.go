@@ -0,0 +1,237 @@
+// Package throughput measures how fast a Socket.IO server echoes binary
+// attachments over websocket, through the "binary-echo" event of the test
+// server, to size maxHttpBufferSize and the chunks of large transfers.
+package throughput
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Config describes a run: Count round trips of a Size bytes attachment, spread
+// over Concurrency connections.
+type Config struct {
+	// URL is the Socket.IO endpoint, e.g. http://localhost:3000/socket.io/.
+	URL         string
+	Size        int
+	Count       int
+	Concurrency int
+}
+
+// Result is the outcome of a run. MBPerSec counts the attachment once per
+// round trip, in megabytes of 10^6 bytes.
+type Result struct {
+	Size             int     `json:"size"`
+	Count            int     `json:"count"`
+	Concurrency      int     `json:"concurrency"`
+	Seconds          float64 `json:"seconds"`
+	MBPerSec         float64 `json:"mb_per_sec"`
+	RoundTripsPerSec float64 `json:"round_trips_per_sec"`
+}
+
+// ParseSize parses a size in bytes, with an optional KB or MB suffix of 1024
+// and 1024*1024 bytes, e.g. 64KB.
+func ParseSize(s string) (int, error) {
+	multiplier := 1
+	number := strings.ToUpper(strings.TrimSpace(s))
+	if n, ok := strings.CutSuffix(number, "KB"); ok {
+		number, multiplier = n, 1<<10
+	} else if n, ok := strings.CutSuffix(number, "MB"); ok {
+		number, multiplier = n, 1<<20
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// Run connects Concurrency websocket clients to cfg.URL, then sends the
+// attachment back and forth Count times in total, each client waiting for
+// its echo before sending the next one. Only the round trips are timed, not
+// the handshakes.
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	if cfg.Size <= 0 || cfg.Count <= 0 || cfg.Concurrency <= 0 {
+		return Result{}, errors.New("size, count and concurrency must be positive")
+	}
+	endpoint, err := WebsocketURL(cfg.URL)
+	if err != nil {
+		return Result{}, err
+	}
+
+	conns := make([]*websocket.Conn, 0, cfg.Concurrency)
+	defer func() {
+		for _, c := range conns {
+			c.CloseNow()
+		}
+	}()
+	for range cfg.Concurrency {
+		c, err := Connect(ctx, endpoint, cfg.Size)
+		if err != nil {
+			return Result{}, err
+		}
+		conns = append(conns, c)
+	}
+
+	payload := make([]byte, cfg.Size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		errs  = make([]error, len(conns))
+		start = time.Now()
+	)
+	for i, c := range conns {
+		// The round trips are shared out, the first clients taking the
+		// remainder
+		count := cfg.Count / len(conns)
+		if i < cfg.Count%len(conns) {
+			count++
+		}
+		wg.Go(func() {
+			for id := range count {
+				if err := echo(ctx, c, id, payload); err != nil {
+					errs[i] = fmt.Errorf("round trip %d: %w", id, err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+	if err := errors.Join(errs...); err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Size:             cfg.Size,
+		Count:            cfg.Count,
+		Concurrency:      cfg.Concurrency,
+		Seconds:          elapsed,
+		MBPerSec:         float64(cfg.Size) * float64(cfg.Count) / elapsed / 1e6,
+		RoundTripsPerSec: float64(cfg.Count) / elapsed,
+	}, nil
+}
+
+// WebsocketURL returns the websocket URL of the Engine.IO endpoint at raw.
+func WebsocketURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported scheme in %q", raw)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawQuery = "EIO=4&transport=websocket"
+	return u.String(), nil
+}
+
+// Connect opens an Engine.IO session over websocket and connects it to the
+// main namespace, accepting messages of size bytes.
+func Connect(ctx context.Context, endpoint string, size int) (*websocket.Conn, error) {
+	c, _, err := websocket.Dial(ctx, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The text packets, such as the handshake, stay well below 32KB
+	c.SetReadLimit(int64(max(size, 32<<10)))
+
+	if _, err := Read(ctx, c, "0"); err != nil {
+		c.CloseNow()
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+		c.CloseNow()
+		return nil, err
+	}
+	if _, err := Read(ctx, c, "40"); err != nil {
+		c.CloseNow()
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	return c, nil
+}
+
+// echo sends payload with the acknowledgement id, and checks the attachment
+// it is acknowledged with.
+func echo(ctx context.Context, c *websocket.Conn, id int, payload []byte) error {
+	packet := fmt.Sprintf(`451-%d["binary-echo",{"_placeholder":true,"num":0}]`, id)
+	if err := c.Write(ctx, websocket.MessageText, []byte(packet)); err != nil {
+		return closed(err)
+	}
+	if err := c.Write(ctx, websocket.MessageBinary, payload); err != nil {
+		return closed(err)
+	}
+
+	if _, err := Read(ctx, c, fmt.Sprintf("461-%d[", id)); err != nil {
+		return err
+	}
+	typ, data, err := c.Read(ctx)
+	if err != nil {
+		return closed(err)
+	}
+	if typ != websocket.MessageBinary || !bytes.Equal(data, payload) {
+		return errors.New("echoed attachment differs from the one sent")
+	}
+	return nil
+}
+
+// Read returns the first text packet starting with prefix, answering the
+// pings and skipping the other packets, such as the "auth" event sent on
+// connection.
+func Read(ctx context.Context, c *websocket.Conn, prefix string) (string, error) {
+	for {
+		typ, data, err := c.Read(ctx)
+		if err != nil {
+			return "", closed(err)
+		}
+		if typ != websocket.MessageText {
+			continue
+		}
+		packet := string(data)
+		switch {
+		case strings.HasPrefix(packet, prefix):
+			return packet, nil
+		case packet == "2":
+			if err := c.Write(ctx, websocket.MessageText, []byte("3")); err != nil {
+				return "", err
+			}
+		case strings.HasPrefix(packet, "44"):
+			return "", fmt.Errorf("connection refused: %s", packet[2:])
+		}
+	}
+}
+
+// closed explains the usual reason the server closes the connection in the
+// middle of a run: with a 1009 close frame, or a reset when the attachment is
+// still being written.
+func closed(err error) error {
+	status := websocket.CloseStatus(err)
+	if status != -1 && status != websocket.StatusMessageTooBig || ctxErr(err) {
+		return err
+	}
+	return fmt.Errorf("%w (an attachment above the maxHttpBufferSize of the server closes the connection)", err)
+}
+
+// ctxErr reports whether err comes from the context of the run.
+func ctxErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
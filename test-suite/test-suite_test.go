@@ -1,60 +1,157 @@
 package test_suite
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"app/internal/eiop"
+	"app/servers/bufferlimit"
+	"app/servers/healthcheck"
+	"app/servers/latency"
+	"app/servers/load"
+	"app/servers/metrics"
+	"app/servers/soak"
+	"app/servers/stickyproxy"
+	"app/servers/testserver"
+	"app/servers/throughput"
+
 	"github.com/coder/websocket"
+	"github.com/zishang520/socket.io/servers/socket/v3"
+)
+
+// URL and WS_URL point at the server under test. Tests running an embedded
+// server point them at it with useServer.
+var (
+	URL    = "http://localhost:3000"
+	WS_URL = "ws://localhost:3000"
 )
 
 const (
-	URL           = "http://localhost:3000"
-	WS_URL        = "ws://localhost:3000"
 	PING_INTERVAL = 300
 	PING_TIMEOUT  = 200
+
+	// The small-buffer server runs with a 10KB maxHttpBufferSize
+	SMALL_BUFFER_URL  = "http://localhost:3001"
+	SMALL_BUFFER_SIZE = 10000
 )
 
-func waitFor(ctx context.Context, c *websocket.Conn) (string, error) {
-	_, data, err := c.Read(ctx)
+// waitForPacket reads the next frame from the websocket and decodes its
+// Engine.IO packet.
+func waitForPacket(ctx context.Context, c *websocket.Conn) (eiop.Packet, error) {
+	typ, data, err := c.Read(ctx)
 	if err != nil {
-		return "", err
+		return eiop.Packet{}, err
 	}
-	return string(data), nil
+	return eiop.Decode(data, typ == websocket.MessageBinary)
 }
 
 func waitForPackets(ctx context.Context, c *websocket.Conn, count int) ([]any, error) {
 	packets := make([]any, 0, count)
 
 	for len(packets) < count {
-		msgType, data, err := c.Read(ctx)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			return nil, err
 		}
 
-		if msgType == websocket.MessageText && string(data) == "2" {
+		if p.Type == eiop.Ping {
 			// ignore PING packets
 			continue
 		}
 
-		if msgType == websocket.MessageBinary {
-			packets = append(packets, data)
+		if p.IsBinary {
+			packets = append(packets, p.Data)
 		} else {
-			packets = append(packets, string(data))
+			packets = append(packets, p.String())
 		}
 	}
 
 	return packets, nil
 }
 
+// decodeOpen decodes the handshake of the Engine.IO OPEN packet in frame, a
+// websocket frame or a polling payload of that single packet, into v.
+func decodeOpen(t *testing.T, frame []byte, v any) {
+	t.Helper()
+
+	p, err := eiop.Decode(frame, false)
+	if err != nil || p.Type != eiop.Open {
+		t.Fatalf("expected an OPEN packet, got %q (%v)", frame, err)
+	}
+	if err := json.Unmarshal(p.Data, v); err != nil {
+		t.Fatalf("invalid handshake %q: %v", frame, err)
+	}
+}
+
 func initLongPollingSession(t *testing.T) string {
-	resp, err := http.Get(URL + "/socket.io/?EIO=4&transport=polling")
+	return initLongPollingSessionWith(t, "")
+}
+
+// initLongPollingSessionWithHeader opens a polling session whose handshake
+// request carries the given header, and returns its Engine.IO sid.
+func initLongPollingSessionWithHeader(t *testing.T, header http.Header) string {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, URL+"/socket.io/?EIO=4&transport=polling", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handshake struct {
+		Sid string `json:"sid"`
+	}
+	decodeOpen(t, body, &handshake)
+	return handshake.Sid
+}
+
+// initLongPollingSessionWith opens a polling session, appending query to the
+// handshake URL, and returns its Engine.IO sid.
+func initLongPollingSessionWith(t *testing.T, query string) string {
+	resp, err := http.Get(URL + "/socket.io/?EIO=4&transport=polling" + query)
 	if err != nil {
 		t.Fatalf("http get: %v", err)
 	}
@@ -65,14 +162,8 @@ func initLongPollingSession(t *testing.T) string {
 		t.Fatalf("read body: %v", err)
 	}
 
-	content := string(body)
-	if len(content) < 2 {
-		t.Fatalf("unexpected response: %q", content)
-	}
 	var val map[string]any
-	if err := json.Unmarshal([]byte(content[1:]), &val); err != nil {
-		t.Fatalf("json unmarshal: %v", err)
-	}
+	decodeOpen(t, body, &val)
 
 	sid, ok := val["sid"].(string)
 	if !ok {
@@ -81,20 +172,277 @@ func initLongPollingSession(t *testing.T) string {
 	return sid
 }
 
+func pollingURL(sid string) string {
+	return fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid)
+}
+
+// poll issues a single GET on the polling session and decodes the payload
+// into its Engine.IO packets.
+func poll(t *testing.T, sid string) []eiop.Packet {
+	t.Helper()
+
+	resp, err := http.Get(pollingURL(sid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200 for poll, got %d (body: %s)", resp.StatusCode, string(body))
+	}
+
+	packets, err := eiop.DecodePayload(body)
+	if err != nil {
+		t.Fatalf("invalid payload %q: %v", body, err)
+	}
+	return packets
+}
+
+// push sends the given Engine.IO packets in a single POST.
+func push(t *testing.T, sid string, packets ...string) {
+	t.Helper()
+
+	resp, err := http.Post(pollingURL(sid), "text/plain", strings.NewReader(strings.Join(packets, string(eiop.Separator))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 200 for push, got %d (body: %s)", resp.StatusCode, string(body))
+	}
+}
+
+// initLongPollingSocketIOSession opens a polling session and connects it to
+// the main namespace, consuming the CONNECT and "auth" packets. It returns the
+// Engine.IO sid and the Socket.IO sid.
+func initLongPollingSocketIOSession(t *testing.T) (string, string) {
+	t.Helper()
+
+	sid := initLongPollingSession(t)
+	return sid, connectLongPollingSession(t, sid)
+}
+
+// connectLongPollingSession connects an open polling session to the main
+// namespace, consuming the CONNECT and "auth" packets. It returns the
+// Socket.IO sid.
+func connectLongPollingSession(t *testing.T, sid string) string {
+	t.Helper()
+
+	push(t, sid, "40")
+
+	var packets []eiop.Packet
+	for len(packets) < 2 {
+		for _, packet := range poll(t, sid) {
+			if packet.Type == eiop.Ping {
+				push(t, sid, "3")
+				continue
+			}
+			packets = append(packets, packet)
+		}
+	}
+
+	if packets[0].Type != eiop.Message || !bytes.HasPrefix(packets[0].Data, []byte("0")) {
+		t.Fatalf("expected socket.io handshake, got %s", packets[0])
+	}
+	if packets[1].Type != eiop.Message || !bytes.HasPrefix(packets[1].Data, []byte(`2["auth",`)) {
+		t.Fatalf("expected auth packet, got %s", packets[1])
+	}
+
+	var handshake map[string]any
+	if err := json.Unmarshal(packets[0].Data[1:], &handshake); err != nil {
+		t.Fatalf("invalid socket.io handshake %q: %v", packets[0], err)
+	}
+	socketSid, _ := handshake["sid"].(string)
+
+	return socketSid
+}
+
+// decodeEvent returns the arguments of a plain-text EVENT packet on the main
+// namespace if it carries the given event name.
+func decodeEvent(packet string, event string) ([]any, bool) {
+	if !strings.HasPrefix(packet, "42[") {
+		return nil, false
+	}
+	var data []any
+	if err := json.Unmarshal([]byte(packet[2:]), &data); err != nil || len(data) == 0 || data[0] != event {
+		return nil, false
+	}
+	return data[1:], true
+}
+
+// waitForEvent reads from the websocket until the given event arrives on the
+// main namespace, answering PINGs and skipping any other packet.
+func waitForEvent(ctx context.Context, c *websocket.Conn, event string) ([]any, error) {
+	for {
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if p.Type == eiop.Ping {
+			c.Write(ctx, websocket.MessageText, []byte("3"))
+			continue
+		}
+		if args, ok := decodeEvent(p.String(), event); ok {
+			return args, nil
+		}
+	}
+}
+
+// pollForEvent polls the session until the given event arrives on the main
+// namespace, answering PINGs and skipping any other packet.
+func pollForEvent(t *testing.T, sid string, event string) []any {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, packet := range poll(t, sid) {
+			if packet.Type == eiop.Ping {
+				push(t, sid, "3")
+				continue
+			}
+			if args, ok := decodeEvent(packet.String(), event); ok {
+				return args
+			}
+		}
+	}
+	t.Fatalf("timed out waiting for %q", event)
+	return nil
+}
+
+// parseEventAckID extracts the ack id of a plain-text EVENT packet sent by the
+// server, e.g. 12 for `4212["event"]`.
+func parseEventAckID(packet string) (string, bool) {
+	if !strings.HasPrefix(packet, "42") {
+		return "", false
+	}
+	end := strings.IndexByte(packet, '[')
+	if end <= 2 {
+		return "", false
+	}
+	return packet[2:end], true
+}
+
+// openWebSocketSession dials the websocket transport and returns the
+// connection along with the Engine.IO sid from the OPEN packet.
+func openWebSocketSession(ctx context.Context, t *testing.T) (*websocket.Conn, string) {
+	t.Helper()
+
+	c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatalf("ws dial: %v", err)
+	}
+
+	p, err := waitForPacket(ctx, c)
+	if err != nil {
+		t.Fatalf("failed to read handshake: %v", err)
+	}
+
+	var handshake map[string]any
+	if p.Type != eiop.Open {
+		t.Fatalf("expected an open packet, got %s", p)
+	}
+	if err := json.Unmarshal(p.Data, &handshake); err != nil {
+		t.Fatalf("invalid handshake %s: %v", p, err)
+	}
+	sid, _ := handshake["sid"].(string)
+
+	return c, sid
+}
+
+// expectSessionUnknown asserts that the server no longer knows the given
+// Engine.IO sid.
+func expectSessionUnknown(t *testing.T, sid string) {
+	t.Helper()
+
+	resp, err := http.Get(pollingURL(sid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 || !strings.Contains(string(body), "Session ID unknown") {
+		t.Fatalf("expected 400 Session ID unknown, got %d (body: %s)", resp.StatusCode, string(body))
+	}
+}
+
+// expectDisconnectThenClose asserts that the server sends the namespace
+// DISCONNECT packet and then closes the connection.
+func expectDisconnectThenClose(ctx context.Context, t *testing.T, c *websocket.Conn, disconnect string) {
+	t.Helper()
+
+	closeCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+
+	p, err := waitForPacket(closeCtx, c)
+	if err != nil {
+		t.Fatalf("expected %q before the connection closed, got error: %v", disconnect, err)
+	}
+	if p.String() != disconnect {
+		t.Fatalf("expected %q, got %s", disconnect, p)
+	}
+
+	expectClose(closeCtx, t, c)
+}
+
+// expectClose reads from the websocket until the connection is closed,
+// failing on any packet that is not a PING.
+func expectClose(ctx context.Context, t *testing.T, c *websocket.Conn) {
+	t.Helper()
+
+	for {
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			if ctx.Err() != nil {
+				t.Fatal("timed out waiting for the connection to close")
+			}
+			return
+		}
+		if p.Type != eiop.Ping {
+			t.Fatalf("expected the connection to close, got %s", p)
+		}
+	}
+}
+
 func initSocketIOConnection(t *testing.T) *websocket.Conn {
+	c, _ := initSocketIOSession(t)
+	return c
+}
+
+// initSocketIOSession connects to the main namespace and returns the
+// connection along with the Socket.IO sid from the CONNECT packet.
+func initSocketIOSession(t *testing.T) (*websocket.Conn, string) {
+	return initSocketIOSessionWith(t, "", nil)
+}
+
+// initSocketIOSessionWith is initSocketIOSession with query appended to the
+// websocket URL and the given dial options.
+func initSocketIOSessionWith(t *testing.T, query string, opts *websocket.DialOptions) (*websocket.Conn, string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+	c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket"+query, opts)
 	if err != nil {
 		t.Fatalf("ws dial: %v", err)
 	}
 
 	// Engine.IO handshake
-	_, err = waitFor(ctx, c)
+	p, err := waitForPacket(ctx, c)
 	if err != nil {
 		t.Fatalf("failed to read handshake: %v", err)
 	}
+	if p.Type != eiop.Open {
+		t.Fatalf("expected an open packet, got %s", p)
+	}
 
 	// send "40" = Socket.IO connect
 	if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
@@ -102,18 +450,152 @@ func initSocketIOConnection(t *testing.T) *websocket.Conn {
 	}
 
 	// Socket.IO handshake
-	_, err = waitFor(ctx, c)
+	p, err = waitForPacket(ctx, c)
 	if err != nil {
 		t.Fatalf("failed to read socket.io handshake: %v", err)
 	}
 
+	var handshake map[string]any
+	if err := json.Unmarshal(bytes.TrimPrefix(p.Data, []byte("0")), &handshake); err != nil {
+		t.Fatalf("invalid socket.io handshake %q: %v", p, err)
+	}
+	sid, _ := handshake["sid"].(string)
+
 	// "auth" packet
-	_, err = waitFor(ctx, c)
+	_, err = waitForPacket(ctx, c)
 	if err != nil {
 		t.Fatalf("failed to read auth packet: %v", err)
 	}
 
-	return c
+	return c, sid
+}
+
+// waitForDisconnectReason queries the server until it has recorded a
+// disconnect reason for the given Socket.IO sid.
+func waitForDisconnectReason(t *testing.T, sid string) string {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	c := initSocketIOConnection(t)
+	defer c.Close(websocket.StatusNormalClosure, "")
+
+	for id := 1; ; id++ {
+		query := fmt.Sprintf(`42%d["last-disconnect-reason",%q]`, id, sid)
+		if err := c.Write(ctx, websocket.MessageText, []byte(query)); err != nil {
+			t.Fatal(err)
+		}
+
+		// The Socket.IO ACK within the Engine.IO message
+		prefix := fmt.Sprintf("3%d", id)
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatalf("no disconnect reason recorded for %s: %v", sid, err)
+			}
+			if p.Type == eiop.Ping {
+				c.Write(ctx, websocket.MessageText, []byte("3"))
+				continue
+			}
+			if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte(prefix)) {
+				continue
+			}
+
+			var reply []any
+			if err := json.Unmarshal(p.Data[len(prefix):], &reply); err != nil {
+				t.Fatal(err)
+			}
+			if len(reply) > 0 {
+				if reason, ok := reply[0].(string); ok {
+					return reason
+				}
+			}
+			break
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// waitForDisconnectRooms queries the server until it has recorded the rooms
+// the given Socket.IO sid was in on "disconnect".
+func waitForDisconnectRooms(t *testing.T, sid string) []any {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	c := initSocketIOConnection(t)
+	defer c.Close(websocket.StatusNormalClosure, "")
+
+	for id := 1; ; id++ {
+		query := fmt.Sprintf(`42%d["last-disconnect-rooms",%q]`, id, sid)
+		if err := c.Write(ctx, websocket.MessageText, []byte(query)); err != nil {
+			t.Fatal(err)
+		}
+
+		// The Socket.IO ACK within the Engine.IO message
+		prefix := fmt.Sprintf("3%d", id)
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatalf("no disconnect rooms recorded for %s: %v", sid, err)
+			}
+			if p.Type == eiop.Ping {
+				c.Write(ctx, websocket.MessageText, []byte("3"))
+				continue
+			}
+			if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte(prefix)) {
+				continue
+			}
+
+			var reply []any
+			if err := json.Unmarshal(p.Data[len(prefix):], &reply); err != nil {
+				t.Fatal(err)
+			}
+			if len(reply) > 0 {
+				if rooms, ok := reply[0].([]any); ok {
+					return rooms
+				}
+			}
+			break
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// emitWithAck emits event with the given ack id and waits for the ack,
+// answering PINGs and skipping any other packet.
+func emitWithAck(ctx context.Context, t *testing.T, c *websocket.Conn, id int, event string) []any {
+	t.Helper()
+
+	if err := c.Write(ctx, websocket.MessageText, []byte(fmt.Sprintf(`42%d[%q]`, id, event))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Socket.IO ACK within the Engine.IO message
+	prefix := fmt.Sprintf("3%d[", id)
+	for {
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatalf("no ack for %q: %v", event, err)
+		}
+		if p.Type == eiop.Ping {
+			c.Write(ctx, websocket.MessageText, []byte("3"))
+			continue
+		}
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte(prefix)) {
+			continue
+		}
+
+		var reply []any
+		if err := json.Unmarshal(p.Data[len(prefix)-1:], &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
 }
 
 func TestEngineIOHandshake(t *testing.T) {
@@ -134,15 +616,8 @@ func TestEngineIOHandshake(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			content := string(body)
-			if !strings.HasPrefix(content, "0") {
-				t.Fatalf("expected handshake starting with 0, got %s", content)
-			}
-
 			var val map[string]any
-			if err := json.Unmarshal([]byte(content[1:]), &val); err != nil {
-				t.Fatal(err)
-			}
+			decodeOpen(t, body, &val)
 
 			// Check all required keys
 			expectedKeys := []string{"sid", "upgrades", "pingInterval", "pingTimeout", "maxPayload"}
@@ -258,17 +733,17 @@ func TestEngineIOHandshake(t *testing.T) {
 			}
 			defer c.Close(websocket.StatusNormalClosure, "")
 
-			data, err := waitFor(ctx, c)
+			p, err := waitForPacket(ctx, c)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			if !strings.HasPrefix(data, "0") {
-				t.Fatalf("expected 0 handshake, got %s", data)
+			if p.Type != eiop.Open {
+				t.Fatalf("expected 0 handshake, got %s", p)
 			}
 
 			var val map[string]any
-			if err := json.Unmarshal([]byte(data[1:]), &val); err != nil {
+			if err := json.Unmarshal(p.Data, &val); err != nil {
 				t.Fatal(err)
 			}
 
@@ -347,6 +822,67 @@ func TestEngineIOHandshake(t *testing.T) {
 	})
 }
 
+// Only GET and POST are served on the engine endpoint, and OPTIONS as a CORS
+// preflight. Without a sid, other methods fail the handshake with 400. With a
+// sid, the polling transport rejects them with 500. HEAD follows the same
+// rules: it never creates a session nor consumes pending packets, and the
+// probed session stays usable.
+func TestEngineIOHTTPMethods(t *testing.T) {
+	tests := []struct {
+		method    string
+		handshake int
+		withSid   int
+	}{
+		{http.MethodDelete, http.StatusBadRequest, http.StatusInternalServerError},
+		{http.MethodPatch, http.StatusBadRequest, http.StatusInternalServerError},
+		{http.MethodHead, http.StatusBadRequest, http.StatusInternalServerError},
+		{http.MethodOptions, http.StatusNoContent, http.StatusNoContent},
+	}
+
+	do := func(t *testing.T, method string, url string) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("should answer %s on the handshake URL with %d", tt.method, tt.handshake), func(t *testing.T) {
+			resp := do(t, tt.method, URL+"/socket.io/?EIO=4&transport=polling")
+
+			if resp.StatusCode != tt.handshake {
+				t.Fatalf("expected %d, got %d", tt.handshake, resp.StatusCode)
+			}
+		})
+
+		t.Run(fmt.Sprintf("should answer %s on a session with %d and leave it unharmed", tt.method, tt.withSid), func(t *testing.T) {
+			sid, _ := initLongPollingSocketIOSession(t)
+
+			// Leave an echo pending in the session's buffer
+			push(t, sid, `42["message","pending"]`)
+			time.Sleep(50 * time.Millisecond)
+
+			if resp := do(t, tt.method, pollingURL(sid)); resp.StatusCode != tt.withSid {
+				t.Fatalf("expected %d, got %d", tt.withSid, resp.StatusCode)
+			}
+
+			if args := pollForEvent(t, sid, "message-back"); len(args) != 1 || args[0] != "pending" {
+				t.Fatalf("expected the pending echo, got %v", args)
+			}
+			push(t, sid, "1")
+		})
+	}
+}
+
 func TestEngineIOHeartbeat(t *testing.T) {
 	t.Run("HTTP long-polling", func(t *testing.T) {
 		t.Run("should send ping/pong packets", func(t *testing.T) {
@@ -368,9 +904,9 @@ func TestEngineIOHeartbeat(t *testing.T) {
 					t.Fatal(err)
 				}
 
-				pollContent := string(pollBody)
-				if pollContent != "2" {
-					t.Fatalf("expected '2', got %s", pollContent)
+				packets, err := eiop.DecodePayload(pollBody)
+				if err != nil || len(packets) != 1 || packets[0].Type != eiop.Ping {
+					t.Fatalf("expected '2', got %s", pollBody)
 				}
 
 				pushResponse, err := http.Post(
@@ -418,19 +954,19 @@ func TestEngineIOHeartbeat(t *testing.T) {
 			defer c.Close(websocket.StatusNormalClosure, "")
 
 			// handshake
-			_, err = waitFor(ctx, c)
+			_, err = waitForPacket(ctx, c)
 			if err != nil {
 				t.Fatal(err)
 			}
 
 			for range 3 {
-				data, err := waitFor(ctx, c)
+				p, err := waitForPacket(ctx, c)
 				if err != nil {
 					t.Fatal(err)
 				}
 
-				if data != "2" {
-					t.Fatalf("expected '2', got %s", data)
+				if p.Type != eiop.Ping {
+					t.Fatalf("expected a ping, got %s", p)
 				}
 
 				err = c.Write(ctx, websocket.MessageText, []byte("3"))
@@ -517,10 +1053,9 @@ func TestEngineIOClose(t *testing.T) {
 				if err != nil {
 					t.Fatal(err)
 				}
-				pullContent := string(pullBody)
-
-				if pullContent != "6" && pullContent != "2" {
-					t.Fatalf("expected '6' (close) or '2' (ping), got %s", pullContent)
+				packets, err := eiop.DecodePayload(pullBody)
+				if err != nil || len(packets) != 1 || (packets[0].Type != eiop.Noop && packets[0].Type != eiop.Ping) {
+					t.Fatalf("expected '6' (noop) or '2' (ping), got %s", pullBody)
 				}
 			} else if pollResponse.StatusCode != 400 {
 				t.Fatalf("expected 200 or 400, got %d", pollResponse.StatusCode)
@@ -553,7 +1088,7 @@ func TestEngineIOClose(t *testing.T) {
 			}
 
 			// handshake
-			_, err = waitFor(ctx, c)
+			_, err = waitForPacket(ctx, c)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -595,12 +1130,12 @@ func TestEngineIOUpgrade(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		probeResponse, err := waitFor(ctx, c)
+		probeResponse, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if probeResponse != "3probe" {
+		if probeResponse.Type != eiop.Pong || string(probeResponse.Data) != "probe" {
 			t.Fatalf("expected '3probe', got %s", probeResponse)
 		}
 
@@ -630,11 +1165,11 @@ func TestEngineIOUpgrade(t *testing.T) {
 		}
 
 		// Wait for probe response
-		probeResp, err := waitFor(ctx, c)
+		probeResp, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if probeResp != "3probe" {
+		if probeResp.Type != eiop.Pong || string(probeResp.Data) != "probe" {
 			t.Logf("Expected probe response, got: %s", probeResp)
 		}
 
@@ -713,7 +1248,7 @@ func TestSocketIOConnect(t *testing.T) {
 		defer c.Close(websocket.StatusNormalClosure, "")
 
 		// Engine.IO handshake
-		_, err = waitFor(ctx, c)
+		_, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -723,17 +1258,17 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if !strings.HasPrefix(data, "40") {
-			t.Fatalf("expected message starting with '40', got %s", data)
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0")) {
+			t.Fatalf("expected message starting with '40', got %s", p)
 		}
 
 		var handshake map[string]any
-		if err := json.Unmarshal([]byte(data[2:]), &handshake); err != nil {
+		if err := json.Unmarshal(p.Data[1:], &handshake); err != nil {
 			t.Fatal(err)
 		}
 
@@ -745,12 +1280,12 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal("sid should be a string")
 		}
 
-		authPacket, err := waitFor(ctx, c)
+		authPacket, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if authPacket != `42["auth",{}]` {
+		if authPacket.String() != `42["auth",{}]` {
 			t.Fatalf("expected auth packet, got %s", authPacket)
 		}
 	})
@@ -766,7 +1301,7 @@ func TestSocketIOConnect(t *testing.T) {
 		defer c.Close(websocket.StatusNormalClosure, "")
 
 		// Engine.IO handshake
-		_, err = waitFor(ctx, c)
+		_, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -776,17 +1311,17 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if !strings.HasPrefix(data, "40") {
-			t.Fatalf("expected message starting with '40', got %s", data)
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0")) {
+			t.Fatalf("expected message starting with '40', got %s", p)
 		}
 
 		var handshake map[string]any
-		if err := json.Unmarshal([]byte(data[2:]), &handshake); err != nil {
+		if err := json.Unmarshal(p.Data[1:], &handshake); err != nil {
 			t.Fatal(err)
 		}
 
@@ -798,12 +1333,12 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal("sid should be a string")
 		}
 
-		authPacket, err := waitFor(ctx, c)
+		authPacket, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if authPacket != `42["auth",{"token":"123"}]` {
+		if authPacket.String() != `42["auth",{"token":"123"}]` {
 			t.Fatalf("expected auth packet with token, got %s", authPacket)
 		}
 	})
@@ -819,7 +1354,7 @@ func TestSocketIOConnect(t *testing.T) {
 		defer c.Close(websocket.StatusNormalClosure, "")
 
 		// Engine.IO handshake
-		_, err = waitFor(ctx, c)
+		_, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -829,17 +1364,17 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if !strings.HasPrefix(data, "40/custom,") {
-			t.Fatalf("expected message starting with '40/custom,', got %s", data)
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0/custom,")) {
+			t.Fatalf("expected message starting with '40/custom,', got %s", p)
 		}
 
 		var handshake map[string]any
-		if err := json.Unmarshal([]byte(data[10:]), &handshake); err != nil {
+		if err := json.Unmarshal(p.Data[9:], &handshake); err != nil {
 			t.Fatal(err)
 		}
 
@@ -851,12 +1386,12 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal("sid should be a string")
 		}
 
-		authPacket, err := waitFor(ctx, c)
+		authPacket, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if authPacket != `42/custom,["auth",{}]` {
+		if authPacket.String() != `42/custom,["auth",{}]` {
 			t.Fatalf("expected auth packet for custom namespace, got %s", authPacket)
 		}
 	})
@@ -872,7 +1407,7 @@ func TestSocketIOConnect(t *testing.T) {
 		defer c.Close(websocket.StatusNormalClosure, "")
 
 		// Engine.IO handshake
-		_, err = waitFor(ctx, c)
+		_, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -882,17 +1417,17 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if !strings.HasPrefix(data, "40/custom,") {
-			t.Fatalf("expected message starting with '40/custom,', got %s", data)
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0/custom,")) {
+			t.Fatalf("expected message starting with '40/custom,', got %s", p)
 		}
 
 		var handshake map[string]any
-		if err := json.Unmarshal([]byte(data[10:]), &handshake); err != nil {
+		if err := json.Unmarshal(p.Data[9:], &handshake); err != nil {
 			t.Fatal(err)
 		}
 
@@ -904,12 +1439,12 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal("sid should be a string")
 		}
 
-		authPacket, err := waitFor(ctx, c)
+		authPacket, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if authPacket != `42/custom,["auth",{"token":"abc"}]` {
+		if authPacket.String() != `42/custom,["auth",{"token":"abc"}]` {
 			t.Fatalf("expected auth packet for custom namespace with token, got %s", authPacket)
 		}
 	})
@@ -925,7 +1460,7 @@ func TestSocketIOConnect(t *testing.T) {
 		defer c.Close(websocket.StatusNormalClosure, "")
 
 		// Engine.IO handshake
-		_, err = waitFor(ctx, c)
+		_, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -935,13 +1470,13 @@ func TestSocketIOConnect(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if data != `44/random,{"message":"Invalid namespace"}` {
-			t.Fatalf("expected error message for invalid namespace, got %s", data)
+		if p.String() != `44/random,{"message":"Invalid namespace"}` {
+			t.Fatalf("expected error message for invalid namespace, got %s", p)
 		}
 	})
 
@@ -956,7 +1491,7 @@ func TestSocketIOConnect(t *testing.T) {
 		defer c.Close(websocket.StatusNormalClosure, "")
 
 		// Engine.IO handshake
-		_, err = waitFor(ctx, c)
+		_, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -1010,13 +1545,13 @@ func TestSocketIODisconnect(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if data != "2" {
-			t.Fatalf("expected '2', got %s", data)
+		if p.Type != eiop.Ping {
+			t.Fatalf("expected a ping, got %s", p)
 		}
 	})
 
@@ -1028,7 +1563,7 @@ func TestSocketIODisconnect(t *testing.T) {
 		defer c.Close(websocket.StatusNormalClosure, "")
 
 		// Wait for ping
-		_, err := waitFor(ctx, c)
+		_, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -1040,13 +1575,13 @@ func TestSocketIODisconnect(t *testing.T) {
 		}
 
 		// Socket.IO handshake for custom namespace
-		_, err = waitFor(ctx, c)
+		_, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		// auth packet for custom namespace
-		_, err = waitFor(ctx, c)
+		_, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -1063,695 +1598,7043 @@ func TestSocketIODisconnect(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if data != `42["message-back","message to main namespace"]` {
-			t.Fatalf("expected message-back, got %s", data)
+		if p.String() != `42["message-back","message to main namespace"]` {
+			t.Fatalf("expected message-back, got %s", p)
 		}
 	})
 }
 
-func TestSocketIOMessage(t *testing.T) {
-	t.Run("should send a plain-text packet", func(t *testing.T) {
+func TestSocketIODisconnectReason(t *testing.T) {
+	t.Run("should record a client namespace disconnect", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 
-		c := initSocketIOConnection(t)
+		c, sid := initSocketIOSession(t)
 		defer c.Close(websocket.StatusNormalClosure, "")
 
-		err := c.Write(ctx, websocket.MessageText, []byte(`42["message",1,"2",{"3":[true]}]`))
+		err := c.Write(ctx, websocket.MessageText, []byte("41"))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
-		if err != nil {
-			t.Fatal(err)
+		if reason := waitForDisconnectReason(t, sid); reason != "client namespace disconnect" {
+			t.Fatalf("expected 'client namespace disconnect', got %s", reason)
 		}
 
-		if data != `42["message-back",1,"2",{"3":[true]}]` {
-			t.Fatalf("expected message-back with same data, got %s", data)
+		// The record is removed once acknowledged
+		q := initSocketIOConnection(t)
+		defer q.Close(websocket.StatusNormalClosure, "")
+		if err := q.Write(ctx, websocket.MessageText, []byte(fmt.Sprintf(`421["last-disconnect-reason",%q]`, sid))); err != nil {
+			t.Fatal(err)
+		}
+		if data := nextPacket(ctx, t, q); data != "431[null]" {
+			t.Fatalf("expected the record to be removed, got %s", data)
 		}
 	})
 
-	t.Run("should send a packet with binary attachments", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	t.Run("should record a transport close", func(t *testing.T) {
+		c, sid := initSocketIOSession(t)
 
-		c := initSocketIOConnection(t)
-		defer c.Close(websocket.StatusNormalClosure, "")
+		// Drop the connection without any Engine.IO or WebSocket close handshake
+		c.CloseNow()
 
-		// Send the message packet
-		err := c.Write(ctx, websocket.MessageText, []byte(`452-["message",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`))
-		if err != nil {
-			t.Fatal(err)
+		if reason := waitForDisconnectReason(t, sid); reason != "transport close" {
+			t.Fatalf("expected 'transport close', got %s", reason)
 		}
+	})
 
-		// Send first binary attachment
-		err = c.Write(ctx, websocket.MessageBinary, []byte{1, 2, 3})
-		if err != nil {
-			t.Fatal(err)
+	t.Run("should record a ping timeout", func(t *testing.T) {
+		c, sid := initSocketIOSession(t)
+		defer c.CloseNow()
+
+		// Never answer the server's PING
+		time.Sleep(time.Duration(PING_INTERVAL+PING_TIMEOUT+200) * time.Millisecond)
+
+		if reason := waitForDisconnectReason(t, sid); reason != "ping timeout" {
+			t.Fatalf("expected 'ping timeout', got %s", reason)
 		}
+	})
+}
 
-		// Send second binary attachment
-		err = c.Write(ctx, websocket.MessageBinary, []byte{4, 5, 6})
+func TestSocketIOVolatile(t *testing.T) {
+	t.Run("should deliver a volatile packet when the transport is writable", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		err := c.Write(ctx, websocket.MessageText, []byte(`42["volatile-ping",1]`))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		// Wait for 3 packets in response
-		packets, err := waitForPackets(ctx, c, 3)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		expectedText := `452-["message-back",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`
-		if packets[0].(string) != expectedText {
-			t.Fatalf("expected %s, got %s", expectedText, packets[0])
+		if p.String() != `42["volatile-pong",1]` {
+			t.Fatalf("expected volatile-pong, got %s", p)
 		}
+	})
 
-		// Check binary data
-		binary1, ok := packets[1].([]byte)
-		if !ok {
-			t.Fatal("expected binary data")
-		}
-		if !bytes.Equal(binary1, []byte{1, 2, 3}) {
-			t.Fatalf("expected [1,2,3], got %v", binary1)
-		}
+	t.Run("should drop a volatile packet when no poll is pending", func(t *testing.T) {
+		sid, _ := initLongPollingSocketIOSession(t)
 
-		binary2, ok := packets[2].([]byte)
-		if !ok {
-			t.Fatal("expected binary data")
-		}
-		if !bytes.Equal(binary2, []byte{4, 5, 6}) {
-			t.Fatalf("expected [4,5,6], got %v", binary2)
+		// No GET is pending, so the polling transport is not writable when the
+		// server answers
+		push(t, sid, `42["volatile-ping",1]`)
+		time.Sleep(100 * time.Millisecond)
+
+		// A regular event emitted afterwards is buffered and must be the first
+		// event drained
+		push(t, sid, `42["message","after"]`)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			for _, packet := range poll(t, sid) {
+				switch {
+				case packet.Type == eiop.Ping:
+					push(t, sid, "3")
+				case strings.HasPrefix(packet.String(), `42["volatile-pong"`):
+					t.Fatalf("expected volatile-pong to be dropped, got %s", packet)
+				case packet.String() == `42["message-back","after"]`:
+					push(t, sid, "1")
+					return
+				default:
+					t.Fatalf("unexpected packet %s", packet)
+				}
+			}
 		}
+		t.Fatal("timed out waiting for message-back")
 	})
+}
 
-	t.Run("should send a plain-text packet with an ack", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
+func TestSocketIOBroadcastWithAck(t *testing.T) {
+	// answerBroadcastAcks acknowledges every "broadcast-ack-request" received
+	// on c (when ack is true) until the connection is closed.
+	answerBroadcastAcks := func(ctx context.Context, c *websocket.Conn, ack bool) {
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				return
+			}
+			if p.Type == eiop.Ping {
+				c.Write(ctx, websocket.MessageText, []byte("3"))
+				continue
+			}
+			if id, ok := parseEventAckID(p.String()); ok && ack && strings.HasSuffix(p.String(), `["broadcast-ack-request"]`) {
+				c.Write(ctx, websocket.MessageText, []byte("43"+id+`["ok"]`))
+			}
+		}
+	}
 
-		c := initSocketIOConnection(t)
-		defer c.Close(websocket.StatusNormalClosure, "")
+	// requestBroadcast triggers the broadcast from c, acknowledges the request
+	// it receives itself, and returns the "ack-summary" payload.
+	requestBroadcast := func(t *testing.T, ctx context.Context, c *websocket.Conn, timeout int) map[string]any {
+		t.Helper()
 
-		err := c.Write(ctx, websocket.MessageText, []byte(`42456["message-with-ack",1,"2",{"3":[false]}]`))
+		err := c.Write(ctx, websocket.MessageText, []byte(fmt.Sprintf(`42["broadcast-with-ack",%d]`, timeout)))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
-		if err != nil {
-			t.Fatal(err)
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type == eiop.Ping {
+				c.Write(ctx, websocket.MessageText, []byte("3"))
+				continue
+			}
+			if id, ok := parseEventAckID(p.String()); ok && strings.HasSuffix(p.String(), `["broadcast-ack-request"]`) {
+				c.Write(ctx, websocket.MessageText, []byte("43"+id+`["ok"]`))
+				continue
+			}
+			if p.Type == eiop.Message && bytes.HasPrefix(p.Data, []byte(`2["ack-summary",`)) {
+				var payload []any
+				if err := json.Unmarshal(p.Data[1:], &payload); err != nil {
+					t.Fatal(err)
+				}
+				summary, ok := payload[1].(map[string]any)
+				if !ok {
+					t.Fatalf("invalid ack-summary: %s", p)
+				}
+				return summary
+			}
 		}
+	}
+
+	t.Run("should collect the acknowledgements of all clients", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c1 := initSocketIOConnection(t)
+		defer c1.Close(websocket.StatusNormalClosure, "")
+		c2 := initSocketIOConnection(t)
+		defer c2.Close(websocket.StatusNormalClosure, "")
+		c3 := initSocketIOConnection(t)
+		defer c3.Close(websocket.StatusNormalClosure, "")
+
+		go answerBroadcastAcks(ctx, c2, true)
+		go answerBroadcastAcks(ctx, c3, true)
 
-		if data != `43456[1,"2",{"3":[false]}]` {
-			t.Fatalf("expected ack response, got %s", data)
+		summary := requestBroadcast(t, ctx, c1, 1000)
+
+		if summary["responses"] != float64(3) {
+			t.Fatalf("expected 3 responses, got %v", summary["responses"])
+		}
+		if summary["timedOut"] != false {
+			t.Fatalf("expected no timeout, got %v", summary["timedOut"])
 		}
 	})
 
-	t.Run("should send a packet with binary attachments and an ack", func(t *testing.T) {
+	t.Run("should report a timeout when a client does not acknowledge", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		c := initSocketIOConnection(t)
-		defer c.Close(websocket.StatusNormalClosure, "")
+		c1 := initSocketIOConnection(t)
+		defer c1.Close(websocket.StatusNormalClosure, "")
+		c2 := initSocketIOConnection(t)
+		defer c2.Close(websocket.StatusNormalClosure, "")
+		c3 := initSocketIOConnection(t)
+		defer c3.Close(websocket.StatusNormalClosure, "")
 
-		// Send the message packet with ack
-		err := c.Write(ctx, websocket.MessageText, []byte(`452-789["message-with-ack",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`))
-		if err != nil {
-			t.Fatal(err)
+		go answerBroadcastAcks(ctx, c2, true)
+		go answerBroadcastAcks(ctx, c3, false)
+
+		start := time.Now()
+		summary := requestBroadcast(t, ctx, c1, 500)
+		elapsed := time.Since(start)
+
+		if summary["responses"] != float64(2) {
+			t.Fatalf("expected 2 responses, got %v", summary["responses"])
+		}
+		if summary["timedOut"] != true {
+			t.Fatalf("expected a timeout, got %v", summary["timedOut"])
 		}
+		if elapsed < 450*time.Millisecond || elapsed > 1500*time.Millisecond {
+			t.Fatalf("expected the timeout after ~500ms, got %v", elapsed)
+		}
+	})
+}
 
-		// Send first binary attachment
-		err = c.Write(ctx, websocket.MessageBinary, []byte{1, 2, 3})
-		if err != nil {
-			t.Fatal(err)
+func TestSocketIOFetchSockets(t *testing.T) {
+	// socketIDs maps a "sockets-list" payload to the sorted socket ids and
+	// checks every entry lists its own sid-room.
+	socketIDs := func(t *testing.T, args []any) []string {
+		t.Helper()
+
+		if len(args) == 0 {
+			t.Fatal("empty sockets-list")
 		}
+		if len(args) > 1 {
+			t.Fatalf("fetching sockets failed: %v", args[1])
+		}
+		list, ok := args[0].([]any)
+		if !ok {
+			t.Fatalf("sockets-list should be an array, got %v", args[0])
+		}
+		ids := make([]string, 0, len(list))
+		for _, item := range list {
+			entry, ok := item.(map[string]any)
+			if !ok {
+				t.Fatalf("invalid sockets-list entry: %v", item)
+			}
+			id, _ := entry["id"].(string)
+			rooms, _ := entry["rooms"].([]any)
+			if !slices.Contains(rooms, any(id)) {
+				t.Fatalf("socket %s is missing its own room: %v", id, rooms)
+			}
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		return ids
+	}
 
-		// Send second binary attachment
-		err = c.Write(ctx, websocket.MessageBinary, []byte{4, 5, 6})
-		if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	room := fmt.Sprintf("fetch-%d", time.Now().UnixNano())
+
+	c1, sid1 := initSocketIOSession(t)
+	defer c1.Close(websocket.StatusNormalClosure, "")
+	c2, sid2 := initSocketIOSession(t)
+	defer c2.Close(websocket.StatusNormalClosure, "")
+	engineSid, sid3 := initLongPollingSocketIOSession(t)
+	defer push(t, engineSid, "1")
+
+	if err := c1.Write(ctx, websocket.MessageText, []byte(fmt.Sprintf(`42["join-room",%q]`, room))); err != nil {
+		t.Fatal(err)
+	}
+	push(t, engineSid, fmt.Sprintf(`42["join-room",%q]`, room))
+
+	t.Run("should list all connected sockets", func(t *testing.T) {
+		if err := c1.Write(ctx, websocket.MessageText, []byte(`42["list-sockets"]`)); err != nil {
 			t.Fatal(err)
 		}
-
-		// Wait for 3 packets in response (ack + binary attachments)
-		packets, err := waitForPackets(ctx, c, 3)
+		args, err := waitForEvent(ctx, c1, "sockets-list")
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		expectedText := `462-789[{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`
-		if packets[0].(string) != expectedText {
-			t.Fatalf("expected %s, got %s", expectedText, packets[0])
+		ids := socketIDs(t, args)
+		for _, sid := range []string{sid1, sid2, sid3} {
+			if _, found := slices.BinarySearch(ids, sid); !found {
+				t.Fatalf("expected %s in %v", sid, ids)
+			}
 		}
+	})
 
-		// Check binary data
-		binary1, ok := packets[1].([]byte)
-		if !ok {
-			t.Fatal("expected binary data")
+	t.Run("should list the sockets of a room over both transports", func(t *testing.T) {
+		expected := []string{sid1, sid3}
+		slices.Sort(expected)
+
+		if err := c1.Write(ctx, websocket.MessageText, []byte(fmt.Sprintf(`42["list-sockets",%q]`, room))); err != nil {
+			t.Fatal(err)
 		}
-		if !bytes.Equal(binary1, []byte{1, 2, 3}) {
-			t.Fatalf("expected [1,2,3], got %v", binary1)
+		args, err := waitForEvent(ctx, c1, "sockets-list")
+		if err != nil {
+			t.Fatal(err)
 		}
-
-		binary2, ok := packets[2].([]byte)
-		if !ok {
-			t.Fatal("expected binary data")
+		if ids := socketIDs(t, args); !slices.Equal(ids, expected) {
+			t.Fatalf("expected %v over websocket, got %v", expected, ids)
 		}
-		if !bytes.Equal(binary2, []byte{4, 5, 6}) {
-			t.Fatalf("expected [4,5,6], got %v", binary2)
+
+		push(t, engineSid, fmt.Sprintf(`42["list-sockets",%q]`, room))
+		if ids := socketIDs(t, pollForEvent(t, engineSid, "sockets-list")); !slices.Equal(ids, expected) {
+			t.Fatalf("expected %v over polling, got %v", expected, ids)
 		}
 	})
+}
 
-	t.Run("should close the connection upon invalid format (unknown packet type)", func(t *testing.T) {
+func TestSocketIOServerDisconnect(t *testing.T) {
+	t.Run("should disconnect and close the connection from the main namespace", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 
-		c := initSocketIOConnection(t)
+		c, sid := openWebSocketSession(ctx, t)
 		defer c.Close(websocket.StatusNormalClosure, "")
 
-		err := c.Write(ctx, websocket.MessageText, []byte("4abc"))
-		if err != nil {
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := waitForEvent(ctx, c, "auth"); err != nil {
 			t.Fatal(err)
 		}
 
-		// Wait for connection to close
-		for {
-			_, _, err := c.Read(ctx)
-			if err != nil {
-				// Connection closed as expected
-				break
-			}
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["kick-me"]`)); err != nil {
+			t.Fatal(err)
 		}
+
+		expectDisconnectThenClose(ctx, t, c, "41")
+
+		expectSessionUnknown(t, sid)
 	})
 
-	t.Run("should close the connection upon invalid format (invalid payload format)", func(t *testing.T) {
+	t.Run("should disconnect and close the connection from a custom namespace", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 
-		c := initSocketIOConnection(t)
+		c, sid := openWebSocketSession(ctx, t)
 		defer c.Close(websocket.StatusNormalClosure, "")
 
-		err := c.Write(ctx, websocket.MessageText, []byte("42{}"))
-		if err != nil {
+		if err := c.Write(ctx, websocket.MessageText, []byte("40/custom,")); err != nil {
 			t.Fatal(err)
 		}
-
-		// Wait for connection to close
-		for {
-			_, _, err := c.Read(ctx)
-			if err != nil {
-				// Connection closed as expected
-				break
+		// Socket.IO handshake + auth for custom
+		for range 2 {
+			if _, err := waitForPacket(ctx, c); err != nil {
+				t.Fatal(err)
 			}
 		}
-	})
-
-	t.Run("should close the connection upon invalid format (invalid ack id)", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-
-		c := initSocketIOConnection(t)
-		defer c.Close(websocket.StatusNormalClosure, "")
 
-		err := c.Write(ctx, websocket.MessageText, []byte(`42abc["message-with-ack",1,"2",{"3":[false]}]`))
-		if err != nil {
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42/custom,["kick-me"]`)); err != nil {
 			t.Fatal(err)
 		}
 
-		// Wait for connection to close
-		for {
-			_, _, err := c.Read(ctx)
+		expectDisconnectThenClose(ctx, t, c, "41/custom,")
+
+		expectSessionUnknown(t, sid)
+	})
+
+	t.Run("should release the pending poll with the disconnect packet", func(t *testing.T) {
+		sid, socketSid := initLongPollingSocketIOSession(t)
+
+		pollDone := make(chan []eiop.Packet, 1)
+		go func() {
+			resp, err := http.Get(pollingURL(sid))
 			if err != nil {
-				// Connection closed as expected
-				break
+				pollDone <- nil
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			packets, _ := eiop.DecodePayload(body)
+			pollDone <- packets
+		}()
+
+		// Make sure the GET is pending before the server disconnects
+		time.Sleep(50 * time.Millisecond)
+		push(t, sid, `42["kick-me"]`)
+
+		select {
+		case packets := <-pollDone:
+			if !slices.ContainsFunc(packets, func(p eiop.Packet) bool { return p.String() == "41" }) {
+				t.Fatalf("expected the pending poll to contain '41', got %q", packets)
 			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the pending poll")
+		}
+
+		expectSessionUnknown(t, sid)
+
+		if reason := waitForDisconnectReason(t, socketSid); reason != "server namespace disconnect" {
+			t.Fatalf("expected 'server namespace disconnect', got %s", reason)
 		}
 	})
 }
 
-func TestSocketIOMultipleNamespaces(t *testing.T) {
-	t.Run("should connect to both main and custom namespace simultaneously", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func TestSocketIOServerSoftDisconnect(t *testing.T) {
+	t.Run("should disconnect the namespace but keep the connection open", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
-		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
-		if err != nil {
-			t.Fatal(err)
-		}
+		c, sid := initSocketIOSession(t)
 		defer c.Close(websocket.StatusNormalClosure, "")
 
-		// Engine.IO handshake
-		_, err = waitFor(ctx, c)
-		if err != nil {
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["kick-soft"]`)); err != nil {
 			t.Fatal(err)
 		}
 
-		// Connect to main namespace
-		err = c.Write(ctx, websocket.MessageText, []byte("40"))
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
+		if p.String() != "41" {
+			t.Fatalf("expected '41', got %s", p)
+		}
 
-		// Socket.IO handshake for main namespace
-		data, err := waitFor(ctx, c)
+		// The heartbeat continues and nothing emitted to the old socket arrives
+		p, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		if !strings.HasPrefix(data, "40") {
-			t.Fatalf("expected message starting with '40', got %s", data)
+		if p.Type != eiop.Ping {
+			t.Fatalf("expected '2', got %s", p)
 		}
-
-		// Auth packet for main namespace
-		_, err = waitFor(ctx, c)
-		if err != nil {
+		if err := c.Write(ctx, websocket.MessageText, []byte("3")); err != nil {
 			t.Fatal(err)
 		}
 
-		// Connect to custom namespace
-		err = c.Write(ctx, websocket.MessageText, []byte("40/custom,"))
-		if err != nil {
+		// Reconnect the namespace over the same connection
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
 			t.Fatal(err)
 		}
 
-		// Socket.IO handshake for custom namespace
-		data, err = waitFor(ctx, c)
+		p, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		if !strings.HasPrefix(data, "40/custom,") {
-			t.Fatalf("expected message starting with '40/custom,', got %s", data)
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0")) {
+			t.Fatalf("expected message starting with '40', got %s", p)
 		}
-
-		// Auth packet for custom namespace
-		_, err = waitFor(ctx, c)
-		if err != nil {
+		var handshake map[string]any
+		if err := json.Unmarshal(p.Data[1:], &handshake); err != nil {
 			t.Fatal(err)
 		}
+		if newSid, _ := handshake["sid"].(string); newSid == "" || newSid == sid {
+			t.Fatalf("expected a new sid, got %q (old: %q)", newSid, sid)
+		}
 
-		// Send message to main namespace
-		err = c.Write(ctx, websocket.MessageText, []byte(`42["message","hello from main"]`))
+		p, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
+		if p.String() != `42["auth",{}]` {
+			t.Fatalf("expected auth packet, got %s", p)
+		}
 
-		data, err = waitFor(ctx, c)
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["message","reconnected"]`)); err != nil {
+			t.Fatal(err)
+		}
+		p, err = waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
+		if p.String() != `42["message-back","reconnected"]` {
+			t.Fatalf("expected message-back, got %s", p)
+		}
 
-		if data != `42["message-back","hello from main"]` {
-			t.Fatalf("expected message-back from main namespace, got %s", data)
+		if reason := waitForDisconnectReason(t, sid); reason != "server namespace disconnect" {
+			t.Fatalf("expected 'server namespace disconnect', got %s", reason)
 		}
 	})
+}
 
-	t.Run("should disconnect from custom namespace without affecting main", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+func TestSocketIOServerAckTimeout(t *testing.T) {
+	// ask triggers "ask-with-timeout" and returns the ack id of the server's
+	// "ask" event.
+	ask := func(t *testing.T, ctx context.Context, c *websocket.Conn) string {
+		t.Helper()
 
-		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
-		if err != nil {
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["ask-with-timeout"]`)); err != nil {
 			t.Fatal(err)
 		}
-		defer c.Close(websocket.StatusNormalClosure, "")
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id, ok := parseEventAckID(p.String()); ok && strings.HasSuffix(p.String(), `["ask"]`) {
+				return id
+			}
+		}
+	}
 
-		// Engine.IO handshake
-		_, err = waitFor(ctx, c)
+	askResult := func(t *testing.T, ctx context.Context, c *websocket.Conn) map[string]any {
+		t.Helper()
+
+		args, err := waitForEvent(ctx, c, "ask-result")
 		if err != nil {
 			t.Fatal(err)
 		}
+		result, _ := args[0].(map[string]any)
+		return result
+	}
 
-		// Connect to main namespace
-		err = c.Write(ctx, websocket.MessageText, []byte("40"))
-		if err != nil {
+	t.Run("should resolve with the value when the client answers in time", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		id := ask(t, ctx, c)
+		if err := c.Write(ctx, websocket.MessageText, []byte("43"+id+`["pong"]`)); err != nil {
 			t.Fatal(err)
 		}
 
-		// Socket.IO handshake + auth for main
-		_, err = waitFor(ctx, c)
-		if err != nil {
+		result := askResult(t, ctx, c)
+		if result["timedOut"] != false || result["value"] != "pong" {
+			t.Fatalf(`expected {"timedOut":false,"value":"pong"}, got %v`, result)
+		}
+	})
+
+	t.Run("should time out when the client never answers", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		ask(t, ctx, c)
+		start := time.Now()
+
+		result := askResult(t, ctx, c)
+		elapsed := time.Since(start)
+
+		if result["timedOut"] != true {
+			t.Fatalf(`expected {"timedOut":true}, got %v`, result)
+		}
+		if elapsed < 150*time.Millisecond || elapsed > 600*time.Millisecond {
+			t.Fatalf("expected the timeout after ~200ms, got %v", elapsed)
+		}
+	})
+}
+
+func TestSocketIORooms(t *testing.T) {
+	// myRooms asks the server for the rooms of the socket and returns them sorted.
+	myRooms := func(t *testing.T, ctx context.Context, c *websocket.Conn) []string {
+		t.Helper()
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["my-rooms"]`)); err != nil {
 			t.Fatal(err)
 		}
-		_, err = waitFor(ctx, c)
+		args, err := waitForEvent(ctx, c, "my-rooms")
 		if err != nil {
 			t.Fatal(err)
 		}
+		list, ok := args[0].([]any)
+		if !ok {
+			t.Fatalf("my-rooms should be an array, got %v", args[0])
+		}
+		rooms := make([]string, 0, len(list))
+		for _, item := range list {
+			room, ok := item.(string)
+			if !ok {
+				t.Fatalf("room should be a string, got %v", item)
+			}
+			rooms = append(rooms, room)
+		}
+		slices.Sort(rooms)
+		return rooms
+	}
 
-		// Connect to custom namespace
-		err = c.Write(ctx, websocket.MessageText, []byte("40/custom,"))
-		if err != nil {
-			t.Fatal(err)
+	expectRooms := func(t *testing.T, got []string, expected ...string) {
+		t.Helper()
+
+		slices.Sort(expected)
+		if !slices.Equal(got, expected) {
+			t.Fatalf("expected rooms %v, got %v", expected, got)
 		}
+	}
 
-		// Socket.IO handshake + auth for custom
-		_, err = waitFor(ctx, c)
-		if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, sid := initSocketIOSession(t)
+	defer c.Close(websocket.StatusNormalClosure, "")
+
+	t.Run("should only be in its own room initially", func(t *testing.T) {
+		expectRooms(t, myRooms(t, ctx, c), sid)
+	})
+
+	t.Run("should join rooms", func(t *testing.T) {
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["join-room","room-a","room-b"]`)); err != nil {
 			t.Fatal(err)
 		}
-		_, err = waitFor(ctx, c)
-		if err != nil {
+		expectRooms(t, myRooms(t, ctx, c), sid, "room-a", "room-b")
+	})
+
+	t.Run("should leave a room", func(t *testing.T) {
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["leave-room","room-a"]`)); err != nil {
 			t.Fatal(err)
 		}
+		expectRooms(t, myRooms(t, ctx, c), sid, "room-b")
+	})
 
-		// Disconnect from custom namespace
-		err = c.Write(ctx, websocket.MessageText, []byte("41/custom,"))
-		if err != nil {
+	t.Run("should broadcast to the other sockets of a room", func(t *testing.T) {
+		other, _ := initSocketIOSession(t)
+		defer other.Close(websocket.StatusNormalClosure, "")
+		if err := other.Write(ctx, websocket.MessageText, []byte(`42["join-room","room-b"]`)); err != nil {
 			t.Fatal(err)
 		}
-
-		// Main namespace should still work
-		err = c.Write(ctx, websocket.MessageText, []byte(`42["message","still connected"]`))
-		if err != nil {
+		if err := other.Write(ctx, websocket.MessageText, []byte(`42["room-broadcast","room-b","headline",1,"2"]`)); err != nil {
 			t.Fatal(err)
 		}
-
-		data, err := waitFor(ctx, c)
+		args, err := waitForEvent(ctx, c, "headline")
 		if err != nil {
 			t.Fatal(err)
 		}
+		if !reflect.DeepEqual(args, []any{float64(1), "2"}) {
+			t.Fatalf(`expected [1,"2"], got %v`, args)
+		}
 
-		// Should be either a ping or the message-back
-		if data == "2" {
-			// It was a ping, respond and wait for actual data
-			err = c.Write(ctx, websocket.MessageText, []byte("3"))
+		// The sender is left out: its next event is the reply to "my-rooms"
+		if err := other.Write(ctx, websocket.MessageText, []byte(`42["my-rooms"]`)); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			p, err := waitForPacket(ctx, other)
 			if err != nil {
 				t.Fatal(err)
 			}
-			data, err = waitFor(ctx, c)
+			if _, ok := decodeEvent(p.String(), "headline"); ok {
+				t.Fatal("expected the sender not to receive the broadcast")
+			}
+			if _, ok := decodeEvent(p.String(), "my-rooms"); ok {
+				break
+			}
+		}
+	})
+
+	t.Run("should not carry membership over a reconnection", func(t *testing.T) {
+		if err := c.Write(ctx, websocket.MessageText, []byte("41")); err != nil {
+			t.Fatal(err)
+		}
+		// The server handles socket packets asynchronously, so a CONNECT sent
+		// before the DISCONNECT is processed would be rejected as invalid state
+		if reason := waitForDisconnectReason(t, sid); reason != "client namespace disconnect" {
+			t.Fatalf("expected 'client namespace disconnect', got %s", reason)
+		}
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+
+		var newSid string
+		for newSid == "" {
+			p, err := waitForPacket(ctx, c)
 			if err != nil {
 				t.Fatal(err)
 			}
+			if p.Type == eiop.Message && bytes.HasPrefix(p.Data, []byte("0")) {
+				var handshake map[string]any
+				if err := json.Unmarshal(p.Data[1:], &handshake); err != nil {
+					t.Fatal(err)
+				}
+				newSid, _ = handshake["sid"].(string)
+			}
 		}
 
-		if data != `42["message-back","still connected"]` {
-			t.Fatalf("expected message-back from main namespace, got %s", data)
-		}
+		expectRooms(t, myRooms(t, ctx, c), newSid)
 	})
 }
 
-func TestEngineIOPayloadLimits(t *testing.T) {
-	t.Run("should reject a payload that exceeds maxHttpBufferSize via HTTP", func(t *testing.T) {
-		sid := initLongPollingSession(t)
+// The rooms of a socket are only known on "disconnecting": by the time
+// "disconnect" is emitted, the socket has left them all.
+func TestSocketIODisconnecting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		// maxHttpBufferSize is 1000000, send a larger payload
-		largePayload := strings.Repeat("a", 1000001)
+	// Room names of their own, as the server is shared with the other tests
+	roomA := fmt.Sprintf("disconnecting-a-%d", time.Now().UnixNano())
+	roomB := fmt.Sprintf("disconnecting-b-%d", time.Now().UnixNano())
+	join := func(t *testing.T, c *websocket.Conn, rooms ...string) {
+		t.Helper()
 
-		resp, err := http.Post(
-			fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid),
-			"text/plain",
-			strings.NewReader("4"+largePayload),
-		)
-		if err != nil {
+		args := []any{"join-room"}
+		for _, room := range rooms {
+			args = append(args, room)
+		}
+		payload, _ := json.Marshal(args)
+		if err := c.Write(ctx, websocket.MessageText, append([]byte("42"), payload...)); err != nil {
 			t.Fatal(err)
 		}
-		defer resp.Body.Close()
-
-		// 413 Payload Too Large is the correct status for oversized payloads
-		if resp.StatusCode != http.StatusRequestEntityTooLarge {
-			t.Fatalf("expected 413 for oversized payload, got %d", resp.StatusCode)
+		// The rooms are listed once joined
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["my-rooms"]`)); err != nil {
+			t.Fatal(err)
 		}
-	})
+		if _, err := waitForEvent(ctx, c, "my-rooms"); err != nil {
+			t.Fatal(err)
+		}
+	}
 
-	t.Run("should accept a payload within maxHttpBufferSize via HTTP", func(t *testing.T) {
-		sid := initLongPollingSession(t)
+	leaver, sid := initSocketIOSession(t)
+	defer leaver.CloseNow()
+	alice, _ := initSocketIOSession(t)
+	defer alice.Close(websocket.StatusNormalClosure, "")
+	bob, _ := initSocketIOSession(t)
+	defer bob.Close(websocket.StatusNormalClosure, "")
+	join(t, leaver, roomA, roomB)
+	join(t, alice, roomA)
+	join(t, bob, roomB)
+
+	if err := leaver.Write(ctx, websocket.MessageText, []byte("41")); err != nil {
+		t.Fatal(err)
+	}
 
-		// Follow the established heartbeat pattern: GET returns ping, POST sends pong
-		pollResp, err := http.Get(fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid))
-		if err != nil {
-			t.Fatal(err)
+	t.Run("should notify each room on disconnecting", func(t *testing.T) {
+		for member, room := range map[*websocket.Conn]string{alice: roomA, bob: roomB} {
+			args, err := waitForEvent(ctx, member, "member-leaving")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []any{map[string]any{"id": sid, "room": room}}
+			if !reflect.DeepEqual(args, expected) {
+				t.Fatalf("expected %v, got %v", expected, args)
+			}
 		}
-		pollBody, _ := io.ReadAll(pollResp.Body)
-		pollResp.Body.Close()
+	})
 
-		if pollResp.StatusCode != 200 {
-			t.Fatalf("expected 200 for poll, got %d (body: %s)", pollResp.StatusCode, string(pollBody))
+	t.Run("should have no room left on disconnect", func(t *testing.T) {
+		if rooms := waitForDisconnectRooms(t, sid); len(rooms) != 0 {
+			t.Fatalf("expected no room on disconnect, got %v", rooms)
 		}
+	})
+}
 
-		// Send a valid pong response (engine.io packet type 3)
-		resp, err := http.Post(
-			fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid),
-			"text/plain",
-			strings.NewReader("3"),
-		)
-		if err != nil {
+func TestSocketIOCatchAll(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	c := initSocketIOConnection(t)
+	defer c.Close(websocket.StatusNormalClosure, "")
+
+	for _, packet := range []string{`42["first",1]`, `42["second","two",{"three":3}]`, `42["third"]`} {
+		if err := c.Write(ctx, websocket.MessageText, []byte(packet)); err != nil {
 			t.Fatal(err)
 		}
-		defer resp.Body.Close()
+	}
 
-		if resp.StatusCode != 200 {
-			t.Fatalf("expected 200 for valid payload, got %d", resp.StatusCode)
-		}
-	})
+	// Only the "auth" event was emitted since the connection
+	expected := []any{map[string]any{
+		"incoming": float64(3),
+		"outgoing": float64(1),
+		"events":   []any{"first", "second", "third"},
+	}}
+	if stats := emitWithAck(ctx, t, c, 1, "stats"); !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("expected %v, got %v", expected, stats)
+	}
+
+	emitWithAck(ctx, t, c, 2, "off-any")
+	if err := c.Write(ctx, websocket.MessageText, []byte(`42["fourth"]`)); err != nil {
+		t.Fatal(err)
+	}
+	if stats := emitWithAck(ctx, t, c, 3, "stats"); !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("expected the counters to stop at %v, got %v", expected, stats)
+	}
 }
 
-func TestSocketIOMessageEdgeCases(t *testing.T) {
-	t.Run("should handle empty string message", func(t *testing.T) {
+func TestSocketIOMessage(t *testing.T) {
+	t.Run("should send a plain-text packet", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 
 		c := initSocketIOConnection(t)
 		defer c.Close(websocket.StatusNormalClosure, "")
 
-		err := c.Write(ctx, websocket.MessageText, []byte(`42["message",""]`))
+		err := c.Write(ctx, websocket.MessageText, []byte(`42["message",1,"2",{"3":[true]}]`))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		p, err := waitForPacket(ctx, c)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if data != `42["message-back",""]` {
-			t.Fatalf("expected empty message-back, got %s", data)
+		if p.String() != `42["message-back",1,"2",{"3":[true]}]` {
+			t.Fatalf("expected message-back with same data, got %s", p)
 		}
 	})
 
-	t.Run("should handle message with special characters", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Run("should send a packet with binary attachments", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		c := initSocketIOConnection(t)
 		defer c.Close(websocket.StatusNormalClosure, "")
 
-		err := c.Write(ctx, websocket.MessageText, []byte(`42["message","hello\nworld\t\"quoted\""]`))
+		// Send the message packet
+		err := c.Write(ctx, websocket.MessageText, []byte(`452-["message",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		// Send first binary attachment
+		err = c.Write(ctx, websocket.MessageBinary, []byte{1, 2, 3})
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if !strings.HasPrefix(data, `42["message-back",`) {
-			t.Fatalf("expected message-back with special chars, got %s", data)
-		}
-	})
-
-	t.Run("should handle message with unicode", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-
-		c := initSocketIOConnection(t)
-		defer c.Close(websocket.StatusNormalClosure, "")
-
-		err := c.Write(ctx, websocket.MessageText, []byte(`42["message","你好世界 🌍"]`))
+		// Send second binary attachment
+		err = c.Write(ctx, websocket.MessageBinary, []byte{4, 5, 6})
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		data, err := waitFor(ctx, c)
+		// Wait for 3 packets in response
+		packets, err := waitForPackets(ctx, c, 3)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if data != `42["message-back","你好世界 🌍"]` {
-			t.Fatalf("expected unicode message-back, got %s", data)
+		expectedText := `452-["message-back",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`
+		if packets[0].(string) != expectedText {
+			t.Fatalf("expected %s, got %s", expectedText, packets[0])
+		}
+
+		// Check binary data
+		binary1, ok := packets[1].([]byte)
+		if !ok {
+			t.Fatal("expected binary data")
+		}
+		if !bytes.Equal(binary1, []byte{1, 2, 3}) {
+			t.Fatalf("expected [1,2,3], got %v", binary1)
+		}
+
+		binary2, ok := packets[2].([]byte)
+		if !ok {
+			t.Fatal("expected binary data")
+		}
+		if !bytes.Equal(binary2, []byte{4, 5, 6}) {
+			t.Fatalf("expected [4,5,6], got %v", binary2)
 		}
 	})
 
-	t.Run("should handle multiple messages in quick succession", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Run("should send a plain-text packet with an ack", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 
 		c := initSocketIOConnection(t)
 		defer c.Close(websocket.StatusNormalClosure, "")
 
-		messageCount := 5
-		for i := 0; i < messageCount; i++ {
-			msg := fmt.Sprintf(`42["message","msg-%d"]`, i)
-			err := c.Write(ctx, websocket.MessageText, []byte(msg))
-			if err != nil {
-				t.Fatal(err)
-			}
+		err := c.Write(ctx, websocket.MessageText, []byte(`42456["message-with-ack",1,"2",{"3":[false]}]`))
+		if err != nil {
+			t.Fatal(err)
 		}
 
-		received := 0
-		for received < messageCount {
-			data, err := waitFor(ctx, c)
-			if err != nil {
-				t.Fatalf("failed reading message %d: %v", received, err)
-			}
-
-			if data == "2" {
-				// Ignore ping, send pong
-				c.Write(ctx, websocket.MessageText, []byte("3"))
-				continue
-			}
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-			expected := fmt.Sprintf(`42["message-back","msg-%d"]`, received)
-			if data != expected {
-				t.Fatalf("expected %s, got %s", expected, data)
-			}
-			received++
+		if p.String() != `43456[1,"2",{"3":[false]}]` {
+			t.Fatalf("expected ack response, got %s", p)
 		}
 	})
 
-	t.Run("should handle multiple ack IDs independently", func(t *testing.T) {
+	t.Run("should send a packet with binary attachments and an ack", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		c := initSocketIOConnection(t)
 		defer c.Close(websocket.StatusNormalClosure, "")
 
-		// Send two messages with different ack IDs
-		err := c.Write(ctx, websocket.MessageText, []byte(`42100["message-with-ack","first"]`))
+		// Send the message packet with ack
+		err := c.Write(ctx, websocket.MessageText, []byte(`452-789["message-with-ack",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		err = c.Write(ctx, websocket.MessageText, []byte(`42200["message-with-ack","second"]`))
+		// Send first binary attachment
+		err = c.Write(ctx, websocket.MessageBinary, []byte{1, 2, 3})
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		ackResponses := make(map[string]bool)
-		for len(ackResponses) < 2 {
-			data, err := waitFor(ctx, c)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			if data == "2" {
-				c.Write(ctx, websocket.MessageText, []byte("3"))
-				continue
-			}
-
-			if strings.HasPrefix(data, "43100") {
-				if data != `43100["first"]` {
-					t.Fatalf("expected ack 100 with 'first', got %s", data)
-				}
-				ackResponses["100"] = true
-			} else if strings.HasPrefix(data, "43200") {
-				if data != `43200["second"]` {
-					t.Fatalf("expected ack 200 with 'second', got %s", data)
-				}
-				ackResponses["200"] = true
-			}
-		}
-	})
-}
-
-func TestEngineIOSessionManagement(t *testing.T) {
-	t.Run("should reject polling with invalid session id", func(t *testing.T) {
-		resp, err := http.Get(URL + "/socket.io/?EIO=4&transport=polling&sid=invalid-session-id")
+		// Send second binary attachment
+		err = c.Write(ctx, websocket.MessageBinary, []byte{4, 5, 6})
 		if err != nil {
 			t.Fatal(err)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != 400 {
-			t.Fatalf("expected 400 for invalid sid, got %d", resp.StatusCode)
+		// Wait for 3 packets in response (ack + binary attachments)
+		packets, err := waitForPackets(ctx, c, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedText := `462-789[{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`
+		if packets[0].(string) != expectedText {
+			t.Fatalf("expected %s, got %s", expectedText, packets[0])
+		}
+
+		// Check binary data
+		binary1, ok := packets[1].([]byte)
+		if !ok {
+			t.Fatal("expected binary data")
+		}
+		if !bytes.Equal(binary1, []byte{1, 2, 3}) {
+			t.Fatalf("expected [1,2,3], got %v", binary1)
+		}
+
+		binary2, ok := packets[2].([]byte)
+		if !ok {
+			t.Fatal("expected binary data")
+		}
+		if !bytes.Equal(binary2, []byte{4, 5, 6}) {
+			t.Fatalf("expected [4,5,6], got %v", binary2)
 		}
 	})
 
-	t.Run("should reject WebSocket with invalid session id", func(t *testing.T) {
+	t.Run("should close the connection upon invalid format (unknown packet type)", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 
-		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket&sid=invalid-session-id", nil)
-		if c != nil {
-			defer c.Close(websocket.StatusNormalClosure, "")
-			// Should close quickly
-			for {
-				_, _, err := c.Read(ctx)
-				if err != nil {
-					break
-				}
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		err := c.Write(ctx, websocket.MessageText, []byte("4abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Wait for connection to close
+		for {
+			_, _, err := c.Read(ctx)
+			if err != nil {
+				// Connection closed as expected
+				break
 			}
 		}
+	})
+
+	t.Run("should close the connection upon invalid format (invalid payload format)", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		err := c.Write(ctx, websocket.MessageText, []byte("42{}"))
 		if err != nil {
-			// Connection rejected - expected
-			t.Logf("Connection correctly rejected: %v", err)
+			t.Fatal(err)
+		}
+
+		// Wait for connection to close
+		for {
+			_, _, err := c.Read(ctx)
+			if err != nil {
+				// Connection closed as expected
+				break
+			}
 		}
 	})
 
-	t.Run("should not allow duplicate polling on same session", func(t *testing.T) {
-		sid := initLongPollingSession(t)
+	t.Run("should close the connection upon invalid format (invalid ack id)", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
 
-		client := &http.Client{Timeout: 5 * time.Second}
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
 
-		// Start first poll (this will block waiting for server data)
-		done1 := make(chan *http.Response, 1)
-		go func() {
-			resp, _ := client.Get(fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid))
-			done1 <- resp
-		}()
+		err := c.Write(ctx, websocket.MessageText, []byte(`42abc["message-with-ack",1,"2",{"3":[false]}]`))
+		if err != nil {
+			t.Fatal(err)
+		}
 
-		// Small delay then start second (duplicate) poll
-		time.Sleep(50 * time.Millisecond)
-		done2 := make(chan *http.Response, 1)
-		go func() {
-			resp, _ := client.Get(fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid))
-			done2 <- resp
-		}()
+		// Wait for connection to close
+		for {
+			_, _, err := c.Read(ctx)
+			if err != nil {
+				// Connection closed as expected
+				break
+			}
+		}
+	})
+}
 
-		timeout := time.After(10 * time.Second)
-		var resp1, resp2 *http.Response
+// The query of the handshake request is exposed as Handshake().Query, each
+// key mapping to the list of its values, repeated keys included. It holds the
+// Engine.IO parameters of the handshake (EIO, transport and the t
+// cache-buster) but no sid, which is only assigned by the handshake.
+func TestSocketIOHandshakeQuery(t *testing.T) {
+	const query = "&foo=bar&arr=1&arr=2"
 
-		// Collect both responses with timeout
-		for i := 0; i < 2; i++ {
-			select {
-			case r := <-done1:
-				resp1 = r
-				if r != nil {
-					defer r.Body.Close()
-				}
-			case r := <-done2:
-				resp2 = r
-				if r != nil {
-					defer r.Body.Close()
-				}
-			case <-timeout:
-				// If we got at least one response, that's enough to validate
-				if resp1 != nil || resp2 != nil {
-					break
+	expectQuery := func(t *testing.T, args []any, transport string, extra map[string][]string) {
+		t.Helper()
+
+		if len(args) != 1 {
+			t.Fatalf("expected the query, got %v", args)
+		}
+		got, ok := args[0].(map[string]any)
+		if !ok {
+			t.Fatalf("expected an object, got %T", args[0])
+		}
+
+		want := map[string][]string{
+			"EIO":       {"4"},
+			"transport": {transport},
+			"foo":       {"bar"},
+			"arr":       {"1", "2"},
+		}
+		for key, values := range extra {
+			want[key] = values
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected keys %v, got %v", want, got)
+		}
+		for key, values := range want {
+			raw, ok := got[key].([]any)
+			if !ok || len(raw) != len(values) {
+				t.Fatalf("%s: expected %q, got %v", key, values, got[key])
+			}
+			for i, value := range values {
+				if raw[i] != value {
+					t.Fatalf("%s: expected %q, got %v", key, values, got[key])
 				}
-				t.Fatal("timed out waiting for poll responses")
 			}
 		}
+	}
 
-		// At least one response should indicate an error (400) for duplicate GET
-		if resp1 != nil && resp2 != nil {
-			if resp1.StatusCode != 400 && resp2.StatusCode != 400 {
-				t.Logf("resp1: %d, resp2: %d (expected at least one 400)", resp1.StatusCode, resp2.StatusCode)
+	t.Run("should expose the query of a websocket handshake", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := initSocketIOSessionWith(t, query, nil)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["query"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "query")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectQuery(t, args, "websocket", nil)
+	})
+
+	t.Run("should expose the query of a polling handshake", func(t *testing.T) {
+		sid := initLongPollingSessionWith(t, query+"&t=abc")
+		connectLongPollingSession(t, sid)
+
+		// The query of later requests, which carry the sid, is not reflected
+		resp, err := http.Post(pollingURL(sid)+"&foo=baz", "text/plain", strings.NewReader(`42["query"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 for push, got %d", resp.StatusCode)
+		}
+
+		expectQuery(t, pollForEvent(t, sid, "query"), "polling", map[string][]string{"t": {"abc"}})
+		push(t, sid, "1")
+	})
+}
+
+// The headers of the handshake request are exposed as Handshake().Headers,
+// keyed by their canonical name whatever the case they were sent with. They
+// are captured when the session opens, so headers of later polling requests
+// are not reflected.
+func TestSocketIOHandshakeHeaders(t *testing.T) {
+	expectHeaders := func(t *testing.T, args []any, want map[string]string) {
+		t.Helper()
+
+		if len(args) != 1 {
+			t.Fatalf("expected the headers, got %v", args)
+		}
+		got, ok := args[0].(map[string]any)
+		if !ok {
+			t.Fatalf("expected an object, got %T", args[0])
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		for name, value := range want {
+			values, ok := got[name].([]any)
+			if !ok || len(values) != 1 || values[0] != value {
+				t.Fatalf("%s: expected [%s], got %v", name, value, got[name])
 			}
-		} else {
-			// Only got one response - check if the server closed the session
-			got := resp1
-			if got == nil {
-				got = resp2
+		}
+	}
+
+	t.Run("should expose the headers of a websocket handshake", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := initSocketIOSessionWith(t, "", &websocket.DialOptions{
+			HTTPHeader: http.Header{
+				"x-test-id":  {"ws-1"},
+				"User-Agent": {"test-suite/1.0"},
+			},
+		})
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["headers"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "headers")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectHeaders(t, args, map[string]string{"X-Test-Id": "ws-1", "User-Agent": "test-suite/1.0"})
+	})
+
+	t.Run("should expose the headers of a polling handshake only", func(t *testing.T) {
+		sid := initLongPollingSessionWithHeader(t, http.Header{
+			"x-test-id":  {"poll-1"},
+			"User-Agent": {"test-suite/1.0"},
+		})
+		connectLongPollingSession(t, sid)
+
+		req, err := http.NewRequest(http.MethodPost, pollingURL(sid), strings.NewReader(`42["headers"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Test-Id", "late")
+		req.Header.Set("User-Agent", "late-agent/1.0")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 for push, got %d", resp.StatusCode)
+		}
+
+		expectHeaders(t, pollForEvent(t, sid, "headers"), map[string]string{"X-Test-Id": "poll-1", "User-Agent": "test-suite/1.0"})
+		push(t, sid, "1")
+	})
+}
+
+// The server has no option to trust proxy headers: Handshake().Address is
+// always the direct peer, and X-Forwarded-For is only available unparsed in
+// Handshake().Headers, multi-hop values included. An application behind a
+// load balancer has to read it from there.
+func TestSocketIOHandshakeAddress(t *testing.T) {
+	expectPeerAddress := func(t *testing.T, args []any) {
+		t.Helper()
+
+		if len(args) != 1 {
+			t.Fatalf("expected the address, got %v", args)
+		}
+		address, ok := args[0].(string)
+		if !ok {
+			t.Fatalf("expected a string, got %T", args[0])
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			t.Fatalf("invalid address %q: %v", address, err)
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			t.Fatalf("expected the loopback peer address, got %s", address)
+		}
+	}
+
+	for _, forwardedFor := range []string{"203.0.113.7", "203.0.113.7, 198.51.100.1, 198.51.100.2"} {
+		t.Run(fmt.Sprintf("should report the peer address over websocket with %q", forwardedFor), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			c, _ := initSocketIOSessionWith(t, "", &websocket.DialOptions{
+				HTTPHeader: http.Header{"X-Forwarded-For": {forwardedFor}},
+			})
+			defer c.Close(websocket.StatusNormalClosure, "")
+
+			if err := c.Write(ctx, websocket.MessageText, []byte(`42["address"]`)); err != nil {
+				t.Fatal(err)
 			}
-			if got != nil {
-				t.Logf("only one response received with status %d", got.StatusCode)
+			args, err := waitForEvent(ctx, c, "address")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectPeerAddress(t, args)
+
+			if err := c.Write(ctx, websocket.MessageText, []byte(`42["headers"]`)); err != nil {
+				t.Fatal(err)
+			}
+			args, err = waitForEvent(ctx, c, "headers")
+			if err != nil {
+				t.Fatal(err)
 			}
+			headers, _ := args[0].(map[string]any)
+			if values, ok := headers["X-Forwarded-For"].([]any); !ok || len(values) != 1 || values[0] != forwardedFor {
+				t.Fatalf("expected the raw X-Forwarded-For %q, got %v", forwardedFor, headers["X-Forwarded-For"])
+			}
+		})
+
+		t.Run(fmt.Sprintf("should report the peer address over polling with %q", forwardedFor), func(t *testing.T) {
+			sid := initLongPollingSessionWithHeader(t, http.Header{"X-Forwarded-For": {forwardedFor}})
+			connectLongPollingSession(t, sid)
+			push(t, sid, `42["address"]`)
+			expectPeerAddress(t, pollForEvent(t, sid, "address"))
+			push(t, sid, "1")
+		})
+	}
+}
+
+// The handshake is taken from the request that opened the session: the first
+// polling GET, or the websocket handshake.
+func TestSocketIOHandshakeInfo(t *testing.T) {
+	expectInfo := func(t *testing.T, args []any, before time.Time, transport string, secure, xdomain bool) {
+		t.Helper()
+
+		if len(args) != 1 {
+			t.Fatalf("expected the handshake info, got %v", args)
+		}
+		info, ok := args[0].(map[string]any)
+		if !ok {
+			t.Fatalf("expected an object, got %T", args[0])
+		}
+		url, _ := info["url"].(string)
+		if !strings.HasPrefix(url, "/socket.io/?") || !strings.Contains(url, "EIO=4") || !strings.Contains(url, "transport="+transport) {
+			t.Fatalf("expected the URL of the %s handshake, got %q", transport, url)
+		}
+		if info["secure"] != secure || info["xdomain"] != xdomain {
+			t.Fatalf("expected secure=%v and xdomain=%v, got %v", secure, xdomain, info)
+		}
+		issued, _ := info["issued"].(float64)
+		if at := time.UnixMilli(int64(issued)); at.Before(before.Truncate(time.Millisecond)) || at.After(time.Now()) {
+			t.Fatalf("expected issued between %v and now, got %v", before, at)
+		}
+		if _, err := time.Parse(time.RFC3339, fmt.Sprint(info["time"])); err != nil {
+			t.Fatalf("expected an RFC 3339 time, got %v", info["time"])
+		}
+		if address, _ := info["address"].(string); address == "" {
+			t.Fatalf("expected the peer address, got %v", info["address"])
+		}
+	}
+	handshakeInfo := func(t *testing.T, c *websocket.Conn) []any {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["handshake-info"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "handshake-info")
+		if err != nil {
+			t.Fatal(err)
 		}
+		return args
+	}
+
+	t.Run("should report a websocket handshake without origin", func(t *testing.T) {
+		before := time.Now()
+		c, _ := initSocketIOSessionWith(t, "", nil)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		expectInfo(t, handshakeInfo(t, c), before, "websocket", false, false)
+	})
+
+	// Xdomain only tells whether an Origin header was sent, so a same-origin
+	// page is reported as cross-domain too
+	for _, origin := range []string{"http://example.com", URL} {
+		t.Run(fmt.Sprintf("should report a websocket handshake from %s as cross-domain", origin), func(t *testing.T) {
+			before := time.Now()
+			c, _ := initSocketIOSessionWith(t, "", &websocket.DialOptions{
+				HTTPHeader: http.Header{"Origin": {origin}},
+			})
+			defer c.Close(websocket.StatusNormalClosure, "")
+
+			expectInfo(t, handshakeInfo(t, c), before, "websocket", false, true)
+		})
+	}
+
+	t.Run("should report a polling handshake", func(t *testing.T) {
+		before := time.Now()
+		sid := initLongPollingSessionWithHeader(t, http.Header{"Origin": {"http://example.com"}})
+		connectLongPollingSession(t, sid)
+		push(t, sid, `42["handshake-info"]`)
+		expectInfo(t, pollForEvent(t, sid, "handshake-info"), before, "polling", false, true)
+		push(t, sid, "1")
+	})
+
+	t.Run("should report a secure handshake over WSS", func(t *testing.T) {
+		cert, err := testserver.SelfSignedCertificate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr, testserver.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close(nil)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(cert.Leaf)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+		before := time.Now()
+		wsURL := WS_URL
+		WS_URL = "wss://" + addr
+		defer func() { WS_URL = wsURL }()
+		c, _ := initSocketIOSessionWith(t, "", &websocket.DialOptions{HTTPClient: client})
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		expectInfo(t, handshakeInfo(t, c), before, "websocket", true, false)
+	})
+}
+
+// The live stats run embedded, so that no socket of another test shows up.
+func TestLiveStats(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Two sockets of the main namespace in the room "lobby", and one of
+	// /custom, each over its own connection
+	a, sidA := initSocketIOSessionWith(t, "", nil)
+	defer a.Close(websocket.StatusNormalClosure, "")
+	b, sidB := initSocketIOSessionWith(t, "", nil)
+	defer b.Close(websocket.StatusNormalClosure, "")
+	for _, c := range []*websocket.Conn{a, b} {
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["join-room","lobby"]`)); err != nil {
+			t.Fatal(err)
+		}
+		// The rooms are listed once joined
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["my-rooms"]`)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := waitForEvent(ctx, c, "my-rooms"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	custom, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer custom.Close(websocket.StatusNormalClosure, "")
+	if p, err := waitForPacket(ctx, custom); err != nil || p.Type != eiop.Open {
+		t.Fatalf("expected an Engine.IO handshake, got %s (%v)", p, err)
+	}
+	if err := custom.Write(ctx, websocket.MessageText, []byte("40/custom,")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := waitForPacket(ctx, custom)
+	if err != nil || p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0/custom,")) {
+		t.Fatalf("expected a Socket.IO handshake, got %q (%v)", p, err)
+	}
+	var handshake struct {
+		Sid string `json:"sid"`
+	}
+	if err := json.Unmarshal(bytes.TrimPrefix(p.Data, []byte("0/custom,")), &handshake); err != nil {
+		t.Fatal(err)
+	}
+
+	ackID := 0
+	liveStats := func(t *testing.T, sidRooms bool) map[string]any {
+		t.Helper()
+
+		ackID++
+		if err := a.Write(ctx, websocket.MessageText, fmt.Appendf(nil, `42%d["live-stats",{"sidRooms":%t}]`, ackID, sidRooms)); err != nil {
+			t.Fatal(err)
+		}
+		prefix := fmt.Sprintf("3%d", ackID)
+		for {
+			p, err := waitForPacket(ctx, a)
+			if err != nil {
+				t.Fatalf("no live stats: %v", err)
+			}
+			if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte(prefix+"[")) {
+				continue
+			}
+			var reply []map[string]any
+			if err := json.Unmarshal(p.Data[len(prefix):], &reply); err != nil || len(reply) != 1 {
+				t.Fatalf("unexpected live stats %q (%v)", p, err)
+			}
+			if uptime, ok := reply[0]["uptime"].(float64); !ok || uptime <= 0 {
+				t.Fatalf("expected a positive uptime, got %v", reply[0]["uptime"])
+			}
+			delete(reply[0], "uptime")
+			return reply[0]
+		}
+	}
+	// snapshot returns the expected stats of the main namespace and /custom,
+	// the only namespaces of the default server which are not created on
+	// demand
+	snapshot := func(clients, main float64, mainRooms map[string]any, custom float64, customRooms map[string]any) map[string]any {
+		return map[string]any{
+			"clients": clients,
+			"namespaces": []any{
+				map[string]any{"name": "/", "sockets": main, "rooms": mainRooms},
+				map[string]any{"name": "/custom", "sockets": custom, "rooms": customRooms},
+			},
+		}
+	}
+
+	t.Run("should count the sockets of each namespace and room", func(t *testing.T) {
+		// The socket of /custom has no room but its own
+		expected := snapshot(3, 2, map[string]any{"lobby": float64(2)}, 1, map[string]any{})
+		if stats := liveStats(t, false); !reflect.DeepEqual(stats, expected) {
+			t.Fatalf("expected %v, got %v", expected, stats)
+		}
+	})
+
+	t.Run("should list the rooms of the socket ids on demand", func(t *testing.T) {
+		expected := snapshot(3,
+			2, map[string]any{"lobby": float64(2), sidA: float64(1), sidB: float64(1)},
+			1, map[string]any{handshake.Sid: float64(1)},
+		)
+		if stats := liveStats(t, true); !reflect.DeepEqual(stats, expected) {
+			t.Fatalf("expected %v, got %v", expected, stats)
+		}
+	})
+
+	t.Run("should reflect a closed socket", func(t *testing.T) {
+		if err := b.Write(ctx, websocket.MessageText, []byte("41")); err != nil {
+			t.Fatal(err)
+		}
+		b.Close(websocket.StatusNormalClosure, "")
+
+		// The engine counts the connection until its transport is closed
+		expected := snapshot(2, 1, map[string]any{"lobby": float64(1)}, 1, map[string]any{})
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stats := liveStats(t, false)
+			if reflect.DeepEqual(stats, expected) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %v, got %v", expected, stats)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+}
+
+// Connecting and disconnecting many clients in a row must neither break a
+// handshake nor leak sockets or goroutines on the server. The server runs
+// embedded, so that no other test's session skews the goroutine count, and
+// its goroutines can be told apart by their stacks.
+//
+// Known upstream leak (engine v3.0.1): the goroutine running the write queue
+// of a websocket transport is only stopped by the transport's DoClose, which
+// Close skips once the transport is marked closed. When the client closes the
+// connection first, the transport is marked closed on reading the close frame,
+// before the engine socket closes it, so every such connection leaves its
+// write queue goroutine behind, parked in queue.(*Queue).loop. The test
+// counts those apart, at most one per cycle, and requires every other
+// goroutine to be released.
+func TestSocketIOConnectionChurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping churn test in short mode")
+	}
+
+	const (
+		cycles  = 500
+		workers = 10
+	)
+
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// goroutines returns the number of goroutines of the process, and how
+	// many of them are parked write queues
+	goroutines := func() (total, queues int) {
+		buf := make([]byte, 1<<20)
+		for {
+			n := runtime.Stack(buf, true)
+			if n < len(buf) {
+				buf = buf[:n]
+				break
+			}
+			buf = make([]byte, 2*len(buf))
+		}
+		for _, g := range bytes.Split(buf, []byte("\n\n")) {
+			total++
+			if bytes.Contains(g, []byte("queue.(*Queue).loop(")) {
+				queues++
+			}
+		}
+		return total, queues
+	}
+
+	baseline, baselineQueues := goroutines()
+
+	// cycle connects to the main namespace, disconnects and returns the sid
+	cycle := func() (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+		if err != nil {
+			return "", err
+		}
+		defer c.CloseNow()
+
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			return "", err
+		}
+		var open map[string]any
+		if p.Type != eiop.Open || json.Unmarshal(p.Data, &open) != nil || open["sid"] == nil {
+			return "", fmt.Errorf("malformed Engine.IO handshake %q", p)
+		}
+
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			return "", err
+		}
+		p, err = waitForPacket(ctx, c)
+		if err != nil {
+			return "", err
+		}
+		var connect struct {
+			Sid string `json:"sid"`
+		}
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0")) || json.Unmarshal(p.Data[1:], &connect) != nil || connect.Sid == "" {
+			return "", fmt.Errorf("malformed Socket.IO handshake %q", p)
+		}
+
+		if err := c.Write(ctx, websocket.MessageText, []byte("41")); err != nil {
+			return "", err
+		}
+		return connect.Sid, c.Close(websocket.StatusNormalClosure, "")
+	}
+
+	jobs := make(chan int)
+	errs := make(chan error, cycles)
+	sids := make(chan string, cycles)
+	for range workers {
+		go func() {
+			for range jobs {
+				sid, err := cycle()
+				if err != nil {
+					errs <- err
+					continue
+				}
+				sids <- sid
+			}
+		}()
+	}
+	for i := range cycles {
+		jobs <- i
+	}
+	close(jobs)
+
+	churned := make(map[string]bool, cycles)
+	for range cycles {
+		select {
+		case err := <-errs:
+			t.Fatalf("cycle failed: %v", err)
+		case sid := <-sids:
+			if churned[sid] {
+				t.Fatalf("duplicate sid %s", sid)
+			}
+			churned[sid] = true
+		}
+	}
+
+	monitor := initSocketIOConnection(t)
+
+	// No churned socket is left on the server
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if err := monitor.Write(ctx, websocket.MessageText, []byte(`42["list-sockets"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, monitor, "sockets-list")
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, _ := args[0].([]any)
+		remaining := 0
+		for _, item := range list {
+			if entry, ok := item.(map[string]any); ok && churned[fmt.Sprint(entry["id"])] {
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d churned sockets are still connected", remaining)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	monitor.Close(websocket.StatusNormalClosure, "")
+
+	// Apart from the leaked write queues, the goroutine count returns to its
+	// baseline within a grace period
+	const slack = 20
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		total, queues := goroutines()
+		leaked := queues - baselineQueues
+		if leaked > cycles+1 {
+			t.Fatalf("expected at most one leaked write queue per connection, got %d", leaked)
+		}
+		if total-leaked <= baseline+slack {
+			if leaked > 0 {
+				t.Logf("known upstream leak: %d websocket write queue goroutines", leaked)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected at most %d goroutines besides %d leaked write queues, got %d", baseline+slack, leaked, total-leaked)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// A client that stops reading while the server floods it must not make the
+// server buffer without bound. The Go server keeps queueing writes but the
+// stalled client cannot answer PINGs, so it is disconnected by the heartbeat
+// and only receives, in order, the events that were flushed before the close.
+func TestSocketIOSlowReader(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping flood test in short mode")
+	}
+
+	const (
+		floodCount = 5000
+		stall      = 2 * time.Second
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	c, sid := initSocketIOSession(t)
+	defer c.CloseNow()
+	// Large enough for a single event, frames are never buffered as a whole
+	c.SetReadLimit(4096)
+
+	if err := c.Write(ctx, websocket.MessageText, []byte(`42["flood"]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(stall)
+
+	// Drain with a streaming counter, checking the order of each event
+	received := 0
+	var err error
+	for {
+		var p eiop.Packet
+		p, err = waitForPacket(ctx, c)
+		if err != nil {
+			break
+		}
+		args, ok := decodeEvent(p.String(), "seq")
+		if !ok {
+			continue
+		}
+		if len(args) != 2 {
+			t.Fatalf("malformed seq event %q", p)
+		}
+		if seq, ok := args[0].(float64); !ok || int(seq) != received {
+			t.Fatalf("expected seq %d, got %v", received, args[0])
+		}
+		if payload, ok := args[1].(string); !ok || len(payload) != 1000 {
+			t.Fatalf("seq %d: expected a 1000-byte payload", received)
+		}
+		received++
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("connection still open after %d events", received)
+	}
+	t.Logf("received %d of %d events before the close: %v", received, floodCount, err)
+
+	if reason := waitForDisconnectReason(t, sid); reason != "ping timeout" {
+		t.Fatalf("expected disconnect reason 'ping timeout', got %q", reason)
+	}
+}
+
+func TestSocketIOMultipleNamespaces(t *testing.T) {
+	t.Run("should connect to both main and custom namespace simultaneously", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		// Engine.IO handshake
+		_, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Connect to main namespace
+		err = c.Write(ctx, websocket.MessageText, []byte("40"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Socket.IO handshake for main namespace
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0")) {
+			t.Fatalf("expected message starting with '40', got %s", p)
+		}
+
+		// Auth packet for main namespace
+		_, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Connect to custom namespace
+		err = c.Write(ctx, websocket.MessageText, []byte("40/custom,"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Socket.IO handshake for custom namespace
+		p, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0/custom,")) {
+			t.Fatalf("expected message starting with '40/custom,', got %s", p)
+		}
+
+		// Auth packet for custom namespace
+		_, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Send message to main namespace
+		err = c.Write(ctx, websocket.MessageText, []byte(`42["message","hello from main"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.String() != `42["message-back","hello from main"]` {
+			t.Fatalf("expected message-back from main namespace, got %s", p)
+		}
+	})
+
+	t.Run("should disconnect from custom namespace without affecting main", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		// Engine.IO handshake
+		_, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Connect to main namespace
+		err = c.Write(ctx, websocket.MessageText, []byte("40"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Socket.IO handshake + auth for main
+		_, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Connect to custom namespace
+		err = c.Write(ctx, websocket.MessageText, []byte("40/custom,"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Socket.IO handshake + auth for custom
+		_, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Disconnect from custom namespace
+		err = c.Write(ctx, websocket.MessageText, []byte("41/custom,"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Main namespace should still work
+		err = c.Write(ctx, websocket.MessageText, []byte(`42["message","still connected"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Should be either a ping or the message-back
+		if p.Type == eiop.Ping {
+			// It was a ping, respond and wait for actual data
+			err = c.Write(ctx, websocket.MessageText, []byte("3"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err = waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if p.String() != `42["message-back","still connected"]` {
+			t.Fatalf("expected message-back from main namespace, got %s", p)
+		}
+	})
+
+	t.Run("should route packets across many dynamic namespaces", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		const count = 50
+		nsp := func(i int) string { return fmt.Sprintf("/dynamic-%d", i) }
+
+		// next returns the next packet, answering PINGs, split into its type
+		// and namespace
+		next := func() (string, string, string) {
+			for {
+				p, err := waitForPacket(ctx, c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.Type == eiop.Ping {
+					c.Write(ctx, websocket.MessageText, []byte("3"))
+					continue
+				}
+				// The Socket.IO packet, e.g. 40/dynamic-1,{...}
+				packet := p.String()
+				name, payload, ok := strings.Cut(packet[min(len(packet), 2):], ",")
+				if p.Type != eiop.Message || !strings.HasPrefix(name, "/dynamic-") || !ok {
+					t.Fatalf("expected a packet from a dynamic namespace, got %q", p)
+				}
+				return packet[:2], name, payload
+			}
+		}
+
+		for i := 1; i <= count; i++ {
+			if err := c.Write(ctx, websocket.MessageText, []byte("40"+nsp(i)+",")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Each namespace answers with its own sid, followed by its auth echo
+		sids := make(map[string]string, count)
+		seen := make(map[string]bool, count)
+		authed := make(map[string]bool, count)
+		for len(sids) < count || len(authed) < count {
+			kind, name, payload := next()
+			switch kind {
+			case "40":
+				var connect struct {
+					Sid string `json:"sid"`
+				}
+				if err := json.Unmarshal([]byte(payload), &connect); err != nil || connect.Sid == "" {
+					t.Fatalf("malformed handshake for %s: %q", name, payload)
+				}
+				if _, ok := sids[name]; ok {
+					t.Fatalf("duplicate handshake for %s", name)
+				}
+				if seen[connect.Sid] {
+					t.Fatalf("sid %s was reused by %s", connect.Sid, name)
+				}
+				sids[name] = connect.Sid
+				seen[connect.Sid] = true
+			case "42":
+				if _, ok := sids[name]; !ok {
+					t.Fatalf("auth echo for %s arrived before its handshake", name)
+				}
+				if payload != `["auth",{}]` {
+					t.Fatalf("expected the auth echo for %s, got %s", name, payload)
+				}
+				authed[name] = true
+			default:
+				t.Fatalf("unexpected packet type %s for %s", kind, name)
+			}
+		}
+		for i := 1; i <= count; i++ {
+			if _, ok := sids[nsp(i)]; !ok {
+				t.Fatalf("no handshake for %s", nsp(i))
+			}
+		}
+
+		// expectRouting sends one message per namespace in a shuffled order
+		// and expects each echo back on the namespace it was sent to
+		expectRouting := func(active []int) {
+			t.Helper()
+
+			rand.Shuffle(len(active), func(i, j int) { active[i], active[j] = active[j], active[i] })
+			for _, i := range active {
+				packet := fmt.Sprintf(`42%s,["message",%d]`, nsp(i), i)
+				if err := c.Write(ctx, websocket.MessageText, []byte(packet)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pending := make(map[string]string, len(active))
+			for _, i := range active {
+				pending[nsp(i)] = fmt.Sprintf(`["message-back",%d]`, i)
+			}
+			for len(pending) > 0 {
+				kind, name, payload := next()
+				expected, ok := pending[name]
+				if kind != "42" || !ok {
+					t.Fatalf("unexpected packet %s%s,%s", kind, name, payload)
+				}
+				if payload != expected {
+					t.Fatalf("expected %s on %s, got %s", expected, name, payload)
+				}
+				delete(pending, name)
+			}
+		}
+
+		all := make([]int, 0, count)
+		for i := 1; i <= count; i++ {
+			all = append(all, i)
+		}
+		expectRouting(all)
+
+		// Leave the odd namespaces, the even ones keep routing
+		remaining := make([]int, 0, count/2)
+		for i := 1; i <= count; i++ {
+			if i%2 == 1 {
+				if err := c.Write(ctx, websocket.MessageText, []byte("41"+nsp(i)+",")); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			remaining = append(remaining, i)
+		}
+		expectRouting(remaining)
+	})
+}
+
+func TestEngineIOPayloadLimits(t *testing.T) {
+	t.Run("should reject a payload that exceeds maxHttpBufferSize via HTTP", func(t *testing.T) {
+		sid := initLongPollingSession(t)
+
+		// maxHttpBufferSize is 1000000, send a larger payload
+		largePayload := strings.Repeat("a", 1000001)
+
+		resp, err := http.Post(
+			fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid),
+			"text/plain",
+			strings.NewReader("4"+largePayload),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		// 413 Payload Too Large is the correct status for oversized payloads
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected 413 for oversized payload, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("should accept a payload within maxHttpBufferSize via HTTP", func(t *testing.T) {
+		sid := initLongPollingSession(t)
+
+		// Follow the established heartbeat pattern: GET returns ping, POST sends pong
+		pollResp, err := http.Get(fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pollBody, _ := io.ReadAll(pollResp.Body)
+		pollResp.Body.Close()
+
+		if pollResp.StatusCode != 200 {
+			t.Fatalf("expected 200 for poll, got %d (body: %s)", pollResp.StatusCode, string(pollBody))
+		}
+
+		// Send a valid pong response (engine.io packet type 3)
+		resp, err := http.Post(
+			fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid),
+			"text/plain",
+			strings.NewReader("3"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected 200 for valid payload, got %d", resp.StatusCode)
+		}
+	})
+}
+
+// smallBufferSession opens a polling session on the small-buffer server,
+// connects it to the main namespace and returns its polling URL.
+func smallBufferSession(t *testing.T) string {
+	t.Helper()
+
+	resp, err := http.Get(SMALL_BUFFER_URL + "/socket.io/?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handshake struct {
+		Sid        string `json:"sid"`
+		MaxPayload int    `json:"maxPayload"`
+	}
+	decodeOpen(t, body, &handshake)
+	if handshake.MaxPayload != SMALL_BUFFER_SIZE {
+		t.Fatalf("expected maxPayload %d, got %d", SMALL_BUFFER_SIZE, handshake.MaxPayload)
+	}
+
+	url := fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", SMALL_BUFFER_URL, handshake.Sid)
+	if status := postPayload(t, url, "40", false); status != http.StatusOK {
+		t.Fatalf("expected 200 for CONNECT, got %d", status)
+	}
+	if packets := pollUntil(t, url, `42["auth",`); !strings.HasPrefix(packets[0], "40") {
+		t.Fatalf("expected socket.io handshake first, got %q", packets)
+	}
+
+	return url
+}
+
+// postPayload sends body in a single POST and returns the status code. When
+// chunked is set, the body is sent without a Content-Length header.
+func postPayload(t *testing.T, url string, body string, chunked bool) int {
+	t.Helper()
+
+	var reader io.Reader = strings.NewReader(body)
+	if chunked {
+		// Hide the length so that the transfer is chunked
+		reader = io.MultiReader(reader)
+	}
+
+	resp, err := http.Post(url, "text/plain", reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+// pollUntil polls url, answering pings, until a packet with the given prefix
+// is received, and returns every non-ping packet received so far.
+func pollUntil(t *testing.T, url string, prefix string) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	var packets []string
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 for poll, got %d (body: %s)", resp.StatusCode, body)
+		}
+
+		payload, err := eiop.DecodePayload(body)
+		if err != nil {
+			t.Fatalf("invalid payload %q: %v", body, err)
+		}
+		for _, p := range payload {
+			if p.Type == eiop.Ping {
+				postPayload(t, url, "3", false)
+				continue
+			}
+			packet := p.String()
+			packets = append(packets, packet)
+			if strings.HasPrefix(packet, prefix) {
+				return packets
+			}
+		}
+	}
+
+	t.Fatalf("timed out waiting for %q, got %q", prefix, packets)
+	return nil
+}
+
+// messageBatch builds a polling payload of count "message" events. The
+// padding of event i is padding(i) bytes long.
+func messageBatch(count int, padding func(i int) int) string {
+	packets := make([]eiop.Packet, count)
+	for i := range packets {
+		packets[i] = eiop.Packet{Type: eiop.Message, Data: fmt.Appendf(nil, `2["message",%d,"%s"]`, i, strings.Repeat("x", padding(i)))}
+	}
+	return string(eiop.EncodePayload(packets))
+}
+
+// echoesBefore sends a marker event in its own POST and returns the
+// "message-back" echoes received before the marker's echo. The server
+// processes the packets of a socket in order, so the echoes of any packet
+// accepted earlier arrive before the marker.
+func echoesBefore(t *testing.T, url string) [][]any {
+	t.Helper()
+
+	if status := postPayload(t, url, `42["message","marker"]`, false); status != http.StatusOK {
+		t.Fatalf("expected 200 for the marker, got %d", status)
+	}
+
+	var echoes [][]any
+	for _, packet := range pollUntil(t, url, `42["message-back","marker"]`) {
+		if args, ok := decodeEvent(packet, "message-back"); ok {
+			echoes = append(echoes, args)
+		}
+	}
+	return echoes[:len(echoes)-1]
+}
+
+// expectEchoes asserts that the echoes are those of the count events of a
+// messageBatch, in order and with their padding intact.
+func expectEchoes(t *testing.T, echoes [][]any, count int, padding func(i int) int) {
+	t.Helper()
+
+	if len(echoes) != count {
+		t.Fatalf("expected %d echoes, got %d", count, len(echoes))
+	}
+	for i, echo := range echoes {
+		if len(echo) != 2 {
+			t.Fatalf("echo %d: expected 2 arguments, got %v", i, echo)
+		}
+		if n, ok := echo[0].(float64); !ok || int(n) != i {
+			t.Fatalf("echo %d: out of order, got %v", i, echo[0])
+		}
+		if pad, ok := echo[1].(string); !ok || len(pad) != padding(i) {
+			t.Fatalf("echo %d: expected %d bytes of padding, got %v", i, padding(i), echo[1])
+		}
+	}
+}
+
+// The small-buffer server only enforces maxHttpBufferSize on the whole POST
+// body. A body over the limit is rejected with 413 and none of its packets are
+// processed, whether or not it carries a Content-Length header. A body within
+// the limit is processed entirely, however large its individual packets are.
+// In both cases the session stays usable.
+func TestEngineIOPayloadLimitsBatching(t *testing.T) {
+	small := func(int) int { return 50 }
+
+	t.Run("should reject a batch of small packets exceeding the limit", func(t *testing.T) {
+		url := smallBufferSession(t)
+
+		body := messageBatch(200, small)
+		if len(body) <= SMALL_BUFFER_SIZE {
+			t.Fatalf("expected a body over %d bytes, got %d", SMALL_BUFFER_SIZE, len(body))
+		}
+
+		if status := postPayload(t, url, body, false); status != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected 413, got %d", status)
+		}
+		expectEchoes(t, echoesBefore(t, url), 0, small)
+	})
+
+	t.Run("should reject a chunked batch exceeding the limit", func(t *testing.T) {
+		url := smallBufferSession(t)
+
+		if status := postPayload(t, url, messageBatch(200, small), true); status != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected 413, got %d", status)
+		}
+		expectEchoes(t, echoesBefore(t, url), 0, small)
+	})
+
+	t.Run("should process every packet of a batch with one oversized packet", func(t *testing.T) {
+		url := smallBufferSession(t)
+
+		// The middle packet takes most of the budget on its own
+		padding := func(i int) int {
+			if i == 2 {
+				return 9000
+			}
+			return 50
+		}
+		body := messageBatch(5, padding)
+		if len(body) > SMALL_BUFFER_SIZE {
+			t.Fatalf("expected a body within %d bytes, got %d", SMALL_BUFFER_SIZE, len(body))
+		}
+
+		if status := postPayload(t, url, body, false); status != http.StatusOK {
+			t.Fatalf("expected 200, got %d", status)
+		}
+		expectEchoes(t, echoesBefore(t, url), 5, padding)
+	})
+
+	t.Run("should accept a batch exactly at the limit", func(t *testing.T) {
+		url := smallBufferSession(t)
+
+		base := len(messageBatch(10, func(int) int { return 0 }))
+		padding := func(i int) int {
+			if i == 9 {
+				return SMALL_BUFFER_SIZE - base
+			}
+			return 0
+		}
+		body := messageBatch(10, padding)
+		if len(body) != SMALL_BUFFER_SIZE {
+			t.Fatalf("expected a body of %d bytes, got %d", SMALL_BUFFER_SIZE, len(body))
+		}
+
+		if status := postPayload(t, url, body, false); status != http.StatusOK {
+			t.Fatalf("expected 200, got %d", status)
+		}
+		expectEchoes(t, echoesBefore(t, url), 10, padding)
+	})
+
+	t.Run("should reject a batch one byte over the limit", func(t *testing.T) {
+		for _, chunked := range []bool{false, true} {
+			url := smallBufferSession(t)
+
+			base := len(messageBatch(10, func(int) int { return 0 }))
+			padding := func(i int) int {
+				if i == 9 {
+					return SMALL_BUFFER_SIZE - base + 1
+				}
+				return 0
+			}
+
+			if status := postPayload(t, url, messageBatch(10, padding), chunked); status != http.StatusRequestEntityTooLarge {
+				t.Fatalf("chunked=%t: expected 413, got %d", chunked, status)
+			}
+			expectEchoes(t, echoesBefore(t, url), 0, padding)
+		}
+	})
+}
+
+// observeBroadcasts connects an observer to the main namespace and signals
+// each "broadcast-ack-request" it receives, that is each "broadcast-with-ack"
+// event processed by the server. The observer is closed with the test.
+func observeBroadcasts(ctx context.Context, t *testing.T) <-chan struct{} {
+	t.Helper()
+
+	observer := initSocketIOConnection(t)
+	t.Cleanup(func() { observer.Close(websocket.StatusNormalClosure, "") })
+
+	requests := make(chan struct{}, 16)
+	go func() {
+		for {
+			p, err := waitForPacket(ctx, observer)
+			if err != nil {
+				return
+			}
+			if p.Type == eiop.Ping {
+				observer.Write(ctx, websocket.MessageText, []byte("3"))
+				continue
+			}
+			if strings.Contains(p.String(), `["broadcast-ack-request"]`) {
+				requests <- struct{}{}
+			}
+		}
+	}()
+
+	return requests
+}
+
+// A polling POST may batch several packets, which are processed in order,
+// once the namespace they are sent to is connected. A malformed packet in a
+// batch closes the session: the packets before it are processed, those after
+// it are dropped, and the POST fails with 429. The close is then only
+// completed by the heartbeat, so the session is gone once pingInterval +
+// pingTimeout have elapsed.
+//
+// Known divergence: the JavaScript server also processes the events batched
+// right behind the CONNECT packet, in order. The Go port joins the namespace
+// asynchronously, so the events reach a namespace the client is not connected
+// to yet, which closes the session the same way as a malformed packet. The
+// subtest below pins this behaviour of the Go port, it is not what the
+// protocol asks for.
+func TestEngineIOPollingBatching(t *testing.T) {
+	// expectSessionExpired waits for the heartbeat to expire the session.
+	expectSessionExpired := func(t *testing.T, sid string) {
+		t.Helper()
+
+		time.Sleep((PING_INTERVAL + PING_TIMEOUT + 100) * time.Millisecond)
+		if status := postPayload(t, pollingURL(sid), "3", false); status != http.StatusBadRequest {
+			t.Fatalf("expected 400 once the session expired, got %d", status)
+		}
+	}
+
+	t.Run("should process the packets of a batch in order", func(t *testing.T) {
+		// The batch is sent once the CONNECT has been acknowledged
+		sid, _ := initLongPollingSocketIOSession(t)
+
+		push(t, sid, `42["message","a"]`, `42456["message-with-ack","b"]`, `42["message","c"]`)
+
+		var got []string
+		for _, packet := range pollUntil(t, pollingURL(sid), `42["message-back","c"]`) {
+			if strings.HasPrefix(packet, "42") || strings.HasPrefix(packet, "43") {
+				got = append(got, packet)
+			}
+		}
+
+		want := []string{`42["message-back","a"]`, `43456["b"]`, `42["message-back","c"]`}
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("diverges from the JavaScript server: rejects events batched with the CONNECT packet", func(t *testing.T) {
+		sid := initLongPollingSession(t)
+
+		body := strings.Join([]string{"40", `42["message","a"]`, `42456["message-with-ack","b"]`}, string(eiop.Separator))
+		if status := postPayload(t, pollingURL(sid), body, false); status != http.StatusTooManyRequests {
+			t.Fatalf("expected 429, got %d", status)
+		}
+
+		expectSessionExpired(t, sid)
+	})
+
+	t.Run("should drop the packets following a malformed one", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		requests := observeBroadcasts(ctx, t)
+
+		sid, _ := initLongPollingSocketIOSession(t)
+
+		body := strings.Join([]string{`42["broadcast-with-ack",50]`, `42["message",`, `42["broadcast-with-ack",50]`}, string(eiop.Separator))
+		if status := postPayload(t, pollingURL(sid), body, false); status != http.StatusTooManyRequests {
+			t.Fatalf("expected 429, got %d", status)
+		}
+
+		select {
+		case <-requests:
+		case <-time.After(time.Second):
+			t.Fatal("expected the packet before the malformed one to be processed")
+		}
+		select {
+		case <-requests:
+			t.Fatal("expected the packet after the malformed one to be dropped")
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		expectSessionExpired(t, sid)
+	})
+}
+
+// A client that stops polling cannot receive pings, so its session expires
+// pingInterval + pingTimeout after the handshake unless a POST answers them.
+// A POST racing the expiry is answered consistently and never hangs: a 200
+// means its packets were processed by the engine, and a 400 Session ID unknown
+// that they were not. A Socket.IO event accepted at the very moment the
+// session expires is dispatched after the socket disconnected and discarded,
+// so the event checks stay clear of the boundary.
+//
+// The heartbeat is scaled by TEST_TIMING_SCALE, e.g. 4 on a loaded machine
+// where the default 500ms window is too tight to straddle reliably, against an
+// embedded server running with the scaled heartbeat.
+func TestEngineIOPingTimeoutDuringPost(t *testing.T) {
+	scale := timingScale(t)
+	interval := time.Duration(float64(PING_INTERVAL*time.Millisecond) * scale)
+	timeout := time.Duration(float64(PING_TIMEOUT*time.Millisecond) * scale)
+	if scale != 1 {
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr, testserver.WithPingInterval(interval), testserver.WithPingTimeout(timeout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close(nil)
+		useServer(t, addr)
+	}
+	heartbeat := interval + timeout
+	client := &http.Client{Timeout: max(time.Second, 2*heartbeat)}
+
+	// postAt sends body on a fresh session once the given fraction of the
+	// heartbeat window has elapsed since the handshake.
+	postAt := func(t *testing.T, sid string, start time.Time, at float64, body string) (int, string) {
+		t.Helper()
+
+		time.Sleep(time.Until(start.Add(time.Duration(float64(heartbeat) * at))))
+
+		resp, err := client.Post(pollingURL(sid), "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST at %v: %v", time.Since(start), err)
+		}
+		defer resp.Body.Close()
+
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	// The PONG is sent at these fractions of the heartbeat window, straddling
+	// the expiry several times
+	for _, at := range []float64{0.9, 0.95, 0.98, 1, 1.02, 1.05, 1.1} {
+		t.Run(fmt.Sprintf("should answer a PONG consistently at %.2f of the heartbeat", at), func(t *testing.T) {
+			start := time.Now()
+			sid := initLongPollingSession(t)
+
+			status, body := postAt(t, sid, start, at, "3")
+			switch status {
+			case http.StatusOK:
+				// The PONG was processed, so the session is still alive and
+				// the pending PING can be polled
+				resp, err := client.Get(pollingURL(sid))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("expected the session to survive the accepted PONG, got %d", resp.StatusCode)
+				}
+			case http.StatusBadRequest:
+				if !strings.Contains(body, "Session ID unknown") {
+					t.Fatalf("expected Session ID unknown, got %s", body)
+				}
+			default:
+				t.Fatalf("expected 200 or 400, got %d (body: %s)", status, body)
+			}
+		})
+	}
+
+	t.Run("should process an event posted before the expiry", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		requests := observeBroadcasts(ctx, t)
+
+		start := time.Now()
+		sid := initLongPollingSession(t)
+		push(t, sid, "40")
+
+		if status, body := postAt(t, sid, start, 0.8, `42["broadcast-with-ack",50]`); status != http.StatusOK {
+			t.Fatalf("expected 200, got %d (body: %s)", status, body)
+		}
+
+		select {
+		case <-requests:
+		case <-time.After(max(time.Second, heartbeat)):
+			t.Fatal("expected the accepted event to be processed")
+		}
+	})
+
+	t.Run("should reject an event posted after the expiry", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		requests := observeBroadcasts(ctx, t)
+
+		start := time.Now()
+		sid := initLongPollingSession(t)
+		push(t, sid, "40")
+
+		status, body := postAt(t, sid, start, 1.2, `42["broadcast-with-ack",50]`)
+		if status != http.StatusBadRequest || !strings.Contains(body, "Session ID unknown") {
+			t.Fatalf("expected 400 Session ID unknown, got %d (body: %s)", status, body)
+		}
+
+		select {
+		case <-requests:
+			t.Fatal("expected the rejected event not to be processed")
+		case <-time.After(time.Duration(float64(200*time.Millisecond) * scale)):
+		}
+	})
+}
+
+// Over websocket, each frame carries exactly one Engine.IO packet and the
+// record separator has no meaning. A frame starting with a non-message packet
+// and batching more after it is invalid and closes the connection. A message
+// frame is decoded as a single packet: what follows the first Socket.IO
+// packet, be it another batched packet or garbage, is ignored and the
+// connection stays open.
+// rawConn is a websocket connection driven frame by frame, to produce what the
+// websocket client refuses to send.
+type rawConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Websocket opcodes used by rawConn
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+)
+
+// dialRaw performs the websocket handshake by hand and returns the connection
+// right after the Engine.IO handshake.
+func dialRaw(t *testing.T) *rawConn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := make([]byte, 16)
+	crand.Read(key)
+	req, err := http.NewRequest(http.MethodGet, URL+"/socket.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &rawConn{Conn: conn, r: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status 101, got %d", resp.StatusCode)
+	}
+
+	if data := c.readText(t); !strings.HasPrefix(data, "0{") {
+		t.Fatalf("expected an Engine.IO handshake, got %q", data)
+	}
+	return c
+}
+
+// dialRawSocketIO returns a raw connection to the main namespace.
+func dialRawSocketIO(t *testing.T) *rawConn {
+	t.Helper()
+
+	c := dialRaw(t)
+	c.writeFrame(t, true, opText, []byte("40"))
+	if data := c.readText(t); !strings.HasPrefix(data, "40{") {
+		t.Fatalf("expected a Socket.IO handshake, got %q", data)
+	}
+	if data := c.readText(t); data != `42["auth",{}]` {
+		t.Fatalf("expected the auth echo, got %q", data)
+	}
+	return c
+}
+
+// rawFrame encodes a masked client frame.
+func rawFrame(fin bool, opcode byte, payload []byte) []byte {
+	header := opcode
+	if fin {
+		header |= 0x80
+	}
+	frame := []byte{header}
+
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	mask := make([]byte, 4)
+	crand.Read(mask)
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func (c *rawConn) writeFrame(t *testing.T, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+
+	if _, err := c.Write(rawFrame(fin, opcode, payload)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFrame reads a single unmasked server frame.
+func (c *rawConn) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return 0, nil, err
+	}
+
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.r, ext); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.r, ext); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0] & 0x0f, payload, nil
+}
+
+// isPing reports whether payload, the payload of a text frame, is an
+// Engine.IO PING packet.
+func isPing(payload []byte) bool {
+	p, err := eiop.Decode(payload, false)
+	return err == nil && p.Type == eiop.Ping
+}
+
+// readText returns the next text message, answering PINGs.
+func (c *rawConn) readText(t *testing.T) string {
+	t.Helper()
+
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opcode != opText {
+			t.Fatalf("expected a text frame, got opcode %d", opcode)
+		}
+		if isPing(payload) {
+			c.writeFrame(t, true, opText, []byte("3"))
+			continue
+		}
+		return string(payload)
+	}
+}
+
+func TestEngineIOWebSocketFraming(t *testing.T) {
+	// expectEcho sends a "message" event and waits for its echo.
+	expectEcho := func(ctx context.Context, t *testing.T, c *websocket.Conn, frame string, want float64) {
+		t.Helper()
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "message-back")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(args) != 1 || args[0] != want {
+			t.Fatalf("expected message-back %v, got %v", want, args)
+		}
+	}
+
+	t.Run("should close the connection on a frame batching several packets", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, sid := initSocketIOSession(t)
+		defer c.CloseNow()
+
+		if err := c.Write(ctx, websocket.MessageText, []byte("2\x1e42[\"message\",1]")); err != nil {
+			t.Fatal(err)
+		}
+
+		// expectClose fails on the "message-back" echo of the embedded event
+		expectClose(ctx, t, c)
+
+		if reason := waitForDisconnectReason(t, sid); reason != "transport error" {
+			t.Fatalf("expected 'transport error', got %s", reason)
+		}
+	})
+
+	t.Run("should only process the first packet of a message frame", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		expectEcho(ctx, t, c, "42[\"message\",1]\x1e42[\"message\",2]", 1)
+
+		// The echo of 2 would arrive first had it been processed
+		expectEcho(ctx, t, c, `42["message",3]`, 3)
+	})
+
+	t.Run("should ignore trailing garbage after a valid packet", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		expectEcho(ctx, t, c, `42["message",1]garbage`, 1)
+		expectEcho(ctx, t, c, `42["message",2]`, 2)
+	})
+
+	t.Run("should reassemble a frame split across TCP writes", func(t *testing.T) {
+		c := dialRawSocketIO(t)
+
+		frame := rawFrame(true, opText, []byte(`42["message","hello"]`))
+		for chunk := range slices.Chunk(frame, 3) {
+			if _, err := c.Write(chunk); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if data := c.readText(t); data != `42["message-back","hello"]` {
+			t.Fatalf("expected the echo of the split frame, got %q", data)
+		}
+	})
+
+	t.Run("should reassemble a message sent as continuation frames", func(t *testing.T) {
+		c := dialRawSocketIO(t)
+
+		c.writeFrame(t, false, opText, []byte(`42["message",`))
+		time.Sleep(10 * time.Millisecond)
+		c.writeFrame(t, true, opContinuation, []byte(`"hello"]`))
+
+		if data := c.readText(t); data != `42["message-back","hello"]` {
+			t.Fatalf("expected the echo of the fragmented message, got %q", data)
+		}
+	})
+
+	// Known divergence: RFC 6455 requires the connection to be failed with
+	// close code 1007 on a text frame that is not valid UTF-8, and the
+	// corrupted event never to be delivered. The Go port sends no close frame
+	// at all: neither gorilla/websocket nor the engine validate text frames,
+	// and the JSON decoder of the parser replaces each invalid byte with
+	// U+FFFD, so the event reaches the handler altered and the session stays
+	// open. The subtest pins this behaviour of the Go port, it is not what the
+	// RFC asks for.
+	t.Run("diverges from RFC 6455: delivers a text frame with invalid UTF-8 with U+FFFD replacements", func(t *testing.T) {
+		c := dialRawSocketIO(t)
+
+		c.writeFrame(t, true, opText, []byte("42[\"message\",\"a\xff\xfeb\"]"))
+
+		opcode, payload, err := c.readFrame()
+		for err == nil && opcode == opText && isPing(payload) {
+			c.writeFrame(t, true, opText, []byte("3"))
+			opcode, payload, err = c.readFrame()
+		}
+		if err != nil {
+			t.Fatalf("expected the altered echo: %v", err)
+		}
+		if opcode == opClose {
+			t.Fatalf("the server now fails the connection, with close payload %q: update the test", payload)
+		}
+		if data := string(payload); opcode != opText || data != "42[\"message-back\",\"a\uFFFD\uFFFDb\"]" {
+			t.Fatalf("expected the echo with U+FFFD replacements, got opcode %d: %q", opcode, payload)
+		}
+
+		// The connection stays open
+		c.writeFrame(t, true, opText, []byte(`42["message","c"]`))
+		if data := c.readText(t); data != `42["message-back","c"]` {
+			t.Fatalf("expected the session to survive, got %q", data)
+		}
+	})
+
+	t.Run("should accept the same bytes as a binary attachment", func(t *testing.T) {
+		c := dialRawSocketIO(t)
+
+		attachment := []byte("a\xff\xfeb")
+		c.writeFrame(t, true, opText, []byte(`451-["message",{"_placeholder":true,"num":0}]`))
+		c.writeFrame(t, true, opBinary, attachment)
+
+		if data := c.readText(t); data != `451-["message-back",{"_placeholder":true,"num":0}]` {
+			t.Fatalf("expected a binary event header, got %q", data)
+		}
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opcode != opBinary || !bytes.Equal(payload, attachment) {
+			t.Fatalf("expected the attachment back, got opcode %d: %q", opcode, payload)
+		}
+	})
+}
+
+func TestSocketIOMessageEdgeCases(t *testing.T) {
+	t.Run("should handle empty string message", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		err := c.Write(ctx, websocket.MessageText, []byte(`42["message",""]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.String() != `42["message-back",""]` {
+			t.Fatalf("expected empty message-back, got %s", p)
+		}
+	})
+
+	t.Run("should handle message with special characters", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		err := c.Write(ctx, websocket.MessageText, []byte(`42["message","hello\nworld\t\"quoted\""]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte(`2["message-back",`)) {
+			t.Fatalf("expected message-back with special chars, got %s", p)
+		}
+	})
+
+	t.Run("should handle message with unicode", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		err := c.Write(ctx, websocket.MessageText, []byte(`42["message","你好世界 🌍"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.String() != `42["message-back","你好世界 🌍"]` {
+			t.Fatalf("expected unicode message-back, got %s", p)
+		}
+	})
+
+	t.Run("should handle multiple messages in quick succession", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		messageCount := 5
+		for i := 0; i < messageCount; i++ {
+			msg := fmt.Sprintf(`42["message","msg-%d"]`, i)
+			err := c.Write(ctx, websocket.MessageText, []byte(msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		received := 0
+		for received < messageCount {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatalf("failed reading message %d: %v", received, err)
+			}
+
+			if p.Type == eiop.Ping {
+				// Ignore ping, send pong
+				c.Write(ctx, websocket.MessageText, []byte("3"))
+				continue
+			}
+
+			expected := fmt.Sprintf(`42["message-back","msg-%d"]`, received)
+			if p.String() != expected {
+				t.Fatalf("expected %s, got %s", expected, p)
+			}
+			received++
+		}
+	})
+
+	t.Run("should handle multiple ack IDs independently", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		// Send two messages with different ack IDs
+		err := c.Write(ctx, websocket.MessageText, []byte(`42100["message-with-ack","first"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.Write(ctx, websocket.MessageText, []byte(`42200["message-with-ack","second"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ackResponses := make(map[string]bool)
+		for len(ackResponses) < 2 {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Type == eiop.Ping {
+				c.Write(ctx, websocket.MessageText, []byte("3"))
+				continue
+			}
+
+			if p.Type == eiop.Message && bytes.HasPrefix(p.Data, []byte("3100")) {
+				if p.String() != `43100["first"]` {
+					t.Fatalf("expected ack 100 with 'first', got %s", p)
+				}
+				ackResponses["100"] = true
+			} else if p.Type == eiop.Message && bytes.HasPrefix(p.Data, []byte("3200")) {
+				if p.String() != `43200["second"]` {
+					t.Fatalf("expected ack 200 with 'second', got %s", p)
+				}
+				ackResponses["200"] = true
+			}
+		}
+	})
+}
+
+func TestEngineIOSessionManagement(t *testing.T) {
+	t.Run("should reject polling with invalid session id", func(t *testing.T) {
+		resp, err := http.Get(URL + "/socket.io/?EIO=4&transport=polling&sid=invalid-session-id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 400 {
+			t.Fatalf("expected 400 for invalid sid, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("should reject WebSocket with invalid session id", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket&sid=invalid-session-id", nil)
+		if c != nil {
+			defer c.Close(websocket.StatusNormalClosure, "")
+			// Should close quickly
+			for {
+				_, _, err := c.Read(ctx)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			// Connection rejected - expected
+			t.Logf("Connection correctly rejected: %v", err)
+		}
+	})
+
+	t.Run("should not allow duplicate polling on same session", func(t *testing.T) {
+		sid := initLongPollingSession(t)
+
+		client := &http.Client{Timeout: 5 * time.Second}
+
+		// Start first poll (this will block waiting for server data)
+		done1 := make(chan *http.Response, 1)
+		go func() {
+			resp, _ := client.Get(fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid))
+			done1 <- resp
+		}()
+
+		// Small delay then start second (duplicate) poll
+		time.Sleep(50 * time.Millisecond)
+		done2 := make(chan *http.Response, 1)
+		go func() {
+			resp, _ := client.Get(fmt.Sprintf("%s/socket.io/?EIO=4&transport=polling&sid=%s", URL, sid))
+			done2 <- resp
+		}()
+
+		timeout := time.After(10 * time.Second)
+		var resp1, resp2 *http.Response
+
+		// Collect both responses with timeout
+		for i := 0; i < 2; i++ {
+			select {
+			case r := <-done1:
+				resp1 = r
+				if r != nil {
+					defer r.Body.Close()
+				}
+			case r := <-done2:
+				resp2 = r
+				if r != nil {
+					defer r.Body.Close()
+				}
+			case <-timeout:
+				// If we got at least one response, that's enough to validate
+				if resp1 != nil || resp2 != nil {
+					break
+				}
+				t.Fatal("timed out waiting for poll responses")
+			}
+		}
+
+		// At least one response should indicate an error (400) for duplicate GET
+		if resp1 != nil && resp2 != nil {
+			if resp1.StatusCode != 400 && resp2.StatusCode != 400 {
+				t.Logf("resp1: %d, resp2: %d (expected at least one 400)", resp1.StatusCode, resp2.StatusCode)
+			}
+		} else {
+			// Only got one response - check if the server closed the session
+			got := resp1
+			if got == nil {
+				got = resp2
+			}
+			if got != nil {
+				t.Logf("only one response received with status %d", got.StatusCode)
+			}
+		}
+	})
+}
+
+// freeAddr returns a loopback address whose port was free a moment ago, for
+// the servers started by the tests themselves.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// timingScale returns the factor, read from TEST_TIMING_SCALE, by which the
+// tests racing the heartbeat scale it. It defaults to 1.
+func timingScale(t *testing.T) float64 {
+	t.Helper()
+
+	env := os.Getenv("TEST_TIMING_SCALE")
+	if env == "" {
+		return 1
+	}
+	scale, err := strconv.ParseFloat(env, 64)
+	if err != nil || scale <= 0 {
+		t.Fatalf("invalid TEST_TIMING_SCALE %q", env)
+	}
+	return scale
+}
+
+// useServer points URL and WS_URL at the server listening on addr until the
+// test ends, so that the helpers can be used against an embedded server.
+func useServer(t *testing.T, addr string) {
+	t.Helper()
+
+	url, wsURL := URL, WS_URL
+	URL, WS_URL = "http://"+addr, "ws://"+addr
+	t.Cleanup(func() { URL, WS_URL = url, wsURL })
+}
+
+// The TLS variant runs embedded, with an in-memory certificate.
+func TestTLS(t *testing.T) {
+	cert, err := testserver.SelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closeOnce sync.Once
+	closeServer := func() { closeOnce.Do(func() { server.Close(nil) }) }
+	defer closeServer()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	t.Run("should open a session over HTTPS long-polling", func(t *testing.T) {
+		resp, err := client.Get("https://" + addr + "/socket.io/?EIO=4&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "0{") {
+			t.Fatalf("expected an Engine.IO handshake, got %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("should connect over WSS", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, "wss://"+addr+"/socket.io/?EIO=4&transport=websocket", &websocket.DialOptions{HTTPClient: client})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		if p, err := waitForPacket(ctx, c); err != nil || p.Type != eiop.Open {
+			t.Fatalf("expected an Engine.IO handshake, got %s (%v)", p, err)
+		}
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		if p, err := waitForPacket(ctx, c); err != nil || p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0{")) {
+			t.Fatalf("expected a Socket.IO handshake, got %q (%v)", p, err)
+		}
+	})
+
+	t.Run("should close the TLS listener on shutdown", func(t *testing.T) {
+		closeServer()
+
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			t.Fatal("expected the listener to be closed")
+		}
+	})
+}
+
+// Shutdown notifies and disconnects every client before closing the listener.
+func TestGracefulShutdown(t *testing.T) {
+	const hint = `42["server-shutdown",{"reconnectAfter":1000}]`
+
+	// shutdown drains the server in the background
+	shutdown := func(server *socket.Server) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			done <- testserver.Shutdown(ctx, server, time.Second)
+		}()
+		return done
+	}
+
+	// expectStopped waits for the shutdown and asserts the listener is closed
+	expectStopped := func(t *testing.T, done <-chan error, addr string) {
+		t.Helper()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("shutdown did not complete")
+		}
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			t.Fatal("expected the listener to be closed")
+		}
+	}
+
+	t.Run("WebSocket", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, _, err := websocket.Dial(ctx, "ws://"+addr+"/socket.io/?EIO=4&transport=websocket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.CloseNow()
+		if _, err := waitForPacket(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := waitForEvent(ctx, c, "auth"); err != nil {
+			t.Fatal(err)
+		}
+
+		done := shutdown(server)
+
+		for _, expected := range []string{hint, "41"} {
+			p, err := waitForPacket(ctx, c)
+			for err == nil && p.Type == eiop.Ping {
+				p, err = waitForPacket(ctx, c)
+			}
+			if err != nil {
+				t.Fatalf("expected %s: %v", expected, err)
+			}
+			if p.String() != expected {
+				t.Fatalf("expected %s, got %s", expected, p)
+			}
+		}
+		expectClose(ctx, t, c)
+		expectStopped(t, done, addr)
+	})
+
+	t.Run("HTTP long-polling", func(t *testing.T) {
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := "http://" + addr + "/socket.io/?EIO=4&transport=polling"
+
+		packets := pollUntil(t, url, "0")
+		var open map[string]any
+		decodeOpen(t, []byte(packets[0]), &open)
+		url += "&sid=" + open["sid"].(string)
+		if status := postPayload(t, url, "40", false); status != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", status)
+		}
+		pollUntil(t, url, "40")
+
+		done := shutdown(server)
+
+		// Collect everything up to the disconnect, skipping PINGs and the auth
+		// echo of the connection
+		packets = nil
+		for !slices.Contains(packets, "41") {
+			resp, err := http.Get(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status 200, got %d after %q", resp.StatusCode, packets)
+			}
+			payload, err := eiop.DecodePayload(body)
+			if err != nil {
+				t.Fatalf("invalid payload %q: %v", body, err)
+			}
+			for _, p := range payload {
+				if p.Type == eiop.Ping {
+					postPayload(t, url, "3", false)
+					continue
+				}
+				if packet := p.String(); !strings.HasPrefix(packet, `42["auth"`) {
+					packets = append(packets, packet)
+				}
+			}
+		}
+		if !slices.Equal(packets, []string{hint, "41"}) {
+			t.Fatalf("expected the shutdown event then a disconnect, got %q", packets)
+		}
+
+		// Like the client library, close the session once disconnected
+		if status := postPayload(t, url, "1", false); status != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", status)
+		}
+		expectStopped(t, done, addr)
+	})
+}
+
+// The health endpoints run embedded, as their status changes on shutdown.
+func TestHealthEndpoints(t *testing.T) {
+	const drainDelay = 500 * time.Millisecond
+
+	for name, start := range map[string]func(addr string) (*socket.Server, error){
+		"types.NewWebServer": func(addr string) (*socket.Server, error) {
+			server, _, err := testserver.New(addr, testserver.WithDrainDelay(drainDelay))
+			return server, err
+		},
+		"http.ServeMux": func(addr string) (*socket.Server, error) {
+			server, _, err := testserver.NewServeMux(addr, testserver.WithDrainDelay(drainDelay))
+			return server, err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			addr := freeAddr(t)
+			server, err := start(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status := func(path string) (int, error) {
+				resp, err := http.Get("http://" + addr + path)
+				if err != nil {
+					return 0, err
+				}
+				resp.Body.Close()
+				return resp.StatusCode, nil
+			}
+			expectStatus := func(t *testing.T, path string, expected int) {
+				t.Helper()
+
+				if code, err := status(path); err != nil || code != expected {
+					t.Fatalf("%s: expected %d, got %d (%v)", path, expected, code, err)
+				}
+			}
+
+			// Ready as soon as the server is returned
+			expectStatus(t, testserver.HealthzPath, http.StatusOK)
+			expectStatus(t, testserver.ReadyzPath, http.StatusOK)
+
+			c, _, err := websocket.Dial(ctx, "ws://"+addr+"/socket.io/?EIO=4&transport=websocket", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.CloseNow()
+			if _, err := waitForPacket(ctx, c); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := waitForEvent(ctx, c, "auth"); err != nil {
+				t.Fatal(err)
+			}
+			expectStatus(t, testserver.ReadyzPath, http.StatusOK)
+
+			started := time.Now()
+			done := make(chan error, 1)
+			go func() { done <- testserver.Shutdown(ctx, server, time.Second) }()
+
+			// Not ready anymore while the client is still connected, but alive
+			for {
+				code, err := status(testserver.ReadyzPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if code == http.StatusServiceUnavailable {
+					break
+				}
+				if code != http.StatusOK || time.Since(started) > drainDelay/2 {
+					t.Fatalf("%s: expected 503 right after shutdown began, got %d", testserver.ReadyzPath, code)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			expectStatus(t, testserver.HealthzPath, http.StatusOK)
+
+			// The client is only disconnected after the drain delay
+			if _, err := waitForEvent(ctx, c, "server-shutdown"); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(started); elapsed < drainDelay {
+				t.Fatalf("expected the client to be disconnected after %v, got %v", drainDelay, elapsed)
+			}
+
+			if err := <-done; err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+			if _, err := status(testserver.HealthzPath); err == nil {
+				t.Fatal("expected the listener to be closed")
+			}
+		})
+	}
+}
+
+// The ServeMux variant runs embedded, and must behave like the
+// types.NewWebServer one while sharing its listener with other routes.
+func TestServeMux(t *testing.T) {
+	addr := freeAddr(t)
+	server, httpServer, err := testserver.NewServeMux(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpServer.Close()
+	defer server.Close(nil)
+
+	useServer(t, addr)
+
+	t.Run("handshake", TestEngineIOHandshake)
+	t.Run("upgrade", TestEngineIOUpgrade)
+
+	t.Run("should serve other routes while sockets are connected", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		resp, err := http.Get(URL + "/hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != "hello\n" {
+			t.Fatalf("expected 200 hello, got %d %q", resp.StatusCode, body)
+		}
+
+		// The socket is still usable afterwards
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["message","still here"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "message-back")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(args) != 1 || args[0] != "still here" {
+			t.Fatalf("expected [still here], got %v", args)
+		}
+	})
+}
+
+// The auth profile runs embedded, as it rejects clients without a token.
+func TestAuthMiddleware(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithAuth(map[string]string{"secret": "user-1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	expectUnauthorized := func(t *testing.T, packet string) {
+		t.Helper()
+
+		var connectError map[string]any
+		if !strings.HasPrefix(packet, "44") || json.Unmarshal([]byte(packet[2:]), &connectError) != nil {
+			t.Fatalf("expected a CONNECT_ERROR packet, got %s", packet)
+		}
+		expected := map[string]any{"message": "unauthorized", "data": map[string]any{"code": "unauthorized"}}
+		if !reflect.DeepEqual(connectError, expected) {
+			t.Fatalf("expected %v, got %v", expected, connectError)
+		}
+	}
+
+	t.Run("WebSocket", func(t *testing.T) {
+		connect := func(ctx context.Context, t *testing.T, auth string) (*websocket.Conn, string) {
+			t.Helper()
+
+			c, _ := openWebSocketSession(ctx, t)
+			if err := c.Write(ctx, websocket.MessageText, []byte("40"+auth)); err != nil {
+				t.Fatal(err)
+			}
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c, p.String()
+		}
+
+		for name, auth := range map[string]string{"without a token": "", "with an invalid token": `{"token":"wrong"}`} {
+			t.Run("should reject a client "+name, func(t *testing.T) {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+
+				c, data := connect(ctx, t, auth)
+				defer c.CloseNow()
+				expectUnauthorized(t, data)
+			})
+		}
+
+		t.Run("should store the user id of a valid token", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			c, data := connect(ctx, t, `{"token":"secret"}`)
+			defer c.CloseNow()
+			if !strings.HasPrefix(data, "40{") {
+				t.Fatalf("expected a CONNECT packet, got %s", data)
+			}
+			if reply := emitWithAck(ctx, t, c, 1, "whoami"); !reflect.DeepEqual(reply, []any{"user-1"}) {
+				t.Fatalf("expected [user-1], got %v", reply)
+			}
+		})
+	})
+
+	t.Run("HTTP long-polling", func(t *testing.T) {
+		// next returns the next packet other than a PING
+		next := func(t *testing.T, sid string, pending *[]eiop.Packet) string {
+			t.Helper()
+
+			for {
+				for len(*pending) == 0 {
+					*pending = poll(t, sid)
+				}
+				packet := (*pending)[0]
+				*pending = (*pending)[1:]
+				if packet.Type != eiop.Ping {
+					return packet.String()
+				}
+				push(t, sid, "3")
+			}
+		}
+
+		for name, auth := range map[string]string{"without a token": "", "with an invalid token": `{"token":"wrong"}`} {
+			t.Run("should reject a client "+name, func(t *testing.T) {
+				sid := initLongPollingSession(t)
+				push(t, sid, "40"+auth)
+
+				var pending []eiop.Packet
+				expectUnauthorized(t, next(t, sid, &pending))
+			})
+		}
+
+		t.Run("should store the user id of a valid token", func(t *testing.T) {
+			sid := initLongPollingSession(t)
+			push(t, sid, `40{"token":"secret"}`)
+
+			var pending []eiop.Packet
+			if packet := next(t, sid, &pending); !strings.HasPrefix(packet, "40{") {
+				t.Fatalf("expected a CONNECT packet, got %s", packet)
+			}
+			push(t, sid, `421["whoami"]`)
+			for {
+				packet := next(t, sid, &pending)
+				if !strings.HasPrefix(packet, "431") {
+					continue
+				}
+				if packet != `431["user-1"]` {
+					t.Fatalf(`expected 431["user-1"], got %s`, packet)
+				}
+				break
+			}
+		})
+	})
+}
+
+// The middleware chains run embedded, as they reject clients without a role.
+func TestNamespaceMiddleware(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr,
+		testserver.WithNamespaceMiddleware("/", testserver.Trace("main-1"), testserver.Trace("main-2")),
+		testserver.WithNamespaceMiddleware("/custom",
+			testserver.Trace("custom-1"), testserver.RequireRole("admin"), testserver.Trace("custom-2")),
+		// A second option for the same namespace appends to its chain
+		testserver.WithNamespaceMiddleware("/custom", testserver.Trace("custom-3")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	// connect joins nsp with the given auth and returns the reply to the
+	// CONNECT packet
+	connect := func(ctx context.Context, t *testing.T, c *websocket.Conn, nsp, auth string) string {
+		t.Helper()
+
+		if err := c.Write(ctx, websocket.MessageText, []byte("40"+nsp+auth)); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type == eiop.Message && (bytes.HasPrefix(p.Data, []byte("0"+nsp)) || bytes.HasPrefix(p.Data, []byte("4"+nsp))) {
+				return p.String()
+			}
+		}
+	}
+
+	// trace returns the middleware trace of the socket connected to nsp
+	trace := func(ctx context.Context, t *testing.T, c *websocket.Conn, nsp string) []any {
+		t.Helper()
+
+		if err := c.Write(ctx, websocket.MessageText, []byte("42"+nsp+`1["middleware-trace"]`)); err != nil {
+			t.Fatal(err)
+		}
+		prefix := "3" + nsp + "1"
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type == eiop.Ping {
+				c.Write(ctx, websocket.MessageText, []byte("3"))
+				continue
+			}
+			if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte(prefix+"[")) {
+				continue
+			}
+			var reply []any
+			if err := json.Unmarshal(p.Data[len(prefix):], &reply); err != nil || len(reply) != 1 {
+				t.Fatalf("malformed ack %s", p)
+			}
+			trace, _ := reply[0].([]any)
+			return trace
+		}
+	}
+
+	t.Run("should only run the main namespace chain for the main namespace", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.CloseNow()
+		if data := connect(ctx, t, c, "", ""); !strings.HasPrefix(data, "40{") {
+			t.Fatalf("expected a CONNECT packet, got %s", data)
+		}
+		if got := trace(ctx, t, c, ""); !reflect.DeepEqual(got, []any{"main-1", "main-2"}) {
+			t.Fatalf("expected [main-1 main-2], got %v", got)
+		}
+	})
+
+	t.Run("should only run the /custom chain for /custom, in registration order", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.CloseNow()
+		if data := connect(ctx, t, c, "/custom,", `{"role":"admin"}`); !strings.HasPrefix(data, "40/custom,{") {
+			t.Fatalf("expected a CONNECT packet, got %s", data)
+		}
+		if got := trace(ctx, t, c, "/custom,"); !reflect.DeepEqual(got, []any{"custom-1", "custom-2", "custom-3"}) {
+			t.Fatalf("expected [custom-1 custom-2 custom-3], got %v", got)
+		}
+	})
+
+	t.Run("should reject /custom without the role, but not the main namespace", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.CloseNow()
+		for _, auth := range []string{"", `{"role":"user"}`} {
+			data := connect(ctx, t, c, "/custom,", auth)
+			var connectError map[string]any
+			if !strings.HasPrefix(data, "44/custom,") || json.Unmarshal([]byte(data[len("44/custom,"):]), &connectError) != nil {
+				t.Fatalf("expected a CONNECT_ERROR packet, got %s", data)
+			}
+			expected := map[string]any{"message": "forbidden", "data": map[string]any{"code": "forbidden"}}
+			if !reflect.DeepEqual(connectError, expected) {
+				t.Fatalf("expected %v, got %v", expected, connectError)
+			}
+		}
+
+		// The same connection still joins the main namespace, whose chain
+		// does not require the role
+		if data := connect(ctx, t, c, "", `{"role":"user"}`); !strings.HasPrefix(data, "40{") {
+			t.Fatalf("expected a CONNECT packet, got %s", data)
+		}
+		if got := trace(ctx, t, c, ""); !reflect.DeepEqual(got, []any{"main-1", "main-2"}) {
+			t.Fatalf("expected [main-1 main-2], got %v", got)
+		}
+	})
+}
+
+// The recovery profile runs embedded, as it changes the CONNECT packet and
+// appends an offset to every event.
+func TestConnectionStateRecovery(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr,
+		testserver.WithConnectionStateRecovery(2*time.Second, true),
+		testserver.WithNamespaceMiddleware("/", testserver.Trace("main")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	type session struct {
+		Sid string `json:"sid"`
+		Pid string `json:"pid"`
+	}
+
+	// connect joins the main namespace with the given auth, and returns the
+	// session from the CONNECT packet along with the events received up to and
+	// including "auth". Missed packets are replayed before the CONNECT packet,
+	// as by the reference implementation, and clients buffer them until then.
+	connect := func(ctx context.Context, t *testing.T, auth string) (*websocket.Conn, session, [][]any) {
+		t.Helper()
+
+		c, _ := openWebSocketSession(ctx, t)
+		if err := c.Write(ctx, websocket.MessageText, []byte("40"+auth)); err != nil {
+			t.Fatal(err)
+		}
+
+		var s session
+		var events [][]any
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type == eiop.Message && bytes.HasPrefix(p.Data, []byte("0")) {
+				if s.Sid != "" || json.Unmarshal(p.Data[1:], &s) != nil {
+					t.Fatalf("unexpected CONNECT packet %s", p)
+				}
+				continue
+			}
+			var event []any
+			if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("2[")) || json.Unmarshal(p.Data[1:], &event) != nil {
+				continue
+			}
+			events = append(events, event)
+			if event[0] != "auth" {
+				continue
+			}
+			if s.Sid == "" {
+				t.Fatal(`expected a CONNECT packet before "auth"`)
+			}
+			return c, s, events
+		}
+	}
+
+	// offset returns the offset the server appended to an event
+	offset := func(t *testing.T, event []any) string {
+		t.Helper()
+
+		offset, ok := event[len(event)-1].(string)
+		if !ok || offset == "" {
+			t.Fatalf("expected an offset at the end of %v", event)
+		}
+		return offset
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, first, events := connect(ctx, t, "")
+	if first.Pid == "" {
+		t.Fatalf("expected a pid in the CONNECT packet, got %+v", first)
+	}
+	authOffset := offset(t, events[len(events)-1])
+	if reply := emitWithAck(ctx, t, c, 1, "recovered"); !reflect.DeepEqual(reply, []any{false}) {
+		t.Fatalf("expected [false], got %v", reply)
+	}
+	if reply := emitWithAck(ctx, t, c, 2, "middleware-trace"); !reflect.DeepEqual(reply, []any{[]any{"main"}}) {
+		t.Fatalf("expected [[main]], got %v", reply)
+	}
+
+	// The echo is delivered, but the client closes without acknowledging it
+	// by its offset
+	if err := c.Write(ctx, websocket.MessageText, []byte(`42["message","missed"]`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waitForEvent(ctx, c, "message-back"); err != nil {
+		t.Fatal(err)
+	}
+	c.CloseNow()
+
+	t.Run("should recover the session and replay the missed packets", func(t *testing.T) {
+		auth, _ := json.Marshal(map[string]string{"pid": first.Pid, "offset": authOffset})
+		c, recovered, events := connect(ctx, t, string(auth))
+		defer c.CloseNow()
+
+		if recovered.Sid != first.Sid {
+			t.Fatalf("expected the sid %s to be recovered, got %s", first.Sid, recovered.Sid)
+		}
+		if len(events) != 2 || events[0][0] != "message-back" || events[0][1] != "missed" {
+			t.Fatalf(`expected the missed "message-back" before "auth", got %v`, events)
+		}
+		if reply := emitWithAck(ctx, t, c, 1, "recovered"); !reflect.DeepEqual(reply, []any{true}) {
+			t.Fatalf("expected [true], got %v", reply)
+		}
+		// skipMiddlewares is set, so the trace of the recovered socket is empty
+		if reply := emitWithAck(ctx, t, c, 2, "middleware-trace"); !reflect.DeepEqual(reply, []any{[]any{}}) {
+			t.Fatalf("expected [[]], got %v", reply)
+		}
+	})
+
+	t.Run("should start a new session for an unknown pid", func(t *testing.T) {
+		c, fresh, _ := connect(ctx, t, `{"pid":"unknown","offset":"`+authOffset+`"}`)
+		defer c.CloseNow()
+
+		if fresh.Sid == first.Sid {
+			t.Fatalf("expected a new sid, got %s", fresh.Sid)
+		}
+		if reply := emitWithAck(ctx, t, c, 1, "recovered"); !reflect.DeepEqual(reply, []any{false}) {
+			t.Fatalf("expected [false], got %v", reply)
+		}
+		if reply := emitWithAck(ctx, t, c, 2, "middleware-trace"); !reflect.DeepEqual(reply, []any{[]any{"main"}}) {
+			t.Fatalf("expected [[main]], got %v", reply)
+		}
+	})
+}
+
+// startProxy starts two embedded test servers behind a proxy routing with
+// mode, points the helpers at the proxy, and returns the number of requests
+// it received.
+func startProxy(t *testing.T, mode stickyproxy.Mode) *atomic.Int64 {
+	t.Helper()
+
+	var backends []*url.URL
+	for range 2 {
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { server.Close(nil) })
+		backends = append(backends, &url.URL{Scheme: "http", Host: addr})
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int64
+	proxy := stickyproxy.New(mode, backends...)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		proxy.ServeHTTP(w, r)
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	useServer(t, listener.Addr().String())
+	return &requests
+}
+
+// The proxy variants run embedded, with two test servers behind the proxy.
+func TestStickyProxy(t *testing.T) {
+	t.Run("should keep polling sessions on their server", func(t *testing.T) {
+		requests := startProxy(t, stickyproxy.Sticky)
+
+		// poll and push fail on any error, "Session ID unknown" included
+		sid, _ := initLongPollingSocketIOSession(t)
+		for i := 0; requests.Load() < 50; i++ {
+			push(t, sid, fmt.Sprintf(`42["message",%d]`, i))
+			if args := pollForEvent(t, sid, "message-back"); !reflect.DeepEqual(args, []any{float64(i)}) {
+				t.Fatalf("expected [%d], got %v", i, args)
+			}
+		}
+
+		// Nothing is pending anymore, so the next poll waits for a PING
+		if packets := poll(t, sid); len(packets) != 1 || packets[0].Type != eiop.Ping {
+			t.Fatalf("expected a PING, got %v", packets)
+		}
+		push(t, sid, "3")
+		push(t, sid, `42["message","after-pong"]`)
+		if args := pollForEvent(t, sid, "message-back"); !reflect.DeepEqual(args, []any{"after-pong"}) {
+			t.Fatalf("expected [after-pong], got %v", args)
+		}
+	})
+
+	t.Run("should lose polling sessions without stickiness", func(t *testing.T) {
+		startProxy(t, stickyproxy.RoundRobin)
+
+		// The handshake reaches the first server, and the next request the
+		// second one, which does not know the session
+		sid := initLongPollingSession(t)
+		resp, err := http.Post(pollingURL(sid), "text/plain", strings.NewReader("40"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "Session ID unknown") {
+			t.Fatalf(`expected 400 "Session ID unknown", got %d %s`, resp.StatusCode, body)
+		}
+	})
+}
+
+// The admin UI profile runs embedded, as its namespace would break the
+// "Invalid namespace" expectations of the default profile.
+func TestAdminUI(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithAdminUI("admin", "secret", 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	// next returns the next packet other than a PING
+	next := func(ctx context.Context, t *testing.T, c *websocket.Conn) string {
+		t.Helper()
+
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type != eiop.Ping {
+				return p.String()
+			}
+			if err := c.Write(ctx, websocket.MessageText, []byte("3")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	connect := func(ctx context.Context, t *testing.T, auth string) (*websocket.Conn, string) {
+		t.Helper()
+
+		c, _ := openWebSocketSession(ctx, t)
+		if err := c.Write(ctx, websocket.MessageText, []byte("40/admin,"+auth)); err != nil {
+			t.Fatal(err)
+		}
+		return c, next(ctx, t, c)
+	}
+
+	for name, auth := range map[string]string{
+		"without credentials":              "",
+		"with an invalid password":         `{"username":"admin","password":"wrong"}`,
+		"with an unknown username":         `{"username":"root","password":"secret"}`,
+		"with credentials of a wrong type": `{"username":1,"password":true}`,
+	} {
+		t.Run("should reject an admin "+name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			c, data := connect(ctx, t, auth)
+			defer c.CloseNow()
+			var connectError map[string]any
+			if !strings.HasPrefix(data, "44/admin,") || json.Unmarshal([]byte(data[len("44/admin,"):]), &connectError) != nil {
+				t.Fatalf("expected a CONNECT_ERROR packet, got %s", data)
+			}
+			if connectError["message"] != "invalid credentials" {
+				t.Fatalf("expected invalid credentials, got %v", connectError)
+			}
+		})
+	}
+
+	t.Run("should send the config then the server stats periodically", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		c, data := connect(ctx, t, `{"username":"admin","password":"secret"}`)
+		defer c.CloseNow()
+		if !strings.HasPrefix(data, "40/admin,{") {
+			t.Fatalf("expected a CONNECT packet, got %s", data)
+		}
+
+		data = next(ctx, t, c)
+		expected := `42/admin,["config",{"supportedFeatures":["JOIN","LEAVE","DISCONNECT"]}]`
+		if data != expected {
+			t.Fatalf("expected %s, got %s", expected, data)
+		}
+
+		for range 2 {
+			data := next(ctx, t, c)
+			var event []any
+			if !strings.HasPrefix(data, "42/admin,") || json.Unmarshal([]byte(data[len("42/admin,"):]), &event) != nil || len(event) != 2 || event[0] != "server_stats" {
+				t.Fatalf("expected a server_stats event, got %s", data)
+			}
+			stats, _ := event[1].(map[string]any)
+			for _, field := range []string{"serverId", "hostname", "pid", "uptime", "clientsCount", "pollingClientsCount"} {
+				if _, ok := stats[field]; !ok {
+					t.Errorf("expected %s in %v", field, stats)
+				}
+			}
+			if clients, _ := stats["clientsCount"].(float64); clients < 1 {
+				t.Errorf("expected at least one client, got %v", stats["clientsCount"])
+			}
+			var names []any
+			namespaces, _ := stats["namespaces"].([]any)
+			for _, nsp := range namespaces {
+				names = append(names, nsp.(map[string]any)["name"])
+			}
+			if !slices.Contains(names, "/") || !slices.Contains(names, "/admin") {
+				t.Errorf("expected / and /admin in %v", names)
+			}
+		}
+	})
+}
+
+// scrape returns the samples served by the metrics endpoint url, keyed by
+// name and labels as written, e.g. socketio_connected_sockets{namespace="/"}.
+func scrape(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	samples := map[string]float64{}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if i < 0 || err != nil {
+			t.Fatalf("invalid sample %q", line)
+		}
+		samples[line[:i]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+// The metrics run embedded, on their own registry.
+func TestMetrics(t *testing.T) {
+	m := metrics.New()
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithInstrumentation(m.Instrument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	endpoint := httptest.NewServer(m.Handler())
+	defer endpoint.Close()
+
+	const (
+		sockets        = `socketio_connected_sockets{namespace="/"}`
+		clients        = `engineio_connected_clients`
+		connections    = `socketio_connections_total{namespace="/"}`
+		disconnections = `socketio_disconnections_total{namespace="/",reason="transport close"}`
+		received       = `engineio_packets_total{direction="received"}`
+		sent           = `engineio_packets_total{direction="sent"}`
+		bytesReceived  = `engineio_bytes_total{direction="received"}`
+		bytesSent      = `engineio_bytes_total{direction="sent"}`
+	)
+
+	before := scrape(t, endpoint.URL)
+	if before[sockets] != 0 || before[clients] != 0 {
+		t.Fatalf("expected no sockets nor clients, got %v", before)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	message := `42["message","hello"]`
+	conns := []*websocket.Conn{initSocketIOConnection(t), initSocketIOConnection(t)}
+	for _, c := range conns {
+		defer c.CloseNow()
+		if err := c.Write(ctx, websocket.MessageText, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := waitForEvent(ctx, c, "message-back"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	during := scrape(t, endpoint.URL)
+	if during[sockets] != 2 || during[clients] != 2 {
+		t.Errorf("expected 2 sockets and 2 clients, got %v and %v", during[sockets], during[clients])
+	}
+	if delta := during[connections] - before[connections]; delta != 2 {
+		t.Errorf("expected 2 more connections, got %v", delta)
+	}
+	// The CONNECT packets, the messages and any heartbeat
+	if delta := during[received] - before[received]; delta < 4 {
+		t.Errorf("expected at least 4 more received packets, got %v", delta)
+	}
+	// The CONNECT packets, the "auth" events and the echoes
+	if delta := during[sent] - before[sent]; delta < 6 {
+		t.Errorf("expected at least 6 more sent packets, got %v", delta)
+	}
+	if delta := during[bytesReceived] - before[bytesReceived]; delta < float64(2*len(message)) {
+		t.Errorf("expected at least %d more received bytes, got %v", 2*len(message), delta)
+	}
+	if delta := during[bytesSent] - before[bytesSent]; delta < float64(2*len(`42["message-back","hello"]`)) {
+		t.Errorf("expected at least %d more sent bytes, got %v", 2*len(`42["message-back","hello"]`), delta)
+	}
+
+	for _, c := range conns {
+		c.Close(websocket.StatusNormalClosure, "")
+	}
+
+	// The disconnections are handled asynchronously
+	for {
+		after := scrape(t, endpoint.URL)
+		if after[disconnections]-before[disconnections] == 2 && after[sockets] == 0 && after[clients] == 0 {
+			if delta := after[connections] - before[connections]; delta != 2 {
+				t.Errorf("expected 2 connections in total, got %v", delta)
+			}
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected 2 disconnections and nothing connected, got %v", after)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// recordingHandler is a slog.Handler keeping every record with its attributes,
+// including the ones added with With.
+type recordingHandler struct {
+	mu      *sync.Mutex
+	records *[]map[string]any
+	attrs   []slog.Attr
+}
+
+func newRecordingHandler() *recordingHandler {
+	return &recordingHandler{mu: &sync.Mutex{}, records: &[]map[string]any{}}
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	record := map[string]any{"msg": r.Message, "level": r.Level}
+	for _, attr := range h.attrs {
+		record[attr.Key] = attr.Value.Any()
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		record[attr.Key] = attr.Value.Any()
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, record)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &recordingHandler{mu: h.mu, records: h.records, attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *recordingHandler) WithGroup(string) slog.Handler { return h }
+
+// find returns the first record with the message msg and the socket id sid.
+func (h *recordingHandler) find(msg, sid string) (map[string]any, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, record := range *h.records {
+		if record["msg"] == msg && record["sid"] == sid {
+			return record, true
+		}
+	}
+	return nil, false
+}
+
+// The logs run embedded, captured by a handler of the test.
+func TestStructuredLogging(t *testing.T) {
+	handler := newRecordingHandler()
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithLogger(slog.New(handler)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	c, sid := initSocketIOSession(t)
+	defer c.CloseNow()
+
+	connected, ok := handler.find("connection established", sid)
+	if !ok {
+		t.Fatalf("expected a connection record for %s", sid)
+	}
+	for key, expected := range map[string]any{"namespace": "/", "transport": "websocket", "protocol": int64(4), "level": slog.LevelInfo} {
+		if connected[key] != expected {
+			t.Errorf("connection: expected %s %v, got %v", key, expected, connected[key])
+		}
+	}
+	if addr, _ := connected["remote_addr"].(string); addr == "" {
+		t.Errorf("connection: expected a remote_addr, got %v", connected)
+	}
+
+	message := `42["message","hello"]`
+	if err := c.Write(ctx, websocket.MessageText, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waitForEvent(ctx, c, "message-back"); err != nil {
+		t.Fatal(err)
+	}
+	received, ok := handler.find("event received", sid)
+	if !ok {
+		t.Fatalf("expected an event record for %s", sid)
+	}
+	if received["event"] != "message" || received["level"] != slog.LevelDebug || received["bytes"] != int64(len(`["hello"]`)) {
+		t.Errorf("event: expected message, debug and %d bytes, got %v", len(`["hello"]`), received)
+	}
+
+	if err := c.Write(ctx, websocket.MessageText, []byte("41")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if disconnected, ok := handler.find("disconnected", sid); ok {
+			if disconnected["reason"] != "client namespace disconnect" {
+				t.Errorf("disconnect: expected client namespace disconnect, got %v", disconnected["reason"])
+			}
+			if duration, _ := disconnected["duration"].(time.Duration); duration <= 0 {
+				t.Errorf("disconnect: expected a positive duration, got %v", disconnected["duration"])
+			}
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected a disconnect record for %s", sid)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// The status reports run embedded, as they ask every connected client.
+func TestStatusReports(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithStatusReports(timeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	// trigger requests a report, and returns the decoded response
+	trigger := func(t *testing.T) <-chan any {
+		t.Helper()
+
+		done := make(chan any, 1)
+		go func() {
+			resp, err := http.Post(URL+testserver.StatusReportPath, "", nil)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer resp.Body.Close()
+			var report any
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				done <- err
+				return
+			}
+			done <- report
+		}()
+		return done
+	}
+
+	// next returns the next packet other than a PING
+	next := func(ctx context.Context, t *testing.T, c *websocket.Conn) string {
+		t.Helper()
+
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type != eiop.Ping {
+				return p.String()
+			}
+			if err := c.Write(ctx, websocket.MessageText, []byte("3")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("should report immediately without clients", func(t *testing.T) {
+		started := time.Now()
+		expected := map[string]any{"responses": []any{}, "timeouts": []any{}}
+		if report := <-trigger(t); !reflect.DeepEqual(report, expected) {
+			t.Fatalf("expected %v, got %v", expected, report)
+		}
+		if elapsed := time.Since(started); elapsed >= timeout {
+			t.Fatalf("expected no wait, took %v", elapsed)
+		}
+	})
+
+	t.Run("should report the responses and the timeouts", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		answering, answeringSid := initSocketIOSession(t)
+		defer answering.CloseNow()
+		silent, silentSid := initSocketIOSession(t)
+		defer silent.CloseNow()
+
+		done := trigger(t)
+
+		for _, c := range []*websocket.Conn{answering, silent} {
+			packet := next(ctx, t, c)
+			id, ok := parseEventAckID(packet)
+			if !ok || packet != "42"+id+`["get-status"]` {
+				t.Fatalf("expected a get-status request, got %s", packet)
+			}
+			if c == answering {
+				if err := c.Write(ctx, websocket.MessageText, []byte("43"+id+`[{"load":0.5}]`)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		expected := map[string]any{
+			"responses": []any{map[string]any{"sid": answeringSid, "status": map[string]any{"load": 0.5}}},
+			"timeouts":  []any{silentSid},
+		}
+		for _, c := range []*websocket.Conn{answering, silent} {
+			args, err := waitForEvent(ctx, c, "status-report")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(args, []any{expected}) {
+				t.Fatalf("expected %v, got %v", expected, args)
+			}
+		}
+		if report := <-done; !reflect.DeepEqual(report, expected) {
+			t.Fatalf("expected the response %v, got %v", expected, report)
+		}
+	})
+}
+
+// The client polling runs embedded, as it broadcasts to every connected client.
+func TestClientPolling(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithClientPolling(timeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	// poll requests a poll of room, and returns the decoded response
+	poll := func(room string) <-chan any {
+		done := make(chan any, 1)
+		go func() {
+			resp, err := http.Post(URL+testserver.PollClientsPath+"?room="+url.QueryEscape(room), "", nil)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer resp.Body.Close()
+			var report any
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				done <- err
+				return
+			}
+			done <- report
+		}()
+		return done
+	}
+
+	t.Run("should respond immediately without clients", func(t *testing.T) {
+		started := time.Now()
+		expected := map[string]any{"room": "fleet", "responses": []any{}, "timeouts": []any{}}
+		if report := <-poll("fleet"); !reflect.DeepEqual(report, expected) {
+			t.Fatalf("expected %v, got %v", expected, report)
+		}
+		if elapsed := time.Since(started); elapsed >= timeout {
+			t.Fatalf("expected no wait, took %v", elapsed)
+		}
+	})
+
+	t.Run("should list the answers and the timeouts", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		type member struct {
+			c       *websocket.Conn
+			sid     string
+			answers bool
+		}
+		var members []member
+		for _, answers := range []bool{true, true, false} {
+			c, sid := initSocketIOSession(t)
+			defer c.CloseNow()
+			if err := c.Write(ctx, websocket.MessageText, []byte(`42["join-room","fleet"]`)); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Write(ctx, websocket.MessageText, []byte(`42["my-rooms"]`)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := waitForEvent(ctx, c, "my-rooms"); err != nil {
+				t.Fatal(err)
+			}
+			members = append(members, member{c, sid, answers})
+		}
+		// Outside the room, so neither polled nor listed
+		outsider := initSocketIOConnection(t)
+		defer outsider.CloseNow()
+
+		done := poll("fleet")
+
+		var responses []any
+		var timeouts []any
+		for _, m := range members {
+			var packet string
+			for {
+				p, err := waitForPacket(ctx, m.c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.Type != eiop.Ping {
+					packet = p.String()
+					break
+				}
+				_ = m.c.Write(ctx, websocket.MessageText, []byte("3"))
+			}
+			id, ok := parseEventAckID(packet)
+			if !ok || packet != "42"+id+`["are-you-there"]` {
+				t.Fatalf("expected an are-you-there request, got %s", packet)
+			}
+			if !m.answers {
+				timeouts = append(timeouts, m.sid)
+				continue
+			}
+			payload := map[string]any{"sid": m.sid, "busy": false}
+			answer, _ := json.Marshal([]any{payload})
+			if err := m.c.Write(ctx, websocket.MessageText, []byte("43"+id+string(answer))); err != nil {
+				t.Fatal(err)
+			}
+			responses = append(responses, map[string]any{"sid": m.sid, "payload": payload})
+		}
+		slices.SortFunc(responses, func(x, y any) int {
+			return strings.Compare(x.(map[string]any)["sid"].(string), y.(map[string]any)["sid"].(string))
+		})
+
+		expected := map[string]any{"room": "fleet", "responses": responses, "timeouts": timeouts}
+		if report := <-done; !reflect.DeepEqual(report, expected) {
+			t.Fatalf("expected %v, got %v", expected, report)
+		}
+	})
+}
+
+func TestNamespaceClosing(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithNamespaceClosing())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// One connection joins both the main namespace and /custom
+	c := initSocketIOConnection(t)
+	defer c.CloseNow()
+
+	// next returns the next packet, answering PINGs
+	next := func() string {
+		t.Helper()
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type != eiop.Ping {
+				return p.String()
+			}
+			_ = c.Write(ctx, websocket.MessageText, []byte("3"))
+		}
+	}
+	write := func(data string) {
+		t.Helper()
+		if err := c.Write(ctx, websocket.MessageText, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// echo checks that the main namespace still echoes messages
+	echo := func(text string) {
+		t.Helper()
+		write(`42["message","` + text + `"]`)
+		if data, expected := next(), `42["message-back","`+text+`"]`; data != expected {
+			t.Fatalf("expected %s, got %s", expected, data)
+		}
+	}
+	closeNamespace := func(name string) (int, string) {
+		t.Helper()
+		resp, err := http.Post(URL+testserver.CloseNamespacePath+"?namespace="+url.QueryEscape(name), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, strings.TrimSpace(string(body))
+	}
+
+	write("40/custom,")
+	if data := next(); !strings.HasPrefix(data, "40/custom,") {
+		t.Fatalf("expected a CONNECT packet for /custom, got %s", data)
+	}
+	if data := next(); !strings.HasPrefix(data, `42/custom,["auth"`) {
+		t.Fatalf("expected the auth event of /custom, got %s", data)
+	}
+	echo("before")
+
+	t.Run("should refuse unknown namespaces", func(t *testing.T) {
+		for _, name := range []string{"/", "/unknown"} {
+			if status, _ := closeNamespace(name); status != http.StatusNotFound {
+				t.Fatalf("%s: expected status 404, got %d", name, status)
+			}
+		}
+	})
+
+	t.Run("should notify and disconnect the sockets of the namespace", func(t *testing.T) {
+		status, body := closeNamespace("/custom")
+		if expected := `{"disconnected":1,"namespace":"/custom"}`; status != http.StatusOK || body != expected {
+			t.Fatalf("expected 200 %s, got %d %s", expected, status, body)
+		}
+		for _, expected := range []string{`42/custom,["namespace-closing",{"namespace":"/custom"}]`, "41/custom,"} {
+			if data := next(); data != expected {
+				t.Fatalf("expected %s, got %s", expected, data)
+			}
+		}
+		echo("during")
+	})
+
+	t.Run("should refuse new sockets", func(t *testing.T) {
+		write("40/custom,")
+		data := next()
+		if !strings.HasPrefix(data, "44/custom,") {
+			t.Fatalf("expected a CONNECT_ERROR packet for /custom, got %s", data)
+		}
+		var connectError struct {
+			Message string         `json:"message"`
+			Data    map[string]any `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "44/custom,")), &connectError); err != nil {
+			t.Fatal(err)
+		}
+		if connectError.Message != "namespace closed" || connectError.Data["code"] != "namespace_closed" {
+			t.Fatalf("expected a namespace_closed error, got %s", data)
+		}
+
+		// Closing it again disconnects nobody
+		if status, body := closeNamespace("/custom"); status != http.StatusOK || body != `{"disconnected":0,"namespace":"/custom"}` {
+			t.Fatalf("expected nobody disconnected, got %d %s", status, body)
+		}
+		echo("after")
+	})
+}
+
+func TestMaxConnections(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithMaxConnections(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// handshake opens a polling session and returns the status and body
+	handshake := func() (int, string) {
+		t.Helper()
+		resp, err := http.Get(URL + "/socket.io/?EIO=4&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	c, _ := openWebSocketSession(ctx, t)
+	defer c.CloseNow()
+	sid := initLongPollingSession(t)
+
+	t.Run("should refuse polling handshakes over the limit", func(t *testing.T) {
+		status, body := handshake()
+		if expected := `{"code":4,"message":"server is full"}`; status != http.StatusForbidden || body != expected {
+			t.Fatalf("expected 403 %s, got %d %s", expected, status, body)
+		}
+	})
+
+	t.Run("should refuse websocket upgrades over the limit", func(t *testing.T) {
+		refused, resp, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+		if err == nil {
+			refused.CloseNow()
+			t.Fatal("expected the upgrade to be refused")
+		}
+		if resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %v", err)
+		}
+	})
+
+	t.Run("should keep the open sessions working", func(t *testing.T) {
+		push(t, sid, "40")
+		if packets := poll(t, sid); packets[0].Type != eiop.Message || !bytes.HasPrefix(packets[0].Data, []byte("0")) {
+			t.Fatalf("expected a CONNECT packet over polling, got %q", packets)
+		}
+
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		p, err := waitForPacket(ctx, c)
+		for err == nil && p.Type == eiop.Ping {
+			p, err = waitForPacket(ctx, c)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0")) {
+			t.Fatalf("expected a CONNECT packet over websocket, got %s", p)
+		}
+	})
+
+	t.Run("should accept handshakes once a connection closes", func(t *testing.T) {
+		c.Close(websocket.StatusNormalClosure, "")
+
+		// The engine counts the client until it notices the close
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			status, body := handshake()
+			if status == http.StatusOK {
+				if !strings.HasPrefix(body, "0{") {
+					t.Fatalf("expected an OPEN packet, got %s", body)
+				}
+				return
+			}
+			if status != http.StatusForbidden || time.Now().After(deadline) {
+				t.Fatalf("expected the handshake to be accepted, got %d %s", status, body)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestAllowlist(t *testing.T) {
+	// start runs an embedded server with the allowlist until the test ends
+	start := func(t *testing.T, opts ...testserver.Option) *socket.Server {
+		t.Helper()
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { server.Close(nil) })
+		useServer(t, addr)
+		return server
+	}
+	// handshake opens a polling session and returns the status and body
+	handshake := func(t *testing.T, header http.Header) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, URL+"/socket.io/?EIO=4&transport=polling", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+
+	t.Run("should accept the clients of the allowlist", func(t *testing.T) {
+		start(t, testserver.WithAllowlist(loopback, false))
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		if status, body := handshake(t, nil); status != http.StatusOK || !strings.HasPrefix(body, "0{") {
+			t.Fatalf("expected an OPEN packet, got %d %s", status, body)
+		}
+		c, _ := openWebSocketSession(ctx, t)
+		c.Close(websocket.StatusNormalClosure, "")
+	})
+
+	t.Run("should refuse the other clients before any session exists", func(t *testing.T) {
+		server := start(t, testserver.WithAllowlist(nil, false))
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		const message = "address not allowed"
+
+		status, body := handshake(t, nil)
+		if expected := `{"code":4,"message":"` + message + `"}`; status != http.StatusForbidden || body != expected {
+			t.Fatalf("expected 403 %s, got %d %s", expected, status, body)
+		}
+
+		c, resp, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+		if err == nil {
+			c.CloseNow()
+			t.Fatal("expected the upgrade to be refused")
+		}
+		if resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %v", err)
+		}
+		if body, _ := io.ReadAll(resp.Body); string(body) != message {
+			t.Fatalf("expected %q, got %q", message, body)
+		}
+
+		if count := server.Engine().ClientsCount(); count != 0 {
+			t.Fatalf("expected no Engine.IO client, got %d", count)
+		}
+	})
+
+	t.Run("should check the forwarded address only behind a trusted proxy", func(t *testing.T) {
+		documentation := []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}
+		forwarded := http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}}
+		spoofed := http.Header{"X-Forwarded-For": {"203.0.113.7, 198.51.100.1"}}
+
+		start(t, testserver.WithAllowlist(documentation, true))
+		if status, body := handshake(t, forwarded); status != http.StatusOK {
+			t.Fatalf("expected the forwarded client to be accepted, got %d %s", status, body)
+		}
+		for _, header := range []http.Header{nil, spoofed} {
+			if status, body := handshake(t, header); status != http.StatusForbidden {
+				t.Fatalf("expected %v to be refused, got %d %s", header, status, body)
+			}
+		}
+
+		start(t, testserver.WithAllowlist(documentation, false))
+		if status, body := handshake(t, forwarded); status != http.StatusForbidden {
+			t.Fatalf("expected the header to be ignored, got %d %s", status, body)
+		}
+	})
+
+	// A namespace middleware refuses the namespace over an open session
+	t.Run("should differ from a namespace middleware refusal", func(t *testing.T) {
+		server := start(t, testserver.WithAllowlist(loopback, false), testserver.WithAuth(nil))
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, sid := openWebSocketSession(ctx, t)
+		defer c.CloseNow()
+		if sid == "" {
+			t.Fatal("expected an Engine.IO sid")
+		}
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("4")) || !strings.Contains(p.String(), `"unauthorized"`) {
+			t.Fatalf("expected a CONNECT_ERROR packet, got %s", p)
+		}
+		if count := server.Engine().ClientsCount(); count != 1 {
+			t.Fatalf("expected the Engine.IO session to stay open, got %d clients", count)
+		}
+	})
+}
+
+// The custom path profile runs embedded, as every other test uses /socket.io/.
+func TestCustomPath(t *testing.T) {
+	variants := []struct {
+		name  string
+		start func(addr string) (*socket.Server, error)
+	}{
+		{"New", func(addr string) (*socket.Server, error) {
+			server, _, err := testserver.New(addr, testserver.WithPath("/ws"))
+			return server, err
+		}},
+		{"NewServeMux", func(addr string) (*socket.Server, error) {
+			server, _, err := testserver.NewServeMux(addr, testserver.WithPath("/ws/"))
+			return server, err
+		}},
+	}
+
+	for _, variant := range variants {
+		t.Run(variant.name, func(t *testing.T) {
+			addr := freeAddr(t)
+			server, err := variant.start(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer testserver.Shutdown(context.Background(), server, 0)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			t.Run("should handshake, upgrade and echo at the custom path", func(t *testing.T) {
+				url := "http://" + addr + "/ws/?EIO=4&transport=polling"
+				packets := pollUntil(t, url, "0")
+				var open map[string]any
+				decodeOpen(t, []byte(packets[len(packets)-1]), &open)
+				sid, _ := open["sid"].(string)
+				url += "&sid=" + sid
+
+				if status := postPayload(t, url, "40", false); status != http.StatusOK {
+					t.Fatalf("expected status 200, got %d", status)
+				}
+				pollUntil(t, url, "40")
+
+				c, _, err := websocket.Dial(ctx, "ws://"+addr+"/ws/?EIO=4&transport=websocket&sid="+sid, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close(websocket.StatusNormalClosure, "")
+				for _, step := range [][2]string{{"2probe", "3probe"}, {"5", ""}, {`42["message","hi"]`, `42["message-back","hi"]`}} {
+					if err := c.Write(ctx, websocket.MessageText, []byte(step[0])); err != nil {
+						t.Fatal(err)
+					}
+					if step[1] == "" {
+						continue
+					}
+					// Skip PINGs and the auth event of the connection
+					p, err := waitForPacket(ctx, c)
+					for err == nil && p.String() != step[1] && (p.Type == eiop.Ping || (p.Type == eiop.Message && bytes.HasPrefix(p.Data, []byte(`2["auth"`)))) {
+						p, err = waitForPacket(ctx, c)
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if p.String() != step[1] {
+						t.Fatalf("expected %s, got %s", step[1], p)
+					}
+				}
+			})
+
+			t.Run("should not serve the default path", func(t *testing.T) {
+				resp, err := http.Get("http://" + addr + "/socket.io/?EIO=4&transport=polling")
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNotFound {
+					t.Fatalf("expected status 404, got %d", resp.StatusCode)
+				}
+
+				c, resp, err := websocket.Dial(ctx, "ws://"+addr+"/socket.io/?EIO=4&transport=websocket", nil)
+				if err == nil {
+					c.CloseNow()
+					t.Fatal("expected the upgrade to fail")
+				}
+				if resp == nil || resp.StatusCode != http.StatusNotFound {
+					t.Fatalf("expected status 404, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+// socketDir returns a short temporary directory, as the path of a unix socket
+// is limited to about a hundred bytes.
+func socketDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// unixClient returns an HTTP client whose connections all go to the unix
+// socket at path, whatever the host of the URL.
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+// expectUnixHandshake opens a polling session over the unix socket at path,
+// connects it to the main namespace and checks the echo of a message.
+func expectUnixHandshake(t *testing.T, path string) {
+	t.Helper()
+
+	client := unixClient(path)
+	defer client.CloseIdleConnections()
+	url := "http://unix/socket.io/?EIO=4&transport=polling"
+
+	get := func() string {
+		t.Helper()
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d %s", resp.StatusCode, body)
+		}
+		return string(body)
+	}
+	post := func(body string) {
+		t.Helper()
+		resp, err := client.Post(url, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+	}
+	// until polls until a packet has the prefix, answering PINGs
+	until := func(prefix string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			body := get()
+			packets, err := eiop.DecodePayload([]byte(body))
+			if err != nil {
+				t.Fatalf("invalid payload %q: %v", body, err)
+			}
+			for _, packet := range packets {
+				if packet.Type == eiop.Ping {
+					post("3")
+				}
+				if strings.HasPrefix(packet.String(), prefix) {
+					return
+				}
+			}
+		}
+		t.Fatalf("timed out waiting for %s", prefix)
+	}
+
+	var open map[string]any
+	decodeOpen(t, []byte(get()), &open)
+	url += "&sid=" + open["sid"].(string)
+
+	post("40")
+	until("40")
+	post(`42["message","over unix"]`)
+	until(`42["message-back","over unix"]`)
+	post("1")
+}
+
+func TestUnixSocket(t *testing.T) {
+	t.Run("should serve polling and websocket sessions", func(t *testing.T) {
+		path := filepath.Join(socketDir(t), "server.sock")
+		server, _, err := testserver.New(freeAddr(t), testserver.WithUnixSocket(path, 0o600))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close(nil)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Type() != fs.ModeSocket || info.Mode().Perm() != 0o600 {
+			t.Fatalf("expected a socket with mode 0600, got %v", info.Mode())
+		}
+
+		expectUnixHandshake(t, path)
+
+		// The websocket library dials through the transport of the client
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		c, _, err := websocket.Dial(ctx, "ws://unix/socket.io/?EIO=4&transport=websocket", &websocket.DialOptions{
+			HTTPClient: unixClient(path),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.CloseNow()
+		if p, err := waitForPacket(ctx, c); err != nil || p.Type != eiop.Open {
+			t.Fatalf("expected an OPEN packet, got %q, %v", p, err)
+		}
+		for _, step := range [][2]string{{"40", "40"}, {`42["message","over unix"]`, `42["message-back","over unix"]`}} {
+			if err := c.Write(ctx, websocket.MessageText, []byte(step[0])); err != nil {
+				t.Fatal(err)
+			}
+			p, err := waitForPacket(ctx, c)
+			for err == nil && (p.Type == eiop.Ping || (p.Type == eiop.Message && bytes.HasPrefix(p.Data, []byte(`2["auth"`)))) {
+				p, err = waitForPacket(ctx, c)
+			}
+			if err != nil || !strings.HasPrefix(p.String(), step[1]) {
+				t.Fatalf("expected %s, got %q, %v", step[1], p, err)
+			}
+		}
+	})
+
+	t.Run("should replace a stale socket and remove it on shutdown", func(t *testing.T) {
+		path := filepath.Join(socketDir(t), "server.sock")
+
+		// A listener closed without unlinking leaves its file behind, like a
+		// crashed server
+		stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stale.SetUnlinkOnClose(false)
+		stale.Close()
+
+		server, _, err := testserver.New(freeAddr(t), testserver.WithUnixSocket(path, 0o660))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectUnixHandshake(t, path)
+
+		// The socket is in use now
+		if _, _, err := testserver.New(freeAddr(t), testserver.WithUnixSocket(path, 0o660)); err == nil {
+			t.Fatal("expected the socket of a running server to be kept")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := testserver.Shutdown(ctx, server, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected the socket to be removed, got %v", err)
+		}
+	})
+
+	t.Run("should keep other files", func(t *testing.T) {
+		path := filepath.Join(socketDir(t), "server.sock")
+		if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := testserver.New(freeAddr(t), testserver.WithUnixSocket(path, 0o660)); err == nil {
+			t.Fatal("expected a regular file to be kept")
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+			t.Fatalf("expected the file to be intact, got %q, %v", data, err)
+		}
+	})
+
+	// The signal handling of the command is covered by building and running it
+	t.Run("should remove the socket when the command is signaled", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("SIGTERM cannot be sent on Windows")
+		}
+		dir := socketDir(t)
+		path := filepath.Join(dir, "server.sock")
+		bin := filepath.Join(dir, "server")
+		if out, err := exec.Command("go", "build", "-o", bin, "./servers").CombinedOutput(); err != nil {
+			t.Fatalf("go build: %v\n%s", err, out)
+		}
+
+		var logs bytes.Buffer
+		cmd := exec.Command(bin, "-addr", freeAddr(t), "-small-buffer-addr", freeAddr(t), "-listen-unix", path, "-shutdown-grace", "2s")
+		cmd.Stderr = &logs
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer cmd.Process.Kill()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, err := os.Lstat(path); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the socket was not created:\n%s", logs.String())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		expectUnixHandshake(t, path)
+
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("expected a clean exit, got %v:\n%s", err, logs.String())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the command did not exit")
+		}
+		if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected the socket to be removed, got %v", err)
+		}
+	})
+}
+
+// The polling-only profile runs embedded, with the transports restricted the
+// way -transports polling does.
+func TestPollingOnly(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithTransports("polling"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	t.Run("should advertise no upgrade", func(t *testing.T) {
+		packets := pollUntil(t, URL+"/socket.io/?EIO=4&transport=polling", "0")
+		var open struct {
+			Upgrades []string `json:"upgrades"`
+		}
+		decodeOpen(t, []byte(packets[len(packets)-1]), &open)
+		if open.Upgrades == nil || len(open.Upgrades) != 0 {
+			t.Fatalf("expected an empty upgrades array, got %v", open.Upgrades)
+		}
+	})
+
+	t.Run("should refuse websocket connections", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		// Neither a websocket session nor the upgrade of a polling one: the
+		// engine answers any websocket handshake with 501 when it is disabled
+		for _, query := range []string{"", "&sid=" + initLongPollingSession(t)} {
+			c, resp, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket"+query, nil)
+			if err == nil {
+				c.Close(websocket.StatusNormalClosure, "")
+				t.Fatalf("expected the websocket connection %q to be refused", query)
+			}
+			if resp == nil || resp.StatusCode != http.StatusNotImplemented {
+				t.Fatalf("expected status 501, got %v (%v)", resp, err)
+			}
+		}
+	})
+
+	t.Run("should connect, acknowledge and send binary attachments over polling", func(t *testing.T) {
+		sid, _ := initLongPollingSocketIOSession(t)
+		url := pollingURL(sid)
+
+		push(t, sid, `42456["message-with-ack",1,"2",{"3":[false]}]`)
+		if packets := pollUntil(t, url, "43"); packets[len(packets)-1] != `43456[1,"2",{"3":[false]}]` {
+			t.Fatalf("expected the ack, got %q", packets)
+		}
+
+		// Each attachment travels base64-encoded as its own packet, "b" then
+		// the data, right after the packet it belongs to
+		push(t, sid, `451-789["message-with-ack",{"_placeholder":true,"num":0}]`, "bAQID")
+		var packets []string
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+			for _, packet := range poll(t, sid) {
+				if packet.Type == eiop.Ping {
+					push(t, sid, "3")
+				} else if strings.HasPrefix(packet.String(), "46") || len(packets) > 0 {
+					packets = append(packets, packet.String())
+				}
+			}
+			if len(packets) >= 2 {
+				break
+			}
+		}
+		if !slices.Equal(packets, []string{`461-789[{"_placeholder":true,"num":0}]`, "bAQID"}) {
+			t.Fatalf("expected the binary ack and its attachment [1 2 3], got %q", packets)
+		}
+	})
+}
+
+// The websocket-only profile runs embedded, with the transports restricted
+// the way -transports websocket does.
+func TestWebSocketOnly(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithTransports("websocket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	t.Run("should reject polling handshakes as an unknown transport", func(t *testing.T) {
+		resp, err := http.Get(URL + "/socket.io/?EIO=4&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || string(body) != `{"code":0,"message":"Transport unknown"}` {
+			t.Fatalf("expected 400 Transport unknown, got %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("should advertise no upgrade over websocket", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var open struct {
+			Upgrades []string `json:"upgrades"`
+		}
+		if p.Type != eiop.Open || json.Unmarshal(p.Data, &open) != nil {
+			t.Fatalf("expected an Engine.IO handshake, got %q", p)
+		}
+		if open.Upgrades == nil || len(open.Upgrades) != 0 {
+			t.Fatalf("expected an empty upgrades array, got %v", open.Upgrades)
+		}
+	})
+
+	t.Run("should keep the session alive while PINGs are answered", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		// Three heartbeats outlast a single ping interval and timeout
+		for range 3 {
+			if p, err := waitForPacket(ctx, c); err != nil || p.Type != eiop.Ping {
+				t.Fatalf("expected a PING, got %s (%v)", p, err)
+			}
+			if err := c.Write(ctx, websocket.MessageText, []byte("3")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("should echo events and acknowledge them", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["message","hello"]`)); err != nil {
+			t.Fatal(err)
+		}
+		if args, err := waitForEvent(ctx, c, "message-back"); err != nil || len(args) != 1 || args[0] != "hello" {
+			t.Fatalf("expected message-back hello, got %v (%v)", args, err)
+		}
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42456["message-with-ack",1,"2",{"3":[false]}]`)); err != nil {
+			t.Fatal(err)
+		}
+		packets, err := waitForPackets(ctx, c, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packets[0] != `43456[1,"2",{"3":[false]}]` {
+			t.Fatalf("expected the ack, got %v", packets[0])
+		}
+	})
+}
+
+// The cookie profile runs embedded over TLS, the way -cookie-name sticky
+// -cookie-samesite none -cookie-secure with -tls-self-signed does.
+func TestCookie(t *testing.T) {
+	// handshakeCookies returns the cookies set on a polling handshake
+	handshakeCookies := func(t *testing.T, client *http.Client, url string) []*http.Cookie {
+		t.Helper()
+
+		resp, err := client.Get(url + "/socket.io/?EIO=4&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+		return resp.Cookies()
+	}
+
+	t.Run("should set no cookie with the default profile", func(t *testing.T) {
+		if cookies := handshakeCookies(t, http.DefaultClient, URL); len(cookies) != 0 {
+			t.Fatalf("expected no cookie, got %v", cookies)
+		}
+	})
+
+	cert, err := testserver.SelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr,
+		testserver.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		testserver.WithCookie(&http.Cookie{Name: "sticky", SameSite: http.SameSiteNoneMode, Secure: true}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	// expectCookie asserts that cookies are the configured cookie alone
+	expectCookie := func(t *testing.T, cookies []*http.Cookie) {
+		t.Helper()
+
+		if len(cookies) != 1 {
+			t.Fatalf("expected one cookie, got %v", cookies)
+		}
+		cookie := cookies[0]
+		if cookie.Name != "sticky" || cookie.Path != "/" || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteNoneMode {
+			t.Fatalf("expected sticky=; Path=/; HttpOnly; Secure; SameSite=None, got %s", cookie)
+		}
+	}
+
+	t.Run("should set the cookie on polling handshakes", func(t *testing.T) {
+		expectCookie(t, handshakeCookies(t, client, "https://"+addr))
+	})
+
+	// The engine checks the handshake request for a sid, rather than each
+	// request, so the whole polling session carries the cookie
+	t.Run("should set the cookie on every polling response of the session", func(t *testing.T) {
+		resp, err := client.Get("https://" + addr + "/socket.io/?EIO=4&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var open struct {
+			Sid string `json:"sid"`
+		}
+		decodeOpen(t, body, &open)
+
+		resp, err = client.Post("https://"+addr+"/socket.io/?EIO=4&transport=polling&sid="+open.Sid, "text/plain", strings.NewReader("40"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+		expectCookie(t, resp.Cookies())
+	})
+}
+
+// The origin-checking profile runs embedded, the way -cors-origins does.
+func TestAllowedOrigins(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithAllowedOrigins("https://app.example.com", "https://*.example.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	url := "http://" + addr + "/socket.io/?EIO=4&transport=polling"
+
+	// request sends a request to url with the given Origin, if any
+	request := func(t *testing.T, method, origin string) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "content-type")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	t.Run("should allow the listed origins with credentials", func(t *testing.T) {
+		for _, origin := range []string{"https://app.example.com", "https://a.example.org", "https://a.b.example.org"} {
+			resp, body := request(t, http.MethodGet, origin)
+			if resp.StatusCode != http.StatusOK || !strings.HasPrefix(body, "0{") {
+				t.Fatalf("%s: expected a handshake, got %d %q", origin, resp.StatusCode, body)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != origin {
+				t.Fatalf("%s: expected the origin to be reflected, got %q", origin, got)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+				t.Fatalf("%s: expected credentials, got %q", origin, got)
+			}
+		}
+	})
+
+	t.Run("should answer the preflight requests of the listed origins", func(t *testing.T) {
+		resp, _ := request(t, http.MethodOptions, "https://a.example.org")
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", resp.StatusCode)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Headers"); got != "content-type" {
+			t.Fatalf("expected the requested headers to be allowed, got %q", got)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Fatalf("expected credentials, got %q", got)
+		}
+	})
+
+	t.Run("should refuse the other origins with 403 and no CORS headers", func(t *testing.T) {
+		for _, origin := range []string{
+			"https://evil.com",
+			"http://app.example.com",     // scheme
+			"https://app.example.com:81", // port
+			"https://example.org",        // apex of the wildcard
+			"https://example.org.evil.com",
+			"null",
+		} {
+			for _, method := range []string{http.MethodGet, http.MethodOptions} {
+				resp, body := request(t, method, origin)
+				if resp.StatusCode != http.StatusForbidden || body != `{"code":4,"message":"origin not allowed"}` {
+					t.Fatalf("%s %s: expected 403 origin not allowed, got %d %q", method, origin, resp.StatusCode, body)
+				}
+				for name := range resp.Header {
+					if strings.HasPrefix(name, "Access-Control-") {
+						t.Fatalf("%s %s: unexpected %s header", method, origin, name)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("should refuse websocket handshakes from the other origins", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		_, resp, err := websocket.Dial(ctx, "ws://"+addr+"/socket.io/?EIO=4&transport=websocket", &websocket.DialOptions{
+			HTTPHeader: http.Header{"Origin": {"https://evil.com"}},
+		})
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected status 403, got %v (%v)", resp, err)
+		}
+
+		c, _, err := websocket.Dial(ctx, "ws://"+addr+"/socket.io/?EIO=4&transport=websocket", &websocket.DialOptions{
+			HTTPHeader: http.Header{"Origin": {"https://app.example.com"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close(websocket.StatusNormalClosure, "")
+	})
+
+	t.Run("should let requests without an Origin through", func(t *testing.T) {
+		resp, body := request(t, http.MethodGet, "")
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(body, "0{") {
+			t.Fatalf("expected a handshake, got %d %q", resp.StatusCode, body)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Fatalf("expected no CORS headers, got %q", got)
+		}
+	})
+
+	t.Run("should reject invalid origins", func(t *testing.T) {
+		for _, pattern := range []string{"app.example.com", "https://app.example.com/", "https://a.*.example.com"} {
+			if _, _, err := testserver.New(freeAddr(t), testserver.WithAllowedOrigins(pattern)); err == nil {
+				t.Fatalf("expected %q to be rejected", pattern)
+			}
+		}
+	})
+}
+
+// The engine's GenerateId hook cannot be replaced in this version of the
+// library, so the ids are the ones of its default generator, whose properties
+// any replacement would have to keep.
+func TestSessionIds(t *testing.T) {
+	const handshakes = 100
+
+	t.Run("should generate URL-safe and unique sids", func(t *testing.T) {
+		format := regexp.MustCompile(`^[A-Za-z0-9_-]{24}$`)
+		seen := make(map[string]bool, handshakes)
+		for range handshakes {
+			sid := initLongPollingSession(t)
+			if !format.MatchString(sid) {
+				t.Fatalf("expected 24 base64url characters, got %q", sid)
+			}
+			if seen[sid] {
+				t.Fatalf("sid %q generated twice", sid)
+			}
+			seen[sid] = true
+		}
+	})
+}
+
+// The compression variant runs embedded, with a 1KB threshold for both the
+// polling responses and the websocket frames.
+func TestCompression(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithCompression(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	// get polls the session with an explicit Accept-Encoding, which keeps the
+	// transport from decompressing the body
+	get := func(t *testing.T, sid string) (*http.Response, []byte) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, pollingURL(sid), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	t.Run("should gzip the large polling responses", func(t *testing.T) {
+		sid, _ := initLongPollingSocketIOSession(t)
+		push(t, sid, `42["big-payload"]`)
+
+		resp, body := get(t, sid)
+		if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("expected a gzip response, got %q", got)
+		}
+		if len(body) > 10*1024 {
+			t.Fatalf("expected the payload to compress, got %d bytes", len(body))
+		}
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args, ok := decodeEvent(string(data), "big-payload")
+		if !ok || len(args) != 1 {
+			t.Fatalf("expected a big-payload event, got %.100q", data)
+		}
+		if payload, _ := args[0].(string); len(payload) < 200*1000 {
+			t.Fatalf("expected a 200KB payload, got %d bytes", len(payload))
+		}
+	})
+
+	t.Run("should send the small polling responses as is", func(t *testing.T) {
+		sid, _ := initLongPollingSocketIOSession(t)
+		push(t, sid, `42["message","hello"]`)
+
+		resp, body := get(t, sid)
+		if got := resp.Header.Get("Content-Encoding"); got != "" {
+			t.Fatalf("expected no compression, got %q", got)
+		}
+		if string(body) != `42["message-back","hello"]` {
+			t.Fatalf("expected message-back, got %q", body)
+		}
+	})
+
+	t.Run("should negotiate permessage-deflate", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, resp, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=4&transport=websocket", &websocket.DialOptions{
+			CompressionMode: websocket.CompressionNoContextTakeover,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close(websocket.StatusNormalClosure, "")
+		if got := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.HasPrefix(got, "permessage-deflate") {
+			t.Fatalf("expected permessage-deflate, got %q", got)
+		}
+	})
+
+	t.Run("should send large payloads over a compressed websocket", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := initSocketIOSessionWith(t, "", &websocket.DialOptions{
+			CompressionMode: websocket.CompressionNoContextTakeover,
+		})
+		defer c.Close(websocket.StatusNormalClosure, "")
+		c.SetReadLimit(1 << 20)
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["big-payload"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "big-payload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload, _ := args[0].(string); len(payload) < 200*1000 {
+			t.Fatalf("expected a 200KB payload, got %d bytes", len(payload))
+		}
+	})
+
+	t.Run("should not negotiate permessage-deflate by default", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close(nil)
+
+		c, resp, err := websocket.Dial(ctx, "ws://"+addr+"/socket.io/?EIO=4&transport=websocket", &websocket.DialOptions{
+			CompressionMode: websocket.CompressionNoContextTakeover,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close(websocket.StatusNormalClosure, "")
+		if got := resp.Header.Get("Sec-WebSocket-Extensions"); got != "" {
+			t.Fatalf("expected no extension, got %q", got)
+		}
+	})
+}
+
+// encodeV3Payload frames packets as an Engine.IO 3 polling payload, each
+// prefixed with its length and a colon.
+func encodeV3Payload(packets ...string) string {
+	var b strings.Builder
+	for _, packet := range packets {
+		fmt.Fprintf(&b, "%d:%s", len([]rune(packet)), packet)
+	}
+	return b.String()
+}
+
+// decodeV3Payload splits an Engine.IO 3 polling payload into its packets.
+func decodeV3Payload(payload string) ([]string, error) {
+	var packets []string
+	data := []rune(payload)
+	for len(data) > 0 {
+		i := slices.Index(data, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("missing length in %q", string(data))
+		}
+		n, err := strconv.Atoi(string(data[:i]))
+		if err != nil || i+1+n > len(data) {
+			return nil, fmt.Errorf("invalid length in %q", string(data))
+		}
+		packets = append(packets, string(data[i+1:i+1+n]))
+		data = data[i+1+n:]
+	}
+	return packets, nil
+}
+
+// The EIO=3 clients, of Socket.IO 2.x, are only accepted by a server started
+// with -allow-eio3, which runs embedded.
+func TestEngineIOv3(t *testing.T) {
+	t.Run("should refuse EIO=3 handshakes by default", func(t *testing.T) {
+		for _, query := range []string{"EIO=3&transport=polling", "transport=polling"} {
+			resp, err := http.Get(URL + "/socket.io/?" + query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusBadRequest || string(body) != `{"code":4,"message":"Unsupported protocol version"}` {
+				t.Fatalf("%s: expected an unsupported protocol version, got %d %q", query, resp.StatusCode, body)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=3&transport=websocket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.CloseNow()
+		_, _, err = c.Read(ctx)
+		if websocket.CloseStatus(err) != websocket.StatusNormalClosure || !strings.Contains(err.Error(), "Unsupported protocol version") {
+			t.Fatalf("expected the websocket to be closed, got %v", err)
+		}
+	})
+
+	handler := newRecordingHandler()
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithAllowEIO3(), testserver.WithLogger(slog.New(handler)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	// get polls the session and decodes the payload
+	get := func(t *testing.T, sid string) []string {
+		t.Helper()
+
+		resp, err := http.Get(URL + "/socket.io/?EIO=3&transport=polling&sid=" + sid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 for poll, got %d %q", resp.StatusCode, body)
+		}
+		packets, err := decodeV3Payload(string(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packets
+	}
+
+	// post sends the packets to the session in one payload
+	post := func(t *testing.T, sid string, packets ...string) {
+		t.Helper()
+
+		resp, err := http.Post(URL+"/socket.io/?EIO=3&transport=polling&sid="+sid, "text/plain", strings.NewReader(encodeV3Payload(packets...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("expected 200 for push, got %d %q", resp.StatusCode, body)
+		}
+	}
+
+	// handshake opens a polling session, which the server connects to the
+	// main namespace, consuming the CONNECT and "auth" packets
+	handshake := func(t *testing.T) string {
+		t.Helper()
+
+		resp, err := http.Get(URL + "/socket.io/?EIO=3&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets, err := decodeV3Payload(string(body))
+		if err != nil || len(packets) == 0 || !strings.HasPrefix(packets[0], "0{") {
+			t.Fatalf("expected an Engine.IO 3 handshake, got %q (%v)", body, err)
+		}
+		var open struct {
+			Sid      string   `json:"sid"`
+			Upgrades []string `json:"upgrades"`
+		}
+		if err := json.Unmarshal([]byte(packets[0][1:]), &open); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(open.Upgrades, []string{"websocket"}) {
+			t.Fatalf("expected the websocket upgrade, got %v", open.Upgrades)
+		}
+
+		packets = packets[1:]
+		for len(packets) < 2 {
+			packets = append(packets, get(t, open.Sid)...)
+		}
+		// Engine.IO 3 clients are connected without sending CONNECT, which
+		// carries no sid, the socket taking the one of the session
+		if packets[0] != "40" || !strings.HasPrefix(packets[1], `42["auth",`) {
+			t.Fatalf("expected the CONNECT and auth packets, got %q", packets)
+		}
+		return open.Sid
+	}
+
+	t.Run("should connect polling clients to the main namespace", func(t *testing.T) {
+		sid := handshake(t)
+
+		post(t, sid, `42["message","héllo"]`)
+		if packets := get(t, sid); !slices.Equal(packets, []string{`42["message-back","héllo"]`}) {
+			t.Fatalf("expected message-back, got %q", packets)
+		}
+	})
+
+	t.Run("should answer the pings of the client", func(t *testing.T) {
+		sid := handshake(t)
+
+		// The server does not ping EIO=3 clients, which ping it instead
+		post(t, sid, "2probe")
+		if packets := get(t, sid); len(packets) != 1 || !strings.HasPrefix(packets[0], "3") {
+			t.Fatalf("expected a PONG, got %q", packets)
+		}
+	})
+
+	t.Run("should connect websocket clients to the main namespace", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, WS_URL+"/socket.io/?EIO=3&transport=websocket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		packets, err := waitForPackets(ctx, c, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := packets[0].(string); !strings.HasPrefix(data, "0{") || !strings.Contains(data, `"upgrades":[]`) {
+			t.Fatalf("expected an Engine.IO handshake, got %q", packets[0])
+		}
+		if packets[1] != "40" {
+			t.Fatalf("expected a CONNECT packet, got %q", packets[1])
+		}
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["message","hello"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "message-back")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, []any{"hello"}) {
+			t.Fatalf("unexpected message-back %v", args)
+		}
+	})
+
+	t.Run("should keep serving EIO=4 clients", func(t *testing.T) {
+		c, _ := initSocketIOSession(t)
+		c.Close(websocket.StatusNormalClosure, "")
+
+		sid, _ := initLongPollingSocketIOSession(t)
+		push(t, sid, `42["message","hello"]`)
+		if packets := poll(t, sid); len(packets) != 1 || packets[0].String() != `42["message-back","hello"]` {
+			t.Fatalf("expected message-back, got %q", packets)
+		}
+	})
+
+	t.Run("should log the protocol of each connection", func(t *testing.T) {
+		sid := handshake(t)
+		_, socketSid := initLongPollingSocketIOSession(t)
+
+		for sid, protocol := range map[string]int64{sid: 3, socketSid: 4} {
+			connected, ok := handler.find("connection established", sid)
+			if !ok {
+				t.Fatalf("expected a connection record for %s", sid)
+			}
+			if connected["protocol"] != protocol {
+				t.Fatalf("expected protocol %d, got %v", protocol, connected["protocol"])
+			}
+		}
+	})
+}
+
+// nextPacket returns the next packet other than a PING, answering it.
+func nextPacket(ctx context.Context, t *testing.T, c *websocket.Conn) string {
+	t.Helper()
+
+	for {
+		p, err := waitForPacket(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != eiop.Ping {
+			return p.String()
+		}
+		if err := c.Write(ctx, websocket.MessageText, []byte("3")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// connectNamespace connects the websocket session to the namespace nsp,
+// consuming the "auth" packet, and returns the Socket.IO sid.
+func connectNamespace(ctx context.Context, t *testing.T, c *websocket.Conn, nsp string) string {
+	t.Helper()
+
+	if err := c.Write(ctx, websocket.MessageText, []byte("40"+nsp+",")); err != nil {
+		t.Fatal(err)
+	}
+	data := nextPacket(ctx, t, c)
+	var connect struct {
+		Sid string `json:"sid"`
+	}
+	payload, ok := strings.CutPrefix(data, "40"+nsp+",")
+	if !ok || json.Unmarshal([]byte(payload), &connect) != nil || connect.Sid == "" {
+		t.Fatalf("expected a handshake for %s, got %q", nsp, data)
+	}
+	if data := nextPacket(ctx, t, c); data != "42"+nsp+`,["auth",{}]` {
+		t.Fatalf("expected the auth echo of %s, got %q", nsp, data)
+	}
+	return connect.Sid
+}
+
+// The namespaces matching RoomNamespaces are created on demand, each joining
+// its sockets to the room named after it.
+func TestRoomNamespaces(t *testing.T) {
+
+	t.Run("should join the room named after the namespace", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		for _, nsp := range []string{"/room-lobby", "/room-42"} {
+			sid := connectNamespace(ctx, t, c, nsp)
+			if err := c.Write(ctx, websocket.MessageText, []byte("42"+nsp+`,["my-rooms"]`)); err != nil {
+				t.Fatal(err)
+			}
+			data := nextPacket(ctx, t, c)
+			var event []any
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "42"+nsp+",")), &event); err != nil || len(event) != 2 || event[0] != "my-rooms" {
+				t.Fatalf("expected my-rooms, got %q", data)
+			}
+			var rooms []string
+			for _, room := range event[1].([]any) {
+				rooms = append(rooms, room.(string))
+			}
+			expected := []string{sid, nsp}
+			slices.Sort(rooms)
+			slices.Sort(expected)
+			if !slices.Equal(rooms, expected) {
+				t.Fatalf("%s: expected rooms %v, got %v", nsp, expected, rooms)
+			}
+		}
+	})
+
+	t.Run("should echo messages", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		connectNamespace(ctx, t, c, "/room-lobby")
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42/room-lobby,["message","hello"]`)); err != nil {
+			t.Fatal(err)
+		}
+		if data := nextPacket(ctx, t, c); data != `42/room-lobby,["message-back","hello"]` {
+			t.Fatalf("expected message-back, got %q", data)
+		}
+	})
+
+	t.Run("should refuse the names that do not match", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		for _, nsp := range []string{"/room-", "/room-Lobby", "/room-a-b", "/rooms-a", "/room-a/b"} {
+			if err := c.Write(ctx, websocket.MessageText, []byte("40"+nsp+",")); err != nil {
+				t.Fatal(err)
+			}
+			if data := nextPacket(ctx, t, c); data != "44"+nsp+`,{"message":"Invalid namespace"}` {
+				t.Fatalf("%s: expected an invalid namespace, got %q", nsp, data)
+			}
+		}
+	})
+
+	t.Run("should log the creation of each namespace", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		handler := newRecordingHandler()
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr, testserver.WithLogger(slog.New(handler)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close(nil)
+		useServer(t, addr)
+
+		// The second socket joins the namespace created for the first one
+		for range 2 {
+			c, _ := openWebSocketSession(ctx, t)
+			defer c.Close(websocket.StatusNormalClosure, "")
+			connectNamespace(ctx, t, c, "/room-lobby")
+		}
+
+		// created counts the records of the namespace nsp
+		created := func(nsp string) int {
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			count := 0
+			for _, record := range *handler.records {
+				if record["msg"] == "namespace created" && record["namespace"] == nsp {
+					count++
+				}
+			}
+			return count
+		}
+		// The static namespaces are logged as they are registered
+		for _, nsp := range []string{"/room-lobby", "/custom"} {
+			if count := created(nsp); count != 1 {
+				t.Fatalf("expected %s to be created once, got %d records", nsp, count)
+			}
+		}
+	})
+}
+
+// The namespaces created on demand are only deleted once empty with
+// -namespace-cleanup, which runs embedded.
+func TestNamespaceCleanup(t *testing.T) {
+	// children lists the namespaces created on demand, as known to the server
+	// of the monitor socket
+	children := func(ctx context.Context, t *testing.T, monitor *websocket.Conn) []string {
+		t.Helper()
+
+		reply := emitWithAck(ctx, t, monitor, 1, "dynamic-namespaces")
+		var names []string
+		if len(reply) > 0 {
+			for _, name := range reply[0].([]any) {
+				names = append(names, name.(string))
+			}
+		}
+		return names
+	}
+
+	// waitForChildren waits until the namespace nsp is listed, or not
+	waitForChildren := func(ctx context.Context, t *testing.T, monitor *websocket.Conn, nsp string, listed bool) {
+		t.Helper()
+
+		for slices.Contains(children(ctx, t, monitor), nsp) != listed {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("expected %s to be listed: %v, got %v", nsp, listed, children(context.Background(), t, monitor))
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	t.Run("should keep empty namespaces by default", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		monitor := initSocketIOConnection(t)
+		defer monitor.Close(websocket.StatusNormalClosure, "")
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		connectNamespace(ctx, t, c, "/room-kept")
+		if err := c.Write(ctx, websocket.MessageText, []byte("41/room-kept,")); err != nil {
+			t.Fatal(err)
+		}
+		// The disconnection is handled asynchronously, so give it time to
+		// delete the namespace if it were going to
+		time.Sleep(50 * time.Millisecond)
+		if !slices.Contains(children(ctx, t, monitor), "/room-kept") {
+			t.Fatal("expected /room-kept to be kept")
+		}
+	})
+
+	handler := newRecordingHandler()
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithNamespaceCleanup(), testserver.WithLogger(slog.New(handler)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	// logged counts the records with the message msg for the namespace nsp
+	logged := func(msg, nsp string) int {
+		handler.mu.Lock()
+		defer handler.mu.Unlock()
+		count := 0
+		for _, record := range *handler.records {
+			if record["msg"] == msg && record["namespace"] == nsp {
+				count++
+			}
+		}
+		return count
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	monitor := initSocketIOConnection(t)
+	defer monitor.Close(websocket.StatusNormalClosure, "")
+
+	t.Run("should delete a namespace once its last socket leaves", func(t *testing.T) {
+		first, _ := openWebSocketSession(ctx, t)
+		defer first.Close(websocket.StatusNormalClosure, "")
+		second, _ := openWebSocketSession(ctx, t)
+		defer second.Close(websocket.StatusNormalClosure, "")
+		connectNamespace(ctx, t, first, "/room-x")
+		connectNamespace(ctx, t, second, "/room-x")
+		waitForChildren(ctx, t, monitor, "/room-x", true)
+
+		if err := first.Write(ctx, websocket.MessageText, []byte("41/room-x,")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if !slices.Contains(children(ctx, t, monitor), "/room-x") {
+			t.Fatal("expected /room-x to be kept while a socket remains")
+		}
+
+		// Closing the connection removes the socket as well
+		second.Close(websocket.StatusNormalClosure, "")
+		waitForChildren(ctx, t, monitor, "/room-x", false)
+		if count := logged("namespace deleted", "/room-x"); count != 1 {
+			t.Fatalf("expected /room-x to be deleted once, got %d records", count)
+		}
+	})
+
+	t.Run("should recreate a deleted namespace", func(t *testing.T) {
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		sid := connectNamespace(ctx, t, c, "/room-x")
+		waitForChildren(ctx, t, monitor, "/room-x", true)
+		if count := logged("namespace created", "/room-x"); count != 2 {
+			t.Fatalf("expected /room-x to be created twice, got %d records", count)
+		}
+
+		// The new namespace starts without the rooms of the deleted one
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42/room-x,["message","hello"]`)); err != nil {
+			t.Fatal(err)
+		}
+		if data := nextPacket(ctx, t, c); data != `42/room-x,["message-back","hello"]` {
+			t.Fatalf("expected message-back, got %q", data)
+		}
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42/room-x,["my-rooms"]`)); err != nil {
+			t.Fatal(err)
+		}
+		data := nextPacket(ctx, t, c)
+		if expected := `42/room-x,["my-rooms",["` + sid + `","/room-x"]]`; data != expected && data != `42/room-x,["my-rooms",["/room-x","`+sid+`"]]` {
+			t.Fatalf("expected the rooms of %s alone, got %q", sid, data)
+		}
+	})
+
+	t.Run("should keep the static namespaces", func(t *testing.T) {
+		c, _ := openWebSocketSession(ctx, t)
+		connectNamespace(ctx, t, c, "/custom")
+		c.Close(websocket.StatusNormalClosure, "")
+
+		c, _ = openWebSocketSession(ctx, t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+		connectNamespace(ctx, t, c, "/custom")
+		if count := logged("namespace deleted", "/custom"); count != 0 {
+			t.Fatalf("expected /custom to be kept, got %d records", count)
+		}
+		if count := logged("namespace created", "/custom"); count != 1 {
+			t.Fatalf("expected /custom to be created once, got %d records", count)
+		}
+	})
+}
+
+func TestBinaryEcho(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("should echo the attachment as an event without acknowledgement", func(t *testing.T) {
+		c := initSocketIOConnection(t)
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`451-["binary-echo",{"_placeholder":true,"num":0}]`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Write(ctx, websocket.MessageBinary, []byte{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+		if p, err := waitForPacket(ctx, c); err != nil || p.String() != `451-["binary-echo",{"_placeholder":true,"num":0}]` {
+			t.Fatalf("expected the binary-echo event, got %q, %v", p, err)
+		}
+		if typ, data, err := c.Read(ctx); err != nil || typ != websocket.MessageBinary || !bytes.Equal(data, []byte{1, 2, 3}) {
+			t.Fatalf("expected the attachment, got %v %v, %v", typ, data, err)
+		}
+	})
+
+	t.Run("should measure the throughput of the smallest size", func(t *testing.T) {
+		result, err := throughput.Run(ctx, throughput.Config{
+			URL:         URL + testserver.DefaultPath,
+			Size:        1 << 10,
+			Count:       10,
+			Concurrency: 2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Count != 10 || result.Seconds <= 0 || result.MBPerSec <= 0 || result.RoundTripsPerSec <= 0 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+
+	t.Run("should explain an attachment above maxHttpBufferSize", func(t *testing.T) {
+		_, err := throughput.Run(ctx, throughput.Config{
+			URL:         SMALL_BUFFER_URL + testserver.DefaultPath,
+			Size:        SMALL_BUFFER_SIZE + 1,
+			Count:       1,
+			Concurrency: 1,
+		})
+		if err == nil || !strings.Contains(err.Error(), "maxHttpBufferSize") {
+			t.Fatalf("expected the connection to be closed, got %v", err)
+		}
+	})
+}
+
+// The load generator runs briefly against an embedded server, so that other
+// tests do not share it.
+func TestLoad(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("should echo every message without errors", func(t *testing.T) {
+		summary, err := load.Run(ctx, load.Config{
+			URL:          "http://" + addr + testserver.DefaultPath,
+			Connections:  20,
+			Rate:         20,
+			PayloadBytes: 100,
+			Duration:     500 * time.Millisecond,
+			RampUp:       200 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Connected != 20 || summary.Failed != 0 || summary.ErrorCount() != 0 {
+			t.Fatalf("expected 20 clients without errors, got %+v", summary)
+		}
+		// Each client emits at least for the duration, 10 messages at 20/s
+		if summary.Sent < 20*10 || summary.Received != summary.Sent || summary.Rate <= 0 {
+			t.Fatalf("expected every message to be echoed, got %+v", summary)
+		}
+		l := summary.Latency
+		if l.P50 <= 0 || l.P50 > l.P95 || l.P95 > l.P99 || l.P99 > l.Max || l.Max > 1000 {
+			t.Fatalf("implausible latencies %+v", l)
+		}
+
+		var text strings.Builder
+		if err := summary.WriteText(&text); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(text.String(), "connections: 20 connected, 0 failed, of 20\n") || !strings.Contains(text.String(), "errors:      none\n") {
+			t.Fatalf("unexpected summary:\n%s", text.String())
+		}
+	})
+
+	t.Run("should count the clients that cannot connect", func(t *testing.T) {
+		summary, err := load.Run(ctx, load.Config{
+			URL:         "http://" + freeAddr(t) + testserver.DefaultPath,
+			Connections: 3,
+			Rate:        1,
+			Duration:    100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Connected != 0 || summary.Failed != 3 || !reflect.DeepEqual(summary.Errors, map[string]int{"dial": 3}) {
+			t.Fatalf("expected 3 dial errors, got %+v", summary)
+		}
+
+		var out bytes.Buffer
+		if err := summary.WriteJSON(&out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), `"errors":{"dial":3}`) {
+			t.Fatalf("unexpected summary %s", out.String())
+		}
+	})
+}
+
+// The latency benchmark runs against an embedded server, so that the load of
+// other tests does not skew it.
+func TestLatency(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, cfg := range []latency.Config{
+		{Transport: "websocket", Iterations: 200, Warmup: 20, Inflight: 1},
+		{Transport: "polling", Iterations: 200, Warmup: 20, Inflight: 1},
+		{Transport: "websocket", Iterations: 200, Warmup: 20, Inflight: 8},
+	} {
+		t.Run(fmt.Sprintf("should measure %s with %d in flight", cfg.Transport, cfg.Inflight), func(t *testing.T) {
+			cfg.URL = "http://" + addr + testserver.DefaultPath
+			result, err := latency.Run(ctx, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Transport != cfg.Transport || result.Iterations != 200 || result.Inflight != cfg.Inflight {
+				t.Fatalf("unexpected result %+v", result)
+			}
+			if result.Min <= 0 || result.Min > result.P50 || result.P50 > result.P95 || result.P95 > result.P99 || result.P99 > result.Max {
+				t.Fatalf("expected ordered percentiles, got %+v", result)
+			}
+			if result.P50 > 100 {
+				t.Fatalf("expected a p50 under 100ms, got %vms", result.P50)
+			}
+			// The warm-up round trips are left out of the histogram
+			count := 0
+			for _, bucket := range result.Histogram {
+				count += bucket.Count
+			}
+			if count != 200 || result.Histogram[len(result.Histogram)-1].Le != "+Inf" {
+				t.Fatalf("expected 200 round trips in the histogram, got %+v", result.Histogram)
+			}
+
+			var text strings.Builder
+			if err := result.WriteText(&text); err != nil {
+				t.Fatal(err)
+			}
+			if header := fmt.Sprintf("%s: 200 round trips, %d in flight\nmin ", cfg.Transport, cfg.Inflight); !strings.HasPrefix(text.String(), header) {
+				t.Fatalf("unexpected result:\n%s", text.String())
+			}
+		})
+	}
+
+	t.Run("should refuse an unknown transport", func(t *testing.T) {
+		_, err := latency.Run(ctx, latency.Config{URL: "http://" + addr, Transport: "webtransport", Iterations: 1, Inflight: 1})
+		if err == nil || !strings.Contains(err.Error(), "unknown transport") {
+			t.Fatalf("expected the transport to be refused, got %v", err)
+		}
+	})
+}
+
+func TestSoak(t *testing.T) {
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithAdminUI("admin", "secret", 500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	t.Run("should keep the clients connected through the churn", func(t *testing.T) {
+		report, err := soak.Run(ctx, soak.Config{
+			URL:           "http://" + addr + testserver.DefaultPath,
+			Connections:   20,
+			Duration:      6 * time.Second,
+			Rate:          2,
+			Churn:         0.1,
+			ChurnInterval: 2 * time.Second,
+			StatsInterval: 2 * time.Second,
+			AdminUsername: "admin",
+			AdminPassword: "secret",
+			RSS:           soak.ProcessRSS,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Incidents) > 0 {
+			t.Fatalf("expected no incident, got %+v", report.Incidents)
+		}
+		if report.Reconnects == 0 {
+			t.Fatal("expected some clients to be reconnected")
+		}
+		for _, stats := range report.Samples {
+			// At most 2 clients are reconnecting at once
+			if stats.Active < 18 || stats.Errors != 0 {
+				t.Fatalf("unexpected sample %+v", stats)
+			}
+		}
+		last := report.Samples[len(report.Samples)-1]
+		if last.Active != 20 || last.ServerSockets != 20 || last.RSS <= 0 {
+			t.Fatalf("unexpected last sample %+v", last)
+		}
+	})
+
+	t.Run("should report the clients which cannot connect", func(t *testing.T) {
+		report, err := soak.Run(ctx, soak.Config{
+			URL:           "http://" + freeAddr(t) + testserver.DefaultPath,
+			Connections:   2,
+			Duration:      500 * time.Millisecond,
+			Rate:          1,
+			ChurnInterval: time.Second,
+			StatsInterval: time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Incidents) < 2 || report.Incidents[0].Kind != "connect" {
+			t.Fatalf("expected connect incidents, got %+v", report.Incidents)
+		}
+		if last := report.Samples[len(report.Samples)-1]; last.Active != 0 || last.ServerSockets != -1 {
+			t.Fatalf("unexpected last sample %+v", last)
+		}
+	})
+}
+
+// The probe runs against the command itself, started with the small profile,
+// so that the flag is covered along with the limit it selects.
+func TestProbeLimit(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "server")
+	if out, err := exec.Command("go", "build", "-o", bin, "./servers").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	t.Run("should refuse an unknown profile or one combined with -max-buffer", func(t *testing.T) {
+		for _, args := range [][]string{{"-profile", "huge"}, {"-profile", "small", "-max-buffer", "1000"}} {
+			out, err := exec.Command(bin, args...).CombinedOutput()
+			if err == nil || !bytes.Contains(out, []byte("profile")) {
+				t.Fatalf("%v: expected the command to fail, got %v:\n%s", args, err, out)
+			}
+		}
+	})
+
+	addr := freeAddr(t)
+	var logs bytes.Buffer
+	cmd := exec.Command(bin, "-addr", addr, "-small-buffer-addr", freeAddr(t), "-profile", "small")
+	cmd.Stderr = &logs
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for transport, refusal := range map[string]string{
+		"websocket": "close 1009 (message too big)",
+		"polling":   "HTTP 413 Request Entity Too Large",
+	} {
+		result, err := bufferlimit.Probe(ctx, bufferlimit.Config{
+			URL:       "http://" + addr + testserver.DefaultPath,
+			Transport: transport,
+			Start:     1 << 10,
+			Max:       1 << 20,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", transport, err)
+		}
+		// The framing of the messages counts in the limit
+		if result.Payload > 64<<10 || result.Payload < 64<<10-bufferlimit.Overhead {
+			t.Fatalf("%s: expected a payload within %d bytes below 64KB, got %+v", transport, bufferlimit.Overhead, result)
+		}
+		if result.Limit != 64<<10 || result.Refusal != refusal {
+			t.Fatalf("%s: expected a limit of 64KB refused with %s, got %+v", transport, refusal, result)
+		}
+	}
+
+	t.Run("should report a limit out of reach", func(t *testing.T) {
+		_, err := bufferlimit.Probe(ctx, bufferlimit.Config{
+			URL:       "http://" + addr + testserver.DefaultPath,
+			Transport: "websocket",
+			Start:     1 << 10,
+			Max:       32 << 10,
+		})
+		if err == nil || !strings.Contains(err.Error(), "no limit found") {
+			t.Fatalf("expected no limit to be found, got %v", err)
+		}
+	})
+
+	// The logs are complete once the command has exited
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	for _, want := range []string{
+		"max_buffer=65536 profile=small",
+		`msg="payload over maxHttpBufferSize" server=main sid=`,
+		`transport=websocket close_code=1009 reason="transport error" max_buffer=65536`,
+		"transport=polling status=413 content_length=",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("expected the logs to contain %q:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestSafeHandler(t *testing.T) {
+	expectPanicError := func(ctx context.Context, t *testing.T, c *websocket.Conn) {
+		t.Helper()
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["panic-please"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "error")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []any{map[string]any{"event": "panic-please", "message": "internal server error"}}
+		if !reflect.DeepEqual(args, expected) {
+			t.Fatalf("expected %v, got %v", expected, args)
+		}
+	}
+
+	expectEcho := func(ctx context.Context, t *testing.T, c *websocket.Conn) {
+		t.Helper()
+
+		if err := c.Write(ctx, websocket.MessageText, []byte(`42["message","still up"]`)); err != nil {
+			t.Fatal(err)
+		}
+		args, err := waitForEvent(ctx, c, "message-back")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, []any{"still up"}) {
+			t.Fatalf("expected [still up], got %v", args)
+		}
+	}
+
+	t.Run("should send an error event and keep serving after a panic", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		c1 := initSocketIOConnection(t)
+		defer c1.Close(websocket.StatusNormalClosure, "")
+		c2 := initSocketIOConnection(t)
+		defer c2.Close(websocket.StatusNormalClosure, "")
+
+		expectPanicError(ctx, t, c1)
+		expectEcho(ctx, t, c2)
+
+		// The client that sent the event stays connected
+		expectEcho(ctx, t, c1)
+	})
+
+	// The variant disconnecting the client runs embedded
+	t.Run("should disconnect the client with WithDisconnectOnPanic", func(t *testing.T) {
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr, testserver.WithDisconnectOnPanic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close(nil)
+		useServer(t, addr)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		c1 := initSocketIOConnection(t)
+		defer c1.CloseNow()
+		c2 := initSocketIOConnection(t)
+		defer c2.Close(websocket.StatusNormalClosure, "")
+
+		expectPanicError(ctx, t, c1)
+		expectDisconnectThenClose(ctx, t, c1, "41")
+		expectEcho(ctx, t, c2)
+	})
+}
+
+// The handshakes refused by the engine, and the origins refused by
+// WithAllowedOrigins, are logged with their code and a reason telling apart
+// the causes sharing a code.
+func TestConnectionErrorLogging(t *testing.T) {
+	handler := newRecordingHandler()
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr,
+		testserver.WithAllowedOrigins("https://app.example.com"),
+		testserver.WithLogger(slog.New(handler)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+
+	for _, tc := range []struct {
+		reason string
+		code   int64
+		method string
+		query  string
+		origin string
+		attrs  map[string]any
+	}{
+		{"unsupported_protocol", 4, http.MethodGet, "EIO=3&transport=polling", "", map[string]any{"protocol": int64(3)}},
+		{"unknown_transport", 0, http.MethodGet, "EIO=4&transport=carrier-pigeon", "", map[string]any{"transport": "carrier-pigeon"}},
+		{"unknown_sid", 1, http.MethodGet, "EIO=4&transport=polling&sid=gone", "", map[string]any{"sid": "gone", "transport": "polling"}},
+		{"bad_handshake_method", 2, http.MethodPost, "EIO=4&transport=polling", "", map[string]any{"method": http.MethodPost}},
+		{"cors", 4, http.MethodGet, "EIO=4&transport=polling", "https://evil.example.com", map[string]any{"origin": "https://evil.example.com"}},
+	} {
+		url := "http://" + addr + testserver.DefaultPath + "?" + tc.query
+		req, err := http.NewRequest(tc.method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Errorf("%s: expected the request to be refused, got %d", tc.reason, resp.StatusCode)
+		}
+
+		record := func() map[string]any {
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			for _, record := range *handler.records {
+				if record["msg"] == "connection error" && record["reason"] == tc.reason {
+					return record
+				}
+			}
+			return nil
+		}()
+		if record == nil {
+			t.Errorf("%s: expected a connection error record", tc.reason)
+			continue
+		}
+		if record["code"] != tc.code || record["level"] != slog.LevelWarn {
+			t.Errorf("%s: expected code %d at warn, got %v", tc.reason, tc.code, record)
+		}
+		if u, _ := record["url"].(string); !strings.HasSuffix(u, "?"+tc.query) {
+			t.Errorf("%s: expected the request URL, got %v", tc.reason, record["url"])
+		}
+		if remote, _ := record["remote_addr"].(string); !strings.HasPrefix(remote, "127.0.0.1:") {
+			t.Errorf("%s: expected a remote_addr, got %v", tc.reason, record["remote_addr"])
+		}
+		for key, expected := range tc.attrs {
+			if record[key] != expected {
+				t.Errorf("%s: expected %s %v, got %v", tc.reason, key, expected, record[key])
+			}
+		}
+	}
+}
+
+// The refused requests are counted in the metrics, listed to the admins and,
+// once repeated, audited.
+func TestConnectionErrorHistory(t *testing.T) {
+	m := metrics.New()
+	handler := newRecordingHandler()
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr,
+		testserver.WithInstrumentation(m.Instrument),
+		testserver.WithConnectionErrorObserver(m.ObserveConnectionError),
+		testserver.WithConnectionErrorAudit(5, time.Minute),
+		testserver.WithAdminUI("admin", "secret", time.Minute),
+		testserver.WithLogger(slog.New(handler)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close(nil)
+	useServer(t, addr)
+
+	endpoint := httptest.NewServer(m.Handler())
+	defer endpoint.Close()
+
+	refuse := func(t *testing.T, method, query string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, URL+testserver.DefaultPath+"?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "burst/1.0")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Fatalf("%s: expected the request to be refused, got %d", query, resp.StatusCode)
+		}
+	}
+
+	// history asks the admin namespace for the refused requests
+	history := func(t *testing.T) []map[string]any {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		c, _ := openWebSocketSession(ctx, t)
+		defer c.CloseNow()
+		// The server closes the session on an event to a namespace it has
+		// not connected yet
+		if err := c.Write(ctx, websocket.MessageText, []byte(`40/admin,{"username":"admin","password":"secret"}`)); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			p, err := waitForPacket(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type == eiop.Message && bytes.HasPrefix(p.Data, []byte("0/admin,")) {
+				if err := c.Write(ctx, websocket.MessageText, []byte(`42/admin,1["connection_errors"]`)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("3/admin,1")) {
+				continue
+			}
+			var ack [][]map[string]any
+			if err := json.Unmarshal(p.Data[len("3/admin,1"):], &ack); err != nil || len(ack) != 1 {
+				t.Fatalf("expected the list of refused requests, got %s", p)
+			}
+			return ack[0]
+		}
+	}
+
+	burst := []struct {
+		reason string
+		code   float64
+		method string
+		query  string
+	}{
+		{"unknown_transport", 0, http.MethodGet, "EIO=4&transport=carrier-pigeon"},
+		{"unknown_sid", 1, http.MethodGet, "EIO=4&transport=polling&sid=gone"},
+		{"bad_handshake_method", 2, http.MethodPost, "EIO=4&transport=polling"},
+		{"unsupported_protocol", 4, http.MethodGet, "EIO=3&transport=polling"},
+	}
+	const rounds = 3
+	for range rounds {
+		for _, tc := range burst {
+			refuse(t, tc.method, tc.query)
+		}
+	}
+
+	samples := scrape(t, endpoint.URL)
+	for _, tc := range burst {
+		sample := fmt.Sprintf(`engineio_connection_errors_total{code="%d",reason="%s"}`, int(tc.code), tc.reason)
+		if samples[sample] != rounds {
+			t.Errorf("expected %s %d, got %v", sample, rounds, samples[sample])
+		}
+	}
+
+	refused := history(t)
+	if len(refused) != rounds*len(burst) {
+		t.Fatalf("expected %d refused requests, got %d: %v", rounds*len(burst), len(refused), refused)
+	}
+	for i, entry := range refused {
+		tc := burst[i%len(burst)]
+		if entry["code"] != tc.code || entry["reason"] != tc.reason || entry["userAgent"] != "burst/1.0" {
+			t.Errorf("expected %s with code %v, got %v", tc.reason, tc.code, entry)
+		}
+		if u, _ := entry["url"].(string); !strings.HasSuffix(u, "?"+tc.query) {
+			t.Errorf("expected the request URL, got %v", entry["url"])
+		}
+		if remote, _ := entry["remoteAddr"].(string); !strings.HasPrefix(remote, "127.0.0.1:") {
+			t.Errorf("expected a remoteAddr, got %v", entry["remoteAddr"])
+		}
+	}
+
+	var audits []map[string]any
+	handler.mu.Lock()
+	for _, record := range *handler.records {
+		if record["msg"] == "repeated connection errors" {
+			audits = append(audits, record)
+		}
+	}
+	handler.mu.Unlock()
+	// The fifth refusal, the first of the second round, reaches the threshold
+	if len(audits) != 1 {
+		t.Fatalf("expected one audit record, got %v", audits)
+	}
+	expected := map[string]any{"remote_addr": "127.0.0.1", "user_agent": "burst/1.0", "count": int64(5), "reason": "unknown_transport", "code": int64(0)}
+	for key, value := range expected {
+		if audits[0][key] != value {
+			t.Errorf("expected %s %v, got %v", key, value, audits[0][key])
+		}
+	}
+
+	t.Run("should keep the last refused requests", func(t *testing.T) {
+		for i := range testserver.ConnectionErrorHistory {
+			refuse(t, http.MethodGet, fmt.Sprintf("EIO=4&transport=polling&sid=gone-%d", i))
+		}
+		refused := history(t)
+		if len(refused) != testserver.ConnectionErrorHistory {
+			t.Fatalf("expected %d refused requests, got %d", testserver.ConnectionErrorHistory, len(refused))
+		}
+		for i, entry := range refused {
+			if u, _ := entry["url"].(string); !strings.HasSuffix(u, fmt.Sprintf("sid=gone-%d", i)) {
+				t.Fatalf("expected the refused requests in order, got %v at %d", entry["url"], i)
+			}
+		}
+	})
+}
+
+// The termination runs embedded, its phases observed through the logs and the
+// clients.
+func TestTermination(t *testing.T) {
+	const (
+		preStopDelay = 300 * time.Millisecond
+		gracePeriod  = 500 * time.Millisecond
+	)
+
+	handler := newRecordingHandler()
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr, testserver.WithLogger(slog.New(handler)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useServer(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status := func(path string) (int, string, error) {
+		resp, err := http.Get(URL + path)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+
+	leaving := initSocketIOConnection(t)
+	defer leaving.CloseNow()
+	staying := initSocketIOConnection(t)
+	defer staying.CloseNow()
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- testserver.Terminate(ctx, server, preStopDelay, gracePeriod) }()
+
+	// Not ready at once, but alive and still accepting handshakes
+	for {
+		code, _, err := status(testserver.ReadyzPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Since(started) > preStopDelay/2 {
+			t.Fatalf("%s: expected 503 right after the termination began, got %d", testserver.ReadyzPath, code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, _, err := status(testserver.HealthzPath); err != nil || code != http.StatusOK {
+		t.Fatalf("%s: expected 200, got %d (%v)", testserver.HealthzPath, code, err)
+	}
+	late := initSocketIOConnection(t)
+	late.Close(websocket.StatusNormalClosure, "")
+
+	// Once the pre-stop delay elapses, every client is told how long it has
+	for _, c := range []*websocket.Conn{leaving, staying} {
+		args, err := waitForEvent(ctx, c, "server-shutdown")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notice, _ := args[0].(map[string]any); len(args) != 1 || notice["seconds"] != float64(1) {
+			t.Fatalf(`expected {"seconds":1}, got %v`, args)
+		}
+	}
+	if elapsed := time.Since(started); elapsed < preStopDelay {
+		t.Fatalf("expected the notice after %v, got %v", preStopDelay, elapsed)
+	}
+
+	// and the new handshakes are refused
+	deadline := time.Now().Add(gracePeriod / 2)
+	for {
+		code, body, err := status("/socket.io/?EIO=4&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := `{"code":4,"message":"server is shutting down"}`; code == http.StatusForbidden && body == expected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the handshake to be refused, got %d %s", code, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// One client leaves on its own, the other one is disconnected once the
+	// grace period expires
+	leaving.Close(websocket.StatusNormalClosure, "")
+	p, err := waitForPacket(ctx, staying)
+	for err == nil && p.Type == eiop.Ping {
+		staying.Write(ctx, websocket.MessageText, []byte("3"))
+		p, err = waitForPacket(ctx, staying)
+	}
+	if err != nil || p.String() != "41" {
+		t.Fatalf("expected a DISCONNECT packet, got %q (%v)", p, err)
+	}
+	if elapsed := time.Since(started); elapsed < preStopDelay+gracePeriod {
+		t.Fatalf("expected the disconnection after %v, got %v", preStopDelay+gracePeriod, elapsed)
+	}
+	expectClose(ctx, t, staying)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("terminate: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("termination did not complete")
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("expected the listener to be closed")
+	}
+
+	phases := []string{
+		"termination started, not ready",
+		"pre-stop delay elapsed",
+		"shutdown notice sent",
+		"new handshakes refused",
+		"grace period expired, disconnecting the remaining sockets",
+		"socket.io server closed",
+		"http listener closed",
+	}
+	var logged []string
+	var notice map[string]any
+	handler.mu.Lock()
+	for _, record := range *handler.records {
+		if msg := record["msg"].(string); slices.Contains(phases, msg) {
+			logged = append(logged, msg)
+			if msg == "shutdown notice sent" {
+				notice = record
+			}
+		}
+	}
+	handler.mu.Unlock()
+	if !slices.Equal(logged, phases) {
+		t.Fatalf("expected the phases %q, got %q", phases, logged)
+	}
+	if notice["sockets"] != int64(2) || notice["seconds"] != int64(1) {
+		t.Errorf("expected the notice to be sent to 2 sockets, got %v", notice)
+	}
+}
+
+// The healthcheck runs against embedded servers, as a probe would against the
+// server of its container.
+func TestHealthcheck(t *testing.T) {
+	// start runs an embedded server until the test ends, and returns its URL
+	start := func(t *testing.T, opts ...testserver.Option) string {
+		t.Helper()
+
+		addr := freeAddr(t)
+		server, _, err := testserver.New(addr, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { server.Close(nil) })
+		return "http://" + addr
+	}
+
+	// run runs the healthcheck command, and returns its exit code and output
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := healthcheck.Run(args, &out)
+		return code, out.String()
+	}
+
+	expect := func(t *testing.T, code int, output string, wantCode int, want ...string) {
+		t.Helper()
+
+		if code != wantCode || strings.Count(output, "\n") != 1 {
+			t.Fatalf("expected exit code %d and a single line, got %d %q", wantCode, code, output)
+		}
+		for _, s := range want {
+			if !strings.Contains(output, s) {
+				t.Fatalf("expected %q in %q", s, output)
+			}
+		}
+	}
+
+	t.Run("should pass against a running server", func(t *testing.T) {
+		url := start(t) + testserver.DefaultPath
+
+		code, output := run("-url", url)
+		expect(t, code, output, 0, "healthy: "+url, "upgrades=websocket", "ping_interval=300ms", "ping_timeout=200ms", "max_payload=1000000")
+
+		code, output = run("-url", url, "-namespace", "/custom", "-expect-upgrade", "websocket", "-timeout", "2s")
+		expect(t, code, output, 0, "healthy: ", "namespace=/custom")
+	})
+
+	t.Run("should fail against a closed port", func(t *testing.T) {
+		url := "http://" + freeAddr(t) + testserver.DefaultPath
+
+		code, output := run("-url", url, "-timeout", "2s")
+		expect(t, code, output, 1, "unhealthy: "+url, "handshake: ", "connection refused")
+	})
+
+	t.Run("should fail against a server at another path", func(t *testing.T) {
+		url := start(t, testserver.WithPath("/ws")) + testserver.DefaultPath
+
+		code, output := run("-url", url)
+		expect(t, code, output, 1, "unhealthy: "+url, "handshake: unexpected status 404")
+	})
+
+	t.Run("should fail without the expected upgrade", func(t *testing.T) {
+		url := start(t, testserver.WithTransports("polling")) + testserver.DefaultPath
+
+		code, output := run("-url", url, "-expect-upgrade", "websocket")
+		expect(t, code, output, 1, "unhealthy: ", "upgrade to websocket not offered")
+	})
+
+	t.Run("should fail when the namespace is refused", func(t *testing.T) {
+		url := start(t) + testserver.DefaultPath
+
+		code, output := run("-url", url, "-namespace", "/unknown")
+		expect(t, code, output, 1, "unhealthy: ", "connect /unknown: refused: ", "Invalid namespace")
 	})
 }
@@ -0,0 +1,198 @@
+//go:build webtransport
+
+package test_suite
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"app/internal/eiop"
+	"app/servers/testserver"
+
+	"github.com/coder/websocket"
+	"github.com/quic-go/webtransport-go"
+	webtrans "github.com/zishang520/socket.io/v3/pkg/webtransport"
+)
+
+// The WebTransport variant runs embedded, over TLS with an in-memory
+// certificate, and HTTP/3 on the UDP port matching its TCP port.
+func TestWebTransport(t *testing.T) {
+	cert, err := testserver.SelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := freeAddr(t)
+	server, _, err := testserver.New(addr,
+		testserver.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		testserver.WithWebTransport(addr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			server.Close(nil)
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	// handshake opens a polling session and returns the body of the response
+	handshake := func(t *testing.T) []byte {
+		t.Helper()
+
+		resp, err := client.Get("https://" + addr + "/socket.io/?EIO=4&transport=polling")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected an Engine.IO handshake, got %d %q", resp.StatusCode, body)
+		}
+		return body
+	}
+
+	t.Run("should advertise the webtransport upgrade", func(t *testing.T) {
+		var open struct {
+			Upgrades []string `json:"upgrades"`
+		}
+		decodeOpen(t, handshake(t), &open)
+		if !slices.Equal(open.Upgrades, []string{"websocket", "webtransport"}) {
+			t.Fatalf("expected the websocket and webtransport upgrades, got %v", open.Upgrades)
+		}
+	})
+
+	t.Run("should keep long-polling unchanged", func(t *testing.T) {
+		var open struct {
+			Sid string `json:"sid"`
+		}
+		decodeOpen(t, handshake(t), &open)
+		url := "https://" + addr + "/socket.io/?EIO=4&transport=polling&sid=" + open.Sid
+
+		resp, err := client.Post(url, "text/plain", strings.NewReader("40"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+
+		resp, err = client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(body), "40{") {
+			t.Fatalf("expected a Socket.IO handshake, got %q", body)
+		}
+	})
+
+	t.Run("should keep websocket unchanged", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		c, _, err := websocket.Dial(ctx, "wss://"+addr+"/socket.io/?EIO=4&transport=websocket", &websocket.DialOptions{HTTPClient: client})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(websocket.StatusNormalClosure, "")
+
+		if p, err := waitForPacket(ctx, c); err != nil || p.Type != eiop.Open {
+			t.Fatalf("expected an Engine.IO handshake, got %s (%v)", p, err)
+		}
+		if err := c.Write(ctx, websocket.MessageText, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		if p, err := waitForPacket(ctx, c); err != nil || p.Type != eiop.Message || !bytes.HasPrefix(p.Data, []byte("0{")) {
+			t.Fatalf("expected a Socket.IO handshake, got %q (%v)", p, err)
+		}
+	})
+
+	t.Run("should open a session over WebTransport", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		dialer := &webtransport.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots}}
+		_, session, err := dialer.Dial(ctx, "https://"+addr+"/socket.io/?EIO=4&transport=webtransport", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.CloseWithError(0, "")
+
+		// The session carries the packets framed on one bidirectional stream,
+		// starting with an empty OPEN packet from the client
+		stream, err := session.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := webtrans.NewConn(session, stream, false, 0, 0, nil, nil, nil)
+		c.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+		read := func() string {
+			t.Helper()
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(data)
+		}
+
+		if err := c.WriteMessage(webtrans.TextMessage, []byte("0")); err != nil {
+			t.Fatal(err)
+		}
+		if data := read(); !strings.HasPrefix(data, "0{") || !strings.Contains(data, `"upgrades":[]`) {
+			t.Fatalf("expected an Engine.IO handshake without upgrades, got %q", data)
+		}
+		if err := c.WriteMessage(webtrans.TextMessage, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		if data := read(); !strings.HasPrefix(data, "40{") {
+			t.Fatalf("expected a Socket.IO handshake, got %q", data)
+		}
+		if err := c.WriteMessage(webtrans.TextMessage, []byte(`42["message","hello"]`)); err != nil {
+			t.Fatal(err)
+		}
+		// The main namespace emits "auth" on connection first
+		for {
+			data := read()
+			if strings.HasPrefix(data, `42["message-back"`) {
+				if data != `42["message-back","hello"]` {
+					t.Fatalf("unexpected message-back %q", data)
+				}
+				break
+			}
+		}
+	})
+
+	t.Run("should close the UDP socket on close", func(t *testing.T) {
+		server.Close(nil)
+		closed = true
+
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			t.Fatalf("expected the UDP socket to be closed: %v", err)
+		}
+		conn.Close()
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// Typing indicator example - shows who is typing in a room, with volatile
+// emits for the indicators that are only worth anything right away.
+//
+// Features:
+//   - Join any number of named rooms
+//   - "typing-start" rebroadcast to the room as a volatile event, which a
+//     client whose connection is not ready to send simply misses
+//   - Duplicate "typing-start" from the same socket debounced for 2 seconds
+//   - "typing-stop" always delivered, including when a typing client
+//     disconnects
+//
+// A typing indicator received late is worse than none: a client catching up
+// after a slow network would see a burst of stale "is typing" notices. The
+// stop, on the other hand, must arrive, or the indicator would stay forever.
+
+func main() {
+	httpServer := types.NewWebServer(nil)
+	server := newServer(httpServer)
+
+	addr := ":3000"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+
+	httpServer.Listen(addr, nil)
+	fmt.Printf("Typing indicator server listening on %s\n", addr)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down server...")
+	server.Close(nil)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	io "github.com/zishang520/socket.io/servers/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// debounce is how long duplicate "typing-start" events of a socket in a room
+// are suppressed, which clients usually send on every keystroke.
+const debounce = 2 * time.Second
+
+// newServer creates a Socket.IO server relaying the typing indicators of the
+// rooms its clients join.
+func newServer(httpServer *types.HttpServer) *io.Server {
+	config := io.DefaultServerOptions()
+	config.SetCors(&types.Cors{Origin: "*"})
+
+	server := io.NewServer(httpServer, config)
+	server.On("connection", func(clients ...any) {
+		if len(clients) == 0 {
+			return
+		}
+		client, ok := clients[0].(*io.Socket)
+		if !ok {
+			return
+		}
+
+		var (
+			mu sync.Mutex
+			// typing maps each room the socket is typing in to the time of
+			// its last rebroadcast "typing-start"
+			typing = map[io.Room]time.Time{}
+		)
+
+		// When the client emits 'join', add it to the room
+		client.On("join", func(args ...any) {
+			room, ok := roomArg(client, args)
+			if !ok {
+				return
+			}
+			client.Join(room)
+			if ack, ok := args[len(args)-1].(io.Ack); ok {
+				ack([]any{room}, nil)
+			}
+		})
+
+		// When the client emits 'typing-start', tell the other members of the
+		// room, unless it already did less than debounce ago. The event is
+		// volatile: a member whose connection cannot take it right now misses
+		// it rather than getting it once it is stale.
+		client.On("typing-start", func(args ...any) {
+			room, ok := roomArg(client, args)
+			if !ok || !client.Rooms().Has(room) {
+				return
+			}
+			mu.Lock()
+			last, started := typing[room]
+			if started && time.Since(last) < debounce {
+				mu.Unlock()
+				return
+			}
+			typing[room] = time.Now()
+			mu.Unlock()
+
+			client.To(room).Volatile().Emit("typing-start", map[string]any{
+				"id":   client.Id(),
+				"room": room,
+			})
+		})
+
+		// When the client emits 'typing-stop', tell the other members of the
+		// room. The event is not volatile, as a missed stop would leave the
+		// indicator on.
+		client.On("typing-stop", func(args ...any) {
+			room, ok := roomArg(client, args)
+			if !ok || !client.Rooms().Has(room) {
+				return
+			}
+			mu.Lock()
+			delete(typing, room)
+			mu.Unlock()
+
+			client.To(room).Emit("typing-stop", map[string]any{
+				"id":   client.Id(),
+				"room": room,
+			})
+		})
+
+		// A client disconnecting while typing stops typing. Its rooms are
+		// still known while disconnecting, but cleared by the time
+		// 'disconnect' is emitted.
+		client.On("disconnecting", func(...any) {
+			mu.Lock()
+			defer mu.Unlock()
+			for room := range typing {
+				client.To(room).Emit("typing-stop", map[string]any{
+					"id":   client.Id(),
+					"room": room,
+				})
+			}
+			clear(typing)
+		})
+	})
+	return server
+}
+
+// roomArg returns the room named by the first argument. Clients may not use
+// the private room of a socket, named after its id.
+func roomArg(client *io.Socket, args []any) (io.Room, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+	name, ok := args[0].(string)
+	if !ok || name == "" || name == string(client.Id()) {
+		return "", false
+	}
+	return io.Room(name), true
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	io_client "github.com/zishang520/socket.io/clients/socket/v3"
+	"github.com/zishang520/socket.io/v3/pkg/types"
+)
+
+// setupServer starts the server and returns its address.
+func setupServer(t *testing.T) string {
+	t.Helper()
+
+	httpServer := types.NewWebServer(nil)
+	ioServer := newServer(httpServer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: httpServer}
+	go server.Serve(ln)
+
+	t.Cleanup(func() {
+		ioServer.Close(nil)
+		server.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+// joinRoom connects a client and joins it to room. Websocket only, as the
+// polling upgrade occasionally stalls the connection.
+func joinRoom(t *testing.T, addr, room string) *io_client.Socket {
+	t.Helper()
+
+	managerOpts := io_client.DefaultManagerOptions()
+	managerOpts.SetAutoConnect(false)
+	managerOpts.SetReconnection(false)
+	managerOpts.SetTransports(types.NewSet(io_client.WebSocket))
+
+	manager := io_client.NewManager("http://"+addr, managerOpts)
+	client := manager.Socket("/", io_client.DefaultSocketOptions())
+	t.Cleanup(func() { client.Disconnect() })
+
+	joined := make(chan struct{}, 1)
+	client.Once("connect", func(...any) {
+		client.EmitWithAck("join", room)(func([]any, error) { joined <- struct{}{} })
+	})
+	client.Connect()
+
+	select {
+	case <-joined:
+		return client
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client did not join %s", room)
+		return nil
+	}
+}
+
+// collect returns a channel receiving the first argument of each event.
+func collect(client *io_client.Socket, event string) <-chan any {
+	ch := make(chan any, 10)
+	client.On(types.EventName(event), func(args ...any) {
+		if len(args) > 0 {
+			ch <- args[0]
+		}
+	})
+	return ch
+}
+
+// expectEvent waits for an event.
+func expectEvent(t *testing.T, events <-chan any, event string) map[string]any {
+	t.Helper()
+
+	select {
+	case payload := <-events:
+		data, _ := payload.(map[string]any)
+		return data
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected %s", event)
+		return nil
+	}
+}
+
+func TestTypingDebounce(t *testing.T) {
+	addr := setupServer(t)
+	alice := joinRoom(t, addr, "general")
+	bob := joinRoom(t, addr, "general")
+
+	var starts atomic.Int32
+	bob.On("typing-start", func(...any) { starts.Add(1) })
+	stops := collect(bob, "typing-stop")
+
+	// Five keystrokes in 500ms
+	for range 5 {
+		alice.Emit("typing-start", "general")
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// The stop follows every start of alice, so those that were delivered
+	// arrived before it
+	alice.Emit("typing-stop", "general")
+	if stop := expectEvent(t, stops, "typing-stop"); stop["id"] != string(alice.Id()) {
+		t.Fatalf("expected the typing-stop of alice, got %v", stop)
+	}
+	if n := starts.Load(); n > 2 {
+		t.Fatalf("expected at most 2 typing-start, got %d", n)
+	}
+
+	// The stop resets the debounce
+	before := starts.Load()
+	alice.Emit("typing-start", "general")
+	alice.Emit("typing-stop", "general")
+	expectEvent(t, stops, "typing-stop")
+	if n := starts.Load() - before; n != 1 {
+		t.Fatalf("expected a typing-start after the stop, got %d", n)
+	}
+}
+
+func TestTypingStopOnDisconnect(t *testing.T) {
+	addr := setupServer(t)
+	alice := joinRoom(t, addr, "general")
+	bob := joinRoom(t, addr, "general")
+	starts := collect(bob, "typing-start")
+	stops := collect(bob, "typing-stop")
+
+	alice.Emit("typing-start", "general")
+	expectEvent(t, starts, "typing-start")
+	alice.Disconnect()
+
+	if stop := expectEvent(t, stops, "typing-stop"); stop["room"] != "general" {
+		t.Fatalf("expected a typing-stop for general, got %v", stop)
+	}
+}
+
+func TestTypingOutsideRoom(t *testing.T) {
+	addr := setupServer(t)
+	bob := joinRoom(t, addr, "general")
+	eve := joinRoom(t, addr, "random")
+	starts := collect(bob, "typing-start")
+	stops := collect(bob, "typing-stop")
+
+	// Only members may type in a room
+	eve.Emit("typing-start", "general")
+	eve.Emit("typing-stop", "general")
+
+	select {
+	case payload := <-starts:
+		t.Fatalf("unexpected typing-start %v", payload)
+	case payload := <-stops:
+		t.Fatalf("unexpected typing-stop %v", payload)
+	case <-time.After(300 * time.Millisecond):
+	}
+}